@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// fetchExclusions asks the order service which services the discount never applies to
+func fetchExclusions(baseURL string) (map[string]bool, error) {
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	resp, err := httpClient.Get(baseURL + "/discount/exclusions")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		ExcludedServices []string `json:"excluded_services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(body.ExcludedServices))
+	for _, name := range body.ExcludedServices {
+		excluded[name] = true
+	}
+	return excluded, nil
+}
+
+// exclusionTag marks services the discount doesn't apply to
+func exclusionTag(excluded map[string]bool, name string) string {
+	if excluded[name] {
+		return "  (no discount)"
+	}
+	return ""
+}
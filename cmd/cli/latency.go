@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushRoundTrip reports a booking's round trip to a Prometheus Pushgateway as
+// booking_cli_round_trip_seconds, grouped by the booking's status. The gateway keeps the latest
+// push per status, so scrapes see the last round trip of each outcome.
+func pushRoundTrip(gatewayURL, status string, roundTrip time.Duration) error {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "booking_cli_round_trip_seconds",
+		Help: "Time from sending POST /order to reading its response, as seen by the booking CLI.",
+	})
+	gauge.Set(roundTrip.Seconds())
+	if status == "" {
+		status = "unknown"
+	}
+	return push.New(gatewayURL, "booking_cli").
+		Grouping("status", strings.ToLower(status)).
+		Collector(gauge).
+		Push()
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/eligibility"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
 )
 
 type Service struct {
@@ -28,80 +31,132 @@ type OrderRequest struct {
 	DiscountPercent  float64   `json:"discount_percent"`
 	FinalPrice       float64   `json:"final_price"`
 	SimulateFailure  bool      `json:"simulate_failure"`
+	FailureMode      string    `json:"failure_mode,omitempty"`
+	IsTest           bool      `json:"is_test,omitempty"`
+	LocationID       string    `json:"location_id,omitempty"`
 }
 
 type OrderResponse struct {
-	OrderID string `json:"order_id"`
-	Status  string `json:"status"`
-	Message string `json:"message"`
-}
-
-var medicalServices = map[string][]Service{
-	"female": {
-		{"Gynecological Checkup", 800},
-		{"Mammography", 1500},
-		{"General Consultation", 500},
-		{"Blood Test - Complete", 600},
-		{"Ultrasound", 1200},
-		{"Thyroid Function Test", 450},
-	},
-	"male": {
-		{"Prostate Examination", 700},
-		{"General Consultation", 500},
-		{"Blood Test - Complete", 600},
-		{"ECG", 400},
-		{"X-Ray Chest", 350},
-		{"Lipid Profile", 550},
-	},
-	"other": {
-		{"General Consultation", 500},
-		{"Blood Test - Complete", 600},
-		{"ECG", 400},
-		{"X-Ray Chest", 350},
-		{"Ultrasound", 1200},
-	},
+	OrderID         string   `json:"order_id"`
+	Status          string   `json:"status"`
+	Message         string   `json:"message"`
+	FinalPrice      float64  `json:"final_price"`
+	DiscountPercent float64  `json:"discount_percent"`
+	DiscountAmount  float64  `json:"discount_amount"`
+	Reasons         []string `json:"reasons,omitempty"`
 }
 
 func main() {
+	format := flag.String("format", formatText, "Output format: text, json or table")
+	jsonOutput := flag.Bool("json", false, "Shorthand for -format json")
+	plain := flag.Bool("plain", false, "Print ASCII instead of emoji and box drawing (default when stdout isn't a terminal)")
+	savePath := flag.String("save-request", "", "Write the request sent to the order service to this file")
+	replayPath := flag.String("replay", "", "Resubmit a request saved with -save-request, skipping the prompts")
+	waitFor := flag.Duration("wait-for-server", 0, "Retry while the order service refuses connections, for up to this long (e.g. 30s)")
+	retries := flag.Int("retries", 3, "Resubmit the order this many times on connection errors and 5xx responses")
+	pushgateway := flag.String("pushgateway", "", "Prometheus Pushgateway URL to report the booking's round trip to")
+	server := flag.String("server", "", "Order service base URL (default ORDER_SERVICE_URL, else "+defaultServerURL+")")
+	timezone := flag.String("clinic-timezone", "Asia/Kolkata", "The order service's CLINIC_TIMEZONE, used for \"today\" in the eligibility preview")
+	var overrides requestOverrides
+	overrides.register(flag.CommandLine)
+	flag.Parse()
+	plainSet := false
+	flag.Visit(func(f *flag.Flag) { plainSet = plainSet || f.Name == "plain" })
+	if *plain || (!plainSet && !isTerminal(os.Stdout)) {
+		setPlain()
+	}
+	if *jsonOutput {
+		if *format != formatText && *format != formatJSON {
+			fmt.Fprintf(stderr, "❌ -json conflicts with -format %s\n", *format)
+			os.Exit(exitUsage)
+		}
+		*format = formatJSON
+	}
+	if err := validateFormat(*format); err != nil {
+		fmt.Fprintf(stderr, "❌ %v\n", err)
+		os.Exit(exitUsage)
+	}
+	clinicLocation, err := time.LoadLocation(*timezone)
+	if err != nil {
+		fmt.Fprintf(stderr, "❌ Invalid -clinic-timezone: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if *retries < 0 {
+		fmt.Fprintf(stderr, "❌ -retries must not be negative, got %d\n", *retries)
+		os.Exit(exitUsage)
+	}
+	if orderServiceURL, err = resolveServerURL(*server); err != nil {
+		fmt.Fprintf(stderr, "❌ %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	// Prompts and progress go to stderr for machine-readable formats so stdout carries only the result
+	ui := stdout
+	if *format != formatText {
+		ui = stderr
+	}
+
+	if *waitFor > 0 {
+		if err := waitForServer(ui, orderServiceURL, *waitFor); err != nil {
+			fmt.Fprintf(ui, "❌ Error contacting server: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+
+	if *replayPath != "" {
+		req, err := loadRequest(*replayPath)
+		if err == nil {
+			overrides.apply(flag.CommandLine, &req)
+			err = validateRequest(req)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "❌ Cannot replay request: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		fmt.Fprintf(ui, "🔁 Replaying request from %s\n", *replayPath)
+		submit(ui, *format, *savePath, *pushgateway, *retries, req, nil)
+		return
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Println("╔════════════════════════════════════════════════════════╗")
-	fmt.Println("║   Medical Clinic Booking System - Event Driven        ║")
-	fmt.Println("╚════════════════════════════════════════════════════════╝")
-	fmt.Println()
+	fmt.Fprintln(ui, "╔════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(ui, "║   Medical Clinic Booking System - Event Driven        ║")
+	fmt.Fprintln(ui, "╚════════════════════════════════════════════════════════╝")
+	fmt.Fprintln(ui)
 
 	// 1. User Input with Validation
 
 	// Validate Name
 	var name string
 	for {
-		fmt.Print("Enter Name: ")
+		fmt.Fprint(ui, "Enter Name: ")
 		name, _ = reader.ReadString('\n')
 		name = strings.TrimSpace(name)
 		if name != "" {
 			break
 		}
-		fmt.Println("❌ Name cannot be empty. Please try again.")
+		fmt.Fprintln(ui, "❌ Name cannot be empty. Please try again.")
 	}
 
 	// Validate Gender
 	var gender string
 	for {
-		fmt.Print("Enter Gender (Male/Female/Other): ")
+		fmt.Fprint(ui, "Enter Gender (Male/Female/Other): ")
 		gender, _ = reader.ReadString('\n')
 		gender = strings.TrimSpace(gender)
 		genderLower := strings.ToLower(gender)
 		if genderLower == "male" || genderLower == "female" || genderLower == "other" {
 			break
 		}
-		fmt.Println("❌ Invalid gender. Please enter: Male, Female, or Other")
+		fmt.Fprintln(ui, "❌ Invalid gender. Please enter: Male, Female, or Other")
 	}
 
 	// Validate Date of Birth
 	var dob string
 	var dobDate time.Time
 	for {
-		fmt.Print("Enter Date of Birth (YYYY-MM-DD): ")
+		fmt.Fprint(ui, "Enter Date of Birth (YYYY-MM-DD): ")
 		dob, _ = reader.ReadString('\n')
 		dob = strings.TrimSpace(dob)
 		var err error
@@ -109,30 +164,34 @@ func main() {
 		if err == nil {
 			// Check if date is not in the future
 			if dobDate.After(time.Now()) {
-				fmt.Println("❌ Date of birth cannot be in the future. Please try again.")
+				fmt.Fprintln(ui, "❌ Date of birth cannot be in the future. Please try again.")
 				continue
 			}
 			break
 		}
-		fmt.Println("❌ Invalid date format. Please use YYYY-MM-DD (e.g., 1990-05-15)")
+		fmt.Fprintln(ui, "❌ Invalid date format. Please use YYYY-MM-DD (e.g., 1990-05-15)")
 	}
 
 	// 2. Display Gender-Specific Medical Services
-	fmt.Printf("\n╔════════════════════════════════════════════════════════╗\n")
-	fmt.Printf("║ Available Medical Services for %s\n", strings.Title(strings.ToLower(gender)))
-	fmt.Printf("╚════════════════════════════════════════════════════════╝\n")
-
-	services := medicalServices[strings.ToLower(gender)]
-	if services == nil {
-		services = medicalServices["other"]
+	fmt.Fprintf(ui, "\n╔════════════════════════════════════════════════════════╗\n")
+	fmt.Fprintf(ui, "║ Available Medical Services for %s\n", strings.Title(strings.ToLower(gender)))
+	fmt.Fprintf(ui, "╚════════════════════════════════════════════════════════╝\n")
+
+	// The order service prices orders from the same catalog
+	var services []Service
+	for _, s := range pricing.ServicesFor(gender) {
+		services = append(services, Service{Name: s.Name, Price: s.Price})
 	}
 
+	// The server's exclusion list keeps this preview in line with its pricing
+	excluded, exclusionsErr := fetchExclusions(orderServiceURL)
+
 	for i, service := range services {
-		fmt.Printf("%d. %-30s ₹%.2f\n", i+1, service.Name, service.Price)
+		fmt.Fprintf(ui, "%d. %-30s ₹%.2f%s\n", i+1, service.Name, service.Price, exclusionTag(excluded, service.Name))
 	}
 
 	// 3. User Selects Services
-	fmt.Print("\nEnter service numbers separated by commas (e.g., 1,3,4): ")
+	fmt.Fprint(ui, "\nEnter service numbers separated by commas (e.g., 1,3,4): ")
 	selection, _ := reader.ReadString('\n')
 	selection = strings.TrimSpace(selection)
 
@@ -158,58 +217,68 @@ func main() {
 
 	// Show warnings for invalid selections
 	if len(invalidSelections) > 0 {
-		fmt.Printf("\n⚠️  Skipped invalid selections: %s\n", strings.Join(invalidSelections, ", "))
+		fmt.Fprintf(ui, "\n⚠️  Skipped invalid selections: %s\n", strings.Join(invalidSelections, ", "))
 	}
 
 	if len(selectedServices) == 0 {
-		fmt.Println("❌ No valid services selected. Exiting.")
+		fmt.Fprintln(ui, "❌ No valid services selected. Exiting.")
 		return
 	}
 
 	// Calculate Base Price
 	basePrice := 0.0
-	fmt.Println("\n╔════════════════════════════════════════════════════════╗")
-	fmt.Println("║ Selected Services:")
-	fmt.Println("╚════════════════════════════════════════════════════════╝")
+	discountable := 0.0
+	fmt.Fprintln(ui, "\n╔════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(ui, "║ Selected Services:")
+	fmt.Fprintln(ui, "╚════════════════════════════════════════════════════════╝")
 	for _, service := range selectedServices {
-		fmt.Printf("  • %-30s ₹%.2f\n", service.Name, service.Price)
+		fmt.Fprintf(ui, "  • %-30s ₹%.2f%s\n", service.Name, service.Price, exclusionTag(excluded, service.Name))
 		basePrice += service.Price
+		if !excluded[service.Name] {
+			discountable += service.Price
+		}
 	}
-	fmt.Printf("\n  Base Price (Total): ₹%.2f\n", basePrice)
+	fmt.Fprintf(ui, "\n  Base Price (Total): ₹%.2f\n", basePrice)
 
 	// 4. Check R1 Eligibility (Birthday OR Price > ₹1000)
-	isR1Eligible, isBirthday := checkR1Eligibility(gender, dob, basePrice)
+	decision := checkR1Eligibility(gender, dob, basePrice, time.Now().In(clinicLocation))
+	isR1Eligible := decision.Eligible
 	discountPercent := 0.0
 	finalPrice := basePrice
 
 	if isR1Eligible {
-		discountPercent = 12.0
-		finalPrice = basePrice * (1 - discountPercent/100)
-		fmt.Println("\n✓ Eligible for 12% Discount!")
-		if isBirthday {
-			fmt.Println("  Reason: Female + Birthday 🎂")
+		discountPercent = decision.Percent
+		var clamped bool
+		finalPrice, clamped, _ = pricing.FinalPrice(basePrice, discountable, discountPercent, pricing.FloorClamp)
+		fmt.Fprintf(ui, "\n✓ Eligible for %g%% Discount!\n", discountPercent)
+		for _, reason := range decision.Reasons {
+			fmt.Fprintf(ui, "  Reason: %s\n", reason)
+		}
+		fmt.Fprintf(ui, "  Discount Amount: ₹%.2f\n", basePrice-finalPrice)
+		fmt.Fprintf(ui, "  Final Price: ₹%.2f\n", finalPrice)
+		if clamped {
+			fmt.Fprintln(ui, "  ⚠️  The discount exceeds the total; the price was capped at zero.")
 		}
-		if basePrice > 1000 {
-			fmt.Println("  Reason: High-Value Order (>₹1000)")
+		if exclusionsErr != nil {
+			fmt.Fprintln(ui, "  ⚠️  Estimate only: discount exclusions could not be loaded,")
+			fmt.Fprintln(ui, "     so the final price may be higher if some services are excluded.")
 		}
-		fmt.Printf("  Discount Amount: ₹%.2f\n", basePrice-finalPrice)
-		fmt.Printf("  Final Price: ₹%.2f\n", finalPrice)
 	} else {
-		fmt.Println("\n✗ Not eligible for discount")
-		fmt.Println("  (Requires: Female + Birthday OR Total > ₹1000)")
+		fmt.Fprintln(ui, "\n✗ Not eligible for discount")
+		fmt.Fprintln(ui, "  (Requires: Female + Birthday OR Total > ₹1000)")
 	}
 
 	// 5. Submit Request
-	fmt.Print("\n╔════════════════════════════════════════════════════════╗\n")
-	fmt.Print("║ Submit Booking Request? (y/n): ")
+	fmt.Fprint(ui, "\n╔════════════════════════════════════════════════════════╗\n")
+	fmt.Fprint(ui, "║ Submit Booking Request? (y/n): ")
 	confirm, _ := reader.ReadString('\n')
 	if strings.ToLower(strings.TrimSpace(confirm)) != "y" {
-		fmt.Println("Booking cancelled.")
+		fmt.Fprintln(ui, "Booking cancelled.")
 		return
 	}
 
 	// Chaos Testing Option
-	fmt.Print("[TEST] Simulate Payment Failure? (y/n): ")
+	fmt.Fprint(ui, "[TEST] Simulate Payment Failure? (y/n): ")
 	simFailIn, _ := reader.ReadString('\n')
 	simFail := strings.ToLower(strings.TrimSpace(simFailIn)) == "y"
 
@@ -226,53 +295,83 @@ func main() {
 		FinalPrice:       finalPrice,
 		SimulateFailure:  simFail,
 	}
+	submit(ui, *format, *savePath, *pushgateway, *retries, req, decision.Reasons)
+}
+
+// submit sends the order, renders the outcome and exits with the matching status code
+func submit(ui io.Writer, format, savePath, pushgateway string, retries int, req OrderRequest, reasons []string) {
 	body, _ := json.Marshal(req)
+	if savePath != "" {
+		if err := saveRequest(savePath, body); err != nil {
+			fmt.Fprintf(ui, "⚠️  Could not save request: %v\n", err)
+		} else {
+			fmt.Fprintf(ui, "💾 Request saved to %s\n", savePath)
+		}
+	}
 
-	fmt.Println("\n╔════════════════════════════════════════════════════════╗")
-	fmt.Println("║ Processing Request...")
-	fmt.Println("╚════════════════════════════════════════════════════════╝")
-	fmt.Println("⏳ Sending request to Order Service...")
+	fmt.Fprintln(ui, "\n╔════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(ui, "║ Processing Request...")
+	fmt.Fprintln(ui, "╚════════════════════════════════════════════════════════╝")
+	fmt.Fprintf(ui, "⏳ Sending request to Order Service at %s...\n", orderServiceURL)
 
-	resp, err := http.Post("http://localhost:8081/order", "application/json", bytes.NewBuffer(body))
+	start := time.Now()
+	resp, err := postOrder(ui, orderServiceURL+"/order?explain=true", body, retries)
 	if err != nil {
-		fmt.Printf("❌ Error contacting server: %v\n", err)
-		return
+		fmt.Fprintf(ui, "❌ Error contacting server: %v\n", err)
+		os.Exit(exitFailed)
 	}
-	defer resp.Body.Close()
 
 	// 7. Display Result
 	var result OrderResponse
 	json.NewDecoder(resp.Body).Decode(&result)
-
-	fmt.Println("\n╔════════════════════════════════════════════════════════╗")
-	fmt.Println("║ BOOKING RESULT")
-	fmt.Println("╚════════════════════════════════════════════════════════╝")
-	fmt.Printf("Order ID:     %s\n", result.OrderID)
-	fmt.Printf("Status:       %s\n", result.Status)
-	fmt.Printf("Message:      %s\n", result.Message)
-
-	if result.Status == "CONFIRMED" {
-		fmt.Printf("\n✓ Booking Confirmed!\n")
-		fmt.Printf("  Reference ID: %s\n", result.OrderID)
-		fmt.Printf("  Final Amount: ₹%.2f\n", finalPrice)
-	} else {
-		fmt.Printf("\n❌ Booking Failed\n")
+	resp.Body.Close()
+	roundTrip := time.Since(start)
+	if pushgateway != "" {
+		if err := pushRoundTrip(pushgateway, result.Status, roundTrip); err != nil {
+			fmt.Fprintf(ui, "⚠️  Could not report the round trip: %v\n", err)
+		}
 	}
-}
 
-func checkR1Eligibility(gender, dob string, basePrice float64) (bool, bool) {
-	isBirthday := false
-	isFemale := strings.ToLower(gender) == "female"
-
-	// Check if today is birthday
-	dobDate, err := time.Parse("2006-01-02", dob)
-	if err == nil {
-		today := time.Now()
-		if dobDate.Month() == today.Month() && dobDate.Day() == today.Day() {
-			isBirthday = true
+	booking := bookingResult{
+		Name:            req.Name,
+		Gender:          req.Gender,
+		DOB:             req.DOB,
+		Services:        req.SelectedServices,
+		BasePrice:       req.BasePrice,
+		Eligible:        req.IsR1Eligible,
+		Reasons:         reasons,
+		DiscountPercent: req.DiscountPercent,
+		FinalPrice:      req.FinalPrice,
+		DiscountAmount:  pricing.DiscountAmount(req.BasePrice, req.FinalPrice),
+		Response:        result,
+		RoundTripMS:     roundTrip.Milliseconds(),
+	}
+	if result.Status == "CONFIRMED" {
+		// The server's amounts are what is charged; show those, not the local preview
+		if diff := result.FinalPrice - req.FinalPrice; diff > pricing.Tolerance || diff < -pricing.Tolerance {
+			fmt.Fprintf(ui, "⚠️  Server price ₹%.2f differs from the preview ₹%.2f; showing the server's price.\n",
+				result.FinalPrice, req.FinalPrice)
 		}
+		booking.FinalPrice = result.FinalPrice
+		booking.DiscountPercent = result.DiscountPercent
+		booking.DiscountAmount = result.DiscountAmount
+		booking.Eligible = result.DiscountPercent > 0
+		booking.Reasons = result.Reasons
 	}
+	if err := render(resultWriter(format), format, booking); err != nil {
+		fmt.Fprintf(stderr, "❌ Failed to render result: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	os.Exit(booking.exitCode())
+}
 
-	// R1: (Female AND Birthday) OR (Price > ₹1000)
-	return (isFemale && isBirthday) || (basePrice > 1000), isBirthday
+// checkR1Eligibility previews R1 locally as of now, which should be in the clinic's timezone like the
+// server's. The order service re-evaluates it and its decision is authoritative.
+func checkR1Eligibility(gender, dob string, basePrice float64, now time.Time) eligibility.Decision {
+	return eligibility.Default().Evaluate(eligibility.Order{
+		Gender:    gender,
+		DOB:       dob,
+		BasePrice: basePrice,
+		Now:       now,
+	})
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBirthdayPreviewNearMidnight(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 00:15 on 16 October at the clinic is still the afternoon of the 15th in New York
+	instant := time.Date(2026, 10, 15, 18, 45, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		dob      string
+		now      time.Time
+		eligible bool
+	}{
+		{"birthday at the clinic", "1990-10-16", instant.In(kolkata), true},
+		{"same instant, client's zone", "1990-10-16", instant.In(newYork), false},
+		{"yesterday at the clinic", "1990-10-15", instant.In(kolkata), false},
+		{"birthday in the client's zone only", "1990-10-15", instant.In(newYork), true},
+		// One minute before the clinic's midnight, it is still the 15th there
+		{"just before clinic midnight", "1990-10-16", time.Date(2026, 10, 15, 23, 59, 0, 0, kolkata), false},
+	}
+	for _, tt := range tests {
+		// Below the high-value threshold, so only the birthday rule can match
+		decision := checkR1Eligibility("Female", tt.dob, 500, tt.now)
+		if decision.Eligible != tt.eligible {
+			t.Errorf("%s: eligible = %v, want %v (reasons %v)", tt.name, decision.Eligible, tt.eligible, decision.Reasons)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatTable = "table"
+)
+
+// Exit codes so scripts can branch on the booking outcome
+const (
+	exitConfirmed = 0
+	exitFailed    = 1
+	exitRejected  = 2
+	exitUsage     = 64
+)
+
+// bookingResult is everything known once a booking round-trip finishes; every format renders from it
+type bookingResult struct {
+	Name            string        `json:"name"`
+	Gender          string        `json:"gender"`
+	DOB             string        `json:"dob"`
+	Services        []Service     `json:"selected_services"`
+	BasePrice       float64       `json:"base_price"`
+	Eligible        bool          `json:"is_r1_eligible"`
+	Reasons         []string      `json:"reasons,omitempty"`
+	DiscountPercent float64       `json:"discount_percent"`
+	FinalPrice      float64       `json:"final_price"`
+	DiscountAmount  float64       `json:"discount_amount"`
+	Response        OrderResponse `json:"response"`
+	RoundTripMS     int64         `json:"round_trip_ms"` // from sending the order to reading the response
+}
+
+func (b bookingResult) exitCode() int {
+	switch b.Response.Status {
+	case "CONFIRMED":
+		return exitConfirmed
+	case "REJECTED":
+		return exitRejected
+	default:
+		return exitFailed
+	}
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case formatText, formatJSON, formatTable:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (want %s, %s or %s)", format, formatText, formatJSON, formatTable)
+}
+
+func render(w io.Writer, format string, b bookingResult) error {
+	switch format {
+	case formatJSON:
+		return renderJSON(w, b)
+	case formatTable:
+		return renderTable(w, b)
+	default:
+		return renderText(w, b)
+	}
+}
+
+func renderText(w io.Writer, b bookingResult) error {
+	fmt.Fprintln(w, "\n╔════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(w, "║ BOOKING RESULT")
+	fmt.Fprintln(w, "╚════════════════════════════════════════════════════════╝")
+	fmt.Fprintf(w, "Order ID:     %s\n", b.Response.OrderID)
+	fmt.Fprintf(w, "Status:       %s\n", b.Response.Status)
+	fmt.Fprintf(w, "Message:      %s\n", b.Response.Message)
+	fmt.Fprintf(w, "Round Trip:   %s\n", time.Duration(b.RoundTripMS)*time.Millisecond)
+
+	if b.Response.Status == "CONFIRMED" {
+		fmt.Fprintf(w, "\n✓ Booking Confirmed!\n")
+		fmt.Fprintf(w, "  Reference ID: %s\n", b.Response.OrderID)
+		if b.DiscountAmount > 0 {
+			fmt.Fprintf(w, "  You Save:     ₹%.2f\n", b.DiscountAmount)
+		}
+		fmt.Fprintf(w, "  Final Amount: ₹%.2f\n", b.FinalPrice)
+	} else {
+		fmt.Fprintf(w, "\n❌ Booking Failed\n")
+	}
+	return nil
+}
+
+func renderJSON(w io.Writer, b bookingResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+func renderTable(w io.Writer, b bookingResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORDER ID\tSTATUS\tSERVICES\tBASE\tDISCOUNT\tFINAL\tMESSAGE")
+	fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f\t%g%%\t%.2f\t%s\n",
+		b.Response.OrderID, b.Response.Status, len(b.Services), b.BasePrice,
+		b.DiscountPercent, b.FinalPrice, strings.TrimSpace(b.Response.Message))
+	return tw.Flush()
+}
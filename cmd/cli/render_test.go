@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func confirmedBooking() bookingResult {
+	return bookingResult{
+		Name:            "Asha Rao",
+		Gender:          "F",
+		DOB:             "1990-10-15",
+		Services:        []Service{{Name: "Mammography", Price: 1500}, {Name: "Ultrasound", Price: 1200}},
+		BasePrice:       2700,
+		Eligible:        true,
+		Reasons:         []string{"Female + Birthday", "High-Value Order"},
+		DiscountPercent: 12,
+		FinalPrice:      2376,
+		DiscountAmount:  324,
+		Response: OrderResponse{
+			OrderID:         "3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14",
+			Status:          "CONFIRMED",
+			Message:         "Booking confirmed! Final price: ₹2376.00 (12% discount applied)",
+			FinalPrice:      2376,
+			DiscountPercent: 12,
+			DiscountAmount:  324,
+			Reasons:         []string{"Female + Birthday", "High-Value Order"},
+		},
+		RoundTripMS: 142,
+	}
+}
+
+// rejectedBooking is an order the quota turned away: the server answers with its reason and no
+// amounts, and the preview's pricing is what the client had asked for
+func rejectedBooking() bookingResult {
+	return bookingResult{
+		Name:            "Asha Rao",
+		Gender:          "F",
+		DOB:             "1990-10-15",
+		Services:        []Service{{Name: "Mammography", Price: 1500}, {Name: "Ultrasound", Price: 1200}},
+		BasePrice:       2700,
+		Eligible:        true,
+		Reasons:         []string{"Female + Birthday", "High-Value Order"},
+		DiscountPercent: 12,
+		FinalPrice:      2376,
+		DiscountAmount:  324,
+		Response: OrderResponse{
+			OrderID: "9c41d7a2-6e3b-4f05-8d12-7a0e5b3c8f61",
+			Status:  "REJECTED",
+			Message: "Daily discount quota reached. Please try again tomorrow.",
+		},
+		RoundTripMS: 97,
+	}
+}
+
+func TestRenderJSONGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		booking  bookingResult
+		golden   string
+		status   string
+		exitCode int
+	}{
+		{"confirmed", confirmedBooking(), "booking.golden.json", "CONFIRMED", exitConfirmed},
+		{"rejected", rejectedBooking(), "booking_rejected.golden.json", "REJECTED", exitRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := render(&out, formatJSON, tt.booking); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("-json output changed; scripts parse it, so only add fields.\ngot:\n%s\nwant:\n%s", out.Bytes(), want)
+			}
+
+			var parsed struct {
+				Response struct {
+					Status  string `json:"status"`
+					Message string `json:"message"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+				t.Fatalf("-json output is not JSON: %v", err)
+			}
+			if parsed.Response.Status != tt.status || parsed.Response.Message != tt.booking.Response.Message {
+				t.Errorf("response = %+v, want status %s with the server's message", parsed.Response, tt.status)
+			}
+			if code := tt.booking.exitCode(); code != tt.exitCode {
+				t.Errorf("exit code = %d, want %d", code, tt.exitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestOverrides are the fields a replayed request may change; only flags given on the command line apply
+type requestOverrides struct {
+	UserID          string
+	Name            string
+	Gender          string
+	DOB             string
+	SimulateFailure bool
+	FailureMode     string
+}
+
+func (o *requestOverrides) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.UserID, "user-id", "", "With -replay: override user_id")
+	fs.StringVar(&o.Name, "name", "", "With -replay: override name")
+	fs.StringVar(&o.Gender, "gender", "", "With -replay: override gender")
+	fs.StringVar(&o.DOB, "dob", "", "With -replay: override date of birth (YYYY-MM-DD)")
+	fs.BoolVar(&o.SimulateFailure, "simulate-failure", false, "With -replay: override simulate_failure")
+	fs.StringVar(&o.FailureMode, "failure-mode", "", "With -replay: override failure_mode")
+}
+
+// apply copies the explicitly set override flags onto req
+func (o *requestOverrides) apply(fs *flag.FlagSet, req *OrderRequest) {
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "user-id":
+			req.UserID = o.UserID
+		case "name":
+			req.Name = o.Name
+		case "gender":
+			req.Gender = o.Gender
+		case "dob":
+			req.DOB = o.DOB
+		case "simulate-failure":
+			req.SimulateFailure = o.SimulateFailure
+		case "failure-mode":
+			req.FailureMode = o.FailureMode
+		}
+	})
+}
+
+// saveRequest writes the exact request body sent to the order service
+func saveRequest(path string, body []byte) error {
+	return os.WriteFile(path, append(body, '\n'), 0o600)
+}
+
+// loadRequest reads a request saved with -save-request
+func loadRequest(path string) (OrderRequest, error) {
+	var req OrderRequest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("%s: %w", path, err)
+	}
+	return req, nil
+}
+
+// validateRequest applies the interactive prompts' checks to a replayed request
+func validateRequest(req OrderRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	switch strings.ToLower(req.Gender) {
+	case "male", "female", "other":
+	default:
+		return fmt.Errorf("invalid gender %q (want Male, Female or Other)", req.Gender)
+	}
+	dob, err := time.Parse("2006-01-02", req.DOB)
+	if err != nil {
+		return fmt.Errorf("invalid date of birth %q (want YYYY-MM-DD)", req.DOB)
+	}
+	if dob.After(time.Now()) {
+		return fmt.Errorf("date of birth cannot be in the future")
+	}
+	if len(req.SelectedServices) == 0 {
+		return fmt.Errorf("no services selected")
+	}
+	total := 0.0
+	for _, service := range req.SelectedServices {
+		if service.Price < 0 {
+			return fmt.Errorf("service %q has a negative price", service.Name)
+		}
+		total += service.Price
+	}
+	if diff := total - req.BasePrice; diff > 0.005 || diff < -0.005 {
+		return fmt.Errorf("base_price %.2f does not match the selected services' total %.2f", req.BasePrice, total)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// retryBackoff is the wait before the first retry; it doubles for each one after, up to 4s
+var retryBackoff = 500 * time.Millisecond
+
+// postOrder posts the order, retrying up to retries more times with backoff on connection errors,
+// 5xx responses and a 409 asking to retry. Other client errors, including 429, are returned as they
+// are. Every attempt carries the same Idempotency-Key, generated for this submission, so an attempt
+// whose order went through (say it answered 504 PENDING, or the connection dropped after the order
+// was taken) is answered with that order rather than starting a second one; while it is still
+// running the server answers 409 with Retry-After. After the last attempt the final response or
+// error is returned.
+func postOrder(ui io.Writer, url string, body []byte, retries int) (*http.Response, error) {
+	key := uuid.New().String()
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := sendOrder(url, key, body)
+		retryable := err != nil || resp.StatusCode >= 500 ||
+			(resp.StatusCode == http.StatusConflict && resp.Header.Get("Retry-After") != "")
+		if !retryable || attempt > retries {
+			return resp, err
+		}
+		if err != nil {
+			fmt.Fprintf(ui, "⚠️  Attempt %d/%d failed: %v\n", attempt, retries+1, err)
+		} else {
+			fmt.Fprintf(ui, "⚠️  Attempt %d/%d failed: %s\n", attempt, retries+1, resp.Status)
+			resp.Body.Close()
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 4*time.Second)
+		fmt.Fprintf(ui, "🔁 Retrying (attempt %d/%d)...\n", attempt+1, retries+1)
+	}
+}
+
+func sendOrder(url, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	return http.DefaultClient.Do(req)
+}
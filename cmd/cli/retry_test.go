@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostOrderRetriesWithOneIdempotencyKey(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		switch len(keys) {
+		case 1:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		case 2:
+			w.WriteHeader(http.StatusGatewayTimeout)
+			io.WriteString(w, `{"order_id":"o1","status":"PENDING"}`)
+		default:
+			io.WriteString(w, `{"order_id":"o1","status":"CONFIRMED"}`)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := postOrder(io.Discard, server.URL+"/order", []byte(`{"user_id":"u1"}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("%d attempts, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("Idempotency-Key per attempt = %q, want one key on every attempt", keys)
+	}
+
+	// The next submission is a new order, with a new key
+	resp, err = postOrder(io.Discard, server.URL+"/order", []byte(`{"user_id":"u1"}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if keys[3] == keys[0] {
+		t.Error("a second submission reused the first one's Idempotency-Key")
+	}
+}
+
+func TestPostOrderRetryLimits(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	tests := []struct {
+		name         string
+		status       int
+		retryAfter   string
+		wantAttempts int
+	}{
+		{"server error until the last attempt", http.StatusServiceUnavailable, "", 3},
+		{"request still in progress", http.StatusConflict, "1", 3},
+		{"bad request", http.StatusBadRequest, "", 1},
+		{"rate limited", http.StatusTooManyRequests, "1", 1},
+		{"key reused for another order", http.StatusUnprocessableEntity, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			resp, err := postOrder(io.Discard, server.URL, []byte(`{}`), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || attempts != tt.wantAttempts {
+				t.Errorf("got %d after %d attempts, want %d after %d", resp.StatusCode, attempts, tt.status, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultServerURL is the order service of a local docker or `go run` setup
+const defaultServerURL = "http://localhost:8081"
+
+// orderServiceURL is the order service's base URL, resolved by resolveServerURL at startup
+var orderServiceURL = defaultServerURL
+
+// resolveServerURL picks the order service's base URL: the -server flag, else ORDER_SERVICE_URL,
+// else the local default. It must be an absolute http(s) URL; a trailing slash is dropped so paths
+// such as /order can be appended.
+func resolveServerURL(flagValue string) (string, error) {
+	raw, source := flagValue, "-server"
+	if raw == "" {
+		raw, source = os.Getenv("ORDER_SERVICE_URL"), "ORDER_SERVICE_URL"
+	}
+	if raw == "" {
+		return defaultServerURL, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", source, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid %s %q: want an http(s) URL such as %s", source, raw, defaultServerURL)
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
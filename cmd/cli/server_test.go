@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveServerURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		want    string
+		wantErr string // substring naming the source of a bad URL
+	}{
+		{"default", "", "", defaultServerURL, ""},
+		{"environment", "", "https://orders.example.com", "https://orders.example.com", ""},
+		{"flag", "http://localhost:9090", "", "http://localhost:9090", ""},
+		{"flag over environment", "http://localhost:9090", "https://orders.example.com", "http://localhost:9090", ""},
+		{"trailing slash dropped", "https://orders.example.com/", "", "https://orders.example.com", ""},
+		{"bad flag", "orders.example.com", "https://orders.example.com", "", "-server"},
+		{"bad environment", "", "ftp://orders.example.com", "", "ORDER_SERVICE_URL"},
+		{"no host", "http://", "", "", "-server"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ORDER_SERVICE_URL", tt.env)
+			got, err := resolveServerURL(tt.flag)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveServerURL(%q) error = %v, want one naming %s", tt.flag, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveServerURL(%q) = %q, %v; want %q", tt.flag, got, err, tt.want)
+			}
+		})
+	}
+}
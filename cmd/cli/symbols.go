@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// plainSymbols is the CLI's symbol set: each emoji or box-drawing glyph it prints, with the ASCII
+// it becomes in plain mode. New output should use glyphs from this list, or add theirs to it.
+var plainSymbols = strings.NewReplacer(
+	"╔", "+", "╗", "+", "╚", "+", "╝", "+", "═", "-", "║", "|",
+	"•", "*",
+	"₹", "Rs.",
+	"✓", "[OK]",
+	"✗", "[NO]",
+	"❌", "[FAIL]",
+	"⚠️", "[WARN]",
+	"⏳", "[WAIT]",
+	"🔁", "[RETRY]",
+	"💾", "[SAVED]",
+	"🎂", "[BIRTHDAY]",
+)
+
+// plainWriter writes through w with the symbol set replaced by ASCII. Callers write whole lines or
+// fmt calls, so no glyph is split across writes.
+type plainWriter struct{ w io.Writer }
+
+func (p plainWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(p.w, plainSymbols.Replace(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// stdout and stderr are where the CLI writes, ASCII-only in plain mode
+var stdout, stderr io.Writer = os.Stdout, os.Stderr
+
+// setPlain switches stdout and stderr to plain mode
+func setPlain() {
+	stdout, stderr = plainWriter{os.Stdout}, plainWriter{os.Stderr}
+}
+
+// resultWriter is where the booking result is rendered: stdout, except that -json output bypasses
+// plain mode, so the server's messages reach scripts exactly as it sent them
+func resultWriter(format string) io.Writer {
+	if p, ok := stdout.(plainWriter); ok && format == formatJSON {
+		return p.w
+	}
+	return stdout
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
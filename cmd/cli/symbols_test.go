@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nonASCII returns the first rune of s outside ASCII, or -1 when there is none
+func nonASCII(s string) rune {
+	for _, r := range s {
+		if r > 127 {
+			return r
+		}
+	}
+	return -1
+}
+
+func TestPlainRenderIsASCII(t *testing.T) {
+	rejected := confirmedBooking()
+	rejected.Response.Status = "REJECTED"
+	rejected.Response.Message = "Daily discount quota reached. Please try again tomorrow."
+	notEligible := confirmedBooking()
+	notEligible.Eligible, notEligible.Reasons, notEligible.DiscountPercent = false, nil, 0
+
+	bookings := map[string]bookingResult{"confirmed": confirmedBooking(), "rejected": rejected, "not eligible": notEligible}
+	for name, b := range bookings {
+		for _, format := range []string{formatText, formatTable} {
+			var out bytes.Buffer
+			if err := render(plainWriter{&out}, format, b); err != nil {
+				t.Fatal(err)
+			}
+			if r := nonASCII(out.String()); r != -1 {
+				t.Errorf("%s, %s: plain output has %q:\n%s", name, format, r, out.String())
+			}
+		}
+	}
+}
+
+func TestPlainRenderMatchesFancy(t *testing.T) {
+	border := strings.Repeat("═", 56)
+	lines := []struct{ fancy, plain string }{
+		{"", ""},
+		{"╔" + border + "╗", "+" + strings.Repeat("-", 56) + "+"},
+		{"║ BOOKING RESULT", "| BOOKING RESULT"},
+		{"╚" + border + "╝", "+" + strings.Repeat("-", 56) + "+"},
+		{"Order ID:     3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14", "Order ID:     3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14"},
+		{"Status:       CONFIRMED", "Status:       CONFIRMED"},
+		{"Message:      Booking confirmed! Final price: ₹2376.00 (12% discount applied)",
+			"Message:      Booking confirmed! Final price: Rs.2376.00 (12% discount applied)"},
+		{"Round Trip:   142ms", "Round Trip:   142ms"},
+		{"", ""},
+		{"✓ Booking Confirmed!", "[OK] Booking Confirmed!"},
+		{"  Reference ID: 3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14", "  Reference ID: 3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14"},
+		{"  You Save:     ₹324.00", "  You Save:     Rs.324.00"},
+		{"  Final Amount: ₹2376.00", "  Final Amount: Rs.2376.00"},
+	}
+	var wantFancy, wantPlain strings.Builder
+	for _, l := range lines {
+		wantFancy.WriteString(l.fancy + "\n")
+		wantPlain.WriteString(l.plain + "\n")
+	}
+
+	var fancy, plain bytes.Buffer
+	if err := render(&fancy, formatText, confirmedBooking()); err != nil {
+		t.Fatal(err)
+	}
+	if err := render(plainWriter{&plain}, formatText, confirmedBooking()); err != nil {
+		t.Fatal(err)
+	}
+	if fancy.String() != wantFancy.String() {
+		t.Errorf("fancy output:\n%s\nwant:\n%s", fancy.String(), wantFancy.String())
+	}
+	if plain.String() != wantPlain.String() {
+		t.Errorf("plain output:\n%s\nwant:\n%s", plain.String(), wantPlain.String())
+	}
+}
+
+func TestJSONBypassesPlainMode(t *testing.T) {
+	defer func(out, errOut io.Writer) { stdout, stderr = out, errOut }(stdout, stderr)
+	var out bytes.Buffer
+	stdout = plainWriter{&out}
+
+	if err := render(resultWriter(formatJSON), formatJSON, confirmedBooking()); err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	if err := renderJSON(&raw, confirmedBooking()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != raw.String() {
+		t.Errorf("-json output was rewritten in plain mode:\n%s\nwant the server's message as sent:\n%s", out.String(), raw.String())
+	}
+
+	// The human formats stay plain
+	out.Reset()
+	if err := render(resultWriter(formatTable), formatTable, confirmedBooking()); err != nil {
+		t.Fatal(err)
+	}
+	if r := nonASCII(out.String()); r != -1 {
+		t.Errorf("plain table output has %q", r)
+	}
+}
+
+// TestPlainSymbolsCoverSource checks every string the CLI prints: each glyph in it must be in
+// plainSymbols, or plain mode lets it through to logs and CI output.
+func TestPlainSymbolsCoverSource(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "symbols.go" {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatalf("%s: %v", fset.Position(lit.Pos()), err)
+			}
+			if r := nonASCII(plainSymbols.Replace(s)); r != -1 {
+				t.Errorf("%s: %q isn't in plainSymbols", fset.Position(lit.Pos()), r)
+			}
+			return true
+		})
+	}
+}
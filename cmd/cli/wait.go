@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// waitForServer polls the order service until it accepts connections or timeout elapses.
+// Connection refused means the service is still starting and is retried with backoff;
+// any other error fails immediately.
+func waitForServer(ui io.Writer, baseURL string, timeout time.Duration) error {
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+	backoff := 100 * time.Millisecond
+	waiting := false
+
+	for {
+		resp, err := httpClient.Get(baseURL + "/discount/exclusions")
+		if err == nil {
+			resp.Body.Close()
+			if waiting {
+				fmt.Fprintln(ui, " ready")
+			}
+			return nil
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			if waiting {
+				fmt.Fprintln(ui)
+			}
+			return err
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			if waiting {
+				fmt.Fprintln(ui)
+			}
+			return fmt.Errorf("order service not reachable after %s: %w", timeout, err)
+		}
+
+		if !waiting {
+			fmt.Fprint(ui, "⏳ Waiting for Order Service")
+			waiting = true
+		}
+		fmt.Fprint(ui, ".")
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 2*time.Second)
+	}
+}
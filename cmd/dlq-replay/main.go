@@ -0,0 +1,262 @@
+// Command dlq-replay republishes dead-lettered events once the bug that made them unparseable is
+// fixed.
+//
+// Each pending or previously failed record in the dead_letter collection is passed through the
+// named transformation (-transform, see -list-transforms), decoded into its event type to check
+// it now parses, and written back to the events collection as a new event stamped with the current
+// time, so listeners resuming from a checkpoint still see it. The record is marked replayed with
+// the new event's ID, or failed with the error; failed records are retried by the next run. A
+// replayed event that still can't be consumed is dead-lettered again under its new ID.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/joho/godotenv"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ProjectID        = "devdolphins-93118"
+	CollectionEvents = "events"
+)
+
+// transform rewrites a dead-lettered event's data in place before it is decoded again
+type transform func(data map[string]interface{}) error
+
+// transforms are the registered transformations, by name
+var transforms = map[string]transform{
+	"none": func(map[string]interface{}) error { return nil },
+	// default-location fills in location_id on events written before clinic locations existed
+	"default-location": func(data map[string]interface{}) error {
+		if location, _ := common.GetString(data, "location_id"); location == "" {
+			data["location_id"] = events.DefaultLocation
+		}
+		return nil
+	},
+	// numeric-strings converts price and count fields written as strings back to numbers
+	"numeric-strings": func(data map[string]interface{}) error {
+		for _, field := range []string{"base_price", "final_price", "discount_percent", "discount_amount", "service_count"} {
+			s, ok := data[field].(string)
+			if !ok {
+				continue
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			data[field] = f
+		}
+		return nil
+	},
+}
+
+// eventTypes maps each event type to a constructor of its struct, for decoding
+var eventTypes = map[events.EventType]func() events.Event{
+	events.EventTypeOrderCreated:     func() events.Event { return &events.OrderCreated{} },
+	events.EventTypeDiscountReserved: func() events.Event { return &events.DiscountReserved{} },
+	events.EventTypeDiscountRejected: func() events.Event { return &events.DiscountRejected{} },
+	events.EventTypeDiscountRelease:  func() events.Event { return &events.DiscountRelease{} },
+	events.EventTypeDiscountConfirm:  func() events.Event { return &events.DiscountConfirm{} },
+	events.EventTypeOrderConfirmed:   func() events.Event { return &events.OrderConfirmed{} },
+	events.EventTypePaymentCompleted: func() events.Event { return &events.PaymentCompleted{} },
+	events.EventTypePaymentFailed:    func() events.Event { return &events.PaymentFailed{} },
+	events.EventTypeOrderSettled:     func() events.Event { return &events.OrderSettled{} },
+	events.EventTypeDailyDigest:      func() events.Event { return &events.DailyDigest{} },
+}
+
+func main() {
+	transformName := flag.String("transform", "none", "Transformation applied before republishing (see -list-transforms)")
+	listTransforms := flag.Bool("list-transforms", false, "List the registered transformations and exit")
+	eventType := flag.String("type", "", "Only replay dead letters of this event type")
+	limit := flag.Int("limit", 100, "Replay at most this many dead letters")
+	dryRun := flag.Bool("dry-run", false, "Transform and decode without republishing or updating records")
+	project := flag.String("project", ProjectID, "Firestore project")
+	database := flag.String("database", "", "Database (default FIRESTORE_DATABASE, else (default))")
+	flag.Parse()
+
+	if *listTransforms {
+		names := make([]string, 0, len(transforms))
+		for name := range transforms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+	fn, ok := transforms[*transformName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown transformation %q (see -list-transforms)\n", *transformName)
+		os.Exit(64)
+	}
+	if *eventType != "" && !events.EventType(*eventType).IsValid() {
+		fmt.Fprintf(os.Stderr, "unknown event type %q\n", *eventType)
+		os.Exit(64)
+	}
+	if *limit < 1 {
+		fmt.Fprintf(os.Stderr, "-limit must be positive, got %d\n", *limit)
+		os.Exit(64)
+	}
+
+	_ = godotenv.Load()
+	if *database == "" {
+		*database = common.EnvOrDefault("FIRESTORE_DATABASE", firestore.DefaultDatabaseID)
+	}
+	retention, err := common.LoadEventRetention()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid EVENT_RETENTION: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	client, err := common.NewFirestoreClientWithDatabase(ctx, *project, *database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	q := client.Collection(common.CollectionDeadLetter).
+		Where("status", "in", []string{common.DeadLetterPending, common.DeadLetterFailed})
+	if *eventType != "" {
+		q = q.Where("type", "==", *eventType)
+	}
+	iter := q.OrderBy("dead_lettered_at", firestore.Asc).Limit(*limit).Documents(ctx)
+	defer iter.Stop()
+
+	publisher := &common.Publisher{Collection: client.Collection(CollectionEvents), Retention: retention}
+	replayed, failed := 0, 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read dead letters: %v\n", err)
+			os.Exit(1)
+		}
+		var record common.DeadLetter
+		if err := doc.DataTo(&record); err != nil {
+			fmt.Fprintf(os.Stderr, "  skipped   %s: unreadable record: %v\n", doc.Ref.ID, err)
+			continue
+		}
+
+		event, err := prepare(record, fn)
+		if *dryRun {
+			if err != nil {
+				failed++
+				fmt.Printf("  would fail   %s (%s): %v\n", doc.Ref.ID, record.Type, err)
+			} else {
+				replayed++
+				fmt.Printf("  would replay %s (%s)\n", doc.Ref.ID, record.Type)
+			}
+			continue
+		}
+
+		var replayID string
+		if err == nil {
+			replayID, err = republish(ctx, publisher, doc.Ref.ID, record.ReplayAttempts+1, event)
+		}
+		if markErr := mark(ctx, doc.Ref, replayID, err); markErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to update dead letter %s: %v\n", doc.Ref.ID, markErr)
+			os.Exit(1)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("  failed    %s (%s): %v\n", doc.Ref.ID, record.Type, err)
+		} else {
+			replayed++
+			fmt.Printf("  replayed  %s (%s) as %s\n", doc.Ref.ID, record.Type, replayID)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run: %d would replay, %d would fail\n", replayed, failed)
+		return
+	}
+	fmt.Printf("%d replayed, %d failed\n", replayed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// prepare transforms a copy of the dead-lettered data and decodes it into its event type,
+// restamped with the current time
+func prepare(record common.DeadLetter, fn transform) (events.Event, error) {
+	newEvent, ok := eventTypes[events.EventType(record.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", record.Type)
+	}
+	data := make(map[string]interface{}, len(record.Data))
+	for k, v := range record.Data {
+		data[k] = v
+	}
+	if err := fn(data); err != nil {
+		return nil, fmt.Errorf("transformation failed: %w", err)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	event := newEvent()
+	if err := json.Unmarshal(raw, event); err != nil {
+		return nil, fmt.Errorf("still unparseable: %w", err)
+	}
+
+	v := reflect.ValueOf(event).Elem()
+	base := v.FieldByName("BaseEvent").Addr().Interface().(*events.BaseEvent)
+	base.Type = events.EventType(record.Type)
+	base.Timestamp = time.Now()
+	base.ExpiresAt = time.Time{}
+	event = v.Interface().(events.Event)
+	if err := events.Validate(event); err != nil {
+		return nil, fmt.Errorf("still invalid: %w", err)
+	}
+	return event, nil
+}
+
+// republish writes the event under an ID derived from the dead letter and attempt, so a run that
+// dies before marking the record doesn't publish the event twice when rerun
+func republish(ctx context.Context, publisher *common.Publisher, id string, attempt int, event events.Event) (string, error) {
+	replayID := fmt.Sprintf("%s-replay-%d", id, attempt)
+	_, err := publisher.Collection.Doc(replayID).Create(ctx, publisher.Stamp(event))
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return "", err
+	}
+	return replayID, nil
+}
+
+// mark records the outcome of a replay attempt on the dead letter
+func mark(ctx context.Context, ref *firestore.DocumentRef, replayID string, replayErr error) error {
+	updates := []firestore.Update{
+		{Path: "replay_attempts", Value: firestore.Increment(1)},
+		{Path: "last_replay_at", Value: time.Now()},
+	}
+	if replayErr != nil {
+		updates = append(updates,
+			firestore.Update{Path: "status", Value: common.DeadLetterFailed},
+			firestore.Update{Path: "last_replay_error", Value: replayErr.Error()})
+	} else {
+		updates = append(updates,
+			firestore.Update{Path: "status", Value: common.DeadLetterReplayed},
+			firestore.Update{Path: "replayed_as", Value: replayID},
+			firestore.Update{Path: "last_replay_error", Value: firestore.Delete})
+	}
+	_, err := ref.Update(ctx, updates)
+	return err
+}
@@ -0,0 +1,200 @@
+// Command indexgen writes firestore.indexes.json from the query registry in pkg/queries.
+//
+// Run it from the repository root. With -check it writes nothing and exits non-zero when the file
+// is out of date, or when a Where/OrderBy chain in the code uses fields that no declared query
+// covers. The scan only sees string-literal field names within one expression, so queries built
+// across statements or helpers are checked field by field rather than as a whole.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/devdolphintest/discount-system/pkg/queries"
+)
+
+type indexFile struct {
+	Indexes        []queries.Index `json:"indexes"`
+	FieldOverrides []struct{}      `json:"fieldOverrides"`
+}
+
+// codeQuery is a Where/OrderBy chain found in the source
+type codeQuery struct {
+	Pos        token.Position
+	Collection string // empty when it couldn't be resolved
+	Fields     []string
+}
+
+func main() {
+	out := flag.String("out", "firestore.indexes.json", "Index file to write or check")
+	check := flag.Bool("check", false, "Fail if the index file is stale or a code query isn't declared")
+	flag.Parse()
+
+	want, err := generate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if !*check {
+		if err := os.WriteFile(*out, want, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	if have, err := os.ReadFile(*out); err != nil || !bytes.Equal(have, want) {
+		fmt.Fprintf(os.Stderr, "%s is out of date; run go run ./cmd/indexgen\n", *out)
+		failed = true
+	}
+
+	found, err := scan(".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, q := range found {
+		if !queries.Covers(queries.Registry, q.Collection, q.Fields) {
+			fmt.Fprintf(os.Stderr, "%s: query on %v is not declared in pkg/queries\n", q.Pos, q.Fields)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// generate renders firestore.indexes.json for the registry
+func generate() ([]byte, error) {
+	out, err := json.MarshalIndent(indexFile{
+		Indexes:        queries.Indexes(queries.Registry),
+		FieldOverrides: []struct{}{},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// scan parses every Go file under root and returns its query chains
+func scan(root string) ([]codeQuery, error) {
+	fset := token.NewFileSet()
+	var found []codeQuery
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); path != root && (strings.HasPrefix(name, ".") || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		consts := stringConsts(file)
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || !isQueryMethod(call) {
+				return true
+			}
+			q := chain(call, consts)
+			if len(q.Fields) > 0 {
+				q.Pos = fset.Position(call.Pos())
+				found = append(found, q)
+			}
+			// Inner calls are part of this chain
+			return false
+		})
+		return nil
+	})
+	return found, err
+}
+
+func isQueryMethod(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && (sel.Sel.Name == "Where" || sel.Sel.Name == "OrderBy")
+}
+
+// chain walks a method chain from its outermost call, collecting filtered and ordered fields and
+// the collection it starts from
+func chain(call *ast.CallExpr, consts map[string]string) codeQuery {
+	var q codeQuery
+	for {
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return q
+		}
+		switch sel.Sel.Name {
+		case "Where", "OrderBy":
+			if len(call.Args) > 0 {
+				if field, ok := literal(call.Args[0], consts); ok {
+					q.Fields = append(q.Fields, field)
+				}
+			}
+		case "Collection":
+			if len(call.Args) > 0 {
+				q.Collection, _ = literal(call.Args[0], consts)
+			}
+			return q
+		}
+		next, ok := sel.X.(*ast.CallExpr)
+		if !ok {
+			return q
+		}
+		call = next
+	}
+}
+
+func literal(expr ast.Expr, consts map[string]string) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, err := strconv.Unquote(e.Value)
+			return s, err == nil
+		}
+	case *ast.Ident:
+		s, ok := consts[e.Name]
+		return s, ok
+	}
+	return "", false
+}
+
+// stringConsts collects the file's string constants, so Collection(CollectionEvents) resolves
+func stringConsts(file *ast.File) map[string]string {
+	consts := make(map[string]string)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if i < len(vs.Values) {
+					if s, ok := literal(vs.Values[i], nil); ok {
+						consts[name.Name] = s
+					}
+				}
+			}
+		}
+	}
+	return consts
+}
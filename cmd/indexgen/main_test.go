@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/queries"
+)
+
+const repoRoot = "../.."
+
+// indexed reports whether a generated index on collection covers every field in fields
+func indexed(collection string, fields []string) bool {
+	for _, idx := range queries.Indexes(queries.Registry) {
+		if collection != "" && idx.CollectionGroup != collection {
+			continue
+		}
+		covered := true
+		for _, f := range fields {
+			if !slices.ContainsFunc(idx.Fields, func(fi queries.IndexField) bool { return fi.FieldPath == f }) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCodeQueriesDeclared(t *testing.T) {
+	found, err := scan(repoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) == 0 {
+		t.Fatal("the scan found no queries")
+	}
+	for _, q := range found {
+		if !queries.Covers(queries.Registry, q.Collection, q.Fields) {
+			t.Errorf("%s: query on %v is not declared in pkg/queries", q.Pos, q.Fields)
+		}
+		if len(q.Fields) > 1 && !indexed(q.Collection, q.Fields) {
+			t.Errorf("%s: composite query on %v has no generated index", q.Pos, q.Fields)
+		}
+	}
+}
+
+// TestCompositeQueriesIndexed covers queries the scan can't see whole, such as stream.go's, which
+// adds its type filter in a later statement
+func TestCompositeQueriesIndexed(t *testing.T) {
+	tests := []struct {
+		query      string
+		collection string
+		fields     []string
+	}{
+		{"order: live event stream (type in, timestamp >)", "events", []string{"type", "timestamp"}},
+		{"order: decision listener", "events", []string{"instance_id", "type", "timestamp"}},
+		{"decision and status lookups", "events", []string{"order_id", "type"}},
+		{"order: user orders page", "orders", []string{"user_id", "created_at"}},
+		{"order: confirmed booking history", "orders", []string{"user_id", "status"}},
+		{"discount: expired holds sweep", "holds", []string{"state", "expires_at"}},
+		{"dlq-replay: dead letters of one type", "dead_letter", []string{"status", "type", "dead_lettered_at"}},
+	}
+	for _, tt := range tests {
+		if !indexed(tt.collection, tt.fields) {
+			t.Errorf("%s: no generated index on %s covers %v", tt.query, tt.collection, tt.fields)
+		}
+	}
+}
+
+func TestIndexFileUpToDate(t *testing.T) {
+	want, err := generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := os.ReadFile(filepath.Join(repoRoot, "firestore.indexes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Error("firestore.indexes.json is out of date; run go run ./cmd/indexgen")
+	}
+}
@@ -0,0 +1,209 @@
+// Command quota-restore imports a snapshot written by quota-snapshot into a target project and
+// database.
+//
+// It first plans the restore: every document is compared with the target and is either created,
+// left unchanged, or would overwrite a document holding different data. Overwrites are refused
+// unless -overwrite is given, and production daily counters for today or later, which a running
+// discount service may be updating, additionally need -overwrite-live. If any document is refused,
+// nothing is written. -dry-run prints the plan and stops.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/snapshot"
+	"github.com/joho/godotenv"
+)
+
+const ProjectID = "devdolphins-93118"
+
+// Planned actions
+const (
+	ActionCreate    = "create"
+	ActionUnchanged = "unchanged"
+	ActionOverwrite = "overwrite"
+	ActionRefused   = "refused"
+)
+
+type step struct {
+	Path   string
+	Action string
+	Reason string // why a step was refused
+	Data   map[string]interface{}
+}
+
+func main() {
+	in := flag.String("in", "", "Snapshot file written by quota-snapshot (required)")
+	project := flag.String("project", ProjectID, "Target Firestore project")
+	database := flag.String("database", "", "Target database (default FIRESTORE_DATABASE, else (default))")
+	dryRun := flag.Bool("dry-run", false, "Print what would change without writing")
+	overwrite := flag.Bool("overwrite", false, "Replace target documents that hold different data")
+	overwriteLive := flag.Bool("overwrite-live", false, "With -overwrite, also replace production daily counters for today or later")
+	flag.Parse()
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "-in is required")
+		os.Exit(64)
+	}
+
+	_ = godotenv.Load()
+	if *database == "" {
+		*database = common.EnvOrDefault("FIRESTORE_DATABASE", firestore.DefaultDatabaseID)
+	}
+
+	loc, err := common.LoadQuotaTimezone()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %v\n", err)
+		os.Exit(1)
+	}
+
+	file, err := readFile(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", *in, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	client, err := common.NewFirestoreClientWithDatabase(ctx, *project, *database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	today := common.QuotaDate(time.Now(), loc)
+	steps, err := plan(ctx, client, file, today, *overwrite, *overwriteLive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "planning failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Snapshot of %s/%s taken %s, restoring into %s/%s\n",
+		file.Project, file.Database, file.TakenAt.Format(time.RFC3339), *project, *database)
+	counts := make(map[string]int)
+	for _, s := range steps {
+		counts[s.Action]++
+		switch s.Action {
+		case ActionCreate, ActionOverwrite:
+			fmt.Printf("  %-9s %s\n", s.Action, s.Path)
+		case ActionRefused:
+			fmt.Printf("  %-9s %s (%s)\n", s.Action, s.Path, s.Reason)
+		}
+	}
+	fmt.Printf("%d to create, %d to overwrite, %d unchanged, %d refused\n",
+		counts[ActionCreate], counts[ActionOverwrite], counts[ActionUnchanged], counts[ActionRefused])
+
+	if *dryRun {
+		return
+	}
+	if counts[ActionRefused] > 0 {
+		fmt.Fprintln(os.Stderr, "refusing to restore: some documents would overwrite different data; nothing was written")
+		os.Exit(1)
+	}
+	if err := apply(ctx, client, steps); err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Restore complete")
+}
+
+func readFile(path string) (snapshot.File, error) {
+	var file snapshot.File
+	f, err := os.Open(path)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&file)
+	return file, err
+}
+
+// plan compares every snapshot document with the target
+func plan(ctx context.Context, client *firestore.Client, file snapshot.File, today string, overwrite, overwriteLive bool) ([]step, error) {
+	steps := make([]step, 0, len(file.Documents))
+	for _, doc := range file.Documents {
+		data, err := snapshot.DecodeFields(doc.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", doc.Path, err)
+		}
+		s := step{Path: doc.Path, Data: data}
+
+		snap, err := client.Doc(doc.Path).Get(ctx)
+		switch {
+		case common.IsNotFound(err):
+			s.Action = ActionCreate
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", doc.Path, err)
+		case snapshot.SameFields(snap.Data(), doc.Fields):
+			s.Action = ActionUnchanged
+		case !overwrite:
+			s.Action, s.Reason = ActionRefused, "target holds different data, use -overwrite"
+		case liveQuota(doc.Path, today) && !overwriteLive:
+			s.Action, s.Reason = ActionRefused, "live production counter, use -overwrite-live"
+		default:
+			s.Action = ActionOverwrite
+		}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+// liveQuota reports whether path belongs to a production daily counter (or its shards and user
+// counts) for today or later, which a running discount service may be updating
+func liveQuota(path, today string) bool {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "daily_quotas" {
+		return false
+	}
+	date := parts[1]
+	if len(parts) >= 4 && parts[2] == "days" {
+		date = parts[3] // daily_quotas/{location}/days/{date}
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return false
+	}
+	return date >= today
+}
+
+// apply writes the planned documents. Creates fail if the document appeared since planning.
+func apply(ctx context.Context, client *firestore.Client, steps []step) error {
+	bw := client.BulkWriter(ctx)
+	jobs := make(map[string]*firestore.BulkWriterJob)
+	for _, s := range steps {
+		var job *firestore.BulkWriterJob
+		var err error
+		switch s.Action {
+		case ActionCreate:
+			job, err = bw.Create(client.Doc(s.Path), s.Data)
+		case ActionOverwrite:
+			job, err = bw.Set(client.Doc(s.Path), s.Data)
+		default:
+			continue
+		}
+		if err != nil {
+			bw.End()
+			return fmt.Errorf("%s: %w", s.Path, err)
+		}
+		jobs[s.Path] = job
+	}
+	bw.End()
+
+	failed := 0
+	for path, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "  failed    %s: %v\n", path, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d writes failed", failed, len(jobs))
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+// Command report breaks production discount usage down by a grouping dimension over a date range.
+//
+// It scans the event log and aggregates in memory: DiscountReserved events in the range (one
+// indexed query on type + timestamp), DiscountRelease events since the range start, and the
+// matching OrderCreated events fetched by order_id in batches of 30 (plus one order_details read
+// per large order when grouping by service). Reads therefore grow linearly with the number of
+// reservations in the range (roughly reservations + releases + reservations/30 queries), which is
+// fine for days to weeks of traffic at R2's daily limit but not for open-ended analytics; export
+// the events to BigQuery for that.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/pii"
+	"github.com/joho/godotenv"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	ProjectID        = "devdolphins-93118"
+	CollectionEvents = "events"
+
+	// inBatchSize is Firestore's limit on values in an "in" filter
+	inBatchSize = 30
+)
+
+// Grouping dimensions
+const (
+	ByDate     = "date"
+	ByGender   = "gender"
+	ByService  = "service"
+	ByReason   = "reason"
+	ByLocation = "location"
+)
+
+// row is one group's usage; Net is what the group still holds of the quota
+type row struct {
+	Group    string `json:"group"`
+	Reserved int    `json:"reserved"`
+	Released int    `json:"released"`
+	Net      int    `json:"net"`
+}
+
+// reservation is a non-test DiscountReserved joined with its order
+type reservation struct {
+	ReservedAt time.Time
+	Released   bool
+	Order      *events.OrderCreated // nil when the OrderCreated event could not be found
+}
+
+func main() {
+	by := flag.String("by", ByDate, "Grouping dimension: date, gender, service, reason or location")
+	from := flag.String("from", "", "First quota day, YYYY-MM-DD (default today)")
+	to := flag.String("to", "", "Last quota day, inclusive, YYYY-MM-DD (default -from)")
+	format := flag.String("format", "text", "Output format: text or json")
+	flag.Parse()
+
+	switch *by {
+	case ByDate, ByGender, ByService, ByReason, ByLocation:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dimension %q\n", *by)
+		os.Exit(64)
+	}
+
+	_ = godotenv.Load()
+	loc, err := common.LoadQuotaTimezone()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %v\n", err)
+		os.Exit(1)
+	}
+	start, end, err := parseRange(*from, *to, loc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(64)
+	}
+
+	var cipher *pii.Cipher
+	if keys := common.EnvOrDefault("PII_KEYS", ""); keys != "" {
+		if cipher, err = pii.NewCipher(keys); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid PII_KEYS: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx := context.Background()
+	client, err := common.NewFirestoreClient(ctx, ProjectID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	reservations, err := loadReservations(ctx, client, cipher, start, end, *by == ByService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "report failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	rows := aggregate(reservations, *by, loc)
+	if *format == "json" {
+		json.NewEncoder(os.Stdout).Encode(rows)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tRESERVED\tRELEASED\tNET\n", *by)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", r.Group, r.Reserved, r.Released, r.Net)
+	}
+	tw.Flush()
+}
+
+// parseRange turns inclusive quota days in loc into a [start, end) time range
+func parseRange(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	if from == "" {
+		from = common.QuotaDate(time.Now(), loc)
+	}
+	if to == "" {
+		to = from
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, loc)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid -from: %w", err)
+	}
+	last, err := time.ParseInLocation("2006-01-02", to, loc)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid -to: %w", err)
+	}
+	if last.Before(start) {
+		return start, start, fmt.Errorf("-to is before -from")
+	}
+	return start, last.AddDate(0, 0, 1), nil
+}
+
+// loadReservations joins the range's reservations with their orders; withServices also resolves
+// service lists stored out of line in order_details
+func loadReservations(ctx context.Context, client *firestore.Client, cipher *pii.Cipher, start, end time.Time, withServices bool) (map[string]*reservation, error) {
+	reservations := make(map[string]*reservation)
+	iter := client.Collection(CollectionEvents).
+		Where("type", "==", events.EventTypeDiscountReserved).
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e events.DiscountReserved
+		if err := doc.DataTo(&e); err != nil || e.IsTest {
+			continue
+		}
+		reservations[e.OrderID] = &reservation{ReservedAt: e.Timestamp}
+	}
+
+	releases := client.Collection(CollectionEvents).
+		Where("type", "==", events.EventTypeDiscountRelease).
+		Where("timestamp", ">=", start).
+		Documents(ctx)
+	defer releases.Stop()
+	for {
+		doc, err := releases.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if orderID, ok := doc.Data()["order_id"].(string); ok {
+			if r, ok := reservations[orderID]; ok {
+				r.Released = true
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(reservations))
+	for id := range reservations {
+		ids = append(ids, id)
+	}
+	for i := 0; i < len(ids); i += inBatchSize {
+		batch := ids[i:min(i+inBatchSize, len(ids))]
+		docs, err := client.Collection(CollectionEvents).
+			Where("type", "==", events.EventTypeOrderCreated).
+			Where("order_id", "in", batch).
+			Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var order events.OrderCreated
+			if err := doc.DataTo(&order); err != nil {
+				continue
+			}
+			if cipher != nil {
+				if err := cipher.DecryptOrder(&order); err != nil {
+					return nil, fmt.Errorf("decrypt order %s: %w", order.OrderID, err)
+				}
+			}
+			if withServices {
+				if err := common.ResolveServices(ctx, client, &order); err != nil {
+					return nil, err
+				}
+			}
+			reservations[order.OrderID].Order = &order
+		}
+	}
+	return reservations, nil
+}
+
+// groupKeys lists the groups a reservation counts toward; service and reason can yield several
+func groupKeys(r *reservation, by string, loc *time.Location) []string {
+	if by == ByDate {
+		return []string{r.ReservedAt.In(loc).Format("2006-01-02")}
+	}
+	if r.Order == nil {
+		return []string{"(unknown)"}
+	}
+	switch by {
+	case ByGender:
+		return []string{r.Order.Gender}
+	case ByLocation:
+		if r.Order.LocationID == "" {
+			return []string{events.DefaultLocation}
+		}
+		return []string{r.Order.LocationID}
+	case ByService:
+		keys := make([]string, 0, len(r.Order.SelectedServices))
+		for _, s := range r.Order.SelectedServices {
+			keys = append(keys, s.Name)
+		}
+		return keys
+	case ByReason:
+		if len(r.Order.Reasons) == 0 {
+			return []string{"(not recorded)"}
+		}
+		return r.Order.Reasons
+	}
+	return nil
+}
+
+func aggregate(reservations map[string]*reservation, by string, loc *time.Location) []row {
+	groups := make(map[string]*row)
+	for _, r := range reservations {
+		for _, key := range groupKeys(r, by, loc) {
+			g, ok := groups[key]
+			if !ok {
+				g = &row{Group: key}
+				groups[key] = g
+			}
+			g.Reserved++
+			if r.Released {
+				g.Released++
+			}
+			g.Net = g.Reserved - g.Released
+		}
+	}
+
+	rows := make([]row, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, *g)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if by == ByDate || rows[i].Net == rows[j].Net {
+			return rows[i].Group < rows[j].Group
+		}
+		return rows[i].Net > rows[j].Net
+	})
+	return rows
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// CollectionCheckpoints holds one document per listener recording the last event it processed
+const CollectionCheckpoints = "listener_checkpoints"
+
+// Checkpoint is a position in the event log. Events are ordered by timestamp, then document ID,
+// so events sharing a timestamp are neither skipped nor replayed around the checkpoint.
+type Checkpoint struct {
+	Timestamp time.Time `firestore:"timestamp"`
+	DocID     string    `firestore:"doc_id"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+func (c Checkpoint) before(ts time.Time, id string) bool {
+	return c.Timestamp.Before(ts) || (c.Timestamp.Equal(ts) && c.DocID < id)
+}
+
+// Checkpointer keeps a listener's checkpoint. It is used from the listener goroutine only.
+type Checkpointer struct {
+	store DocStore
+	path  string
+	last  Checkpoint
+}
+
+// LoadCheckpointer reads the named listener's checkpoint. Without one the listener starts from
+// the beginning of the log.
+func LoadCheckpointer(ctx context.Context, store DocStore, name string) (*Checkpointer, error) {
+	cp := &Checkpointer{store: store, path: CollectionCheckpoints + "/" + name}
+	doc, err := store.Get(ctx, cp.path)
+	if IsNotFound(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.DataTo(&cp.last); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// CheckpointFromEnv loads the named listener's checkpoint unless LISTENER_CHECKPOINT is false
+// (default true). It returns nil then, which ListenFrom treats as replaying the whole log.
+func CheckpointFromEnv(ctx context.Context, client *firestore.Client, name string) (*Checkpointer, error) {
+	enabled, err := EnvBool("LISTENER_CHECKPOINT", true)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	return LoadCheckpointer(ctx, FirestoreStore{Client: client}, name)
+}
+
+// Last is the checkpoint loaded or most recently saved; zero when there is none
+func (cp *Checkpointer) Last() Checkpoint { return cp.last }
+
+// resume positions q, which must be ordered by timestamp ascending, after the checkpoint
+func (cp *Checkpointer) resume(q firestore.Query) firestore.Query {
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc)
+	if cp.last.DocID == "" {
+		return q
+	}
+	return q.StartAfter(cp.last.Timestamp, cp.last.DocID)
+}
+
+// advance saves the latest of added, the documents added in a snapshot, once the handler has
+// processed them all
+func (cp *Checkpointer) advance(ctx context.Context, added []*Doc) error {
+	next, moved := cp.last, false
+	for _, doc := range added {
+		ts, ok := doc.Data()["timestamp"].(time.Time)
+		if ok && next.before(ts, doc.ID) {
+			next, moved = Checkpoint{Timestamp: ts, DocID: doc.ID}, true
+		}
+	}
+	if !moved {
+		return nil
+	}
+	next.UpdatedAt = time.Now()
+	if err := cp.store.Set(ctx, cp.path, next); err != nil {
+		return err
+	}
+	cp.last = next
+	return nil
+}
+
+// addedDocs are the documents a snapshot added
+func addedDocs(snap *firestore.QuerySnapshot) []*Doc {
+	var added []*Doc
+	for _, change := range snap.Changes {
+		if change.Kind == firestore.DocumentAdded {
+			added = append(added, SnapshotDoc(change.Doc))
+		}
+	}
+	return added
+}
+
+// handled moves the checkpoint past added, a snapshot's documents, once the handler has processed
+// them: handleErr, the handler's error, is returned and the checkpoint left where it was when an
+// event failed, and nothing moves when ctx is done, since the handler may have stopped partway for
+// shutdown. Either way the events are delivered again from the checkpoint.
+func (cp *Checkpointer) handled(ctx context.Context, added []*Doc, handleErr error, logger *slog.Logger) error {
+	if handleErr != nil || ctx.Err() != nil {
+		return handleErr
+	}
+	if err := cp.advance(ctx, added); err != nil {
+		// The next save, or a replay from the older checkpoint, covers these events
+		logger.Warn("Failed to save listener checkpoint", "error", err)
+	}
+	return nil
+}
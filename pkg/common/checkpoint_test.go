@@ -0,0 +1,306 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// logEvents writes n events a second apart and returns them as a listener delivers them
+func logEvents(t *testing.T, store *MemStore, n int) []*Doc {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	docs := make([]*Doc, n)
+	for i := range docs {
+		path := fmt.Sprintf("events/e%d", i+1)
+		if err := store.Set(ctx, path, map[string]interface{}{"timestamp": start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := store.Get(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs[i] = doc
+	}
+	return docs
+}
+
+// watchEvent is an event in a watchServer's log
+type watchEvent struct {
+	id string
+	ts time.Time
+}
+
+// watchServer stands in for Firestore's Listen RPC, enough for a listener on the events collection.
+// A subscription gets each batch as one snapshot, without the events before its query's start
+// cursor, and the ID the cursor starts after is sent on starts ("" without one).
+type watchServer struct {
+	firestorepb.UnimplementedFirestoreServer
+	batches [][]watchEvent
+	starts  chan string
+}
+
+// newWatchServer serves batches on a local port and points Firestore clients created afterwards at it
+func newWatchServer(t *testing.T, batches ...[]watchEvent) *watchServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &watchServer{batches: batches, starts: make(chan string, 10)}
+	grpcServer := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	t.Setenv("FIRESTORE_EMULATOR_HOST", lis.Addr().String())
+	return srv
+}
+
+func (s *watchServer) Listen(stream firestorepb.Firestore_ListenServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	target := req.GetAddTarget()
+	query := target.GetQuery()
+	var from Checkpoint
+	if cursor := query.GetStructuredQuery().GetStartAt(); cursor != nil {
+		if cursor.Before {
+			return errors.New("watchServer only supports StartAfter cursors")
+		}
+		ref := cursor.Values[1].GetReferenceValue()
+		from = Checkpoint{Timestamp: cursor.Values[0].GetTimestampValue().AsTime(), DocID: ref[strings.LastIndex(ref, "/")+1:]}
+	}
+	s.starts <- from.DocID
+
+	ids := []int32{target.TargetId}
+	send := func(change *firestorepb.TargetChange) error {
+		return stream.Send(&firestorepb.ListenResponse{ResponseType: &firestorepb.ListenResponse_TargetChange{TargetChange: change}})
+	}
+	if err := send(&firestorepb.TargetChange{TargetChangeType: firestorepb.TargetChange_ADD, TargetIds: ids}); err != nil {
+		return err
+	}
+	for i, batch := range s.batches {
+		sent := 0
+		for _, e := range batch {
+			if from.DocID != "" && !from.before(e.ts, e.id) {
+				continue
+			}
+			doc := &firestorepb.Document{
+				Name:       query.Parent + "/events/" + e.id,
+				Fields:     map[string]*firestorepb.Value{"timestamp": {ValueType: &firestorepb.Value_TimestampValue{TimestampValue: timestamppb.New(e.ts)}}},
+				CreateTime: timestamppb.New(e.ts),
+				UpdateTime: timestamppb.New(e.ts),
+			}
+			if err := stream.Send(&firestorepb.ListenResponse{ResponseType: &firestorepb.ListenResponse_DocumentChange{
+				DocumentChange: &firestorepb.DocumentChange{Document: doc, TargetIds: ids},
+			}}); err != nil {
+				return err
+			}
+			sent++
+		}
+		// The first snapshot is sent even when empty, as Firestore does
+		if i == 0 {
+			if err := send(&firestorepb.TargetChange{TargetChangeType: firestorepb.TargetChange_CURRENT, TargetIds: ids}); err != nil {
+				return err
+			}
+		} else if sent == 0 {
+			continue
+		}
+		if err := send(&firestorepb.TargetChange{TargetChangeType: firestorepb.TargetChange_NO_CHANGE, ReadTime: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// listenEvents runs ListenFrom on the events collection from cp until done returns true for the
+// events delivered so far, one slice per snapshot, and returns them
+func listenEvents(t *testing.T, cp *Checkpointer, handle func(ids []string) error, done func(delivered [][]string) bool) [][]string {
+	t.Helper()
+	client, err := firestore.NewClient(context.Background(), "test-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rc := Reconnect{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var delivered [][]string
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		rc.ListenFrom(ctx, client.Collection("events").OrderBy("timestamp", firestore.Asc), cp, logger, nil, func(snap *firestore.QuerySnapshot) error {
+			ids := []string{}
+			for _, doc := range addedDocs(snap) {
+				ids = append(ids, doc.ID)
+			}
+			mu.Lock()
+			delivered = append(delivered, ids)
+			mu.Unlock()
+			return handle(ids)
+		})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		finished := done(delivered)
+		mu.Unlock()
+		if finished {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the listener; delivered %v", delivered)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	return delivered
+}
+
+func TestCheckpointResumesFromFailedEvent(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	e := func(i int) watchEvent {
+		return watchEvent{id: fmt.Sprintf("e%d", i), ts: start.Add(time.Duration(i) * time.Second)}
+	}
+	// e3 and e4 share a timestamp, so the resume position needs the document ID
+	e4 := e(4)
+	e4.ts = e(3).ts
+	server := newWatchServer(t, []watchEvent{e(1), e(2)}, []watchEvent{e(3), e4})
+
+	cp, err := LoadCheckpointer(ctx, store, "discount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first snapshot is processed. In the next, e3 times out once; the listener resubscribes
+	// after e2 and the retry succeeds.
+	failedOnce := false
+	handle := func(ids []string) error {
+		if slices.Contains(ids, "e3") && !failedOnce {
+			failedOnce = true
+			return errors.New("order event e3: context deadline exceeded")
+		}
+		return nil
+	}
+	saved := func([][]string) bool {
+		doc, err := store.Get(ctx, CollectionCheckpoints+"/discount")
+		return err == nil && doc.Data()["doc_id"] == "e4"
+	}
+	delivered := listenEvents(t, cp, handle, saved)
+	if got := fmt.Sprint(delivered); got != "[[e1 e2] [e3 e4] [] [e3 e4]]" {
+		t.Errorf("delivered %s, want [[e1 e2] [e3 e4] [] [e3 e4]]", got)
+	}
+	if got := fmt.Sprint(drain(server.starts)); got != "[ e2]" {
+		t.Errorf("subscriptions started after %q, want the beginning and then e2", got)
+	}
+
+	// A restarted listener resumes after e4, so nothing is delivered again
+	restarted, err := LoadCheckpointer(ctx, store, "discount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := restarted.Last(); last.DocID != "e4" || !last.Timestamp.Equal(e4.ts) || last.UpdatedAt.IsZero() {
+		t.Fatalf("checkpoint = %+v, want e4", last)
+	}
+	noFailures := func([]string) error { return nil }
+	delivered = listenEvents(t, restarted, noFailures, func(d [][]string) bool { return len(d) > 0 })
+	if got := fmt.Sprint(delivered); got != "[[]]" {
+		t.Errorf("restarted listener delivered %s, want one empty snapshot", got)
+	}
+	if got := fmt.Sprint(drain(server.starts)); got != "[e4]" {
+		t.Errorf("restarted subscription started after %s, want e4", got)
+	}
+}
+
+// drain returns what has been sent on ch so far
+func drain(ch chan string) []string {
+	var got []string
+	for {
+		select {
+		case s := <-ch:
+			got = append(got, s)
+		default:
+			return got
+		}
+	}
+}
+
+func TestCheckpointHeldAtShutdown(t *testing.T) {
+	store := NewMemStore()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	docs := logEvents(t, store, 2)
+	cp, err := LoadCheckpointer(context.Background(), store, "projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cp.handled(ctx, docs, nil, logger); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpointer(context.Background(), store, "projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := loaded.Last(); last.DocID != "" {
+		t.Errorf("a snapshot handled during shutdown moved the checkpoint to %+v", last)
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	docs := logEvents(t, store, 3)
+
+	cp, err := LoadCheckpointer(ctx, store, "discount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := cp.Last(); last.DocID != "" || !last.Timestamp.IsZero() {
+		t.Fatalf("a listener without a checkpoint loaded %+v", last)
+	}
+	// Out of order within a snapshot, the latest event is saved
+	if err := cp.advance(ctx, []*Doc{docs[1], docs[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpointer(ctx, store, "discount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := docs[1].Data()["timestamp"].(time.Time)
+	if last := loaded.Last(); last.DocID != "e2" || !last.Timestamp.Equal(want) {
+		t.Errorf("loaded %+v, want e2 at %s", last, want)
+	}
+	// Each listener has its own checkpoint
+	other, err := LoadCheckpointer(ctx, store, "projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Last().DocID != "" {
+		t.Errorf("the projection listener loaded the discount listener's checkpoint %+v", other.Last())
+	}
+}
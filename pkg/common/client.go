@@ -4,10 +4,25 @@ import (
 	"context"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// NewFirestoreClient connects to the database named by FIRESTORE_DATABASE, or the project's
+// default database when it is unset
 func NewFirestoreClient(ctx context.Context, projectID string) (*firestore.Client, error) {
+	return NewFirestoreClientWithDatabase(ctx, projectID, EnvOrDefault("FIRESTORE_DATABASE", firestore.DefaultDatabaseID))
+}
+
+// NewFirestoreClientWithDatabase connects to a named database, e.g. a regional one for data residency
+func NewFirestoreClientWithDatabase(ctx context.Context, projectID, databaseID string) (*firestore.Client, error) {
 	// If FIRESTORE_EMULATOR_HOST is set, the client library automatically uses it.
 	// We just need to make sure projectID matches.
-	return firestore.NewClient(ctx, projectID)
+	return firestore.NewClientWithDatabase(ctx, projectID, databaseID)
+}
+
+// IsNotFound reports whether err is Firestore's NotFound, such as from getting a document that
+// doesn't exist
+func IsNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
 }
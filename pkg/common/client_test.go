@@ -0,0 +1,32 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsNotFound(t *testing.T) {
+	_, missing := NewMemStore().Get(context.Background(), "reservations/o1")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"NotFound status", status.Error(codes.NotFound, "no such document"), true},
+		{"wrapped NotFound", fmt.Errorf("read reservation: %w", status.Error(codes.NotFound, "no such document")), true},
+		{"missing document in the fake", missing, true},
+		{"other status", status.Error(codes.Aborted, "transaction contention"), false},
+		{"generic error", errors.New("not found"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsNotFound(tt.err); got != tt.want {
+			t.Errorf("%s: IsNotFound(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
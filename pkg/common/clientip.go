@@ -0,0 +1,66 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is the set of networks whose X-Forwarded-For hops are believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma-separated CIDR list such as "10.0.0.0/8,192.168.1.1/32".
+// An empty spec trusts no proxies.
+func ParseTrustedProxies(spec string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, cidr := range strings.Split(spec, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (t TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the originating client address. Starting from RemoteAddr it walks
+// X-Forwarded-For right to left, moving past each hop only while the current one is a
+// trusted proxy, so a client can't spoof its address by prepending entries.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil || !t.trusts(ip) {
+		return addr
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		addr = hop.String()
+		if !t.trusts(hop) {
+			break
+		}
+	}
+	return addr
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CollectionDeadLetter holds events a consumer couldn't parse, one document per event keyed by the
+// event's document ID, so they can be inspected and replayed with cmd/dlq-replay
+const CollectionDeadLetter = "dead_letter"
+
+// Dead-letter states
+const (
+	DeadLetterPending  = "pending"  // not replayed yet
+	DeadLetterReplayed = "replayed" // republished as ReplayedAs
+	DeadLetterFailed   = "failed"   // the last replay failed with LastReplayError
+)
+
+// DeadLetter is an unparseable event as it was read, with the error it failed on
+type DeadLetter struct {
+	EventID        string                 `firestore:"event_id"`
+	Type           string                 `firestore:"type,omitempty"` // empty when the event has no string type
+	Data           map[string]interface{} `firestore:"data"`
+	Error          string                 `firestore:"error"`
+	Consumer       string                 `firestore:"consumer"`
+	DeadLetteredAt time.Time              `firestore:"dead_lettered_at"`
+	Status         string                 `firestore:"status"`
+
+	ReplayAttempts  int       `firestore:"replay_attempts"`
+	LastReplayAt    time.Time `firestore:"last_replay_at,omitempty"`
+	LastReplayError string    `firestore:"last_replay_error,omitempty"`
+	ReplayedAs      string    `firestore:"replayed_as,omitempty"` // ID of the republished event
+}
+
+// ParseEvent decodes doc into p, a pointer to an event, and validates it, so an event missing
+// required fields fails here rather than being processed with zero values
+func ParseEvent(doc *Doc, p interface{}) error {
+	if err := doc.DataTo(p); err != nil {
+		return err
+	}
+	if event, ok := p.(events.Event); ok {
+		return events.Validate(event)
+	}
+	return nil
+}
+
+// DeadLetterEvent records an event consumer failed to parse with parseErr. An event already
+// dead-lettered, say when a listener without a checkpoint reads it again, keeps its first record.
+func DeadLetterEvent(ctx context.Context, store DocStore, consumer string, doc *Doc, parseErr error) error {
+	data := doc.Data()
+	eventType, _ := GetString(data, "type")
+	record := DeadLetter{
+		EventID:        doc.ID,
+		Type:           eventType,
+		Data:           data,
+		Error:          parseErr.Error(),
+		Consumer:       consumer,
+		DeadLetteredAt: time.Now(),
+		Status:         DeadLetterPending,
+	}
+	err := store.Create(ctx, CollectionDeadLetter+"/"+doc.ID, record)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
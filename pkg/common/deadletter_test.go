@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+func TestParseEventDeadLettersMissingFields(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+
+	// An OrderCreated with neither order_id nor timestamp
+	raw := map[string]interface{}{"type": string(events.EventTypeOrderCreated), "base_price": 1000.0, "user_id": "u1"}
+	if err := store.Set(ctx, "events/e1", raw); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := store.Get(ctx, "events/e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event events.OrderCreated
+	parseErr := ParseEvent(doc, &event)
+	var invalid *events.ValidationError
+	if !errors.As(parseErr, &invalid) {
+		t.Fatalf("ParseEvent = %v, want a validation error", parseErr)
+	}
+
+	before := time.Now()
+	if err := DeadLetterEvent(ctx, store, "discount", doc, parseErr); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ctx, CollectionDeadLetter+"/e1")
+	if err != nil {
+		t.Fatalf("no dead-letter record: %v", err)
+	}
+	var record DeadLetter
+	if err := stored.DataTo(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.EventID != "e1" || record.Consumer != "discount" || record.Status != DeadLetterPending {
+		t.Errorf("record = %+v", record)
+	}
+	if record.Type != string(events.EventTypeOrderCreated) {
+		t.Errorf("type = %q, want %s", record.Type, events.EventTypeOrderCreated)
+	}
+	if record.Error != parseErr.Error() {
+		t.Errorf("error = %q, want %q", record.Error, parseErr.Error())
+	}
+	if record.DeadLetteredAt.Before(before.Truncate(time.Microsecond)) || record.DeadLetteredAt.After(time.Now()) {
+		t.Errorf("dead_lettered_at = %v, want the time of the call", record.DeadLetteredAt)
+	}
+	if record.Data["user_id"] != "u1" || record.Data["base_price"] != 1000.0 {
+		t.Errorf("data = %v, want the raw event %v", record.Data, raw)
+	}
+
+	// Reading the event again keeps the first record
+	if err := DeadLetterEvent(ctx, store, "order", doc, errors.New("later failure")); err != nil {
+		t.Fatalf("second dead-letter: %v", err)
+	}
+	again, _ := store.Get(ctx, CollectionDeadLetter+"/e1")
+	if again.Data()["consumer"] != "discount" {
+		t.Errorf("the first record was replaced: %v", again.Data())
+	}
+}
+
+func TestParseEventAcceptsValidEvents(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	release := events.NewDiscountRelease("t1", "", "o1", "Payment failed")
+	if err := store.Set(ctx, "events/e2", release); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := store.Get(ctx, "events/e2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.DiscountRelease
+	if err := ParseEvent(doc, &event); err != nil {
+		t.Fatalf("ParseEvent: %v", err)
+	}
+	if event.OrderID != "o1" || event.Reason != "Payment failed" {
+		t.Errorf("parsed %+v", event)
+	}
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// ResolveServices fills in an order's SelectedServices from order_details when the event only references them
+func ResolveServices(ctx context.Context, client *firestore.Client, e *events.OrderCreated) error {
+	if e.DetailsID == "" {
+		return nil
+	}
+	doc, err := client.Collection(events.CollectionOrderDetails).Doc(e.DetailsID).Get(ctx)
+	if err != nil {
+		return fmt.Errorf("order details %s: %w", e.DetailsID, err)
+	}
+	var details events.OrderDetails
+	if err := doc.DataTo(&details); err != nil {
+		return err
+	}
+	e.SelectedServices = details.SelectedServices
+	return nil
+}
@@ -0,0 +1,234 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// DocStore is the part of Firestore that transactional code uses: single documents addressed by
+// slash-separated path, such as "reservations/{order_id}", read and written directly or inside a
+// transaction. FirestoreStore backs it with a client; MemStore keeps the documents in memory, so
+// that code can be tested without the emulator. Queries and listeners stay on the client.
+type DocStore interface {
+	Get(ctx context.Context, path string) (*Doc, error)
+	// GetAll reads several documents at once; missing documents are nil
+	GetAll(ctx context.Context, paths []string) ([]*Doc, error)
+	Create(ctx context.Context, path string, data interface{}) error
+	Set(ctx context.Context, path string, data interface{}, opts ...firestore.SetOption) error
+	Update(ctx context.Context, path string, updates []firestore.Update) error
+	Delete(ctx context.Context, path string) error
+	// RunTransaction runs f, again if a document it read changed before it could commit
+	RunTransaction(ctx context.Context, f func(context.Context, DocTx) error) error
+	// NewDocID returns an unused ID for a new document in collection
+	NewDocID(collection string) string
+}
+
+// DocTx reads and writes documents in a transaction. As in Firestore, every read must come before
+// the first write, and the writes are applied together when the transaction commits.
+type DocTx interface {
+	Get(path string) (*Doc, error)
+	// GetAll reads several documents at once; missing documents are nil
+	GetAll(paths []string) ([]*Doc, error)
+	Create(path string, data interface{}) error
+	Set(path string, data interface{}, opts ...firestore.SetOption) error
+	Update(path string, updates []firestore.Update) error
+	Delete(path string) error
+}
+
+// Doc is a document read from a DocStore
+type Doc struct {
+	Path string // relative to the database, e.g. reservations/{order_id}
+	ID   string
+
+	data   map[string]interface{}
+	dataTo func(interface{}) error
+}
+
+// Data returns the document's fields
+func (d *Doc) Data() map[string]interface{} { return d.data }
+
+// DataTo decodes the document into p, a pointer to a struct or map, as DocumentSnapshot.DataTo does
+func (d *Doc) DataTo(p interface{}) error { return d.dataTo(p) }
+
+// SnapshotDoc wraps a document read through the client, such as one a listener delivered
+func SnapshotDoc(snap *firestore.DocumentSnapshot) *Doc {
+	return &Doc{Path: DocPath(snap.Ref), ID: snap.Ref.ID, data: snap.Data(), dataTo: snap.DataTo}
+}
+
+// DocPath is ref's path relative to its database, the form DocStore takes
+func DocPath(ref *firestore.DocumentRef) string {
+	if _, path, ok := strings.Cut(ref.Path, "/documents/"); ok {
+		return path
+	}
+	return ref.Path
+}
+
+// FirestoreStore is the DocStore backed by a Firestore client
+type FirestoreStore struct {
+	Client *firestore.Client
+}
+
+func (s FirestoreStore) ref(path string) (*firestore.DocumentRef, error) {
+	ref := s.Client.Doc(path)
+	if ref == nil {
+		return nil, fmt.Errorf("invalid document path %q", path)
+	}
+	return ref, nil
+}
+
+func (s FirestoreStore) refs(paths []string) ([]*firestore.DocumentRef, error) {
+	refs := make([]*firestore.DocumentRef, len(paths))
+	for i, path := range paths {
+		ref, err := s.ref(path)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// snapshotDocs converts the result of a GetAll, leaving missing documents nil
+func snapshotDocs(snaps []*firestore.DocumentSnapshot) []*Doc {
+	docs := make([]*Doc, len(snaps))
+	for i, snap := range snaps {
+		if snap != nil && snap.Exists() {
+			docs[i] = SnapshotDoc(snap)
+		}
+	}
+	return docs
+}
+
+func (s FirestoreStore) Get(ctx context.Context, path string) (*Doc, error) {
+	ref, err := s.ref(path)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotDoc(snap), nil
+}
+
+func (s FirestoreStore) GetAll(ctx context.Context, paths []string) ([]*Doc, error) {
+	refs, err := s.refs(paths)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := s.Client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotDocs(snaps), nil
+}
+
+func (s FirestoreStore) Create(ctx context.Context, path string, data interface{}) error {
+	ref, err := s.ref(path)
+	if err != nil {
+		return err
+	}
+	_, err = ref.Create(ctx, data)
+	return err
+}
+
+func (s FirestoreStore) Set(ctx context.Context, path string, data interface{}, opts ...firestore.SetOption) error {
+	ref, err := s.ref(path)
+	if err != nil {
+		return err
+	}
+	_, err = ref.Set(ctx, data, opts...)
+	return err
+}
+
+func (s FirestoreStore) Update(ctx context.Context, path string, updates []firestore.Update) error {
+	ref, err := s.ref(path)
+	if err != nil {
+		return err
+	}
+	_, err = ref.Update(ctx, updates)
+	return err
+}
+
+func (s FirestoreStore) Delete(ctx context.Context, path string) error {
+	ref, err := s.ref(path)
+	if err != nil {
+		return err
+	}
+	_, err = ref.Delete(ctx)
+	return err
+}
+
+func (s FirestoreStore) RunTransaction(ctx context.Context, f func(context.Context, DocTx) error) error {
+	return s.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return f(ctx, firestoreTx{store: s, tx: tx})
+	})
+}
+
+func (s FirestoreStore) NewDocID(collection string) string {
+	return s.Client.Collection(collection).NewDoc().ID
+}
+
+type firestoreTx struct {
+	store FirestoreStore
+	tx    *firestore.Transaction
+}
+
+func (t firestoreTx) Get(path string) (*Doc, error) {
+	ref, err := t.store.ref(path)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := t.tx.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotDoc(snap), nil
+}
+
+func (t firestoreTx) GetAll(paths []string) ([]*Doc, error) {
+	refs, err := t.store.refs(paths)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := t.tx.GetAll(refs)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotDocs(snaps), nil
+}
+
+func (t firestoreTx) Create(path string, data interface{}) error {
+	ref, err := t.store.ref(path)
+	if err != nil {
+		return err
+	}
+	return t.tx.Create(ref, data)
+}
+
+func (t firestoreTx) Set(path string, data interface{}, opts ...firestore.SetOption) error {
+	ref, err := t.store.ref(path)
+	if err != nil {
+		return err
+	}
+	return t.tx.Set(ref, data, opts...)
+}
+
+func (t firestoreTx) Update(path string, updates []firestore.Update) error {
+	ref, err := t.store.ref(path)
+	if err != nil {
+		return err
+	}
+	return t.tx.Update(ref, updates)
+}
+
+func (t firestoreTx) Delete(path string) error {
+	ref, err := t.store.ref(path)
+	if err != nil {
+		return err
+	}
+	return t.tx.Delete(ref)
+}
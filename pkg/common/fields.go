@@ -0,0 +1,24 @@
+package common
+
+import "math"
+
+// GetInt64 reads an integer field from a document's data without panicking on a malformed
+// document. Whole-number doubles, as written by clients without an integer type, are accepted.
+// ok is false when the field is missing or isn't an integer.
+func GetInt64(data map[string]interface{}, key string) (int64, bool) {
+	switch v := data[key].(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// GetString reads a string field from a document's data; ok is false when it is missing or not a string
+func GetString(data map[string]interface{}, key string) (string, bool) {
+	v, ok := data[key].(string)
+	return v, ok
+}
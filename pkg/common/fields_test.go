@@ -0,0 +1,42 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetInt64(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   map[string]interface{}
+		want   int64
+		wantOK bool
+	}{
+		{"int64", map[string]interface{}{"count": int64(7)}, 7, true},
+		{"whole double", map[string]interface{}{"count": 7.0}, 7, true},
+		{"fractional double", map[string]interface{}{"count": 7.5}, 0, false},
+		{"double out of range", map[string]interface{}{"count": math.Inf(1)}, 0, false},
+		{"string", map[string]interface{}{"count": "7"}, 0, false},
+		{"int", map[string]interface{}{"count": 7}, 0, false},
+		{"nil", map[string]interface{}{"count": nil}, 0, false},
+		{"missing", map[string]interface{}{}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := GetInt64(tt.data, "count")
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: GetInt64 = %d, %v; want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGetString(t *testing.T) {
+	data := map[string]interface{}{"type": "OrderCreated", "count": int64(1)}
+	if got, ok := GetString(data, "type"); !ok || got != "OrderCreated" {
+		t.Errorf("GetString(type) = %q, %v", got, ok)
+	}
+	for _, key := range []string{"count", "missing"} {
+		if got, ok := GetString(data, key); ok || got != "" {
+			t.Errorf("GetString(%s) = %q, %v; want not ok", key, got, ok)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Reconnect controls how a snapshot listener (re)subscribes. When Firestore recovers from an
+// outage every replica reconnects at once; the jitter spreads those subscriptions out and the
+// backoff stops a single replica from hammering a struggling backend.
+type Reconnect struct {
+	Jitter     time.Duration // random delay, up to this, before every subscription including the first
+	MinBackoff time.Duration // wait after the first failure, doubled per consecutive failure
+	MaxBackoff time.Duration // cap on the wait between attempts
+}
+
+// LoadReconnect reads LISTENER_JITTER (default 0), LISTENER_RETRY_MIN (default 1s) and
+// LISTENER_RETRY_MAX (default 30s)
+func LoadReconnect() (Reconnect, error) {
+	rc := Reconnect{MinBackoff: time.Second, MaxBackoff: 30 * time.Second}
+	var err error
+	if rc.Jitter, err = EnvDuration("LISTENER_JITTER", 0); err != nil {
+		return rc, err
+	}
+	if rc.MinBackoff, err = EnvDuration("LISTENER_RETRY_MIN", rc.MinBackoff); err != nil {
+		return rc, err
+	}
+	if rc.MaxBackoff, err = EnvDuration("LISTENER_RETRY_MAX", rc.MaxBackoff); err != nil {
+		return rc, err
+	}
+	if rc.Jitter < 0 || rc.MinBackoff <= 0 || rc.MaxBackoff < rc.MinBackoff {
+		return rc, fmt.Errorf("invalid listener reconnect settings: jitter %s, retry %s..%s", rc.Jitter, rc.MinBackoff, rc.MaxBackoff)
+	}
+	return rc, nil
+}
+
+// Listen subscribes to q and passes every snapshot to handle until ctx is done. A listener error
+// ends the subscription; Listen reports it to onError (which may be nil) and subscribes again after
+// the backoff plus jitter. A fresh subscription redelivers every matching document as added, so
+// handlers must be idempotent, as they already are for a restart.
+func (rc Reconnect) Listen(ctx context.Context, q firestore.Query, logger *slog.Logger, onError func(error), handle func(*firestore.QuerySnapshot)) {
+	rc.listen(ctx, func() firestore.Query { return q }, logger, onError, func(snap *firestore.QuerySnapshot) error {
+		handle(snap)
+		return nil
+	})
+}
+
+// ListenFrom is Listen for a query ordered by timestamp ascending that resumes after cp rather
+// than replaying the whole log. Every subscription, including one after an error, starts after
+// the checkpoint, which advances once handle has processed a snapshot unless ctx is done by then.
+//
+// handle returns an error when an event in the snapshot failed and should be retried. The
+// checkpoint then stays where it was, and the subscription ends as on a listener error, so the
+// next one delivers the failed event again, along with the ones after it. A nil cp behaves like
+// Listen, and the error only resubscribes.
+func (rc Reconnect) ListenFrom(ctx context.Context, q firestore.Query, cp *Checkpointer, logger *slog.Logger, onError func(error), handle func(*firestore.QuerySnapshot) error) {
+	if cp == nil {
+		rc.listen(ctx, func() firestore.Query { return q }, logger, onError, handle)
+		return
+	}
+	rc.listen(ctx, func() firestore.Query { return cp.resume(q) }, logger, onError, func(snap *firestore.QuerySnapshot) error {
+		return cp.handled(ctx, addedDocs(snap), handle(snap), logger)
+	})
+}
+
+func (rc Reconnect) listen(ctx context.Context, query func() firestore.Query, logger *slog.Logger, onError func(error), handle func(*firestore.QuerySnapshot) error) {
+	failures := 0
+	for {
+		wait := rc.backoff(failures) + randDuration(rc.Jitter)
+		if wait > 0 {
+			if failures > 0 {
+				logger.Info("Resubscribing listener", "attempt", failures+1, "wait", wait.String())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		err := listenOnce(query().Snapshots(ctx), handle, func() { failures = 0 })
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		failures++
+		if onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// listenOnce drains one subscription until it fails or handle does; reset is called on every
+// snapshot so a healthy stream clears the failure count
+func listenOnce(iter *firestore.QuerySnapshotIterator, handle func(*firestore.QuerySnapshot) error, reset func()) error {
+	defer iter.Stop()
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reset()
+		if err := handle(snap); err != nil {
+			return fmt.Errorf("handle snapshot: %w", err)
+		}
+	}
+}
+
+// backoff is the wait before attempt failures+1: none for the first subscription, then
+// MinBackoff doubling up to MaxBackoff
+func (rc Reconnect) backoff(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	wait := rc.MinBackoff
+	for i := 1; i < failures && wait < rc.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, rc.MaxBackoff)
+}
+
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// returnsOnCancel runs listen, cancels its context after a moment and fails unless it returns
+func returnsOnCancel(t *testing.T, listen func(ctx context.Context)) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		listen(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener kept running after its context was cancelled")
+	}
+}
+
+func TestListenStopsOnCancel(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handle := func(*firestore.QuerySnapshot) { t.Error("handled a snapshot from an unreachable Firestore") }
+
+	t.Run("waiting to subscribe", func(t *testing.T) {
+		rc := Reconnect{Jitter: time.Hour, MinBackoff: time.Second, MaxBackoff: time.Second}
+		returnsOnCancel(t, func(ctx context.Context) {
+			rc.Listen(ctx, firestore.Query{}, logger, nil, handle)
+		})
+	})
+
+	t.Run("subscribed", func(t *testing.T) {
+		// Nothing listens on the emulator address, so the subscription fails and retries until cancelled
+		t.Setenv("FIRESTORE_EMULATOR_HOST", "127.0.0.1:1")
+		client, err := firestore.NewClient(context.Background(), "test-project")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		rc := Reconnect{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+		returnsOnCancel(t, func(ctx context.Context) {
+			rc.Listen(ctx, client.Collection("events").Query, logger, nil, handle)
+		})
+	})
+}
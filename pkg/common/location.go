@@ -0,0 +1,34 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// Locations is the allowlist of clinic locations that have their own daily quota
+type Locations map[string]bool
+
+// ParseLocations builds an allowlist from location IDs, e.g. from QUOTA_LOCATIONS
+func ParseLocations(ids []string) (Locations, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("at least one location is required")
+	}
+	locations := make(Locations, len(ids))
+	for _, id := range ids {
+		locations[id] = true
+	}
+	return locations, nil
+}
+
+// Resolve maps an order's location to its quota namespace. An empty ID means the default
+// global location; anything not on the allowlist is an error.
+func (l Locations) Resolve(id string) (string, error) {
+	if id == "" {
+		id = events.DefaultLocation
+	}
+	if !l[id] {
+		return "", fmt.Errorf("unknown location %q", id)
+	}
+	return id, nil
+}
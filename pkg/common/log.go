@@ -0,0 +1,21 @@
+package common
+
+import (
+	"log/slog"
+	"os"
+)
+
+// LevelCritical marks conditions that need an operator, such as leaked quota
+const LevelCritical = slog.LevelError + 4
+
+// NewLogger returns the services' JSON logger, which renders LevelCritical as "CRITICAL"
+func NewLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any() == LevelCritical {
+				a.Value = slog.StringValue("CRITICAL")
+			}
+			return a
+		},
+	}))
+}
@@ -0,0 +1,322 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// MemStore's codec follows the Firestore client's: struct fields are named by their firestore tag
+// (the field name without one), "-" skips a field, omitempty skips a zero value, and embedded
+// structs are flattened into their parent.
+
+var (
+	typeOfTime     = reflect.TypeOf(time.Time{})
+	typeOfSentinel = reflect.TypeOf(firestore.Delete)
+)
+
+// encodeDoc converts a document's data, a struct or map, into stored form. allowDelete permits
+// firestore.Delete values, as a Set with MergeAll does.
+func encodeDoc(data interface{}, allowDelete bool) (map[string]interface{}, error) {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("document data must be a struct or map, got %T", data)
+	}
+	encoded, err := encodeValue(v, allowDelete)
+	if err != nil {
+		return nil, err
+	}
+	return encoded.(map[string]interface{}), nil
+}
+
+func encodeValue(v reflect.Value, allowDelete bool) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch v.Type() {
+	case typeOfTime:
+		return memTime(v.Interface().(time.Time)), nil
+	case typeOfSentinel:
+		switch v.Interface() {
+		case firestore.ServerTimestamp:
+			return memTime(time.Now()), nil
+		case firestore.Delete:
+			if allowDelete {
+				return firestore.Delete, nil
+			}
+		}
+		return nil, fmt.Errorf("%v can't be used here", v.Interface())
+	}
+	if v.Type().PkgPath() == typeOfSentinel.PkgPath() {
+		// Field transforms such as Increment, and document references
+		return nil, fmt.Errorf("MemStore doesn't support %s values", v.Type())
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(v.Elem(), allowDelete)
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte(nil), v.Bytes()...), nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			elem, err := encodeValue(v.Index(i), false)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = elem
+		}
+		return out, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, got %s", v.Type())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := encodeValue(iter.Value(), allowDelete)
+			if err != nil {
+				return nil, err
+			}
+			out[iter.Key().String()] = elem
+		}
+		return out, nil
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		if err := encodeStruct(v, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func encodeStruct(v reflect.Value, out map[string]interface{}) error {
+	for _, f := range structFields(v.Type()) {
+		field := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(field) {
+			continue
+		}
+		value, err := encodeValue(field, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		out[f.name] = value
+	}
+	return nil
+}
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields lists t's stored fields, flattening untagged embedded structs
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("firestore")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, inner := range structFields(f.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, index: []int{i}, omitEmpty: strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	if v.Type() == typeOfTime {
+		return v.Interface().(time.Time).IsZero()
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
+
+// copyValue deep-copies stored data, so callers can't change the store through what they read
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			out[key] = copyValue(elem)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = copyValue(elem)
+		}
+		return out
+	case []byte:
+		return append([]byte(nil), v...)
+	}
+	return v
+}
+
+// decodeDoc decodes stored data into p, a pointer to a struct or map
+func decodeDoc(data map[string]interface{}, p interface{}) error {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("DataTo needs a non-nil pointer, got %T", p)
+	}
+	return decodeValue(v.Elem(), copyValue(data))
+}
+
+func decodeValue(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Type() == typeOfTime {
+		t, ok := src.(time.Time)
+		if !ok {
+			return mismatch(dst, src)
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return mismatch(dst, src)
+		}
+		dst.Set(reflect.ValueOf(src))
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decodeValue(dst.Elem(), src)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch(dst, src)
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch(dst, src)
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := src.(int64)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch(dst, src)
+		}
+		dst.SetInt(n)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		n, ok := src.(int64)
+		if !ok || n < 0 || dst.OverflowUint(uint64(n)) {
+			return mismatch(dst, src)
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch n := src.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch(dst, src)
+		}
+	case reflect.Slice:
+		if b, ok := src.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(b)
+			return nil
+		}
+		elems, ok := src.([]interface{})
+		if !ok {
+			return mismatch(dst, src)
+		}
+		out := reflect.MakeSlice(dst.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := decodeValue(out.Index(i), elem); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch(dst, src)
+		}
+		out := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for key, elem := range m {
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(value, elem); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), value)
+		}
+		dst.Set(out)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch(dst, src)
+		}
+		for _, f := range structFields(dst.Type()) {
+			value, present := m[f.name]
+			if !present {
+				continue
+			}
+			if err := decodeValue(dst.FieldByIndex(f.index), value); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+	default:
+		return mismatch(dst, src)
+	}
+	return nil
+}
+
+func mismatch(dst reflect.Value, src interface{}) error {
+	return fmt.Errorf("cannot set type %s to %T", dst.Type(), src)
+}
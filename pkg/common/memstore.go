@@ -0,0 +1,406 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memTxAttempts matches the Firestore client's default number of transaction attempts
+const memTxAttempts = 5
+
+// MemStore is an in-memory DocStore for tests. Documents are stored as Firestore would store them
+// (structs become maps by their firestore tags, integers int64, times UTC to the microsecond), so
+// DataTo behaves as it does against Firestore. Transactions are optimistic, as Firestore's are
+// for contended documents: one whose reads changed before it committed is run again, up to five
+// attempts, and its writes are applied all together or not at all.
+type MemStore struct {
+	mu       sync.Mutex
+	docs     map[string]map[string]interface{}
+	versions map[string]int64 // bumped by every write to a path, deletes included
+	ids      int
+}
+
+// NewMemStore returns an empty store
+func NewMemStore() *MemStore {
+	return &MemStore{docs: make(map[string]map[string]interface{}), versions: make(map[string]int64)}
+}
+
+// checkDocPath rejects paths that don't name a document: an even number of non-empty segments
+func checkDocPath(path string) error {
+	segments := strings.Split(path, "/")
+	if len(segments)%2 != 0 {
+		return fmt.Errorf("invalid document path %q", path)
+	}
+	for _, s := range segments {
+		if s == "" {
+			return fmt.Errorf("invalid document path %q", path)
+		}
+	}
+	return nil
+}
+
+func notFound(path string) error {
+	return status.Errorf(codes.NotFound, "document %s not found", path)
+}
+
+// doc returns a copy of the stored document, nil when it doesn't exist. s.mu must be held.
+func (s *MemStore) doc(path string) *Doc {
+	data, ok := s.docs[path]
+	if !ok {
+		return nil
+	}
+	return memDoc(path, copyValue(data).(map[string]interface{}))
+}
+
+func memDoc(path string, data map[string]interface{}) *Doc {
+	id := path[strings.LastIndex(path, "/")+1:]
+	return &Doc{Path: path, ID: id, data: data, dataTo: func(p interface{}) error { return decodeDoc(data, p) }}
+}
+
+func (s *MemStore) Get(ctx context.Context, path string) (*Doc, error) {
+	if err := checkDocPath(path); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if doc := s.doc(path); doc != nil {
+		return doc, nil
+	}
+	return nil, notFound(path)
+}
+
+func (s *MemStore) GetAll(ctx context.Context, paths []string) ([]*Doc, error) {
+	for _, path := range paths {
+		if err := checkDocPath(path); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make([]*Doc, len(paths))
+	for i, path := range paths {
+		docs[i] = s.doc(path)
+	}
+	return docs, nil
+}
+
+func (s *MemStore) Create(ctx context.Context, path string, data interface{}) error {
+	return s.write(memCreate(path, data))
+}
+
+func (s *MemStore) Set(ctx context.Context, path string, data interface{}, opts ...firestore.SetOption) error {
+	return s.write(memSet(path, data, opts))
+}
+
+func (s *MemStore) Update(ctx context.Context, path string, updates []firestore.Update) error {
+	return s.write(memUpdate(path, updates))
+}
+
+func (s *MemStore) Delete(ctx context.Context, path string) error {
+	return s.write(memDelete(path))
+}
+
+// write applies a single write outside a transaction
+func (s *MemStore) write(w memWrite, err error) error {
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply([]memWrite{w})
+}
+
+// apply performs writes atomically: all of them, or none if one fails. s.mu must be held.
+func (s *MemStore) apply(writes []memWrite) error {
+	staged := make(map[string]map[string]interface{})
+	current := func(path string) (map[string]interface{}, bool) {
+		if data, ok := staged[path]; ok {
+			return data, data != nil
+		}
+		data, ok := s.docs[path]
+		if !ok {
+			return nil, false
+		}
+		return copyValue(data).(map[string]interface{}), true
+	}
+	for _, w := range writes {
+		data, exists := current(w.path)
+		next, err := w.apply(data, exists)
+		if err != nil {
+			return err
+		}
+		staged[w.path] = next
+	}
+	for path, data := range staged {
+		if data == nil {
+			delete(s.docs, path)
+		} else {
+			s.docs[path] = data
+		}
+		s.versions[path]++
+	}
+	return nil
+}
+
+func (s *MemStore) RunTransaction(ctx context.Context, f func(context.Context, DocTx) error) error {
+	for attempt := 0; attempt < memTxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tx := &memTx{store: s, reads: make(map[string]int64)}
+		if err := f(ctx, tx); err != nil {
+			return err
+		}
+		committed, err := s.commit(tx)
+		if committed || err != nil {
+			return err
+		}
+	}
+	return status.Error(codes.Aborted, "transaction aborted: too much contention")
+}
+
+// commit applies tx's writes unless a document it read has changed since, reporting whether it did
+func (s *MemStore) commit(tx *memTx) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, version := range tx.reads {
+		if s.versions[path] != version {
+			return false, nil
+		}
+	}
+	return true, s.apply(tx.writes)
+}
+
+func (s *MemStore) NewDocID(collection string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids++
+	return fmt.Sprintf("doc%016d", s.ids)
+}
+
+// List returns the documents directly in collection, ordered by ID
+func (s *MemStore) List(collection string) []*Doc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var docs []*Doc
+	for path := range s.docs {
+		if parent, _, ok := cutLast(path); ok && parent == collection {
+			docs = append(docs, s.doc(path))
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	return docs
+}
+
+func cutLast(path string) (string, string, bool) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i], path[i+1:], true
+}
+
+type memTx struct {
+	store  *MemStore
+	reads  map[string]int64 // version of each document when read
+	writes []memWrite
+}
+
+func (t *memTx) Get(path string) (*Doc, error) {
+	docs, err := t.GetAll([]string{path})
+	if err != nil {
+		return nil, err
+	}
+	if docs[0] == nil {
+		return nil, notFound(path)
+	}
+	return docs[0], nil
+}
+
+func (t *memTx) GetAll(paths []string) ([]*Doc, error) {
+	if len(t.writes) > 0 {
+		return nil, fmt.Errorf("read after write in transaction")
+	}
+	for _, path := range paths {
+		if err := checkDocPath(path); err != nil {
+			return nil, err
+		}
+	}
+	s := t.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make([]*Doc, len(paths))
+	for i, path := range paths {
+		t.reads[path] = s.versions[path]
+		docs[i] = s.doc(path)
+	}
+	return docs, nil
+}
+
+func (t *memTx) Create(path string, data interface{}) error {
+	return t.add(memCreate(path, data))
+}
+
+func (t *memTx) Set(path string, data interface{}, opts ...firestore.SetOption) error {
+	return t.add(memSet(path, data, opts))
+}
+
+func (t *memTx) Update(path string, updates []firestore.Update) error {
+	return t.add(memUpdate(path, updates))
+}
+
+func (t *memTx) Delete(path string) error {
+	return t.add(memDelete(path))
+}
+
+func (t *memTx) add(w memWrite, err error) error {
+	if err != nil {
+		return err
+	}
+	t.writes = append(t.writes, w)
+	return nil
+}
+
+// memWrite is one pending write. apply returns the document's new data, nil to delete it.
+type memWrite struct {
+	path  string
+	apply func(data map[string]interface{}, exists bool) (map[string]interface{}, error)
+}
+
+// Writes encode their data when they are made, so invalid data fails the call as it does in Firestore
+
+func memCreate(path string, data interface{}) (memWrite, error) {
+	if err := checkDocPath(path); err != nil {
+		return memWrite{}, err
+	}
+	fields, err := encodeDoc(data, false)
+	if err != nil {
+		return memWrite{}, err
+	}
+	return memWrite{path: path, apply: func(_ map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		if exists {
+			return nil, status.Errorf(codes.AlreadyExists, "document %s already exists", path)
+		}
+		return fields, nil
+	}}, nil
+}
+
+func memSet(path string, data interface{}, opts []firestore.SetOption) (memWrite, error) {
+	merge := false
+	for _, opt := range opts {
+		if !reflect.DeepEqual(opt, firestore.MergeAll) {
+			return memWrite{}, fmt.Errorf("MemStore only supports the MergeAll set option")
+		}
+		merge = true
+	}
+	if err := checkDocPath(path); err != nil {
+		return memWrite{}, err
+	}
+	fields, err := encodeDoc(data, merge)
+	if err != nil {
+		return memWrite{}, err
+	}
+	return memWrite{path: path, apply: func(current map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		if !merge || !exists {
+			return withoutDeletes(fields), nil
+		}
+		mergeFields(current, fields)
+		return current, nil
+	}}, nil
+}
+
+func memUpdate(path string, updates []firestore.Update) (memWrite, error) {
+	if err := checkDocPath(path); err != nil {
+		return memWrite{}, err
+	}
+	type fieldUpdate struct {
+		path  []string
+		value interface{}
+	}
+	fields := make([]fieldUpdate, len(updates))
+	for i, u := range updates {
+		fieldPath := []string(u.FieldPath)
+		if u.Path != "" {
+			fieldPath = strings.Split(u.Path, ".")
+		}
+		if len(fieldPath) == 0 {
+			return memWrite{}, fmt.Errorf("update %d has no field path", i)
+		}
+		value, err := encodeValue(reflect.ValueOf(u.Value), true)
+		if err != nil {
+			return memWrite{}, err
+		}
+		fields[i] = fieldUpdate{path: fieldPath, value: value}
+	}
+	return memWrite{path: path, apply: func(current map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		if !exists {
+			return nil, notFound(path)
+		}
+		for _, f := range fields {
+			m := current
+			for _, key := range f.path[:len(f.path)-1] {
+				next, ok := m[key].(map[string]interface{})
+				if !ok {
+					next = make(map[string]interface{})
+					m[key] = next
+				}
+				m = next
+			}
+			last := f.path[len(f.path)-1]
+			if f.value == firestore.Delete {
+				delete(m, last)
+			} else {
+				m[last] = f.value
+			}
+		}
+		return current, nil
+	}}, nil
+}
+
+func memDelete(path string) (memWrite, error) {
+	if err := checkDocPath(path); err != nil {
+		return memWrite{}, err
+	}
+	return memWrite{path: path, apply: func(map[string]interface{}, bool) (map[string]interface{}, error) {
+		return nil, nil
+	}}, nil
+}
+
+// mergeFields sets every field of src in dst, recursing into maps as MergeAll does and removing
+// fields set to firestore.Delete
+func mergeFields(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if value == firestore.Delete {
+			delete(dst, key)
+			continue
+		}
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				mergeFields(dstMap, srcMap)
+				continue
+			}
+			value = withoutDeletes(srcMap)
+		}
+		dst[key] = value
+	}
+}
+
+func withoutDeletes(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	mergeFields(out, m)
+	return out
+}
+
+// memTime stores times as Firestore returns them
+func memTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
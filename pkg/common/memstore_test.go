@@ -0,0 +1,320 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const counterPath = "daily_quotas/2026-10-15"
+
+// addToCount moves the counter by delta in a transaction, never below zero, and reports whether it moved
+func addToCount(ctx context.Context, store DocStore, delta int64) (bool, error) {
+	moved := false
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx DocTx) error {
+		moved = false
+		count := int64(0)
+		doc, err := tx.Get(counterPath)
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			count, _ = GetInt64(doc.Data(), "count")
+		}
+		if count+delta < 0 {
+			return nil
+		}
+		moved = true
+		return tx.Set(counterPath, map[string]interface{}{"count": count + delta}, firestore.MergeAll)
+	})
+	return moved, err
+}
+
+func readCount(t *testing.T, store DocStore) int64 {
+	t.Helper()
+	doc, err := store.Get(context.Background(), counterPath)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	count, ok := GetInt64(doc.Data(), "count")
+	if !ok {
+		t.Fatalf("count is %T, want an integer", doc.Data()["count"])
+	}
+	return count
+}
+
+func TestMemStoreConcurrentIncrements(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+
+	const workers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// Like Firestore, the store gives up after five conflicting attempts
+				_, err := addToCount(ctx, store, 1)
+				if status.Code(err) == codes.Aborted {
+					continue
+				}
+				if err != nil {
+					t.Errorf("increment: %v", err)
+				}
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := readCount(t, store); got != workers {
+		t.Errorf("count = %d after %d concurrent increments, want %d", got, workers, workers)
+	}
+}
+
+func TestMemStoreRetriesConflictingTransaction(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	if err := store.Set(ctx, counterPath, map[string]interface{}{"count": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx DocTx) error {
+		attempts++
+		doc, err := tx.Get(counterPath)
+		if err != nil {
+			return err
+		}
+		count, _ := GetInt64(doc.Data(), "count")
+		if attempts == 1 {
+			// Another writer commits between this transaction's read and its commit
+			if _, err := addToCount(ctx, store, 1); err != nil {
+				return err
+			}
+		}
+		return tx.Set(counterPath, map[string]interface{}{"count": count + 1})
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("transaction ran %d times, want 2", attempts)
+	}
+	if got := readCount(t, store); got != 3 {
+		t.Errorf("count = %d, want 3: the retry must see the other writer's increment", got)
+	}
+}
+
+func TestMemStoreDecrementStopsAtZero(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	for _, delta := range []int64{1, 1, -1} {
+		if _, err := addToCount(ctx, store, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		want      int64
+		wantMoved bool
+	}{
+		{want: 0, wantMoved: true},
+		{want: 0, wantMoved: false},
+		{want: 0, wantMoved: false},
+	}
+	for i, tt := range tests {
+		moved, err := addToCount(ctx, store, -1)
+		if err != nil {
+			t.Fatalf("decrement %d: %v", i+1, err)
+		}
+		if moved != tt.wantMoved {
+			t.Errorf("decrement %d moved = %v, want %v", i+1, moved, tt.wantMoved)
+		}
+		if got := readCount(t, store); got != tt.want {
+			t.Errorf("after decrement %d count = %d, want %d", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestMemStoreTransactionIsAtomic(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	if err := store.Create(ctx, "order_decisions/o1", map[string]interface{}{"type": "DiscountReserved"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx DocTx) error {
+		if err := tx.Set(counterPath, map[string]interface{}{"count": 1}); err != nil {
+			return err
+		}
+		return tx.Create("order_decisions/o1", map[string]interface{}{"type": "DiscountRejected"})
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("err = %v, want AlreadyExists", err)
+	}
+	if _, err := store.Get(ctx, counterPath); !IsNotFound(err) {
+		t.Errorf("the counter was written by a failed transaction (err = %v)", err)
+	}
+}
+
+func TestMemStoreTransactionErrors(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name  string
+		body  func(tx DocTx) error
+		check func(error) bool
+	}{
+		{"read after write", func(tx DocTx) error {
+			if err := tx.Set(counterPath, map[string]interface{}{"count": 1}); err != nil {
+				return err
+			}
+			_, err := tx.Get(counterPath)
+			return err
+		}, func(err error) bool { return err != nil }},
+		{"update missing document", func(tx DocTx) error {
+			return tx.Update("reservations/missing", []firestore.Update{{Path: "released", Value: true}})
+		}, IsNotFound},
+		{"get missing document", func(tx DocTx) error {
+			_, err := tx.Get("reservations/missing")
+			return err
+		}, IsNotFound},
+		{"collection path", func(tx DocTx) error {
+			_, err := tx.Get("reservations")
+			return err
+		}, func(err error) bool { return err != nil && !IsNotFound(err) }},
+		{"body error", func(tx DocTx) error { return errStop }, func(err error) bool { return errors.Is(err, errStop) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.RunTransaction(ctx, func(ctx context.Context, tx DocTx) error { return tt.body(tx) })
+			if !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+	if _, err := store.Get(ctx, counterPath); !IsNotFound(err) {
+		t.Errorf("a failed transaction wrote the counter (err = %v)", err)
+	}
+}
+
+type memBase struct {
+	Type      string    `firestore:"type"`
+	Timestamp time.Time `firestore:"timestamp"`
+}
+
+type memLevel string
+
+type memRecord struct {
+	memBase
+	OrderID  string            `firestore:"order_id"`
+	Count    int               `firestore:"count"`
+	Price    float64           `firestore:"price"`
+	Level    memLevel          `firestore:"level"`
+	Tags     []string          `firestore:"tags"`
+	Limits   map[string]int64  `firestore:"limits"`
+	Note     string            `firestore:"note,omitempty"`
+	Skipped  string            `firestore:"-"`
+	Untagged bool              `firestore:""`
+	Extra    map[string]string `firestore:"extra,omitempty"`
+}
+
+func TestMemStoreRoundTrip(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	// Stored as Firestore returns it: UTC, microsecond precision
+	ts := time.Date(2026, 10, 15, 9, 30, 0, 123456789, time.FixedZone("IST", 5*3600+1800))
+	in := memRecord{
+		memBase: memBase{Type: "OrderCreated", Timestamp: ts},
+		OrderID: "o1",
+		Count:   3,
+		Price:   499.5,
+		Level:   "gold",
+		Tags:    []string{"a", "b"},
+		Limits:  map[string]int64{"global": 100},
+		Skipped: "not stored",
+	}
+	if err := store.Set(ctx, "records/o1", in); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := store.Get(ctx, "records/o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := doc.Data()
+	for _, field := range []string{"type", "timestamp", "order_id", "count", "Untagged"} {
+		if _, ok := data[field]; !ok {
+			t.Errorf("field %q missing from %v", field, data)
+		}
+	}
+	for _, field := range []string{"note", "extra", "Skipped", "memBase"} {
+		if _, ok := data[field]; ok {
+			t.Errorf("field %q should not be stored", field)
+		}
+	}
+	if _, ok := data["count"].(int64); !ok {
+		t.Errorf("count stored as %T, want int64", data["count"])
+	}
+
+	var out memRecord
+	if err := doc.DataTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := in
+	want.Skipped = ""
+	want.Timestamp = ts.UTC().Truncate(time.Microsecond)
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("round trip = %+v, want %+v", out, want)
+	}
+
+	// What a reader changes doesn't reach the store
+	data["order_id"] = "changed"
+	if again, _ := store.Get(ctx, "records/o1"); again.Data()["order_id"] != "o1" {
+		t.Error("changing read data changed the stored document")
+	}
+}
+
+func TestMemStoreMergeAndUpdate(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+	path := "quota_monthly/global/months/2026-10"
+	if err := store.Set(ctx, path, map[string]interface{}{"month": "2026-10", "days": map[string]interface{}{"2026-10-01": 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, path, map[string]interface{}{"days": map[string]interface{}{"2026-10-02": 7}}, firestore.MergeAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(ctx, path, []firestore.Update{
+		{Path: "days.2026-10-03", Value: 9},
+		{Path: "month", Value: firestore.Delete},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := store.Get(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"days": map[string]interface{}{"2026-10-01": int64(5), "2026-10-02": int64(7), "2026-10-03": int64(9)},
+	}
+	if !reflect.DeepEqual(doc.Data(), want) {
+		t.Errorf("document = %v, want %v", doc.Data(), want)
+	}
+
+	if err := store.Set(ctx, path, map[string]interface{}{"n": firestore.Increment(1)}, firestore.MergeAll); err == nil {
+		t.Error("Increment was accepted, but MemStore can't apply transforms")
+	}
+}
@@ -0,0 +1,282 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/api/iterator"
+)
+
+// EventMirror is a second event bus every published event is copied to while the system migrates
+// off the Firestore events collection. Events keep their Firestore document ID on the mirror, so
+// the verifier can find each one on both sides.
+type EventMirror interface {
+	Publish(ctx context.Context, id string, event events.Event) error
+	// Get returns the event's JSON payload; found is false when the mirror doesn't have it
+	Get(ctx context.Context, id string) (payload []byte, found bool, err error)
+}
+
+// HTTPMirror talks to a bridge in front of the new bus (e.g. a Pub/Sub or Kafka REST adapter) that
+// accepts PUT {URL}/{id} with the event's JSON and serves it back on GET {URL}/{id}
+type HTTPMirror struct {
+	URL    string
+	Client *http.Client
+}
+
+func (m *HTTPMirror) eventURL(id string) string {
+	return m.URL + "/" + url.PathEscape(id)
+}
+
+func (m *HTTPMirror) Publish(ctx context.Context, id string, event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.eventURL(id), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("mirror returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (m *HTTPMirror) Get(ctx context.Context, id string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.eventURL(id), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("mirror returned %s", resp.Status)
+	}
+	payload, err := io.ReadAll(resp.Body)
+	return payload, err == nil, err
+}
+
+// MirrorConfig enables dual writes and their verification
+type MirrorConfig struct {
+	Mirror         EventMirror   // nil when EVENT_MIRROR_URL is unset
+	VerifyInterval time.Duration // how often the verifier runs; 0 disables it
+	VerifySample   float64       // fraction of events checked
+	VerifyGrace    time.Duration // events younger than this are left for the next run
+}
+
+// LoadMirrorConfig reads EVENT_MIRROR_URL, EVENT_MIRROR_TIMEOUT (default 2s),
+// EVENT_MIRROR_VERIFY_INTERVAL (default 0, disabled), EVENT_MIRROR_VERIFY_SAMPLE (default 0.1)
+// and EVENT_MIRROR_VERIFY_GRACE (default 30s)
+func LoadMirrorConfig() (MirrorConfig, error) {
+	var cfg MirrorConfig
+	target := EnvOrDefault("EVENT_MIRROR_URL", "")
+	if target == "" {
+		return cfg, nil
+	}
+	timeout, err := EnvDuration("EVENT_MIRROR_TIMEOUT", 2*time.Second)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Mirror = &HTTPMirror{URL: target, Client: &http.Client{Timeout: timeout}}
+
+	if cfg.VerifyInterval, err = EnvDuration("EVENT_MIRROR_VERIFY_INTERVAL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.VerifyGrace, err = EnvDuration("EVENT_MIRROR_VERIFY_GRACE", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.VerifySample, err = EnvFloat("EVENT_MIRROR_VERIFY_SAMPLE", 0.1); err != nil {
+		return cfg, err
+	}
+	if cfg.VerifySample <= 0 || cfg.VerifySample > 1 {
+		return cfg, fmt.Errorf("EVENT_MIRROR_VERIFY_SAMPLE must be in (0, 1], got %g", cfg.VerifySample)
+	}
+	return cfg, nil
+}
+
+var (
+	mirrorPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_mirror_publish_failures_total",
+		Help: "Events written to Firestore but not to the mirror bus, by event type.",
+	}, []string{"type"})
+
+	mirrorChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_mirror_checks_total",
+		Help: "Events sampled by the mirror verifier, by result (match, missing, mismatch or error).",
+	}, []string{"result"})
+)
+
+// MirrorEvent copies an event already written to Firestore as id. Failures are logged and
+// counted but never returned: Firestore stays the source of truth until cutover.
+func (p *Publisher) MirrorEvent(ctx context.Context, id string, event events.Event) {
+	if p.Mirror == nil {
+		return
+	}
+	if err := p.Mirror.Publish(ctx, id, event); err != nil {
+		mirrorPublishFailures.WithLabelValues(string(event.EventType())).Inc()
+		p.Logger.Warn("Mirror publish failed", "id", id, "type", event.EventType(), "error", err)
+	}
+}
+
+// VerifyMirrorLoop checks every interval that a sample of the events written to collection in the
+// previous interval reached the mirror with the same payload
+func VerifyMirrorLoop(ctx context.Context, collection *firestore.CollectionRef, cfg MirrorConfig, logger *slog.Logger) {
+	if cfg.Mirror == nil || cfg.VerifyInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.VerifyInterval)
+	defer ticker.Stop()
+
+	end := time.Now().Add(-cfg.VerifyGrace)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := end
+		end = time.Now().Add(-cfg.VerifyGrace)
+		if err := verifyMirror(ctx, collection, cfg, start, end, logger); err != nil {
+			logger.Error("Mirror verification failed", "error", err)
+		}
+	}
+}
+
+func verifyMirror(ctx context.Context, collection *firestore.CollectionRef, cfg MirrorConfig, start, end time.Time, logger *slog.Logger) error {
+	iter := collection.
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		Documents(ctx)
+	defer iter.Stop()
+
+	results := make(map[string]int)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if rand.Float64() >= cfg.VerifySample {
+			continue
+		}
+		result := checkMirrored(ctx, cfg.Mirror, doc, logger)
+		mirrorChecks.WithLabelValues(result).Inc()
+		results[result]++
+	}
+	logger.Info("Mirror verification", "from", start, "to", end, "match", results["match"],
+		"missing", results["missing"], "mismatch", results["mismatch"], "error", results["error"])
+	return nil
+}
+
+// checkMirrored compares one Firestore event with the mirror's copy
+func checkMirrored(ctx context.Context, mirror EventMirror, doc *firestore.DocumentSnapshot, logger *slog.Logger) string {
+	eventType, _ := GetString(doc.Data(), "type")
+	payload, found, err := mirror.Get(ctx, doc.Ref.ID)
+	if err != nil {
+		logger.Warn("Mirror lookup failed", "id", doc.Ref.ID, "type", eventType, "error", err)
+		return "error"
+	}
+	if !found {
+		logger.Warn("Event missing from mirror", "id", doc.Ref.ID, "type", eventType)
+		return "missing"
+	}
+
+	want, err := normalizePayload(doc.Data())
+	if err != nil {
+		logger.Warn("Unreadable event", "id", doc.Ref.ID, "type", eventType, "error", err)
+		return "error"
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(payload, &got); err != nil {
+		logger.Warn("Unreadable mirror payload", "id", doc.Ref.ID, "type", eventType, "error", err)
+		return "mismatch"
+	}
+	if got, err = normalizePayload(got); err != nil || !reflect.DeepEqual(want, got) {
+		logger.Warn("Mirror payload differs", "id", doc.Ref.ID, "type", eventType, "firestore", want, "mirror", got)
+		return "mismatch"
+	}
+	return "match"
+}
+
+// normalizePayload brings a Firestore document and a JSON payload to one form: JSON types, times
+// truncated to Firestore's microsecond precision, and zero values dropped since omitempty differs
+// between the json and firestore tags of some fields
+func normalizePayload(data map[string]interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return normalizeValue(out).(map[string]interface{}), nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if item = normalizeValue(item); !isZeroValue(item) {
+				out[k] = item
+			}
+		}
+		return out
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+		}
+	}
+	return v
+}
+
+func isZeroValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == "" || v == "0001-01-01T00:00:00Z"
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// EncodeCursor builds an opaque page token from the last document's timestamp and ID
+func EncodeCursor(ts time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(ts.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+// DecodeCursor reverses EncodeCursor
+func DecodeCursor(token string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	tsPart, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, tsPart)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	return ts, id, nil
+}
+
+// Paginate returns up to limit documents of q ordered by the timestamp field (ties broken by
+// document ID), starting after cursor when one is given. The returned token is empty on the last page.
+func Paginate(ctx context.Context, q firestore.Query, field string, dir firestore.Direction, limit int, cursor string) ([]*firestore.DocumentSnapshot, string, error) {
+	q = q.OrderBy(field, dir).OrderBy(firestore.DocumentID, dir)
+	if cursor != "" {
+		ts, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		q = q.StartAfter(ts, id)
+	}
+
+	// Fetch one extra document to learn whether another page exists
+	iter := q.Limit(limit + 1).Documents(ctx)
+	defer iter.Stop()
+
+	var docs []*firestore.DocumentSnapshot
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		docs = append(docs, doc)
+	}
+
+	if len(docs) <= limit {
+		return docs, "", nil
+	}
+	docs = docs[:limit]
+	last := docs[limit-1]
+	ts, _ := last.Data()[field].(time.Time)
+	return docs, EncodeCursor(ts, last.Ref.ID), nil
+}
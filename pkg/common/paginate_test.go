@@ -0,0 +1,48 @@
+package common
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		ts time.Time
+		id string
+	}{
+		{time.Date(2026, 10, 15, 9, 30, 0, 123456789, time.UTC), "order-1"},
+		// Any zone comes back as the same instant in UTC
+		{time.Date(2026, 10, 15, 15, 0, 0, 0, kolkata), "3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14"},
+		// Document IDs may contain the separator
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "a|b"},
+	}
+	for _, tt := range tests {
+		token := EncodeCursor(tt.ts, tt.id)
+		ts, id, err := DecodeCursor(token)
+		if err != nil {
+			t.Errorf("DecodeCursor(%q): %v", token, err)
+			continue
+		}
+		if !ts.Equal(tt.ts) || id != tt.id {
+			t.Errorf("round-trip = %s %q, want %s %q", ts, id, tt.ts, tt.id)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for name, token := range map[string]string{
+		"not base64":     "not a cursor!",
+		"no separator":   encode("2026-10-15T09:30:00Z"),
+		"no document ID": encode("2026-10-15T09:30:00Z|"),
+		"bad timestamp":  encode("yesterday|order-1"),
+		"padded base64":  base64.URLEncoding.EncodeToString([]byte("2026-10-15T09:30:00Z|o")),
+		"empty":          "",
+	} {
+		if _, _, err := DecodeCursor(token); err == nil {
+			t.Errorf("%s: DecodeCursor(%q) accepted it", name, token)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AckMode controls whether a publish waits for Firestore to confirm the write
+type AckMode string
+
+const (
+	AckConfirmed     AckMode = "confirmed"       // wait for the write and report its error (default)
+	AckFireAndForget AckMode = "fire_and_forget" // write in the background and only log failures
+)
+
+// ParseAckModes parses per-event-type overrides such as "DiscountConfirm=fire_and_forget"
+func ParseAckModes(spec string) (map[events.EventType]AckMode, error) {
+	modes := make(map[events.EventType]AckMode)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, mode, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ack mode %q: want <EventType>=<mode>", entry)
+		}
+		eventType := events.EventType(name)
+		if !eventType.IsValid() {
+			return nil, fmt.Errorf("unknown event type %q in ack mode %q", name, entry)
+		}
+		switch AckMode(mode) {
+		case AckConfirmed, AckFireAndForget:
+			modes[eventType] = AckMode(mode)
+		default:
+			return nil, fmt.Errorf("unknown ack mode %q for %s", mode, eventType)
+		}
+	}
+	return modes, nil
+}
+
+// Publisher appends events to the events collection
+type Publisher struct {
+	Collection *firestore.CollectionRef
+	Modes      map[events.EventType]AckMode // per-event-type overrides; unlisted types are confirmed
+	Logger     *slog.Logger
+	Mirror     EventMirror   // also receives every event while migrating buses; nil when not dual-writing
+	Retention  time.Duration // events expire this long after their timestamp; 0 leaves expires_at unset
+}
+
+// MinEventRetention keeps expires_at well clear of anything still reading an event: decisions are
+// awaited for seconds, holds for minutes, and a stopped listener resumes from its checkpoint.
+const MinEventRetention = 7 * 24 * time.Hour
+
+// LoadEventRetention reads EVENT_RETENTION (default 720h, 0 disables expiry)
+func LoadEventRetention() (time.Duration, error) {
+	retention, err := EnvDuration("EVENT_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return 0, err
+	}
+	if retention != 0 && retention < MinEventRetention {
+		return 0, fmt.Errorf("EVENT_RETENTION must be 0 or at least %s, got %s", MinEventRetention, retention)
+	}
+	return retention, nil
+}
+
+// Stamp returns event with ExpiresAt set Retention after its timestamp. Events are values, so the
+// stamped event is a copy. Events written outside Publish, such as inside a transaction, go
+// through events.Validate and Stamp first.
+func (p *Publisher) Stamp(event events.Event) events.Event {
+	if p.Retention <= 0 {
+		return event
+	}
+	v := reflect.New(reflect.TypeOf(event)).Elem()
+	v.Set(reflect.ValueOf(event))
+	field := v.FieldByName("BaseEvent")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(events.BaseEvent{}) {
+		return event
+	}
+	base := field.Addr().Interface().(*events.BaseEvent)
+	from := base.Timestamp
+	if from.IsZero() {
+		from = time.Now()
+	}
+	base.ExpiresAt = from.Add(p.Retention)
+	return v.Interface().(events.Event)
+}
+
+// Publish writes an event using its type's ack mode. Fire-and-forget publishes always return nil,
+// except for an event that fails events.Validate, which is never written.
+func (p *Publisher) Publish(ctx context.Context, event events.Event) error {
+	if err := events.Validate(event); err != nil {
+		return err
+	}
+	event = p.Stamp(event)
+	if p.Modes[event.EventType()] == AckFireAndForget {
+		go func() {
+			if err := p.write(context.WithoutCancel(ctx), p.Collection.NewDoc(), event); err != nil {
+				p.Logger.Error("Fire-and-forget publish failed", "type", event.EventType(), "error", err)
+			}
+		}()
+		return nil
+	}
+	return p.write(ctx, p.Collection.NewDoc(), event)
+}
+
+// write creates the event document, then copies it to the mirror. A document that already exists
+// is an earlier attempt of the same publish that did succeed.
+func (p *Publisher) write(ctx context.Context, ref *firestore.DocumentRef, event events.Event) error {
+	if _, err := ref.Create(ctx, event); err != nil && status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	p.MirrorEvent(ctx, ref.ID, event)
+	return nil
+}
+
+// PublishWithRetry publishes with confirmation, retrying with exponential backoff (starting at
+// backoff) up to attempts times. It gives up early if ctx is done, and doesn't try an event that
+// fails events.Validate.
+func (p *Publisher) PublishWithRetry(ctx context.Context, event events.Event, attempts int, backoff time.Duration) error {
+	if err := events.Validate(event); err != nil {
+		return err
+	}
+	// Every attempt writes the same document, so a retry after a lost response can't duplicate the event
+	ref := p.Collection.NewDoc()
+	event = p.Stamp(event)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = p.write(ctx, ref, event); err == nil {
+			return nil
+		}
+		p.Logger.Warn("Publish attempt failed", "type", event.EventType(), "attempt", attempt, "error", err)
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultQuotaTimezone is the zone whose midnight starts a new quota day unless QUOTA_TIMEZONE is set
+const DefaultQuotaTimezone = "Asia/Kolkata"
+
+// QuotaDateLayout formats quota days, which key the daily counters
+const QuotaDateLayout = "2006-01-02"
+
+// LoadQuotaTimezone reads QUOTA_TIMEZONE, an IANA zone name such as America/New_York (default
+// Asia/Kolkata). Every service and tool that names quota days must agree on it.
+func LoadQuotaTimezone() (*time.Location, error) {
+	loc, err := time.LoadLocation(EnvOrDefault("QUOTA_TIMEZONE", DefaultQuotaTimezone))
+	if err != nil {
+		return nil, fmt.Errorf("QUOTA_TIMEZONE: %w", err)
+	}
+	return loc, nil
+}
+
+// QuotaDate is the quota day t falls on in loc. Days follow the zone's calendar, so around a DST
+// change a day is 23 or 25 hours long rather than shifting its midnight.
+func QuotaDate(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(QuotaDateLayout)
+}
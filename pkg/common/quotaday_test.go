@@ -0,0 +1,83 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaDate(t *testing.T) {
+	utc := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		name string
+		zone string // QUOTA_TIMEZONE; empty for the default
+		at   string
+		want string
+	}{
+		{"default zone, last second of the day", "", "2026-10-15T18:29:59Z", "2026-10-15"},
+		{"default zone, IST midnight", "", "2026-10-15T18:30:00Z", "2026-10-16"},
+		{"default zone, UTC midnight is still the same day", "", "2026-10-16T00:00:00Z", "2026-10-16"},
+
+		// Spring forward: 2026-03-08 runs 23 hours, from 05:00Z to 04:00Z
+		{"before spring forward", "America/New_York", "2026-03-08T04:59:59Z", "2026-03-07"},
+		{"spring forward day starts", "America/New_York", "2026-03-08T05:00:00Z", "2026-03-08"},
+		{"spring forward, 2am skipped", "America/New_York", "2026-03-08T07:00:00Z", "2026-03-08"},
+		{"spring forward day ends", "America/New_York", "2026-03-09T03:59:59Z", "2026-03-08"},
+		{"after spring forward", "America/New_York", "2026-03-09T04:00:00Z", "2026-03-09"},
+
+		// Fall back: 2026-11-01 runs 25 hours, from 04:00Z to 05:00Z
+		{"before fall back", "America/New_York", "2026-11-01T03:59:59Z", "2026-10-31"},
+		{"fall back day starts", "America/New_York", "2026-11-01T04:00:00Z", "2026-11-01"},
+		{"fall back, 1am repeated", "America/New_York", "2026-11-01T06:30:00Z", "2026-11-01"},
+		{"fall back day ends", "America/New_York", "2026-11-02T04:59:59Z", "2026-11-01"},
+		{"after fall back", "America/New_York", "2026-11-02T05:00:00Z", "2026-11-02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("QUOTA_TIMEZONE", tt.zone)
+			loc, err := LoadQuotaTimezone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := QuotaDate(utc(tt.at), loc); got != tt.want {
+				t.Errorf("QuotaDate(%s) in %s = %s, want %s", tt.at, loc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadQuotaTimezone(t *testing.T) {
+	tests := []struct {
+		zone    string
+		want    string
+		wantErr bool
+	}{
+		{"", DefaultQuotaTimezone, false},
+		{"America/New_York", "America/New_York", false},
+		{"Mars/Olympus_Mons", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			t.Setenv("QUOTA_TIMEZONE", tt.zone)
+			loc, err := LoadQuotaTimezone()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "QUOTA_TIMEZONE") {
+					t.Errorf("err = %v, want an error naming QUOTA_TIMEZONE", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.String() != tt.want {
+				t.Errorf("zone = %s, want %s", loc, tt.want)
+			}
+		})
+	}
+}
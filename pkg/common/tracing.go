@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/devdolphintest/discount-system"
+
+// ParentSpanID returns the ID of ctx's active span, for an event's ParentSpanID.
+// It is empty when tracing is disabled, since there is then no valid span.
+func ParentSpanID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.SpanID().String()
+}
+
+// StartEventSpan starts the span for consuming an event. When the event carries a ParentSpanID the
+// span is a child of the publisher's span; the OTel trace ID is the event's TraceID (a UUID) with
+// the dashes removed, so publishers must root their traces on it. Without a tracer provider this
+// returns a no-op span.
+func StartEventSpan(ctx context.Context, e events.BaseEvent, name string) (context.Context, trace.Span) {
+	if e.ParentSpanID != "" {
+		traceID, traceErr := trace.TraceIDFromHex(strings.ReplaceAll(e.TraceID, "-", ""))
+		spanID, spanErr := trace.SpanIDFromHex(e.ParentSpanID)
+		if traceErr == nil && spanErr == nil {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+				Remote:     true,
+			}))
+		}
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+		attribute.String("trace_id", e.TraceID),
+		attribute.String("event_type", string(e.Type)),
+	))
+}
+
+// InitTracing installs a tracer provider exporting to the OTLP/HTTP endpoint in
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT), which also honours the
+// exporter's other OTEL_EXPORTER_OTLP_* variables. With neither set tracing stays disabled: spans
+// are no-ops and events carry no ParentSpanID. The returned function flushes and stops the
+// provider.
+func InitTracing(ctx context.Context, service string) (func(context.Context) error, error) {
+	if EnvOrDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" && EnvOrDefault("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithIDGenerator(traceIDGenerator{}),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartTraceSpan starts the root span of a saga. Its OTel trace ID is traceID (a UUID) with the
+// dashes removed, so the spans StartEventSpan starts for the saga's events join the same trace.
+func StartTraceSpan(ctx context.Context, traceID, name string) (context.Context, trace.Span) {
+	if id, err := trace.TraceIDFromHex(strings.ReplaceAll(traceID, "-", "")); err == nil {
+		ctx = context.WithValue(ctx, rootTraceIDKey{}, id)
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("trace_id", traceID),
+	))
+}
+
+type rootTraceIDKey struct{}
+
+// traceIDGenerator takes a root span's trace ID from StartTraceSpan's context, and otherwise
+// generates random IDs
+type traceIDGenerator struct{}
+
+func (g traceIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID, ok := ctx.Value(rootTraceIDKey{}).(trace.TraceID)
+	if !ok {
+		_, _ = rand.Read(traceID[:])
+	}
+	return traceID, g.NewSpanID(ctx, traceID)
+}
+
+func (traceIDGenerator) NewSpanID(context.Context, trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	_, _ = rand.Read(spanID[:])
+	return spanID
+}
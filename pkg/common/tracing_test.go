@@ -0,0 +1,99 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans installs a tracer provider recording finished spans in memory for the test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithIDGenerator(traceIDGenerator{}),
+	)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func TestEventSpansJoinTheSagaTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	const traceID = "3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14"
+
+	// The order service roots the saga and publishes OrderCreated from its span
+	ctx, root := StartTraceSpan(context.Background(), traceID, "HandleOrder")
+	order := events.NewOrderCreated(traceID, ParentSpanID(ctx), "o1")
+	root.End()
+
+	// The discount service consumes it, in another process, and publishes its decision
+	ctx, consume := StartEventSpan(context.Background(), order.BaseEvent, "ProcessOrderCreated")
+	reserved := events.NewDiscountReserved(traceID, ParentSpanID(ctx), "o1")
+	consume.End()
+
+	// The order service consumes the decision
+	_, decide := StartEventSpan(context.Background(), reserved.BaseEvent, "ProcessDiscountReserved")
+	decide.End()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+
+	wantTrace := strings.ReplaceAll(traceID, "-", "")
+	for name, s := range spans {
+		if got := s.SpanContext.TraceID().String(); got != wantTrace {
+			t.Errorf("%s is in trace %s, want %s", name, got, wantTrace)
+		}
+		if !hasAttribute(s, "trace_id", traceID) {
+			t.Errorf("%s has no trace_id attribute %s", name, traceID)
+		}
+	}
+	links := []struct{ child, parent string }{
+		{"ProcessOrderCreated", "HandleOrder"},
+		{"ProcessDiscountReserved", "ProcessOrderCreated"},
+	}
+	for _, l := range links {
+		if got, want := spans[l.child].Parent.SpanID(), spans[l.parent].SpanContext.SpanID(); got != want {
+			t.Errorf("%s's parent is %s, want %s (%s)", l.child, got, want, l.parent)
+		}
+	}
+	if spans["HandleOrder"].Parent.IsValid() {
+		t.Error("the saga's root span has a parent")
+	}
+}
+
+func TestTracingDisabledLeavesNoParent(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTracerProvider(previous)
+
+	ctx, span := StartTraceSpan(context.Background(), "3f2b8c1e-0d4a-4e8b-9a61-5b7f0c2d9e14", "HandleOrder")
+	defer span.End()
+	if id := ParentSpanID(ctx); id != "" {
+		t.Errorf("ParentSpanID = %q with tracing disabled, want empty", id)
+	}
+}
+
+func hasAttribute(s tracetest.SpanStub, key, value string) bool {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key && kv.Value.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
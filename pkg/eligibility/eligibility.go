@@ -0,0 +1,121 @@
+package eligibility
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stacking policies for combining the percentages of several matched rules
+const (
+	StackingMax = "max" // apply the single highest matched percentage
+	StackingSum = "sum" // add up every matched percentage
+)
+
+// Order is the input every rule is evaluated against
+type Order struct {
+	UserID    string
+	Gender    string
+	DOB       string
+	BasePrice float64
+	Now       time.Time // evaluation instant, already in the clinic's timezone
+
+	// Booking history, only looked up when a history-based rule is enabled
+	HistoryKnown  bool
+	PriorBookings int // confirmed bookings before this one
+}
+
+// Match is what a rule contributes when it applies to an order
+type Match struct {
+	Reason  string
+	Percent float64
+}
+
+// Rule is a single eligibility condition
+type Rule interface {
+	Name() string
+	Evaluate(o Order) (Match, bool)
+}
+
+// Decision is the combined outcome of all rules for an order
+type Decision struct {
+	Eligible bool
+	Percent  float64
+	Reasons  []string
+}
+
+// Engine grants a discount when any rule matches and every gate passes
+type Engine struct {
+	Rules    []Rule
+	Gates    []Rule
+	Stacking string
+}
+
+// DefaultPercent is the standard R1 discount rate
+const DefaultPercent = 12
+
+// Default returns the standard R1 rules: (Female AND Birthday) OR (Price > ₹1000), 12% either way
+func Default() *Engine {
+	return DefaultAt(DefaultPercent)
+}
+
+// DefaultAt returns the standard R1 rules granting percent instead of 12%, for promotions
+func DefaultAt(percent float64) *Engine {
+	return &Engine{
+		Rules: []Rule{
+			Birthday{Percent: percent},
+			HighValue{Threshold: 1000, Percent: percent},
+		},
+		Stacking: StackingMax,
+	}
+}
+
+// ValidatePercent checks a discount rate is in (0, 100]
+func ValidatePercent(percent float64) error {
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("discount percent must be in (0, 100], got %g", percent)
+	}
+	return nil
+}
+
+// ValidateStacking checks a stacking policy name
+func ValidateStacking(policy string) error {
+	switch policy {
+	case StackingMax, StackingSum:
+		return nil
+	}
+	return fmt.Errorf("unknown stacking policy %q (want %q or %q)", policy, StackingMax, StackingSum)
+}
+
+// Evaluate runs the gates and rules against an order
+func (e *Engine) Evaluate(o Order) Decision {
+	var gateReasons []string
+	for _, gate := range e.Gates {
+		m, ok := gate.Evaluate(o)
+		if !ok {
+			return Decision{}
+		}
+		if m.Reason != "" {
+			gateReasons = append(gateReasons, m.Reason)
+		}
+	}
+
+	var d Decision
+	for _, rule := range e.Rules {
+		m, ok := rule.Evaluate(o)
+		if !ok {
+			continue
+		}
+		d.Eligible = true
+		d.Reasons = append(d.Reasons, m.Reason)
+		if e.Stacking == StackingSum {
+			d.Percent += m.Percent
+		} else if m.Percent > d.Percent {
+			d.Percent = m.Percent
+		}
+	}
+
+	if d.Eligible {
+		d.Reasons = append(d.Reasons, gateReasons...)
+	}
+	return d
+}
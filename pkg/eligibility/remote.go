@@ -0,0 +1,133 @@
+package eligibility
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Remote evaluates eligibility with an external rules service, so rules can change without a deploy.
+//
+// It POSTs the order context as JSON to URL and expects {"eligible", "percent", "reasons"} back.
+// Identical requests within CacheTTL (order context plus the current minute) are answered from cache.
+type Remote struct {
+	URL      string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	Client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedDecision
+}
+
+type cachedDecision struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+// remoteRequest is the order context sent to the rules service
+type remoteRequest struct {
+	UserID        string   `json:"user_id"`
+	Gender        string   `json:"gender"`
+	DOB           string   `json:"dob"`
+	BasePrice     float64  `json:"base_price"`
+	Services      []string `json:"services"`
+	Now           string   `json:"now"` // RFC 3339 in the clinic's timezone, to the minute
+	HistoryKnown  bool     `json:"history_known"`
+	PriorBookings int      `json:"prior_bookings"`
+}
+
+type remoteResponse struct {
+	Eligible bool     `json:"eligible"`
+	Percent  float64  `json:"percent"`
+	Reasons  []string `json:"reasons"`
+}
+
+// Evaluate asks the rules service for a decision. Callers fall back to local rules on error.
+func (r *Remote) Evaluate(ctx context.Context, o Order, services []string) (Decision, error) {
+	body, err := json.Marshal(remoteRequest{
+		UserID:        o.UserID,
+		Gender:        o.Gender,
+		DOB:           o.DOB,
+		BasePrice:     o.BasePrice,
+		Services:      services,
+		Now:           o.Now.Truncate(time.Minute).Format(time.RFC3339),
+		HistoryKnown:  o.HistoryKnown,
+		PriorBookings: o.PriorBookings,
+	})
+	if err != nil {
+		return Decision{}, err
+	}
+	key := string(body)
+	if d, ok := r.cached(key); ok {
+		return d, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("rules service: unexpected status %s", resp.Status)
+	}
+
+	var out remoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Decision{}, fmt.Errorf("rules service: %w", err)
+	}
+	if out.Percent < 0 || out.Percent > 100 {
+		return Decision{}, fmt.Errorf("rules service: percent %g out of range", out.Percent)
+	}
+	d := Decision{Eligible: out.Eligible, Reasons: out.Reasons}
+	if d.Eligible {
+		d.Percent = out.Percent
+	}
+	r.store(key, d)
+	return d, nil
+}
+
+func (r *Remote) cached(key string) (Decision, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.cache[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		return Decision{}, false
+	}
+	return c.decision, true
+}
+
+func (r *Remote) store(key string, d Decision) {
+	if r.CacheTTL <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache == nil {
+		r.cache = make(map[string]cachedDecision)
+	}
+	now := time.Now()
+	// Drop expired entries as we go so the cache stays bounded by the request rate
+	for k, c := range r.cache {
+		if now.After(c.expiresAt) {
+			delete(r.cache, k)
+		}
+	}
+	r.cache[key] = cachedDecision{decision: d, expiresAt: now.Add(r.CacheTTL)}
+}
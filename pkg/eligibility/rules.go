@@ -0,0 +1,116 @@
+package eligibility
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Birthday matches female users booking on their birthday
+type Birthday struct {
+	Percent float64
+}
+
+func (Birthday) Name() string { return "birthday" }
+
+func (r Birthday) Evaluate(o Order) (Match, bool) {
+	if strings.ToLower(o.Gender) != "female" {
+		return Match{}, false
+	}
+	dob, err := time.Parse("2006-01-02", o.DOB)
+	if err != nil {
+		return Match{}, false
+	}
+	if dob.Month() != o.Now.Month() || dob.Day() != o.Now.Day() {
+		return Match{}, false
+	}
+	return Match{Reason: "Birthday", Percent: r.Percent}, true
+}
+
+// HighValue matches orders whose base price exceeds the threshold
+type HighValue struct {
+	Threshold float64
+	Percent   float64
+}
+
+func (HighValue) Name() string { return "high_value" }
+
+func (r HighValue) Evaluate(o Order) (Match, bool) {
+	if o.BasePrice <= r.Threshold {
+		return Match{}, false
+	}
+	return Match{Reason: "High-Value Order", Percent: r.Percent}, true
+}
+
+// FirstBooking matches users with no prior confirmed booking. Unknown history never matches.
+type FirstBooking struct {
+	Percent float64
+}
+
+func (FirstBooking) Name() string { return "first_booking" }
+
+func (r FirstBooking) Evaluate(o Order) (Match, bool) {
+	if !o.HistoryKnown || o.PriorBookings > 0 {
+		return Match{}, false
+	}
+	return Match{Reason: "First Booking", Percent: r.Percent}, true
+}
+
+// Loyalty matches users with at least MinBookings prior confirmed bookings. Unknown history never matches.
+type Loyalty struct {
+	MinBookings int
+	Percent     float64
+}
+
+func (Loyalty) Name() string { return "loyalty" }
+
+func (r Loyalty) Evaluate(o Order) (Match, bool) {
+	if !o.HistoryKnown || o.PriorBookings < r.MinBookings {
+		return Match{}, false
+	}
+	return Match{Reason: "Loyalty", Percent: r.Percent}, true
+}
+
+// TimeOfDay matches orders placed within a daily window, which may cross midnight (e.g. 22:00-02:00).
+// Start is inclusive and End is exclusive, both in minutes since midnight of Order.Now's location.
+type TimeOfDay struct {
+	Start int
+	End   int
+}
+
+// ParseTimeOfDay parses a window such as "14:00-17:00"
+func ParseTimeOfDay(window string) (TimeOfDay, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return TimeOfDay{}, fmt.Errorf("invalid time window %q: want HH:MM-HH:MM", window)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return TimeOfDay{}, fmt.Errorf("invalid window start %q: %w", from, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return TimeOfDay{}, fmt.Errorf("invalid window end %q: %w", to, err)
+	}
+	w := TimeOfDay{Start: start.Hour()*60 + start.Minute(), End: end.Hour()*60 + end.Minute()}
+	if w.Start == w.End {
+		return TimeOfDay{}, fmt.Errorf("invalid time window %q: start equals end", window)
+	}
+	return w, nil
+}
+
+func (TimeOfDay) Name() string { return "time_of_day" }
+
+func (r TimeOfDay) Evaluate(o Order) (Match, bool) {
+	minute := o.Now.Hour()*60 + o.Now.Minute()
+	var in bool
+	if r.Start < r.End {
+		in = minute >= r.Start && minute < r.End
+	} else {
+		in = minute >= r.Start || minute < r.End
+	}
+	if !in {
+		return Match{}, false
+	}
+	return Match{Reason: "Off-Peak Booking"}, true
+}
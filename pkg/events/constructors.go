@@ -0,0 +1,106 @@
+package events
+
+import "time"
+
+// Constructors stamp BaseEvent with the event's type and the current time, so the type can't be
+// mismatched with the struct. parentSpanID is the publishing span's ID (common.ParentSpanID), empty
+// when tracing is disabled or the event isn't published from a span. Fields not taken as
+// parameters are set by the caller.
+
+// NewBaseEvent stamps a BaseEvent of type t with the current time
+func NewBaseEvent(t EventType, traceID, parentSpanID string) BaseEvent {
+	return BaseEvent{
+		TraceID:      traceID,
+		Type:         t,
+		Timestamp:    time.Now(),
+		ParentSpanID: parentSpanID,
+	}
+}
+
+// NewOrderCreated starts an OrderCreated for orderID
+func NewOrderCreated(traceID, parentSpanID, orderID string) OrderCreated {
+	return OrderCreated{
+		BaseEvent: NewBaseEvent(EventTypeOrderCreated, traceID, parentSpanID),
+		OrderID:   orderID,
+	}
+}
+
+// NewDiscountReserved starts an approved DiscountReserved for orderID
+func NewDiscountReserved(traceID, parentSpanID, orderID string) DiscountReserved {
+	return DiscountReserved{
+		BaseEvent: NewBaseEvent(EventTypeDiscountReserved, traceID, parentSpanID),
+		OrderID:   orderID,
+		Status:    "Approved",
+	}
+}
+
+// NewDiscountRejected starts a DiscountRejected for orderID
+func NewDiscountRejected(traceID, parentSpanID, orderID, reason string) DiscountRejected {
+	return DiscountRejected{
+		BaseEvent: NewBaseEvent(EventTypeDiscountRejected, traceID, parentSpanID),
+		OrderID:   orderID,
+		Status:    "Rejected",
+		Reason:    reason,
+	}
+}
+
+// NewDiscountRelease starts a DiscountRelease for orderID
+func NewDiscountRelease(traceID, parentSpanID, orderID, reason string) DiscountRelease {
+	return DiscountRelease{
+		BaseEvent: NewBaseEvent(EventTypeDiscountRelease, traceID, parentSpanID),
+		OrderID:   orderID,
+		Reason:    reason,
+	}
+}
+
+// NewDiscountConfirm returns a DiscountConfirm for orderID
+func NewDiscountConfirm(traceID, parentSpanID, orderID string) DiscountConfirm {
+	return DiscountConfirm{
+		BaseEvent: NewBaseEvent(EventTypeDiscountConfirm, traceID, parentSpanID),
+		OrderID:   orderID,
+	}
+}
+
+// NewOrderConfirmed starts an OrderConfirmed for orderID
+func NewOrderConfirmed(traceID, parentSpanID, orderID string, finalPrice float64) OrderConfirmed {
+	return OrderConfirmed{
+		BaseEvent:  NewBaseEvent(EventTypeOrderConfirmed, traceID, parentSpanID),
+		OrderID:    orderID,
+		FinalPrice: finalPrice,
+	}
+}
+
+// NewPaymentCompleted returns a PaymentCompleted for orderID
+func NewPaymentCompleted(traceID, parentSpanID, orderID string, amount float64) PaymentCompleted {
+	return PaymentCompleted{
+		BaseEvent: NewBaseEvent(EventTypePaymentCompleted, traceID, parentSpanID),
+		OrderID:   orderID,
+		Amount:    amount,
+	}
+}
+
+// NewPaymentFailed returns a PaymentFailed for orderID
+func NewPaymentFailed(traceID, parentSpanID, orderID string, amount float64, reason string) PaymentFailed {
+	return PaymentFailed{
+		BaseEvent: NewBaseEvent(EventTypePaymentFailed, traceID, parentSpanID),
+		OrderID:   orderID,
+		Amount:    amount,
+		Reason:    reason,
+	}
+}
+
+// NewOrderSettled starts an OrderSettled for orderID
+func NewOrderSettled(traceID, parentSpanID, orderID string) OrderSettled {
+	return OrderSettled{
+		BaseEvent: NewBaseEvent(EventTypeOrderSettled, traceID, parentSpanID),
+		OrderID:   orderID,
+	}
+}
+
+// NewDailyDigest starts the DailyDigest for date; digests belong to no trace
+func NewDailyDigest(date string) DailyDigest {
+	return DailyDigest{
+		BaseEvent: NewBaseEvent(EventTypeDailyDigest, "", ""),
+		Date:      date,
+	}
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConstructorsStampBaseEvent(t *testing.T) {
+	const traceID, parentSpanID, orderID = "trace-1", "span-1", "order-1"
+	before := time.Now()
+
+	created := NewOrderCreated(traceID, parentSpanID, orderID)
+	reserved := NewDiscountReserved(traceID, parentSpanID, orderID)
+	rejected := NewDiscountRejected(traceID, parentSpanID, orderID, "quota")
+	release := NewDiscountRelease(traceID, parentSpanID, orderID, "payment failed")
+	confirm := NewDiscountConfirm(traceID, parentSpanID, orderID)
+	confirmed := NewOrderConfirmed(traceID, parentSpanID, orderID, 880)
+	paid := NewPaymentCompleted(traceID, parentSpanID, orderID, 880)
+	failed := NewPaymentFailed(traceID, parentSpanID, orderID, 880, "declined")
+	settled := NewOrderSettled(traceID, parentSpanID, orderID)
+
+	tests := []struct {
+		name     string
+		base     BaseEvent
+		orderID  string
+		wantType EventType
+	}{
+		{"NewOrderCreated", created.BaseEvent, created.OrderID, EventTypeOrderCreated},
+		{"NewDiscountReserved", reserved.BaseEvent, reserved.OrderID, EventTypeDiscountReserved},
+		{"NewDiscountRejected", rejected.BaseEvent, rejected.OrderID, EventTypeDiscountRejected},
+		{"NewDiscountRelease", release.BaseEvent, release.OrderID, EventTypeDiscountRelease},
+		{"NewDiscountConfirm", confirm.BaseEvent, confirm.OrderID, EventTypeDiscountConfirm},
+		{"NewOrderConfirmed", confirmed.BaseEvent, confirmed.OrderID, EventTypeOrderConfirmed},
+		{"NewPaymentCompleted", paid.BaseEvent, paid.OrderID, EventTypePaymentCompleted},
+		{"NewPaymentFailed", failed.BaseEvent, failed.OrderID, EventTypePaymentFailed},
+		{"NewOrderSettled", settled.BaseEvent, settled.OrderID, EventTypeOrderSettled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.base.Type != tt.wantType || tt.base.EventType() != tt.wantType {
+				t.Errorf("type = %s, want %s", tt.base.Type, tt.wantType)
+			}
+			if tt.base.TraceID != traceID || tt.base.ParentSpanID != parentSpanID {
+				t.Errorf("trace_id %q, parent_span_id %q; want %q, %q", tt.base.TraceID, tt.base.ParentSpanID, traceID, parentSpanID)
+			}
+			if tt.orderID != orderID {
+				t.Errorf("order_id = %q, want %q", tt.orderID, orderID)
+			}
+			if tt.base.Timestamp.Before(before) || tt.base.Timestamp.After(time.Now()) {
+				t.Errorf("timestamp %s is not the time of construction", tt.base.Timestamp)
+			}
+			if !tt.base.ExpiresAt.IsZero() {
+				t.Error("expires_at is the publisher's to set")
+			}
+		})
+	}
+}
+
+func TestConstructorsSetTheirFields(t *testing.T) {
+	if r := NewDiscountReserved("t", "", "o"); r.Status != "Approved" {
+		t.Errorf("DiscountReserved status = %q, want Approved", r.Status)
+	}
+	if r := NewDiscountRejected("t", "", "o", "quota"); r.Status != "Rejected" || r.Reason != "quota" {
+		t.Errorf("DiscountRejected = %+v", r)
+	}
+	if p := NewPaymentFailed("t", "", "o", 880, "declined"); p.Amount != 880 || p.Reason != "declined" {
+		t.Errorf("PaymentFailed = %+v", p)
+	}
+	if c := NewOrderConfirmed("t", "", "o", 880); c.FinalPrice != 880 {
+		t.Errorf("OrderConfirmed final_price = %v, want 880", c.FinalPrice)
+	}
+
+	// Digests belong to no trace
+	d := NewDailyDigest("2026-10-15")
+	if d.Type != EventTypeDailyDigest || d.Date != "2026-10-15" || d.TraceID != "" || d.ParentSpanID != "" {
+		t.Errorf("DailyDigest = %+v", d)
+	}
+}
@@ -4,19 +4,61 @@ import (
 	"time"
 )
 
+// EventType names an event's type. It is stored as a plain string in Firestore and JSON.
+type EventType string
+
 // EventType constants
 const (
-	EventTypeOrderCreated     = "OrderCreated"
-	EventTypeDiscountReserved = "DiscountReserved"
-	EventTypeDiscountRejected = "DiscountRejected"
-	EventTypeDiscountRelease  = "DiscountRelease"
+	EventTypeOrderCreated     EventType = "OrderCreated"
+	EventTypeDiscountReserved EventType = "DiscountReserved"
+	EventTypeDiscountRejected EventType = "DiscountRejected"
+	EventTypeDiscountRelease  EventType = "DiscountRelease"
+	EventTypeDiscountConfirm  EventType = "DiscountConfirm"
+	EventTypeOrderConfirmed   EventType = "OrderConfirmed"
+	EventTypePaymentCompleted EventType = "PaymentCompleted"
+	EventTypePaymentFailed    EventType = "PaymentFailed"
+	EventTypeDailyDigest      EventType = "DailyDigest"
+	EventTypeOrderSettled     EventType = "OrderSettled"
 )
 
+var knownTypes = map[EventType]bool{
+	EventTypeOrderCreated:     true,
+	EventTypeDiscountReserved: true,
+	EventTypeDiscountRejected: true,
+	EventTypeDiscountRelease:  true,
+	EventTypeDiscountConfirm:  true,
+	EventTypeOrderConfirmed:   true,
+	EventTypePaymentCompleted: true,
+	EventTypePaymentFailed:    true,
+	EventTypeDailyDigest:      true,
+	EventTypeOrderSettled:     true,
+}
+
+// IsValid reports whether t is one of the event types above
+func (t EventType) IsValid() bool { return knownTypes[t] }
+
+// DefaultLocation is the single global quota namespace used when an order names no clinic location
+const DefaultLocation = "global"
+
 // BaseEvent contains common fields for all events
 type BaseEvent struct {
 	TraceID   string    `json:"trace_id" firestore:"trace_id"`
-	Type      string    `json:"type" firestore:"type"`
+	Type      EventType `json:"type" firestore:"type"`
 	Timestamp time.Time `json:"timestamp" firestore:"timestamp"`
+	// ParentSpanID is the publishing span's ID, so consumers can parent their spans on it;
+	// empty when tracing is disabled
+	ParentSpanID string `json:"parent_span_id,omitempty" firestore:"parent_span_id,omitempty"`
+	// ExpiresAt is when the events collection's TTL policy may delete the event, set by the
+	// publisher; consumers ignore it
+	ExpiresAt time.Time `json:"expires_at,omitzero" firestore:"expires_at,omitempty"`
+}
+
+// EventType returns the event's type; every event embeds BaseEvent and so satisfies Event
+func (b BaseEvent) EventType() EventType { return b.Type }
+
+// Event is any publishable event
+type Event interface {
+	EventType() EventType
 }
 
 // Service represents a medical service
@@ -33,18 +75,55 @@ type OrderCreated struct {
 	Name             string    `json:"name" firestore:"name"`
 	Gender           string    `json:"gender" firestore:"gender"`
 	DOB              string    `json:"dob" firestore:"dob"`
-	SelectedServices []Service `json:"selected_services" firestore:"selected_services"`
+	SelectedServices []Service `json:"selected_services" firestore:"selected_services"` // empty when DetailsID is set
+	ServiceCount     int       `json:"service_count" firestore:"service_count"`
+	DetailsID        string    `json:"details_id,omitempty" firestore:"details_id,omitempty"` // order_details document holding SelectedServices
 	BasePrice        float64   `json:"base_price" firestore:"base_price"`
 	IsR1Eligible     bool      `json:"is_r1_eligible" firestore:"is_r1_eligible"`
+	Reasons          []string  `json:"reasons,omitempty" firestore:"reasons,omitempty"` // eligibility rules that matched
 	DiscountPercent  float64   `json:"discount_percent" firestore:"discount_percent"`
 	FinalPrice       float64   `json:"final_price" firestore:"final_price"`
+	DiscountAmount   float64   `json:"discount_amount" firestore:"discount_amount"` // pricing.DiscountAmount(BasePrice, FinalPrice)
+	// DiscountableAmount is the part of BasePrice the discount applies to, excluding
+	// DISCOUNT_EXCLUDED_SERVICES, so the discount service can reprice at its tier rate
+	DiscountableAmount float64 `json:"discountable_amount,omitempty" firestore:"discountable_amount,omitempty"`
+	IsTest             bool    `json:"is_test" firestore:"is_test"` // test traffic uses the separate test quota
+	LocationID         string  `json:"location_id" firestore:"location_id"`
+	QuotaDate          string  `json:"quota_date,omitempty" firestore:"quota_date,omitempty"`   // YYYY-MM-DD quota day, decided at creation
+	InstanceID         string  `json:"instance_id,omitempty" firestore:"instance_id,omitempty"` // order-service replica awaiting the decision
+}
+
+// CollectionOrderDetails holds service lists too large to embed in OrderCreated
+const CollectionOrderDetails = "order_details"
+
+// OrderDetails is the out-of-line part of a large order, keyed by order_id
+type OrderDetails struct {
+	OrderID          string    `json:"order_id" firestore:"order_id"`
+	SelectedServices []Service `json:"selected_services" firestore:"selected_services"`
+	CreatedAt        time.Time `json:"created_at" firestore:"created_at"`
 }
 
 // DiscountReserved represents a successful discount reservation
 type DiscountReserved struct {
 	BaseEvent
-	OrderID string `json:"order_id" firestore:"order_id"`
-	Status  string `json:"status" firestore:"status"` // "Approved"
+	OrderID    string `json:"order_id" firestore:"order_id"`
+	Status     string `json:"status" firestore:"status"` // "Approved"
+	IsTest     bool   `json:"is_test" firestore:"is_test"`
+	LocationID string `json:"location_id" firestore:"location_id"`
+	// ConfirmDeadline is when the discount service releases the reservation unless a
+	// DiscountConfirm arrives first; zero when reservation holds are disabled
+	ConfirmDeadline time.Time `json:"confirm_deadline,omitzero" firestore:"confirm_deadline,omitempty"`
+	QuotaLimit      int64     `json:"quota_limit,omitempty" firestore:"quota_limit,omitempty"` // the day's limit; zero if not reported
+	QuotaRemaining  int64     `json:"quota_remaining" firestore:"quota_remaining"`             // slots left after this decision
+	InstanceID      string    `json:"instance_id,omitempty" firestore:"instance_id,omitempty"` // copied from OrderCreated to route the decision
+	// DiscountPercent is the rate the reservation was granted at: OrderCreated's, capped at the
+	// discount service's DISCOUNT_PERCENT, or the tier rate when discount tiers are configured. Zero
+	// from discount services that predate it.
+	DiscountPercent float64 `json:"discount_percent,omitempty" firestore:"discount_percent,omitempty"`
+	// FinalPrice and DiscountAmount are the order's amounts at DiscountPercent, and differ from
+	// OrderCreated's only when the order was repriced
+	FinalPrice     float64 `json:"final_price,omitempty" firestore:"final_price,omitempty"`
+	DiscountAmount float64 `json:"discount_amount,omitempty" firestore:"discount_amount,omitempty"`
 }
 
 // DiscountRejected represents a failed discount reservation (quota full)
@@ -53,11 +132,75 @@ type DiscountRejected struct {
 	OrderID string `json:"order_id" firestore:"order_id"`
 	Status  string `json:"status" firestore:"status"` // "Rejected"
 	Reason  string `json:"reason" firestore:"reason"`
+	// Quota state when the counter was read; QuotaLimit is zero for rejections made without reading it
+	QuotaLimit     int64  `json:"quota_limit,omitempty" firestore:"quota_limit,omitempty"`
+	QuotaRemaining int64  `json:"quota_remaining" firestore:"quota_remaining"`
+	InstanceID     string `json:"instance_id,omitempty" firestore:"instance_id,omitempty"` // copied from OrderCreated to route the decision
 }
 
 // DiscountRelease represents a compensation action to release a quota
 type DiscountRelease struct {
+	BaseEvent
+	OrderID    string `json:"order_id" firestore:"order_id"`
+	Reason     string `json:"reason" firestore:"reason"`
+	UserID     string `json:"user_id,omitempty" firestore:"user_id,omitempty"` // returns the user's per-user count too
+	IsTest     bool   `json:"is_test" firestore:"is_test"`
+	LocationID string `json:"location_id" firestore:"location_id"`
+}
+
+// DiscountConfirm represents the order service committing a reserved discount after a successful booking
+type DiscountConfirm struct {
 	BaseEvent
 	OrderID string `json:"order_id" firestore:"order_id"`
-	Reason  string `json:"reason" firestore:"reason"`
+}
+
+// OrderConfirmed records a booking completed without a discount. It never touches the quota.
+type OrderConfirmed struct {
+	BaseEvent
+	OrderID    string  `json:"order_id" firestore:"order_id"`
+	FinalPrice float64 `json:"final_price" firestore:"final_price"`
+	IsTest     bool    `json:"is_test" firestore:"is_test"`
+}
+
+// PaymentCompleted records that a discounted booking was paid for, which commits its reservation
+type PaymentCompleted struct {
+	BaseEvent
+	OrderID string  `json:"order_id" firestore:"order_id"`
+	Amount  float64 `json:"amount" firestore:"amount"` // amount charged, the order's final price
+}
+
+// PaymentFailed records that payment for a discounted booking failed; the DiscountRelease
+// published with it returns the quota
+type PaymentFailed struct {
+	BaseEvent
+	OrderID string  `json:"order_id" firestore:"order_id"`
+	Amount  float64 `json:"amount" firestore:"amount"` // amount that failed to charge
+	Reason  string  `json:"reason" firestore:"reason"`
+}
+
+// OrderSettled is billing's record of a completed order and what was charged for it, published once
+// per confirmed booking with or without a discount. Unlike the saga events it is a contract with
+// billing: fields are only ever added, never renamed or repurposed.
+type OrderSettled struct {
+	BaseEvent
+	OrderID         string  `json:"order_id" firestore:"order_id"`
+	UserID          string  `json:"user_id" firestore:"user_id"`
+	BasePrice       float64 `json:"base_price" firestore:"base_price"`
+	DiscountAmount  float64 `json:"discount_amount" firestore:"discount_amount"`
+	FinalPrice      float64 `json:"final_price" firestore:"final_price"` // amount charged
+	Currency        string  `json:"currency" firestore:"currency"`       // ISO 4217
+	DiscountApplied bool    `json:"discount_applied" firestore:"discount_applied"`
+	IsTest          bool    `json:"is_test" firestore:"is_test"`
+}
+
+// DailyDigest summarizes one day of quota activity, emitted once after the day ends
+type DailyDigest struct {
+	BaseEvent
+	Date       string `json:"date" firestore:"date"`
+	Limit      int64  `json:"limit" firestore:"limit"`
+	Approvals  int64  `json:"approvals" firestore:"approvals"`
+	Rejections int64  `json:"rejections" firestore:"rejections"`
+	Releases   int64  `json:"releases" firestore:"releases"`
+	NetUsed    int64  `json:"net_used" firestore:"net_used"`
+	PeakUsage  int64  `json:"peak_usage" firestore:"peak_usage"`
 }
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventTypeIsValid(t *testing.T) {
+	tests := []struct {
+		eventType EventType
+		want      bool
+	}{
+		{EventTypeOrderCreated, true},
+		{EventTypeDiscountReserved, true},
+		{EventTypeDiscountRejected, true},
+		{EventTypeDiscountRelease, true},
+		{EventTypeDiscountConfirm, true},
+		{EventTypeOrderConfirmed, true},
+		{EventTypePaymentCompleted, true},
+		{EventTypePaymentFailed, true},
+		{EventTypeDailyDigest, true},
+		{EventTypeOrderSettled, true},
+		{"", false},
+		{"orderCreated", false}, // names are case-sensitive
+		{"ORDERCREATED", false},
+		{" OrderCreated", false},
+		{"OrderCancelled", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.eventType), func(t *testing.T) {
+			if got := tt.eventType.IsValid(); got != tt.want {
+				t.Errorf("EventType(%q).IsValid() = %v, want %v", tt.eventType, got, tt.want)
+			}
+		})
+	}
+	if len(knownTypes) != 10 {
+		t.Errorf("%d known types; add the new type to this table", len(knownTypes))
+	}
+}
+
+func TestPaymentEventsRoundTrip(t *testing.T) {
+	completed := NewPaymentCompleted("trace-1", "span-1", "o1", 880)
+	failed := NewPaymentFailed("trace-2", "", "o2", 1320.5, "Card declined")
+	// JSON keeps the instant, not the monotonic reading, so compare after one round-trip
+	completed.Timestamp = time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	failed.Timestamp = completed.Timestamp
+
+	for _, tt := range []struct {
+		event Event
+		into  Event
+	}{
+		{completed, &PaymentCompleted{}},
+		{failed, &PaymentFailed{}},
+	} {
+		data, err := json.Marshal(tt.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, tt.into); err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.ValueOf(tt.into).Elem().Interface()
+		if !reflect.DeepEqual(got, tt.event) {
+			t.Errorf("%s round-tripped to %+v, want %+v", tt.event.EventType(), got, tt.event)
+		}
+	}
+
+	var fields map[string]interface{}
+	data, _ := json.Marshal(failed)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"type", "trace_id", "timestamp", "order_id", "amount", "reason"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("PaymentFailed JSON has no %q: %s", key, data)
+		}
+	}
+}
+
+// TestTagsMatch checks the convention that each event field has the same json and firestore name
+func TestTagsMatch(t *testing.T) {
+	for _, event := range []interface{}{PaymentCompleted{}, PaymentFailed{}, BaseEvent{}} {
+		typ := reflect.TypeOf(event)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Anonymous {
+				continue
+			}
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			storeName, _, _ := strings.Cut(field.Tag.Get("firestore"), ",")
+			if jsonName == "" || jsonName != storeName {
+				t.Errorf("%s.%s: json %q, firestore %q", typ.Name(), field.Name, jsonName, storeName)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists everything wrong with a malformed event
+type ValidationError struct {
+	Type     EventType
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Type, strings.Join(e.Problems, "; "))
+}
+
+// Validator is an event that can check its own fields before it is published
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks event if its type has a Validate method; other events always pass
+func Validate(event Event) error {
+	if v, ok := event.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// problems collects validation failures for one event
+type problems struct {
+	t    EventType
+	list []string
+}
+
+func (p *problems) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		p.list = append(p.list, fmt.Sprintf(format, args...))
+	}
+}
+
+// base checks the fields every event needs
+func (p *problems) base(b BaseEvent, orderID string) {
+	p.check(b.Type == p.t, "type must be %s, got %q", p.t, b.Type)
+	p.check(!b.Timestamp.IsZero(), "timestamp is required")
+	p.check(orderID != "", "order_id is required")
+}
+
+func (p *problems) percent(percent float64) {
+	p.check(percent >= 0 && percent <= 100, "discount_percent must be between 0 and 100, got %g", percent)
+}
+
+func (p *problems) err() error {
+	if len(p.list) == 0 {
+		return nil
+	}
+	return &ValidationError{Type: p.t, Problems: p.list}
+}
+
+// Validate checks that the order is identified and its amounts are in range
+func (e OrderCreated) Validate() error {
+	p := problems{t: EventTypeOrderCreated}
+	p.base(e.BaseEvent, e.OrderID)
+	p.check(e.BasePrice >= 0, "base_price must not be negative, got %g", e.BasePrice)
+	p.check(e.FinalPrice >= 0, "final_price must not be negative, got %g", e.FinalPrice)
+	p.check(e.FinalPrice <= e.BasePrice, "final_price %g exceeds base_price %g", e.FinalPrice, e.BasePrice)
+	p.check(e.DiscountAmount >= 0, "discount_amount must not be negative, got %g", e.DiscountAmount)
+	p.check(e.DiscountableAmount >= 0, "discountable_amount must not be negative, got %g", e.DiscountableAmount)
+	p.check(e.ServiceCount >= 0, "service_count must not be negative, got %d", e.ServiceCount)
+	p.percent(e.DiscountPercent)
+	return p.err()
+}
+
+// Validate checks that the reservation is an approval with amounts in range
+func (e DiscountReserved) Validate() error {
+	p := problems{t: EventTypeDiscountReserved}
+	p.base(e.BaseEvent, e.OrderID)
+	p.check(e.Status == "Approved", "status must be Approved, got %q", e.Status)
+	p.check(e.QuotaLimit >= 0, "quota_limit must not be negative, got %d", e.QuotaLimit)
+	p.check(e.QuotaRemaining >= 0, "quota_remaining must not be negative, got %d", e.QuotaRemaining)
+	p.check(e.FinalPrice >= 0, "final_price must not be negative, got %g", e.FinalPrice)
+	p.check(e.DiscountAmount >= 0, "discount_amount must not be negative, got %g", e.DiscountAmount)
+	p.percent(e.DiscountPercent)
+	return p.err()
+}
+
+// Validate checks that the rejection is identified and says why
+func (e DiscountRejected) Validate() error {
+	p := problems{t: EventTypeDiscountRejected}
+	p.base(e.BaseEvent, e.OrderID)
+	p.check(e.Status == "Rejected", "status must be Rejected, got %q", e.Status)
+	p.check(e.Reason != "", "reason is required")
+	p.check(e.QuotaLimit >= 0, "quota_limit must not be negative, got %d", e.QuotaLimit)
+	p.check(e.QuotaRemaining >= 0, "quota_remaining must not be negative, got %d", e.QuotaRemaining)
+	return p.err()
+}
+
+// Validate checks that the release is identified and says why
+func (e DiscountRelease) Validate() error {
+	p := problems{t: EventTypeDiscountRelease}
+	p.base(e.BaseEvent, e.OrderID)
+	p.check(e.Reason != "", "reason is required")
+	return p.err()
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	order := func(edit func(*OrderCreated)) Event {
+		e := NewOrderCreated("t1", "", "o1")
+		e.BasePrice, e.FinalPrice, e.DiscountAmount, e.DiscountableAmount = 1000, 880, 120, 1000
+		e.DiscountPercent, e.ServiceCount = 12, 1
+		edit(&e)
+		return e
+	}
+	reserved := func(edit func(*DiscountReserved)) Event {
+		e := NewDiscountReserved("t1", "", "o1")
+		e.QuotaLimit, e.QuotaRemaining, e.FinalPrice, e.DiscountAmount, e.DiscountPercent = 100, 99, 880, 120, 12
+		edit(&e)
+		return e
+	}
+	rejected := func(edit func(*DiscountRejected)) Event {
+		e := NewDiscountRejected("t1", "", "o1", "Daily discount quota reached")
+		e.QuotaLimit = 100
+		edit(&e)
+		return e
+	}
+	release := func(edit func(*DiscountRelease)) Event {
+		e := NewDiscountRelease("t1", "", "o1", "Payment failed")
+		edit(&e)
+		return e
+	}
+
+	tests := []struct {
+		name        string
+		event       Event
+		wantProblem string // empty when the event is valid
+	}{
+		{"valid OrderCreated", order(func(e *OrderCreated) {}), ""},
+		{"OrderCreated without order_id", order(func(e *OrderCreated) { e.OrderID = "" }), "order_id is required"},
+		{"OrderCreated without timestamp", order(func(e *OrderCreated) { e.Timestamp = time.Time{} }), "timestamp is required"},
+		{"OrderCreated with the wrong type", order(func(e *OrderCreated) { e.Type = EventTypeOrderConfirmed }), "type must be OrderCreated"},
+		{"OrderCreated with negative base_price", order(func(e *OrderCreated) { e.BasePrice, e.FinalPrice = -1, -1 }), "base_price must not be negative"},
+		{"OrderCreated with negative final_price", order(func(e *OrderCreated) { e.FinalPrice = -1 }), "final_price must not be negative"},
+		{"OrderCreated with final_price above base_price", order(func(e *OrderCreated) { e.FinalPrice = 1001 }), "final_price 1001 exceeds base_price 1000"},
+		{"OrderCreated with negative discount_amount", order(func(e *OrderCreated) { e.DiscountAmount = -1 }), "discount_amount must not be negative"},
+		{"OrderCreated with negative discountable_amount", order(func(e *OrderCreated) { e.DiscountableAmount = -1 }), "discountable_amount must not be negative"},
+		{"OrderCreated with negative service_count", order(func(e *OrderCreated) { e.ServiceCount = -1 }), "service_count must not be negative"},
+		{"OrderCreated with negative discount_percent", order(func(e *OrderCreated) { e.DiscountPercent = -1 }), "discount_percent must be between 0 and 100"},
+		{"OrderCreated with discount_percent over 100", order(func(e *OrderCreated) { e.DiscountPercent = 101 }), "discount_percent must be between 0 and 100"},
+
+		{"valid DiscountReserved", reserved(func(e *DiscountReserved) {}), ""},
+		{"DiscountReserved without order_id", reserved(func(e *DiscountReserved) { e.OrderID = "" }), "order_id is required"},
+		{"DiscountReserved without timestamp", reserved(func(e *DiscountReserved) { e.Timestamp = time.Time{} }), "timestamp is required"},
+		{"DiscountReserved not approved", reserved(func(e *DiscountReserved) { e.Status = "" }), "status must be Approved"},
+		{"DiscountReserved with negative quota_limit", reserved(func(e *DiscountReserved) { e.QuotaLimit = -1 }), "quota_limit must not be negative"},
+		{"DiscountReserved with negative quota_remaining", reserved(func(e *DiscountReserved) { e.QuotaRemaining = -1 }), "quota_remaining must not be negative"},
+		{"DiscountReserved with negative final_price", reserved(func(e *DiscountReserved) { e.FinalPrice = -1 }), "final_price must not be negative"},
+		{"DiscountReserved with negative discount_amount", reserved(func(e *DiscountReserved) { e.DiscountAmount = -1 }), "discount_amount must not be negative"},
+		{"DiscountReserved with discount_percent over 100", reserved(func(e *DiscountReserved) { e.DiscountPercent = 150 }), "discount_percent must be between 0 and 100"},
+
+		{"valid DiscountRejected", rejected(func(e *DiscountRejected) {}), ""},
+		{"DiscountRejected without order_id", rejected(func(e *DiscountRejected) { e.OrderID = "" }), "order_id is required"},
+		{"DiscountRejected without reason", rejected(func(e *DiscountRejected) { e.Reason = "" }), "reason is required"},
+		{"DiscountRejected not rejected", rejected(func(e *DiscountRejected) { e.Status = "Approved" }), "status must be Rejected"},
+		{"DiscountRejected with negative quota_limit", rejected(func(e *DiscountRejected) { e.QuotaLimit = -1 }), "quota_limit must not be negative"},
+		{"DiscountRejected with negative quota_remaining", rejected(func(e *DiscountRejected) { e.QuotaRemaining = -1 }), "quota_remaining must not be negative"},
+
+		{"valid DiscountRelease", release(func(e *DiscountRelease) {}), ""},
+		{"DiscountRelease without order_id", release(func(e *DiscountRelease) { e.OrderID = "" }), "order_id is required"},
+		{"DiscountRelease without timestamp", release(func(e *DiscountRelease) { e.Timestamp = time.Time{} }), "timestamp is required"},
+		{"DiscountRelease without reason", release(func(e *DiscountRelease) { e.Reason = "" }), "reason is required"},
+
+		// Types without a Validate method always pass
+		{"PaymentCompleted without order_id", PaymentCompleted{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.event)
+			if tt.wantProblem == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate = %v, want a *ValidationError", err)
+			}
+			if !strings.Contains(invalid.Error(), tt.wantProblem) {
+				t.Errorf("Validate = %v, want a problem containing %q", err, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestValidationErrorListsEveryProblem(t *testing.T) {
+	err := Validate(DiscountRelease{})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Validate = %v, want a *ValidationError", err)
+	}
+	if len(invalid.Problems) != 4 {
+		t.Errorf("problems = %q, want type, timestamp, order_id and reason", invalid.Problems)
+	}
+	want := "invalid DiscountRelease: type must be DiscountRelease"
+	if !strings.HasPrefix(invalid.Error(), want) {
+		t.Errorf("Error() = %q, want it to start with %q", invalid.Error(), want)
+	}
+}
@@ -0,0 +1,125 @@
+// Package pii encrypts personally identifiable event fields at rest with AES-GCM.
+//
+// Keys are versioned so they can be rotated: values are written with the active (first)
+// key and stored as "enc:<version>:<base64(nonce|ciphertext)>", and any configured
+// version can still decrypt older values.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+const prefix = "enc:"
+
+// Cipher encrypts with the active key version and decrypts with any known version
+type Cipher struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewCipher parses a key spec of the form "v2:<base64 key>,v1:<base64 key>".
+// The first entry is the active key; keys must be 16, 24 or 32 bytes.
+func NewCipher(spec string) (*Cipher, error) {
+	c := &Cipher{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(spec, ",") {
+		version, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || version == "" {
+			return nil, fmt.Errorf("invalid key entry %q: want <version>:<base64 key>", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", version, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", version, err)
+		}
+		if _, dup := c.keys[version]; dup {
+			return nil, fmt.Errorf("duplicate key version %s", version)
+		}
+		c.keys[version] = aead
+		if c.active == "" {
+			c.active = version
+		}
+	}
+	return c, nil
+}
+
+// Encrypt seals a value with the active key. Empty values stay empty.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	aead := c.keys[c.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(c.active))
+	return prefix + c.active + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by any known key version. Values without the
+// encryption prefix are returned unchanged so events written before encryption still read.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+	version, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	aead, ok := c.keys[version]
+	if !ok {
+		return "", fmt.Errorf("unknown key version %s", version)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted value too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(version))
+	if err != nil {
+		return "", fmt.Errorf("key %s: %w", version, err)
+	}
+	return string(plaintext), nil
+}
+
+// EncryptOrder encrypts the PII fields (name, gender, DOB) of an OrderCreated event in place.
+// Routing fields such as order_id, type and timestamp stay plaintext so queries keep working.
+func (c *Cipher) EncryptOrder(e *events.OrderCreated) error {
+	for _, field := range []*string{&e.Name, &e.Gender, &e.DOB} {
+		v, err := c.Encrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+	return nil
+}
+
+// DecryptOrder reverses EncryptOrder in place
+func (c *Cipher) DecryptOrder(e *events.OrderCreated) error {
+	for _, field := range []*string{&e.Name, &e.Gender, &e.DOB} {
+		v, err := c.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Service is a bookable medical service at its list price
+type Service struct {
+	Name  string
+	Price float64
+}
+
+// Catalog lists the services offered per gender. "other" also serves any gender not listed.
+var Catalog = map[string][]Service{
+	"female": {
+		{"Gynecological Checkup", 800},
+		{"Mammography", 1500},
+		{"General Consultation", 500},
+		{"Blood Test - Complete", 600},
+		{"Ultrasound", 1200},
+		{"Thyroid Function Test", 450},
+	},
+	"male": {
+		{"Prostate Examination", 700},
+		{"General Consultation", 500},
+		{"Blood Test - Complete", 600},
+		{"ECG", 400},
+		{"X-Ray Chest", 350},
+		{"Lipid Profile", 550},
+	},
+	"other": {
+		{"General Consultation", 500},
+		{"Blood Test - Complete", 600},
+		{"ECG", 400},
+		{"X-Ray Chest", 350},
+		{"Ultrasound", 1200},
+	},
+}
+
+// ServicesFor returns the services offered to gender
+func ServicesFor(gender string) []Service {
+	if services, ok := Catalog[strings.ToLower(gender)]; ok {
+		return services
+	}
+	return Catalog["other"]
+}
+
+// ListPrices prices the named services from the catalog and returns them with their total. A
+// service has the same price for every gender it is offered to. It fails on a service no gender is
+// offered; see NotOffered for the gender check.
+func ListPrices(names []string) ([]Service, float64, error) {
+	prices := make(map[string]float64)
+	for _, services := range Catalog {
+		for _, s := range services {
+			prices[s.Name] = s.Price
+		}
+	}
+	priced := make([]Service, len(names))
+	total := 0.0
+	for i, name := range names {
+		price, ok := prices[name]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown service %q", name)
+		}
+		priced[i] = Service{Name: name, Price: price}
+		total += price
+	}
+	return priced, total, nil
+}
+
+// NotOffered returns the named services missing from gender's catalog, such as a female-only
+// service on a male customer's order
+func NotOffered(gender string, names []string) []string {
+	offered := make(map[string]bool)
+	for _, s := range ServicesFor(gender) {
+		offered[s.Name] = true
+	}
+	var missing []string
+	for _, name := range names {
+		if !offered[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,70 @@
+// Package pricing computes order prices shared by the order service and the CLI preview.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Tolerance is the largest difference between two amounts that still counts as equal (one paisa)
+const Tolerance = 0.01
+
+// Currency is the ISO 4217 code of every amount in the system
+const Currency = "INR"
+
+// Floor policies for a discount that would take the final price below zero
+const (
+	FloorClamp  = "clamp"  // charge zero
+	FloorReject = "reject" // refuse the order
+)
+
+// ErrNegativePrice is returned under FloorReject when the discount exceeds the order total
+var ErrNegativePrice = errors.New("discount exceeds the order total")
+
+// ValidateFloorPolicy reports whether policy is a known floor policy
+func ValidateFloorPolicy(policy string) error {
+	if policy != FloorClamp && policy != FloorReject {
+		return fmt.Errorf("unknown price floor policy %q (want %s or %s)", policy, FloorClamp, FloorReject)
+	}
+	return nil
+}
+
+// FinalPrice applies percent to the discountable part of base. A result below zero is clamped to
+// zero (clamped reports this) or rejected with ErrNegativePrice, depending on policy.
+func FinalPrice(base, discountable, percent float64, policy string) (price float64, clamped bool, err error) {
+	price = base - discountable*percent/100
+	if price >= 0 {
+		return price, false, nil
+	}
+	if policy == FloorReject {
+		return price, false, ErrNegativePrice
+	}
+	return 0, true, nil
+}
+
+// DiscountAmount is the absolute discount on an order, rounded to the paisa. Consumers read it off
+// the event rather than recomputing base - final, so they all agree on the exact amount.
+func DiscountAmount(base, final float64) float64 {
+	return math.Round((base-final)*100) / 100
+}
+
+// CheckDiscountAmount reports an error unless amount equals base - final within Tolerance
+func CheckDiscountAmount(base, final, amount float64) error {
+	if diff := base - final - amount; diff > Tolerance || diff < -Tolerance {
+		return fmt.Errorf("discount amount %.2f does not match base %.2f - final %.2f", amount, base, final)
+	}
+	return nil
+}
+
+// CheckSettlement reports an error unless a charged order's amounts are consistent: none negative,
+// the final price no more than the base, and the discount amount their difference
+func CheckSettlement(base, final, discount float64) error {
+	if base < 0 || final < 0 || discount < 0 {
+		return fmt.Errorf("negative amount: base %.2f, final %.2f, discount %.2f", base, final, discount)
+	}
+	if final > base+Tolerance {
+		return fmt.Errorf("final price %.2f exceeds base %.2f", final, base)
+	}
+	return CheckDiscountAmount(base, final, discount)
+}
@@ -0,0 +1,51 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFinalPriceFloor(t *testing.T) {
+	tests := []struct {
+		name                    string
+		base, discountable, pct float64
+		policy                  string
+		wantPrice               float64
+		wantClamped             bool
+		wantErr                 error
+	}{
+		{"above the floor", 1000, 1000, 12, FloorClamp, 880, false, nil},
+		{"exactly at the floor, clamp", 1000, 1000, 100, FloorClamp, 0, false, nil},
+		{"exactly at the floor, reject", 1000, 1000, 100, FloorReject, 0, false, nil},
+		{"below the floor, clamp", 1000, 1000, 150, FloorClamp, 0, true, nil},
+		{"below the floor, reject", 1000, 1000, 150, FloorReject, -500, false, ErrNegativePrice},
+		{"just below the floor, reject", 1000, 1000, 100.001, FloorReject, -0.01, false, ErrNegativePrice},
+		{"zero base", 0, 0, 12, FloorReject, 0, false, nil},
+		{"zero discount", 1000, 1000, 0, FloorReject, 1000, false, nil},
+		{"excluded services keep their price", 1000, 400, 100, FloorReject, 600, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, clamped, err := FinalPrice(tt.base, tt.discountable, tt.pct, tt.policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if diff := price - tt.wantPrice; diff > Tolerance || diff < -Tolerance || clamped != tt.wantClamped {
+				t.Errorf("FinalPrice = %g (clamped %v), want %g (clamped %v)", price, clamped, tt.wantPrice, tt.wantClamped)
+			}
+		})
+	}
+}
+
+func TestValidateFloorPolicy(t *testing.T) {
+	for _, policy := range []string{FloorClamp, FloorReject} {
+		if err := ValidateFloorPolicy(policy); err != nil {
+			t.Errorf("ValidateFloorPolicy(%q) = %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"", "Clamp", "zero"} {
+		if err := ValidateFloorPolicy(policy); err == nil {
+			t.Errorf("ValidateFloorPolicy(%q) accepted an unknown policy", policy)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package projection
+
+import (
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// DefaultCollection is the read-model collection holding one document per order
+const DefaultCollection = "orders"
+
+// Order statuses stored in the read-model
+const (
+	StatusPending   = "PENDING"
+	StatusReserved  = "RESERVED" // discount reserved, booking not confirmed yet
+	StatusConfirmed = "CONFIRMED"
+	StatusRejected  = "REJECTED"
+	StatusFailed    = "FAILED"
+)
+
+// EventTypes lists the events the projection consumes
+var EventTypes = []events.EventType{
+	events.EventTypeOrderCreated,
+	events.EventTypeDiscountReserved,
+	events.EventTypeDiscountRejected,
+	events.EventTypeDiscountRelease,
+	events.EventTypeDiscountConfirm,
+	events.EventTypeOrderConfirmed,
+	events.EventTypePaymentCompleted,
+	events.EventTypePaymentFailed,
+}
+
+// OrderView is the current state of a single order, keyed by order_id
+type OrderView struct {
+	OrderID         string    `json:"order_id" firestore:"order_id"`
+	TraceID         string    `json:"trace_id" firestore:"trace_id"`
+	UserID          string    `json:"user_id" firestore:"user_id"`
+	Status          string    `json:"status" firestore:"status"`
+	Reason          string    `json:"reason,omitempty" firestore:"reason"`
+	BasePrice       float64   `json:"base_price" firestore:"base_price"`
+	DiscountPercent float64   `json:"discount_percent" firestore:"discount_percent"`
+	FinalPrice      float64   `json:"final_price" firestore:"final_price"`
+	DiscountAmount  float64   `json:"discount_amount" firestore:"discount_amount"`
+	QuotaReserved   bool      `json:"quota_reserved" firestore:"quota_reserved"`
+	QuotaReleased   bool      `json:"quota_released" firestore:"quota_released"`
+	CreatedAt       time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// statusRank orders statuses so late or replayed events never move an order backwards
+var statusRank = map[string]int{
+	"":              0,
+	StatusPending:   1,
+	StatusReserved:  2,
+	StatusConfirmed: 3,
+	StatusRejected:  3,
+	StatusFailed:    4,
+}
+
+func (v *OrderView) setStatus(status string) {
+	if statusRank[status] >= statusRank[v.Status] {
+		v.Status = status
+	}
+}
+
+func (v *OrderView) touch(ts time.Time) {
+	if ts.After(v.UpdatedAt) {
+		v.UpdatedAt = ts
+	}
+}
+
+// Apply folds a single event document into the view. Unknown event types are ignored. A reserved
+// discount leaves the order RESERVED; it is CONFIRMED once the booking is (DiscountConfirm,
+// OrderConfirmed or PaymentCompleted).
+func Apply(v *OrderView, doc *common.Doc) error {
+	eventType, _ := doc.Data()["type"].(string)
+	switch events.EventType(eventType) {
+	case events.EventTypeOrderCreated:
+		var e events.OrderCreated
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.TraceID = e.TraceID
+		v.UserID = e.UserID
+		v.BasePrice = e.BasePrice
+		if v.DiscountPercent == 0 {
+			// Otherwise a DiscountReserved applied first already holds the granted price
+			v.DiscountPercent = e.DiscountPercent
+			v.FinalPrice = e.FinalPrice
+			v.DiscountAmount = e.DiscountAmount
+		}
+		v.CreatedAt = e.Timestamp
+		v.setStatus(StatusPending)
+		v.touch(e.Timestamp)
+	case events.EventTypeDiscountReserved:
+		var e events.DiscountReserved
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.QuotaReserved = true
+		if e.DiscountPercent != 0 && e.DiscountPercent != v.DiscountPercent {
+			// A discount tier repriced the order
+			v.DiscountPercent = e.DiscountPercent
+			v.FinalPrice = e.FinalPrice
+			v.DiscountAmount = e.DiscountAmount
+		}
+		v.setStatus(StatusReserved)
+		v.touch(e.Timestamp)
+	case events.EventTypeDiscountRejected:
+		var e events.DiscountRejected
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.Reason = e.Reason
+		v.setStatus(StatusRejected)
+		v.touch(e.Timestamp)
+	case events.EventTypeDiscountRelease:
+		var e events.DiscountRelease
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.QuotaReleased = true
+		v.Reason = e.Reason
+		v.setStatus(StatusFailed)
+		v.touch(e.Timestamp)
+	case events.EventTypeDiscountConfirm:
+		var e events.DiscountConfirm
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.setStatus(StatusConfirmed)
+		v.touch(e.Timestamp)
+	case events.EventTypeOrderConfirmed:
+		var e events.OrderConfirmed
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.setStatus(StatusConfirmed)
+		v.touch(e.Timestamp)
+	case events.EventTypePaymentCompleted:
+		var e events.PaymentCompleted
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.setStatus(StatusConfirmed)
+		v.touch(e.Timestamp)
+	case events.EventTypePaymentFailed:
+		var e events.PaymentFailed
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		v.OrderID = e.OrderID
+		v.Reason = e.Reason
+		v.setStatus(StatusFailed)
+		v.touch(e.Timestamp)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// eventDoc stores event as the publisher would and reads it back as an event document
+func eventDoc(t *testing.T, store *common.MemStore, n int, event events.Event) *common.Doc {
+	t.Helper()
+	path := fmt.Sprintf("events/e%d", n)
+	if err := store.Set(context.Background(), path, event); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := store.Get(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestApplyStatus(t *testing.T) {
+	created := events.NewOrderCreated("t1", "", "o1")
+	reserved := events.NewDiscountReserved("t1", "", "o1")
+	confirm := events.NewDiscountConfirm("t1", "", "o1")
+	orderConfirmed := events.NewOrderConfirmed("t1", "", "o1", 880)
+	paid := events.NewPaymentCompleted("t1", "", "o1", 880)
+	release := events.NewDiscountRelease("t1", "", "o1", "Payment failed")
+	rejected := events.NewDiscountRejected("t1", "", "o1", "Daily discount quota reached")
+
+	tests := []struct {
+		name   string
+		events []events.Event
+		want   string
+	}{
+		{"created", []events.Event{created}, StatusPending},
+		{"reserved awaits confirmation", []events.Event{created, reserved}, StatusReserved},
+		{"confirmed by DiscountConfirm", []events.Event{created, reserved, confirm}, StatusConfirmed},
+		{"confirmed by PaymentCompleted", []events.Event{created, reserved, paid}, StatusConfirmed},
+		{"confirmed without a discount", []events.Event{created, orderConfirmed}, StatusConfirmed},
+		{"late reservation doesn't undo the confirmation", []events.Event{created, confirm, reserved}, StatusConfirmed},
+		{"released reservation", []events.Event{created, reserved, release}, StatusFailed},
+		{"rejected", []events.Event{created, rejected}, StatusRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := common.NewMemStore()
+			var view OrderView
+			for i, event := range tt.events {
+				if err := Apply(&view, eventDoc(t, store, i, event)); err != nil {
+					t.Fatalf("Apply %s: %v", event.EventType(), err)
+				}
+			}
+			if view.Status != tt.want {
+				t.Errorf("status = %s, want %s", view.Status, tt.want)
+			}
+			if view.OrderID != "o1" {
+				t.Errorf("order_id = %q", view.OrderID)
+			}
+		})
+	}
+}
+
+func TestApplyReservedMarksQuota(t *testing.T) {
+	store := common.NewMemStore()
+	reserved := events.NewDiscountReserved("t1", "", "o1")
+	reserved.DiscountPercent, reserved.FinalPrice, reserved.DiscountAmount = 15, 850, 150
+
+	var view OrderView
+	if err := Apply(&view, eventDoc(t, store, 0, reserved)); err != nil {
+		t.Fatal(err)
+	}
+	if !view.QuotaReserved || view.DiscountPercent != 15 || view.FinalPrice != 850 {
+		t.Errorf("view = %+v, want the reservation's tier price and quota_reserved", view)
+	}
+}
@@ -0,0 +1,133 @@
+// Package queries declares every Firestore query the services and tools run, so the composite
+// indexes they need can be generated rather than maintained by hand.
+//
+// When adding or changing a query, update Registry and run `go run ./cmd/indexgen` to rewrite
+// firestore.indexes.json. `go run ./cmd/indexgen -check` fails when the file is stale or when a
+// query in the code filters or orders on fields no declared query covers.
+package queries
+
+import (
+	"slices"
+	"strings"
+)
+
+// Index field orders, as Firestore names them
+const (
+	Asc  = "ASCENDING"
+	Desc = "DESCENDING"
+)
+
+// Field is one filtered or ordered field, in index order: equality filters first, then the
+// range filter or sort field
+type Field struct {
+	Path  string
+	Order string
+}
+
+// Query is one query shape the code runs
+type Query struct {
+	Name       string
+	Collection string
+	Fields     []Field
+}
+
+func eq(path string) Field   { return Field{Path: path, Order: Asc} }
+func asc(path string) Field  { return Field{Path: path, Order: Asc} }
+func desc(path string) Field { return Field{Path: path, Order: Desc} }
+
+// Registry lists every query, grouped by the collection it reads
+var Registry = []Query{
+	// events
+	{Name: "order: decision listener", Collection: "events", Fields: []Field{eq("instance_id"), eq("type"), asc("timestamp")}},
+	{Name: "order: decision lookup", Collection: "events", Fields: []Field{eq("order_id"), eq("type")}},
+	{Name: "order: status from events", Collection: "events", Fields: []Field{eq("order_id"), eq("type")}},
+	{Name: "order: live event stream", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "discount: order event listener", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "discount: prior decision check", Collection: "events", Fields: []Field{eq("order_id"), eq("type")}},
+	{Name: "discount: last order health check", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "discount: daily digest", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "discount: reconcile reservations and releases", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "projection: event replay and listener", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "report: reservations and releases in range", Collection: "events", Fields: []Field{eq("type"), asc("timestamp")}},
+	{Name: "report: orders by id", Collection: "events", Fields: []Field{eq("order_id"), eq("type")}},
+
+	// orders read-model (ORDERS_COLLECTION)
+	{Name: "order: user orders page", Collection: "orders", Fields: []Field{eq("user_id"), desc("created_at")}},
+	{Name: "order: confirmed booking history", Collection: "orders", Fields: []Field{eq("user_id"), eq("status")}},
+
+	// holds
+	{Name: "discount: expired holds sweep", Collection: "holds", Fields: []Field{eq("state"), asc("expires_at")}},
+
+	// dead_letter
+	{Name: "dlq-replay: dead letters to replay", Collection: "dead_letter", Fields: []Field{eq("status"), asc("dead_lettered_at")}},
+	{Name: "dlq-replay: dead letters of one type", Collection: "dead_letter", Fields: []Field{eq("status"), eq("type"), asc("dead_lettered_at")}},
+}
+
+// Index is a composite index in firestore.indexes.json
+type Index struct {
+	CollectionGroup string       `json:"collectionGroup"`
+	QueryScope      string       `json:"queryScope"`
+	Fields          []IndexField `json:"fields"`
+}
+
+// IndexField is one field of an Index
+type IndexField struct {
+	FieldPath string `json:"fieldPath"`
+	Order     string `json:"order"`
+}
+
+// Indexes returns the composite indexes the registry needs, deduplicated and sorted.
+// Single-field queries are served by Firestore's automatic indexes and are skipped.
+func Indexes(registry []Query) []Index {
+	seen := make(map[string]bool)
+	var out []Index
+	for _, q := range registry {
+		if len(q.Fields) < 2 {
+			continue
+		}
+		idx := Index{CollectionGroup: q.Collection, QueryScope: "COLLECTION"}
+		key := q.Collection
+		for _, f := range q.Fields {
+			idx.Fields = append(idx.Fields, IndexField{FieldPath: f.Path, Order: f.Order})
+			key += "|" + f.Path + ":" + f.Order
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, idx)
+	}
+	slices.SortFunc(out, func(a, b Index) int {
+		return strings.Compare(indexKey(a), indexKey(b))
+	})
+	return out
+}
+
+func indexKey(idx Index) string {
+	key := idx.CollectionGroup
+	for _, f := range idx.Fields {
+		key += "|" + f.FieldPath
+	}
+	return key
+}
+
+// Covers reports whether some declared query on collection filters or orders on every field in
+// fields. An empty collection matches any collection.
+func Covers(registry []Query, collection string, fields []string) bool {
+	for _, q := range registry {
+		if collection != "" && q.Collection != collection {
+			continue
+		}
+		covered := true
+		for _, f := range fields {
+			if !slices.ContainsFunc(q.Fields, func(qf Field) bool { return qf.Path == f }) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
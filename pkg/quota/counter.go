@@ -0,0 +1,177 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// DaysPath is the collection holding a location's daily counters. The global location keeps the
+// original {collection}/{date} documents; other locations live under {collection}/{location}/days/{date}.
+func DaysPath(isTest bool, location string) string {
+	if location == "" || location == events.DefaultLocation {
+		return Collection(isTest)
+	}
+	return Collection(isTest) + "/" + location + "/days"
+}
+
+// Counter is one location's counter for one day.
+//
+// With a single shard it is the counter document itself. Otherwise the count is split across
+// {counter}/shards/{i}, and each shard owns a fixed share of the limit (limit/N, with the remainder
+// going to the first shards). A reservation only reads and writes the shard it lands on, so
+// concurrent reservations on different shards don't contend. The total can still never exceed the
+// limit, because no shard exceeds its share and the shares sum to the limit.
+type Counter struct {
+	q    *Quota
+	path string
+}
+
+// Counter returns the counter of a location's day
+func (q *Quota) Counter(isTest bool, location, date string) Counter {
+	return Counter{q: q, path: DaysPath(isTest, location) + "/" + date}
+}
+
+// Path is the counter document's path
+func (c Counter) Path() string {
+	return c.path
+}
+
+func (c Counter) shards() int {
+	return c.q.Config.Shards
+}
+
+func (c Counter) shard(i int) string {
+	if c.shards() <= 1 {
+		return c.path
+	}
+	return c.path + "/shards/" + strconv.Itoa(i)
+}
+
+// ShardPaths lists the documents holding the counter's count, in shard order
+func (c Counter) ShardPaths() []string {
+	paths := make([]string, max(c.shards(), 1))
+	for i := range paths {
+		paths[i] = c.shard(i)
+	}
+	return paths
+}
+
+// capacity is shard i's share of limit
+func (c Counter) capacity(i int, limit int64) int64 {
+	n := int64(max(c.shards(), 1))
+	capacity := limit / n
+	if int64(i) < limit%n {
+		capacity++
+	}
+	return capacity
+}
+
+// probeOrder lists every shard once, starting at a random one so concurrent reservations spread out
+func (c Counter) probeOrder() []int {
+	n := max(c.shards(), 1)
+	start := rand.IntN(n)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = (start + i) % n
+	}
+	return order
+}
+
+// ShardCounts reads each document's count; missing documents and fields count as zero. A count
+// that isn't an integer is an error rather than zero, which would hand out extra discounts.
+func ShardCounts(docs []*common.Doc) ([]int64, error) {
+	counts := make([]int64, len(docs))
+	for i, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		count, err := countField(doc)
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = count
+	}
+	return counts, nil
+}
+
+// countField reads a counter document's count, zero when it has none
+func countField(doc *common.Doc) (int64, error) {
+	data := doc.Data()
+	if _, present := data["count"]; !present {
+		return 0, nil
+	}
+	count, ok := common.GetInt64(data, "count")
+	if !ok {
+		return 0, fmt.Errorf("malformed count %v (%T) in %s", data["count"], data["count"], doc.Path)
+	}
+	return count, nil
+}
+
+// ReadShards returns every shard's count inside tx; missing shards count as zero
+func (c Counter) ReadShards(tx common.DocTx) ([]int64, error) {
+	docs, err := tx.GetAll(c.ShardPaths())
+	if err != nil {
+		return nil, err
+	}
+	return ShardCounts(docs)
+}
+
+// Total sums the shards outside a transaction, for reporting
+func (c Counter) Total(ctx context.Context) (int64, error) {
+	docs, err := c.q.Store.GetAll(ctx, c.ShardPaths())
+	if err != nil {
+		return 0, err
+	}
+	counts, err := ShardCounts(docs)
+	if err != nil {
+		return 0, err
+	}
+	return sum(counts), nil
+}
+
+func sum(counts []int64) int64 {
+	total := int64(0)
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// decrement returns one reservation from the fullest shard. counts must come from ReadShards in the
+// same transaction. It reports false when every shard is already zero.
+func (c Counter) decrement(tx common.DocTx, counts []int64) (bool, error) {
+	fullest := 0
+	for i, count := range counts {
+		if count > counts[fullest] {
+			fullest = i
+		}
+	}
+	if len(counts) == 0 || counts[fullest] <= 0 {
+		return false, nil
+	}
+	return true, tx.Set(c.shard(fullest), map[string]interface{}{"count": counts[fullest] - 1}, firestore.MergeAll)
+}
+
+// SetTotal rewrites the shards inside tx so they sum to total, filling each up to its share of the
+// limit. A total above the limit is kept on the last shard so drift stays visible.
+func (c Counter) SetTotal(tx common.DocTx, total int64) error {
+	remaining := total
+	n := max(c.shards(), 1)
+	for i := 0; i < n; i++ {
+		count := min(remaining, c.capacity(i, c.q.Config.Limit))
+		if i == n-1 {
+			count = remaining
+		}
+		if err := tx.Set(c.shard(i), map[string]interface{}{"count": count}, firestore.MergeAll); err != nil {
+			return err
+		}
+		remaining -= count
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+func TestReserveWithMalformedCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   interface{}
+		wantErr bool
+	}{
+		{"string", "12", true},
+		{"fractional double", 1.5, true},
+		{"whole double", 1.0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newTestQuota(Config{Limit: 3})
+			ctx := context.Background()
+			shard := q.Counter(false, events.DefaultLocation, testDate).ShardPaths()[0]
+			if err := store.Set(ctx, shard, map[string]interface{}{"count": tt.count}); err != nil {
+				t.Fatal(err)
+			}
+
+			decision, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reserve = %v, %v; want error %v", decision, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// Nothing is decided against a count that can't be read
+				if n := len(store.List(CollectionDecisions)); n != 0 {
+					t.Errorf("%d decision markers written", n)
+				}
+				return
+			}
+			if decision.EventType() != events.EventTypeDiscountReserved || used(t, q, testDate) != 2 {
+				t.Errorf("decision %s, used %d; want a reservation counted on top of 1", decision.EventType(), used(t, q, testDate))
+			}
+		})
+	}
+}
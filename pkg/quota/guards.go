@@ -0,0 +1,124 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// Actions for OrderCreated events whose timestamp is too far from server time
+const (
+	DriftFlag   = "flag"   // log for investigation and process normally
+	DriftReject = "reject" // reject the discount
+)
+
+// Drift guards birthday eligibility, which depends on "today", against skewed clocks
+type Drift struct {
+	MaxDrift time.Duration // 0 disables the check
+	Action   string
+
+	// QuotaDateSkew is how far from the local clock an order's quota_date may be
+	QuotaDateSkew time.Duration
+}
+
+// LoadDrift reads EVENT_MAX_DRIFT, EVENT_DRIFT_ACTION and QUOTA_DATE_MAX_SKEW
+func LoadDrift() (Drift, error) {
+	var cfg Drift
+	var err error
+	if cfg.MaxDrift, err = common.EnvDuration("EVENT_MAX_DRIFT", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.QuotaDateSkew, err = common.EnvDuration("QUOTA_DATE_MAX_SKEW", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	cfg.Action = common.EnvOrDefault("EVENT_DRIFT_ACTION", DriftFlag)
+	if cfg.Action != DriftFlag && cfg.Action != DriftReject {
+		return cfg, fmt.Errorf("EVENT_DRIFT_ACTION: unknown action %q", cfg.Action)
+	}
+	return cfg, nil
+}
+
+// Actions for OrderCreated events arriving inside the freeze window around midnight in QUOTA_TIMEZONE
+const (
+	FreezeDefer  = "defer"  // hold processing until the window has passed
+	FreezeReject = "reject" // reject with a transient reason
+)
+
+// Freeze keeps reservations away from the day boundary, where "today" is ambiguous
+type Freeze struct {
+	Window time.Duration // on each side of midnight; 0 disables the freeze
+	Action string
+}
+
+// LoadFreeze reads QUOTA_FREEZE_WINDOW and QUOTA_FREEZE_ACTION
+func LoadFreeze() (Freeze, error) {
+	var cfg Freeze
+	var err error
+	if cfg.Window, err = common.EnvDuration("QUOTA_FREEZE_WINDOW", 0); err != nil {
+		return cfg, err
+	}
+	cfg.Action = common.EnvOrDefault("QUOTA_FREEZE_ACTION", FreezeDefer)
+	if cfg.Action != FreezeDefer && cfg.Action != FreezeReject {
+		return cfg, fmt.Errorf("QUOTA_FREEZE_ACTION: unknown action %q", cfg.Action)
+	}
+	return cfg, nil
+}
+
+// Remaining returns how long the freeze window around the nearest midnight in tz still lasts, or 0
+// when now is outside it
+func (f Freeze) Remaining(now time.Time, tz *time.Location) time.Duration {
+	if f.Window <= 0 {
+		return 0
+	}
+	local := now.In(tz)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+	if since := local.Sub(midnight); since < f.Window {
+		return f.Window - since
+	}
+	next := midnight.AddDate(0, 0, 1)
+	if until := next.Sub(local); until < f.Window {
+		return until + f.Window
+	}
+	return 0
+}
+
+// AwaitFreeze blocks until the freeze window has passed, or ctx is done, when the defer action is
+// configured
+func (q *Quota) AwaitFreeze(ctx context.Context) {
+	if q.Config.Freeze.Action != FreezeDefer {
+		return
+	}
+	wait := q.Config.Freeze.Remaining(time.Now(), q.Timezone)
+	if wait <= 0 {
+		return
+	}
+	q.Logger.Info("Quota freeze window, deferring order processing", "wait", wait.String())
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
+// guard applies the drift and freeze checks an order must pass before it reaches the quota. It
+// returns the rejection written when one refuses the order, nil when the order may proceed, and, like
+// Reject, ErrAlreadyDecided for an order decided before.
+func (q *Quota) guard(ctx context.Context, event events.OrderCreated) (events.Event, error) {
+	if drift := q.Config.Drift; drift.MaxDrift > 0 {
+		if d := time.Since(event.Timestamp); d > drift.MaxDrift || d < -drift.MaxDrift {
+			q.Logger.Warn("Order Timestamp Drift", "order_id", event.OrderID, "trace_id", event.TraceID,
+				"event_timestamp", event.Timestamp, "drift", d.String(), "max_drift", drift.MaxDrift.String(), "action", drift.Action)
+			if drift.Action == DriftReject {
+				return q.Reject(ctx, event, "Order timestamp is out of range. Please try again.")
+			}
+		}
+	}
+
+	if q.Config.Freeze.Action == FreezeReject && q.Config.Freeze.Remaining(time.Now(), q.Timezone) > 0 {
+		q.Logger.Warn("Order rejected during quota freeze window", "order_id", event.OrderID, "trace_id", event.TraceID)
+		return q.Reject(ctx, event, "The daily discount quota is resetting. Please try again in a minute.")
+	}
+	return nil, nil
+}
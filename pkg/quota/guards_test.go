@@ -0,0 +1,81 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+func TestFreezeRemaining(t *testing.T) {
+	freeze := Freeze{Window: time.Minute}
+	midnight := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Duration
+	}{
+		{"before the window", midnight.Add(-2 * time.Minute), 0},
+		{"before midnight", midnight.Add(-20 * time.Second), 80 * time.Second},
+		{"after midnight", midnight.Add(20 * time.Second), 40 * time.Second},
+		{"after the window", midnight.Add(time.Minute), 0},
+	}
+	for _, tt := range tests {
+		if got := freeze.Remaining(tt.now, time.UTC); got != tt.want {
+			t.Errorf("%s: Remaining = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if got := (Freeze{}).Remaining(midnight, time.UTC); got != 0 {
+		t.Errorf("disabled freeze: Remaining = %s, want 0", got)
+	}
+}
+
+func TestDecideRejectsBeforeTheQuota(t *testing.T) {
+	stale := testOrder("o1", "u1")
+	stale.Timestamp = time.Now().Add(-time.Hour)
+	tests := []struct {
+		name  string
+		cfg   Config
+		order events.OrderCreated
+	}{
+		{"drift", Config{Drift: Drift{MaxDrift: 5 * time.Minute, Action: DriftReject}}, stale},
+		// A window wider than half a day covers every instant
+		{"freeze", Config{Freeze: Freeze{Window: 13 * time.Hour, Action: FreezeReject}}, testOrder("o1", "u1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newTestQuota(tt.cfg)
+			q.Timezone = time.UTC
+			ctx := context.Background()
+
+			decision, err := q.Decide(ctx, tt.order, testDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := decision.(events.DiscountRejected); !ok {
+				t.Fatalf("decision = %T, want DiscountRejected", decision)
+			}
+			if _, err := store.Get(ctx, DecisionPath("o1")); err != nil {
+				t.Errorf("the rejection has no decision marker: %v", err)
+			}
+			if used(t, q, testDate) != 0 {
+				t.Error("a rejection took a quota slot")
+			}
+			if _, err := q.Decide(ctx, tt.order, testDate); !errors.Is(err, ErrAlreadyDecided) {
+				t.Errorf("second Decide error = %v, want ErrAlreadyDecided", err)
+			}
+		})
+	}
+
+	// Flagged drift only logs
+	q, _ := newTestQuota(Config{Drift: Drift{MaxDrift: 5 * time.Minute, Action: DriftFlag}})
+	decision, err := q.Decide(context.Background(), stale, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decision.(events.DiscountReserved); !ok {
+		t.Errorf("flagged drift: decision = %T, want DiscountReserved", decision)
+	}
+}
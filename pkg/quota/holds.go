@@ -0,0 +1,188 @@
+package quota
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+const CollectionHolds = "holds"
+
+// Hold states
+const (
+	HoldHeld      = "held"      // reserved, awaiting DiscountConfirm
+	HoldConfirmed = "confirmed" // committed by the order service
+	HoldReleased  = "released"  // compensated by a DiscountRelease
+	HoldExpired   = "expired"   // not confirmed in time, quota returned by the sweeper
+)
+
+// Hold is a reservation awaiting confirmation, stored in the holds collection keyed by order_id.
+//
+// ExpiresAt drives the sweeper, which returns unconfirmed quota. DeleteAt is the field for the
+// Firestore TTL policy and is set well after ExpiresAt: TTL deletion is asynchronous and skips the
+// quota decrement, so a hold must never be deleted before the sweeper has had a chance to expire it.
+type Hold struct {
+	OrderID    string    `firestore:"order_id"`
+	UserID     string    `firestore:"user_id"`
+	TraceID    string    `firestore:"trace_id"`
+	QuotaDate  string    `firestore:"quota_date"`
+	LocationID string    `firestore:"location_id"`
+	IsTest     bool      `firestore:"is_test"`
+	State      string    `firestore:"state"`
+	ExpiresAt  time.Time `firestore:"expires_at"`
+	DeleteAt   time.Time `firestore:"delete_at"`
+	UpdatedAt  time.Time `firestore:"updated_at"`
+}
+
+// HoldPath is the path of an order's hold
+func HoldPath(orderID string) string {
+	return CollectionHolds + "/" + orderID
+}
+
+func (q *Quota) newHold(event events.OrderCreated, quotaDate string, now time.Time) Hold {
+	expiresAt := now.Add(q.Config.HoldTTL)
+	return Hold{
+		OrderID:    event.OrderID,
+		UserID:     event.UserID,
+		TraceID:    event.TraceID,
+		QuotaDate:  quotaDate,
+		LocationID: event.LocationID,
+		IsTest:     event.IsTest,
+		State:      HoldHeld,
+		ExpiresAt:  expiresAt,
+		DeleteAt:   expiresAt.Add(q.Config.HoldRetention),
+		UpdatedAt:  now,
+	}
+}
+
+// readHold reads an order's hold inside tx: nil when holds are disabled or the order has none
+func (q *Quota) readHold(tx common.DocTx, orderID string) (*Hold, error) {
+	if q.Config.HoldTTL <= 0 {
+		return nil, nil
+	}
+	doc, err := tx.Get(HoldPath(orderID))
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hold Hold
+	if err := doc.DataTo(&hold); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func setHoldState(tx common.DocTx, orderID, state string, now time.Time) error {
+	return tx.Update(HoldPath(orderID), []firestore.Update{
+		{Path: "state", Value: state},
+		{Path: "updated_at", Value: now},
+	})
+}
+
+// ConfirmHold marks an order's hold as confirmed so the sweeper leaves it alone. It reports whether
+// the hold was confirmed now; a missing hold, or one no longer held, is left as it is.
+func (q *Quota) ConfirmHold(ctx context.Context, orderID string) (bool, error) {
+	confirmed := false
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		confirmed = false
+		hold, err := q.readHold(tx, orderID)
+		if err != nil || hold == nil {
+			return err
+		}
+		if hold.State != HoldHeld {
+			q.Logger.Warn("Confirm for hold that is no longer held", "order_id", orderID, "state", hold.State)
+			return nil
+		}
+		confirmed = true
+		return setHoldState(tx, orderID, HoldConfirmed, time.Now())
+	})
+	return confirmed, err
+}
+
+// ExpireHold returns an unconfirmed hold's quota to its original day and records a DiscountRelease
+// for the audit trail; the release processor sees the expired state and does not decrement again.
+// It returns the hold, and the day's count after it, when the hold was expired now, and nil when it
+// was no longer held.
+func (q *Quota) ExpireHold(ctx context.Context, orderID string) (*Hold, int64, error) {
+	var releaseID string
+	var release events.Event
+	var expired *Hold
+	var usedAfter int64
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		expired = nil
+		doc, err := tx.Get(HoldPath(orderID))
+		if err != nil {
+			return err
+		}
+		var hold Hold
+		if err := doc.DataTo(&hold); err != nil {
+			return err
+		}
+		if hold.State != HoldHeld {
+			return nil
+		}
+		resPath := ReservationPath(hold.OrderID)
+		_, err = tx.Get(resPath)
+		if err != nil && !common.IsNotFound(err) {
+			return err
+		}
+		hasReservation := err == nil
+
+		counter := q.Counter(hold.IsTest, hold.LocationID, hold.QuotaDate)
+		counts, err := counter.ReadShards(tx)
+		if err != nil {
+			return err
+		}
+		user, err := counter.userQuota(tx, hold.UserID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		decremented, err := counter.decrement(tx, counts)
+		if err != nil {
+			return err
+		}
+		usedAfter = sum(counts)
+		if decremented {
+			usedAfter--
+		}
+		if err := counter.releaseUser(tx, hold.UserID, user); err != nil {
+			return err
+		}
+		if hasReservation {
+			if err := markReleased(tx, resPath, now); err != nil {
+				return err
+			}
+		}
+		if err := setHoldState(tx, hold.OrderID, HoldExpired, now); err != nil {
+			return err
+		}
+
+		releaseEvent := events.NewDiscountRelease(hold.TraceID, "", hold.OrderID, "Reservation hold expired without confirmation")
+		releaseEvent.Timestamp = now
+		releaseEvent.UserID = hold.UserID
+		releaseEvent.IsTest = hold.IsTest
+		releaseEvent.LocationID = hold.LocationID
+		if err := releaseEvent.Validate(); err != nil {
+			return err
+		}
+		release = q.Publisher.Stamp(releaseEvent)
+		expired = &hold
+		q.Logger.Warn("Reservation Hold Expired", "order_id", hold.OrderID, "trace_id", hold.TraceID, "date", hold.QuotaDate)
+		releaseID = q.Store.NewDocID(CollectionEvents)
+		return tx.Set(CollectionEvents+"/"+releaseID, release)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if expired != nil {
+		q.Publisher.MirrorEvent(ctx, releaseID, release)
+	}
+	return expired, usedAfter, nil
+}
@@ -0,0 +1,105 @@
+package quota
+
+import (
+	"context"
+	"errors"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Decisions are counted by whichever process makes them: the discount service, or the order service
+// with DISCOUNT_INLINE
+var (
+	quotaTransactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quota_transactions_total",
+		Help: "Quota reservation transactions, by result (committed, duplicate or failed).",
+	}, []string{"result"})
+
+	// quotaTransactionRetries counts transaction bodies re-run after Firestore aborted an attempt
+	// because of contention on the counter document
+	quotaTransactionRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "quota_transaction_retries_total",
+		Help: "Quota transaction attempts retried after contention.",
+	})
+)
+
+// Quota usage, labelled by quota day and location. Test traffic has its own counters and isn't
+// reported. Old days' series stay until the process restarts, one set per day and location.
+var (
+	quotaDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quota_decisions_total",
+		Help: "Discount decisions committed, by quota day, location and decision (approved or rejected).",
+	}, []string{"date", "location", "decision"})
+
+	quotaReleases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quota_releases_total",
+		Help: "Reservations returned to the quota by a release or an expired hold, by quota day and location.",
+	}, []string{"date", "location"})
+
+	quotaUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "quota_used",
+		Help: "The day's quota count as of this replica's last decision or release, by quota day and location.",
+	}, []string{"date", "location"})
+
+	quotaRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "quota_remaining",
+		Help: "Quota left for the day as of this replica's last decision or release, by quota day and location.",
+	}, []string{"date", "location"})
+)
+
+// Decide runs an R1 order through the drift and freeze checks and then Reserve, counting the
+// transaction and its decision. Both services decide orders through it, so the checks and metrics
+// don't depend on which one does. Errors are Reserve's.
+func (q *Quota) Decide(ctx context.Context, event events.OrderCreated, date string) (events.Event, error) {
+	if rejection, err := q.guard(ctx, event); rejection != nil || err != nil {
+		return rejection, err
+	}
+
+	decision, err := q.Reserve(ctx, event, date)
+	switch {
+	case errors.Is(err, ErrAlreadyDecided):
+		quotaTransactions.WithLabelValues("duplicate").Inc()
+	case err != nil:
+		quotaTransactions.WithLabelValues("failed").Inc()
+	default:
+		q.recordDecision(ctx, event, date, decision)
+		quotaTransactions.WithLabelValues("committed").Inc()
+	}
+	return decision, err
+}
+
+// recordDecision counts a committed decision. After an approval the day's count is read back
+// (summing the shards), since the transaction only saw its own shard.
+func (q *Quota) recordDecision(ctx context.Context, event events.OrderCreated, date string, decision events.Event) {
+	if event.IsTest {
+		return
+	}
+	switch decision.EventType() {
+	case events.EventTypeDiscountReserved:
+		quotaDecisions.WithLabelValues(date, event.LocationID, "approved").Inc()
+		count, err := q.Counter(false, event.LocationID, date).Total(ctx)
+		if err != nil {
+			q.Logger.Warn("Failed to read quota usage for metrics", "date", date, "location", event.LocationID, "error", err)
+			return
+		}
+		q.setUsage(date, event.LocationID, count)
+	case events.EventTypeDiscountRejected:
+		quotaDecisions.WithLabelValues(date, event.LocationID, "rejected").Inc()
+	}
+}
+
+// RecordRelease counts a committed release or expired hold; count is the day's count after it
+func (q *Quota) RecordRelease(isTest bool, date, location string, count int64) {
+	if isTest {
+		return
+	}
+	quotaReleases.WithLabelValues(date, location).Inc()
+	q.setUsage(date, location, count)
+}
+
+func (q *Quota) setUsage(date, location string, count int64) {
+	quotaUsed.WithLabelValues(date, location).Set(float64(count))
+	quotaRemaining.WithLabelValues(date, location).Set(float64(max(q.Config.Limit-count, 0)))
+}
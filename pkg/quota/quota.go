@@ -0,0 +1,129 @@
+// Package quota keeps the R2 daily discount quota: the per-location counters, the per-user caps,
+// and the reservations, holds and decision markers written with every decision. Reserve decides an
+// R1 order against it in a single transaction; Decide puts the drift and freeze checks in front of
+// it and counts the result.
+//
+// The discount service runs Decide as it consumes OrderCreated events; with DISCOUNT_INLINE the
+// order service runs it within the request instead. Both go through a common.DocStore, and both must
+// load the same configuration, or their counters diverge.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/eligibility"
+)
+
+const (
+	CollectionEvents = "events"
+	CollectionQuotas = "daily_quotas"
+
+	// CollectionTestQuotas keeps test-traffic reservations out of the production count
+	CollectionTestQuotas = "test_quotas"
+
+	DefaultLimit = 100 // R1, when DISCOUNT_QUOTA_LIMIT is unset
+)
+
+// Config is the quota's shape, shared by every process that decides orders
+type Config struct {
+	Limit         int64         // discounts per location per day
+	Shards        int           // shard documents per daily counter; 1 keeps the single document
+	PerUserLimit  int64         // discounts per user per day; 0 disables the cap
+	ReleaseLimit  int64         // a user's returned reservations per day before further ones are refused; 0 disables the cap
+	Percent       float64       // the highest rate an order may claim (DISCOUNT_PERCENT); 0 leaves the order's rate unchecked
+	Tiers         []Tier        // shrink the discount as the day's pool depletes; nil keeps the order's own rate
+	HoldTTL       time.Duration // confirmation deadline of a reservation; 0 disables holds
+	HoldRetention time.Duration // how long finished holds are kept before TTL deletion
+	Drift         Drift         // how far an order's timestamp may be from the clock deciding it
+	Freeze        Freeze        // the window around midnight in which no order is reserved
+}
+
+// LoadConfig reads the quota configuration from the environment. An unusable DISCOUNT_QUOTA_LIMIT
+// falls back to the default with a warning, as it always has; every other setting must be valid.
+func LoadConfig(logger *slog.Logger) (Config, error) {
+	cfg := Config{Limit: DefaultLimit}
+	limit, err := common.EnvInt("DISCOUNT_QUOTA_LIMIT", DefaultLimit)
+	if err != nil || limit < 1 {
+		logger.Warn("Invalid DISCOUNT_QUOTA_LIMIT, using the default", "error", err, "limit", limit, "default", DefaultLimit)
+		limit = DefaultLimit
+	}
+	cfg.Limit = int64(limit)
+
+	if cfg.Shards, err = common.EnvInt("QUOTA_SHARDS", 1); err != nil {
+		return cfg, err
+	}
+	if cfg.Shards < 1 || int64(cfg.Shards) > cfg.Limit {
+		return cfg, fmt.Errorf("QUOTA_SHARDS must be between 1 and the quota limit (%d), got %d", cfg.Limit, cfg.Shards)
+	}
+
+	perUser, err := common.EnvInt("DISCOUNT_PER_USER_LIMIT", 5)
+	if err != nil {
+		return cfg, err
+	}
+	if perUser < 0 {
+		return cfg, fmt.Errorf("DISCOUNT_PER_USER_LIMIT must not be negative, got %d", perUser)
+	}
+	cfg.PerUserLimit = int64(perUser)
+
+	releases, err := common.EnvInt("DISCOUNT_USER_RELEASE_LIMIT", 20)
+	if err != nil {
+		return cfg, err
+	}
+	if releases < 0 {
+		return cfg, fmt.Errorf("DISCOUNT_USER_RELEASE_LIMIT must not be negative, got %d", releases)
+	}
+	cfg.ReleaseLimit = int64(releases)
+
+	if cfg.Percent, err = common.EnvFloat("DISCOUNT_PERCENT", eligibility.DefaultPercent); err != nil {
+		return cfg, err
+	}
+	if err := eligibility.ValidatePercent(cfg.Percent); err != nil {
+		return cfg, fmt.Errorf("DISCOUNT_PERCENT: %w", err)
+	}
+
+	if spec := common.EnvOrDefault("DISCOUNT_TIERS", ""); spec != "" {
+		if err := json.Unmarshal([]byte(spec), &cfg.Tiers); err != nil {
+			return cfg, fmt.Errorf("DISCOUNT_TIERS: %w", err)
+		}
+		if err := ValidateTiers(cfg.Tiers); err != nil {
+			return cfg, fmt.Errorf("DISCOUNT_TIERS: %w", err)
+		}
+	}
+
+	if cfg.HoldTTL, err = common.EnvDuration("RESERVATION_HOLD_TTL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.HoldRetention, err = common.EnvDuration("RESERVATION_HOLD_RETENTION", 7*24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.Drift, err = LoadDrift(); err != nil {
+		return cfg, err
+	}
+	if cfg.Freeze, err = LoadFreeze(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Quota decides orders against the quota kept in Store
+type Quota struct {
+	Store  common.DocStore
+	Config Config
+	// Publisher stamps the events written inside quota transactions and mirrors them once committed
+	Publisher *common.Publisher
+	Logger    *slog.Logger
+	// Timezone's midnight starts each quota day (QUOTA_TIMEZONE); the freeze window sits around it
+	Timezone *time.Location
+}
+
+// Collection returns where an order's daily counters live
+func Collection(isTest bool) string {
+	if isTest {
+		return CollectionTestQuotas
+	}
+	return CollectionQuotas
+}
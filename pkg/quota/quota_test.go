@@ -0,0 +1,459 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+const testDate = "2026-10-15"
+
+func newTestQuota(cfg Config) (*Quota, *common.MemStore) {
+	if cfg.Limit == 0 {
+		cfg.Limit = 3
+	}
+	if cfg.Shards == 0 {
+		cfg.Shards = 1
+	}
+	store := common.NewMemStore()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return &Quota{Store: store, Config: cfg, Publisher: &common.Publisher{Logger: logger}, Logger: logger}, store
+}
+
+func testOrder(orderID, userID string) events.OrderCreated {
+	order := events.NewOrderCreated("trace-"+orderID, "", orderID)
+	order.UserID = userID
+	order.Name = "Test User"
+	order.Gender = "F"
+	order.DOB = "1990-01-01"
+	order.SelectedServices = []events.Service{{Name: "Consultation", Price: 1000}}
+	order.BasePrice = 1000
+	order.IsR1Eligible = true
+	order.DiscountPercent = 12
+	order.FinalPrice = 880
+	order.DiscountAmount = 120
+	order.DiscountableAmount = 1000
+	order.LocationID = events.DefaultLocation
+	order.QuotaDate = testDate
+	return order
+}
+
+func used(t *testing.T, q *Quota, date string) int64 {
+	t.Helper()
+	total, err := q.Counter(false, events.DefaultLocation, date).Total(context.Background())
+	if err != nil {
+		t.Fatalf("Total: %v", err)
+	}
+	return total
+}
+
+// decisionEvents lists the decisions written to the events collection
+func decisionEvents(store *common.MemStore) []*common.Doc {
+	var decisions []*common.Doc
+	for _, doc := range store.List(CollectionEvents) {
+		switch doc.Data()["type"] {
+		case string(events.EventTypeDiscountReserved), string(events.EventTypeDiscountRejected):
+			decisions = append(decisions, doc)
+		}
+	}
+	return decisions
+}
+
+func TestReserveUntilLimitThenReject(t *testing.T) {
+	for _, shards := range []int{1, 3} {
+		q, store := newTestQuota(Config{Limit: 3, Shards: shards})
+		ctx := context.Background()
+
+		var got []events.EventType
+		for _, id := range []string{"o1", "o2", "o3", "o4"} {
+			decision, err := q.Reserve(ctx, testOrder(id, ""), testDate)
+			if err != nil {
+				t.Fatalf("shards=%d: Reserve %s: %v", shards, id, err)
+			}
+			got = append(got, decision.EventType())
+		}
+		want := []events.EventType{events.EventTypeDiscountReserved, events.EventTypeDiscountReserved,
+			events.EventTypeDiscountReserved, events.EventTypeDiscountRejected}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("shards=%d: decisions = %v, want %v", shards, got, want)
+				break
+			}
+		}
+		if n := used(t, q, testDate); n != 3 {
+			t.Errorf("shards=%d: used = %d, want 3", shards, n)
+		}
+		if n := len(store.List(CollectionReservations)); n != 3 {
+			t.Errorf("shards=%d: %d reservations, want 3", shards, n)
+		}
+		if n := len(decisionEvents(store)); n != 4 {
+			t.Errorf("shards=%d: %d decision events, want 4", shards, n)
+		}
+		if n := len(store.List(CollectionDecisions)); n != 4 {
+			t.Errorf("shards=%d: %d decision markers, want 4", shards, n)
+		}
+	}
+}
+
+func TestReserveWritesDecisionWithMarker(t *testing.T) {
+	q, store := newTestQuota(Config{})
+	ctx := context.Background()
+
+	decision, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved, ok := decision.(events.DiscountReserved)
+	if !ok {
+		t.Fatalf("decision is %T, want events.DiscountReserved", decision)
+	}
+	if reserved.OrderID != "o1" || reserved.QuotaLimit != 3 || reserved.QuotaRemaining != 2 {
+		t.Errorf("reserved = %+v", reserved)
+	}
+
+	marker, err := store.Get(ctx, DecisionPath("o1"))
+	if err != nil {
+		t.Fatalf("decision marker: %v", err)
+	}
+	var m DecisionMarker
+	if err := marker.DataTo(&m); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ctx, CollectionEvents+"/"+m.EventID)
+	if err != nil {
+		t.Fatalf("the marker points at %s, which wasn't written: %v", m.EventID, err)
+	}
+	if stored.Data()["type"] != string(events.EventTypeDiscountReserved) || m.Type != events.EventTypeDiscountReserved {
+		t.Errorf("marker %+v points at a %v event", m, stored.Data()["type"])
+	}
+}
+
+func TestRejectWritesDecisionWithMarker(t *testing.T) {
+	q, store := newTestQuota(Config{})
+	ctx := context.Background()
+
+	decision, err := q.Reject(ctx, testOrder("o1", "u1"), "Order timestamp is out of range. Please try again.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected, ok := decision.(events.DiscountRejected); !ok || rejected.OrderID != "o1" {
+		t.Fatalf("decision = %+v, want a DiscountRejected for o1", decision)
+	}
+	marker, err := store.Get(ctx, DecisionPath("o1"))
+	if err != nil {
+		t.Fatalf("decision marker: %v", err)
+	}
+	if marker.Data()["type"] != string(events.EventTypeDiscountRejected) {
+		t.Errorf("marker = %v", marker.Data())
+	}
+	if used(t, q, testDate) != 0 {
+		t.Error("a rejection took a quota slot")
+	}
+
+	// The order is decided: neither a second rejection nor a reservation writes another decision
+	if _, err := q.Reject(ctx, testOrder("o1", "u1"), "again"); !errors.Is(err, ErrAlreadyDecided) {
+		t.Errorf("second Reject error = %v, want ErrAlreadyDecided", err)
+	}
+	if _, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate); !errors.Is(err, ErrAlreadyDecided) {
+		t.Errorf("Reserve after Reject error = %v, want ErrAlreadyDecided", err)
+	}
+	if n := len(decisionEvents(store)); n != 1 {
+		t.Errorf("%d decisions written, want 1", n)
+	}
+}
+
+func TestReleaseReturnsSlotOnce(t *testing.T) {
+	q, _ := newTestQuota(Config{PerUserLimit: 5})
+	ctx := context.Background()
+	if _, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate); err != nil {
+		t.Fatal(err)
+	}
+
+	release := events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed")
+	for i, wantReleased := range []bool{true, false} {
+		res, usedAfter, err := q.Release(ctx, release)
+		if err != nil {
+			t.Fatalf("release %d: %v", i+1, err)
+		}
+		if (res != nil) != wantReleased {
+			t.Errorf("release %d returned %+v, want released = %v", i+1, res, wantReleased)
+		}
+		if wantReleased && (res.QuotaDate != testDate || usedAfter != 0) {
+			t.Errorf("release %d: date %s, used after %d", i+1, res.QuotaDate, usedAfter)
+		}
+	}
+	if n := used(t, q, testDate); n != 0 {
+		t.Errorf("used = %d after a duplicate release, want 0", n)
+	}
+}
+
+func TestExpireHoldReturnsQuota(t *testing.T) {
+	q, store := newTestQuota(Config{HoldTTL: time.Minute, HoldRetention: time.Hour})
+	ctx := context.Background()
+
+	decision, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadline := decision.(events.DiscountReserved).ConfirmDeadline; deadline.IsZero() {
+		t.Error("a reservation with holds enabled has no confirm deadline")
+	}
+
+	hold, usedAfter, err := q.ExpireHold(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold == nil || usedAfter != 0 {
+		t.Fatalf("ExpireHold = %+v, %d; want the hold and 0 used", hold, usedAfter)
+	}
+	doc, err := store.Get(ctx, HoldPath("o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := doc.Data()["state"]; state != HoldExpired {
+		t.Errorf("hold state = %v, want %s", state, HoldExpired)
+	}
+
+	// A release arriving after the expiry finds the slot already returned
+	res, _, err := q.Release(ctx, events.NewDiscountRelease("trace-o1", "", "o1", "Late release"))
+	if err != nil || res != nil {
+		t.Errorf("Release after expiry = %+v, %v; want nothing released", res, err)
+	}
+	if n := used(t, q, testDate); n != 0 {
+		t.Errorf("used = %d, want 0", n)
+	}
+	if confirmed, err := q.ConfirmHold(ctx, "o1"); err != nil || confirmed {
+		t.Errorf("ConfirmHold on an expired hold = %v, %v; want false", confirmed, err)
+	}
+}
+
+func TestDuplicateOrderEventsReserveOnce(t *testing.T) {
+	q, store := newTestQuota(Config{Limit: 10, PerUserLimit: 5})
+	ctx := context.Background()
+	order := testOrder("o1", "u1")
+
+	// Two copies of one OrderCreated, decided at the same time, race on the decision marker
+	const copies = 2
+	errs := make(chan error, copies)
+	start := make(chan struct{})
+	for i := 0; i < copies; i++ {
+		go func() {
+			<-start
+			_, err := q.Reserve(ctx, order, testDate)
+			errs <- err
+		}()
+	}
+	close(start)
+
+	decided, duplicates := 0, 0
+	for i := 0; i < copies; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			decided++
+		case errors.Is(err, ErrAlreadyDecided):
+			duplicates++
+		default:
+			t.Fatalf("Reserve: %v", err)
+		}
+	}
+	if decided != 1 || duplicates != 1 {
+		t.Errorf("%d decided and %d duplicates, want 1 and 1", decided, duplicates)
+	}
+	if n := len(store.List(CollectionReservations)); n != 1 {
+		t.Errorf("%d reservations, want 1", n)
+	}
+	if n := len(decisionEvents(store)); n != 1 {
+		t.Errorf("%d decision events, want 1", n)
+	}
+	if n := used(t, q, testDate); n != 1 {
+		t.Errorf("used = %d, want 1", n)
+	}
+	user, err := store.Get(ctx, q.Counter(false, events.DefaultLocation, testDate).userDoc("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := common.GetInt64(user.Data(), "count"); count != 1 {
+		t.Errorf("user count = %d, want 1", count)
+	}
+
+	// A copy redelivered later is refused without touching the counter
+	if _, err := q.Reserve(ctx, order, testDate); !errors.Is(err, ErrAlreadyDecided) {
+		t.Errorf("redelivered copy: err = %v, want ErrAlreadyDecided", err)
+	}
+	if n := used(t, q, testDate); n != 1 {
+		t.Errorf("used = %d after a redelivery, want 1", n)
+	}
+}
+
+func TestLoadConfigQuotaLimit(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int64
+	}{
+		{"", DefaultLimit},
+		{"25", 25},
+		{"1", 1},
+		{"0", DefaultLimit},
+		{"-3", DefaultLimit},
+		{"lots", DefaultLimit},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Setenv("DISCOUNT_QUOTA_LIMIT", tt.env)
+		cfg, err := LoadConfig(logger)
+		if err != nil {
+			t.Errorf("DISCOUNT_QUOTA_LIMIT=%q: %v", tt.env, err)
+			continue
+		}
+		if cfg.Limit != tt.want {
+			t.Errorf("DISCOUNT_QUOTA_LIMIT=%q: limit = %d, want %d", tt.env, cfg.Limit, tt.want)
+		}
+	}
+}
+
+func TestConfiguredLimitDecides(t *testing.T) {
+	t.Setenv("DISCOUNT_QUOTA_LIMIT", "1")
+	cfg, err := LoadConfig(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := newTestQuota(cfg)
+	ctx := context.Background()
+	first, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.Reserve(ctx, testOrder("o2", "u2"), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.EventType() != events.EventTypeDiscountReserved || second.EventType() != events.EventTypeDiscountRejected {
+		t.Errorf("decisions = %s, %s; want the limit of 1 to reserve one and reject the next", first.EventType(), second.EventType())
+	}
+}
+
+func TestPerUserCapWithGlobalRoom(t *testing.T) {
+	q, store := newTestQuota(Config{Limit: 10, PerUserLimit: 2})
+	ctx := context.Background()
+
+	var got []events.Event
+	for _, id := range []string{"o1", "o2", "o3"} {
+		decision, err := q.Reserve(ctx, testOrder(id, "u1"), testDate)
+		if err != nil {
+			t.Fatalf("Reserve %s: %v", id, err)
+		}
+		got = append(got, decision)
+	}
+	rejected, ok := got[2].(events.DiscountRejected)
+	if !ok || rejected.Reason != "Per-user daily discount limit reached" {
+		t.Fatalf("u1's third order got %+v, want the per-user rejection", got[2])
+	}
+	if rejected.QuotaRemaining != 8 {
+		t.Errorf("rejection reports %d remaining, want the global 8", rejected.QuotaRemaining)
+	}
+
+	// The rejection touched neither counter
+	if n := used(t, q, testDate); n != 2 {
+		t.Errorf("used = %d, want 2", n)
+	}
+	user, err := store.Get(ctx, q.Counter(false, events.DefaultLocation, testDate).userDoc("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := common.GetInt64(user.Data(), "count"); count != 2 {
+		t.Errorf("u1's count = %d, want 2", count)
+	}
+
+	// Another user still gets the global quota
+	decision, err := q.Reserve(ctx, testOrder("o4", "u2"), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.EventType() != events.EventTypeDiscountReserved {
+		t.Errorf("u2 got %s, want a reservation", decision.EventType())
+	}
+}
+
+func TestLoadConfigPercent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range []struct {
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{"", 12, false},
+		{"20", 20, false},
+		{"100", 100, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"100.5", 0, true},
+		{"twelve", 0, true},
+	} {
+		t.Setenv("DISCOUNT_PERCENT", tt.env)
+		cfg, err := LoadConfig(logger)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DISCOUNT_PERCENT=%q: error = %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.Percent != tt.want {
+			t.Errorf("DISCOUNT_PERCENT=%q: percent = %g, want %g", tt.env, cfg.Percent, tt.want)
+		}
+	}
+}
+
+func TestReserveChecksConfiguredPercent(t *testing.T) {
+	// An order at a 20% promotion rate is reserved at it
+	q, store := newTestQuota(Config{Percent: 20})
+	ctx := context.Background()
+	order := testOrder("o1", "u1")
+	order.DiscountPercent, order.FinalPrice, order.DiscountAmount = 20, 800, 200
+	if reserved := reserve(t, q, order); reserved.DiscountPercent != 20 || reserved.FinalPrice != 800 || reserved.DiscountAmount != 200 {
+		t.Errorf("reserved at %g%%: final %.2f, discount %.2f; want 20%%: 800.00, 200.00",
+			reserved.DiscountPercent, reserved.FinalPrice, reserved.DiscountAmount)
+	}
+
+	// An order claiming more is repriced at the configured rate
+	order = testOrder("o2", "u2")
+	order.DiscountPercent, order.FinalPrice, order.DiscountAmount = 50, 500, 500
+	if reserved := reserve(t, q, order); reserved.DiscountPercent != 20 || reserved.FinalPrice != 800 || reserved.DiscountAmount != 200 {
+		t.Errorf("a 50%% claim was reserved at %g%%: final %.2f, discount %.2f; want 20%%: 800.00, 200.00",
+			reserved.DiscountPercent, reserved.FinalPrice, reserved.DiscountAmount)
+	}
+
+	// One that can't be repriced is rejected without taking a slot
+	order = testOrder("o3", "u3")
+	order.DiscountPercent, order.FinalPrice, order.DiscountAmount, order.DiscountableAmount = 50, 500, 500, 0
+	decision, err := q.Reserve(ctx, order, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decision.(events.DiscountRejected); !ok {
+		t.Errorf("decision = %T, want DiscountRejected", decision)
+	}
+	if _, err := store.Get(ctx, DecisionPath("o3")); err != nil {
+		t.Errorf("the rejection has no decision marker: %v", err)
+	}
+	if n := used(t, q, testDate); n != 2 {
+		t.Errorf("used = %d, want 2", n)
+	}
+}
+
+// reserve reserves order, failing the test unless it is approved
+func reserve(t *testing.T, q *Quota, order events.OrderCreated) events.DiscountReserved {
+	t.Helper()
+	decision, err := q.Reserve(context.Background(), order, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved, ok := decision.(events.DiscountReserved)
+	if !ok {
+		t.Fatalf("%s: decision = %T, want DiscountReserved", order.OrderID, decision)
+	}
+	return reserved
+}
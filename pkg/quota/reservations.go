@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+const CollectionReservations = "reservations"
+
+// Reservation records an approved order's quota slot, keyed by order_id, so a release returns it to
+// the day (and location) it was taken from rather than to whatever day the release arrives on.
+// Released is set in the same transaction that returns the slot, so a duplicate or replayed
+// release finds it set and decrements nothing.
+type Reservation struct {
+	OrderID    string    `firestore:"order_id"`
+	UserID     string    `firestore:"user_id"`
+	QuotaDate  string    `firestore:"quota_date"`
+	LocationID string    `firestore:"location_id"`
+	IsTest     bool      `firestore:"is_test"`
+	Released   bool      `firestore:"released"`  // quota returned, by a DiscountRelease or an expired hold
+	Committed  bool      `firestore:"committed"` // the booking was paid for (PaymentCompleted)
+	ReservedAt time.Time `firestore:"reserved_at"`
+	UpdatedAt  time.Time `firestore:"updated_at"`
+}
+
+func newReservation(event events.OrderCreated, quotaDate string, now time.Time) Reservation {
+	return Reservation{
+		OrderID:    event.OrderID,
+		UserID:     event.UserID,
+		QuotaDate:  quotaDate,
+		LocationID: event.LocationID,
+		IsTest:     event.IsTest,
+		ReservedAt: now,
+		UpdatedAt:  now,
+	}
+}
+
+// ReservationPath is the path of an order's reservation
+func ReservationPath(orderID string) string {
+	return CollectionReservations + "/" + orderID
+}
+
+// markReleased records inside tx that a reservation's quota has been returned
+func markReleased(tx common.DocTx, path string, now time.Time) error {
+	return tx.Update(path, []firestore.Update{
+		{Path: "released", Value: true},
+		{Path: "updated_at", Value: now},
+	})
+}
+
+// Release returns a released order's slot to the day and location it was reserved from, and
+// releases its hold so the sweeper leaves it alone. It returns the reservation, and the day's count
+// after the release, when a slot was returned, and nil when there was nothing to return: no
+// reservation, or one already returned.
+func (q *Quota) Release(ctx context.Context, event events.DiscountRelease) (*Reservation, int64, error) {
+	var released *Reservation
+	var usedAfter int64
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		released = nil
+		// The reservation knows the day and location the slot was taken from, and whether it was already returned
+		resPath := ReservationPath(event.OrderID)
+		resDoc, err := tx.Get(resPath)
+		if common.IsNotFound(err) {
+			q.Logger.Warn("No reservation for released order, nothing to decrement", "order_id", event.OrderID, "trace_id", event.TraceID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var res Reservation
+		if err := resDoc.DataTo(&res); err != nil {
+			return err
+		}
+		if res.Released {
+			q.Logger.Info("Reservation already released, nothing to release", "order_id", event.OrderID)
+			return nil
+		}
+
+		// With holds enabled the hold is released too, so the sweeper leaves it alone
+		hold, err := q.readHold(tx, event.OrderID)
+		if err != nil {
+			return err
+		}
+		if hold != nil && (hold.State == HoldReleased || hold.State == HoldExpired) {
+			q.Logger.Info("Hold already returned, nothing to release", "order_id", event.OrderID, "state", hold.State)
+			return nil
+		}
+		counter := q.Counter(res.IsTest, res.LocationID, res.QuotaDate)
+		counts, err := counter.ReadShards(tx)
+		if err != nil {
+			return err
+		}
+		user, err := counter.userQuota(tx, res.UserID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := markReleased(tx, resPath, now); err != nil {
+			return err
+		}
+		if hold != nil {
+			if err := setHoldState(tx, event.OrderID, HoldReleased, now); err != nil {
+				return err
+			}
+		}
+
+		decremented, err := counter.decrement(tx, counts)
+		if err != nil {
+			return err
+		}
+		if err := counter.releaseUser(tx, res.UserID, user); err != nil {
+			return err
+		}
+		usedAfter = sum(counts)
+		if decremented {
+			usedAfter--
+			q.Logger.Info("Quota Compensation Executed", "order_id", event.OrderID, "date", res.QuotaDate, "new_count", usedAfter)
+		} else {
+			// The count is already zero, e.g. after a reconciliation
+			q.Logger.Info("Quota count is already zero, nothing to decrement", "order_id", event.OrderID, "date", res.QuotaDate)
+		}
+		released = &res
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return released, usedAfter, nil
+}
+
+// Commit marks a paid order's reservation as committed. With holds enabled it also confirms the
+// hold, so a booking whose DiscountConfirm was lost is not expired by the sweeper. It reports
+// whether the reservation was committed now, rather than missing or committed before.
+func (q *Quota) Commit(ctx context.Context, event events.PaymentCompleted) (bool, error) {
+	committed := false
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		committed = false
+		resPath := ReservationPath(event.OrderID)
+		resDoc, err := tx.Get(resPath)
+		if common.IsNotFound(err) {
+			q.Logger.Warn("No reservation for paid order", "order_id", event.OrderID, "trace_id", event.TraceID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var res Reservation
+		if err := resDoc.DataTo(&res); err != nil {
+			return err
+		}
+		if res.Committed {
+			return nil
+		}
+		if res.Released {
+			// The quota went back before the payment was recorded; the booking keeps its discount
+			q.Logger.Warn("Payment completed for a released reservation", "order_id", event.OrderID, "trace_id", event.TraceID,
+				"date", res.QuotaDate)
+		}
+
+		hold, err := q.readHold(tx, event.OrderID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Update(resPath, []firestore.Update{
+			{Path: "committed", Value: true},
+			{Path: "updated_at", Value: now},
+		}); err != nil {
+			return err
+		}
+		if hold != nil && hold.State == HoldHeld {
+			if err := setHoldState(tx, event.OrderID, HoldConfirmed, now); err != nil {
+				return err
+			}
+		}
+		committed = true
+		return nil
+	})
+	return committed, err
+}
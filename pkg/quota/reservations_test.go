@@ -0,0 +1,109 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+const nextDate = "2026-10-16"
+
+func TestReleaseReturnsSlotToReservedDay(t *testing.T) {
+	tests := []struct {
+		name string
+		// releaseOn is the quota day the release arrives on
+		releaseOn string
+	}{
+		{"same day", testDate},
+		{"next day", nextDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newTestQuota(Config{Limit: 5})
+			ctx := context.Background()
+			if _, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate); err != nil {
+				t.Fatal(err)
+			}
+			// Another order is reserved on the day the release arrives
+			other := testOrder("o2", "u2")
+			other.QuotaDate = tt.releaseOn
+			if _, err := q.Reserve(ctx, other, tt.releaseOn); err != nil {
+				t.Fatal(err)
+			}
+
+			res, usedAfter, err := q.Release(ctx, events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res == nil || res.QuotaDate != testDate {
+				t.Fatalf("released %+v, want o1's reservation on %s", res, testDate)
+			}
+
+			wantUsed := map[string]int64{testDate: 0, nextDate: 1}
+			if tt.releaseOn == testDate {
+				wantUsed = map[string]int64{testDate: 1, nextDate: 0}
+			}
+			for date, want := range wantUsed {
+				if n := used(t, q, date); n != want {
+					t.Errorf("%s used = %d, want %d", date, n, want)
+				}
+			}
+			if usedAfter != wantUsed[testDate] {
+				t.Errorf("usedAfter = %d, want %s's count %d", usedAfter, testDate, wantUsed[testDate])
+			}
+			if n := len(store.List(CollectionReservations)); n != 2 {
+				t.Errorf("%d reservations, want 2", n)
+			}
+		})
+	}
+}
+
+func TestReleaseWithoutReservation(t *testing.T) {
+	q, _ := newTestQuota(Config{})
+	ctx := context.Background()
+	if _, err := q.Reserve(ctx, testOrder("o1", "u1"), testDate); err != nil {
+		t.Fatal(err)
+	}
+
+	res, _, err := q.Release(ctx, events.NewDiscountRelease("trace-o9", "", "o9", "Payment failed"))
+	if err != nil || res != nil {
+		t.Errorf("Release of an unreserved order = %+v, %v; want nothing released", res, err)
+	}
+	if n := used(t, q, testDate); n != 1 {
+		t.Errorf("used = %d, want 1", n)
+	}
+}
+
+func TestDuplicateReleaseDecrementsOnce(t *testing.T) {
+	q, store := newTestQuota(Config{Limit: 5})
+	ctx := context.Background()
+	for _, id := range []string{"o1", "o2"} {
+		if _, err := q.Reserve(ctx, testOrder(id, "u-"+id), testDate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same DiscountRelease, delivered twice
+	release := events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed")
+	for i := 0; i < 2; i++ {
+		if _, _, err := q.Release(ctx, release); err != nil {
+			t.Fatalf("release %d: %v", i+1, err)
+		}
+	}
+	if n := used(t, q, testDate); n != 1 {
+		t.Errorf("used = %d, want 1: the count drops by exactly one", n)
+	}
+
+	doc, err := store.Get(ctx, ReservationPath("o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Reservation
+	if err := doc.DataTo(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Released {
+		t.Error("the reservation isn't flagged released")
+	}
+}
@@ -0,0 +1,246 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// CollectionDecisions holds one marker per decided order, keyed by order_id. The quota transaction
+// reads it before deciding and creates it with the decision, so duplicate OrderCreated events racing
+// past the decision query can't both reserve: the loser's transaction is retried, finds the marker
+// and stops.
+const CollectionDecisions = "order_decisions"
+
+// ErrAlreadyDecided is returned by Reserve for an order whose decision marker exists
+var ErrAlreadyDecided = errors.New("order already decided")
+
+// DecisionMarker records which decision an order got, and in which event
+type DecisionMarker struct {
+	OrderID   string           `firestore:"order_id"`
+	Type      events.EventType `firestore:"type"` // DiscountReserved or DiscountRejected
+	EventID   string           `firestore:"event_id"`
+	DecidedAt time.Time        `firestore:"decided_at"`
+}
+
+// DecisionPath is the path of an order's decision marker
+func DecisionPath(orderID string) string {
+	return CollectionDecisions + "/" + orderID
+}
+
+// Rejection is the DiscountRejected answering event
+func Rejection(ctx context.Context, event events.OrderCreated, reason string) events.DiscountRejected {
+	rejected := events.NewDiscountRejected(event.TraceID, common.ParentSpanID(ctx), event.OrderID, reason)
+	rejected.InstanceID = event.InstanceID
+	return rejected
+}
+
+// Reject decides an order with a rejection for reason, without touching the quota: for orders
+// refused before they reach it. Like Reserve, it writes the decision marker with the event and
+// returns ErrAlreadyDecided, having written nothing, when the order was decided before.
+func (q *Quota) Reject(ctx context.Context, event events.OrderCreated, reason string) (events.Event, error) {
+	var eventID string
+	var decision events.Event
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		if _, err := tx.Get(DecisionPath(event.OrderID)); err == nil {
+			return ErrAlreadyDecided
+		} else if !common.IsNotFound(err) {
+			return err
+		}
+		var err error
+		eventID, decision, err = q.writeDecision(tx, event.OrderID, Rejection(ctx, event, reason))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.Publisher.MirrorEvent(ctx, eventID, decision)
+	return decision, nil
+}
+
+// writeDecision writes decision as a new event document inside tx, with the order's marker
+// pointing at it, and returns the event's ID and the stamped event
+func (q *Quota) writeDecision(tx common.DocTx, orderID string, decision events.Event) (string, events.Event, error) {
+	if err := events.Validate(decision); err != nil {
+		return "", nil, err
+	}
+	eventID := q.Store.NewDocID(CollectionEvents)
+	if err := tx.Create(DecisionPath(orderID), DecisionMarker{
+		OrderID:   orderID,
+		Type:      decision.EventType(),
+		EventID:   eventID,
+		DecidedAt: time.Now(),
+	}); err != nil {
+		return "", nil, err
+	}
+	decision = q.Publisher.Stamp(decision)
+	return eventID, decision, tx.Set(CollectionEvents+"/"+eventID, decision)
+}
+
+// Reserve decides an R1 order against the quota of its location (event.LocationID, already
+// resolved) on date. It tries shards until one has room, the last one rejecting if it is full too,
+// and writes the decision event together with the counter, reservation, hold and decision marker,
+// returning it once committed. It returns ErrAlreadyDecided, having written nothing, when the order
+// was decided before.
+//
+// An order claiming more than Config.Percent is repriced at it, or rejected when it can't be.
+func (q *Quota) Reserve(ctx context.Context, event events.OrderCreated, date string) (events.Event, error) {
+	if q.overRate(event) && event.DiscountableAmount <= 0 {
+		q.Logger.Warn("Order discount above the configured rate", "trace_id", event.TraceID, "order_id", event.OrderID,
+			"discount", event.DiscountPercent, "percent", q.Config.Percent)
+		return q.Reject(ctx, event, "The discount rate is not available. Please try again.")
+	}
+	counter := q.Counter(event.IsTest, event.LocationID, date)
+	order := counter.probeOrder()
+	for n, shard := range order {
+		decision, err := q.reserveOnShard(ctx, event, counter, shard, date, n == len(order)-1)
+		if err != nil || decision != nil {
+			return decision, err
+		}
+	}
+	return nil, errors.New("no counter shards to reserve on")
+}
+
+// overRate reports whether an order claims more than the configured rate. Tiers, when set, price
+// every reservation themselves.
+func (q *Quota) overRate(event events.OrderCreated) bool {
+	return q.Config.Tiers == nil && q.Config.Percent > 0 && event.DiscountPercent > q.Config.Percent
+}
+
+// reserveOnShard reserves one slot on a counter shard and writes the decision in the same transaction,
+// returning the decision once committed. When the shard is full it writes nothing and returns nil,
+// unless it is the last shard to try, in which case it writes the rejection.
+func (q *Quota) reserveOnShard(ctx context.Context, event events.OrderCreated, counter Counter, shard int, today string, last bool) (events.Event, error) {
+	cfg := q.Config
+	quotaPath := counter.shard(shard)
+	capacity := counter.capacity(shard, cfg.Limit)
+	decided := false
+	var eventID string
+	var decisionEvent events.Event
+
+	attempts := 0
+	err := q.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		if attempts++; attempts > 1 {
+			quotaTransactionRetries.Inc()
+		}
+		decided = false
+
+		// A duplicate of this event may have been decided since the caller looked
+		markerPath := DecisionPath(event.OrderID)
+		if _, err := tx.Get(markerPath); err == nil {
+			return ErrAlreadyDecided
+		} else if !common.IsNotFound(err) {
+			return err
+		}
+
+		// Read the shard's count; a missing document is a new day, count 0
+		currentCount := int64(0)
+		doc, err := tx.Get(quotaPath)
+		if err != nil && !common.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			// A malformed count fails the transaction rather than approving against zero
+			if currentCount, err = countField(doc); err != nil {
+				return err
+			}
+		}
+
+		// The user's own cap applies whichever shard the order lands on
+		user, err := counter.userQuota(tx, event.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Tiers go by the day's approvals, so every shard is read
+		dayCount := currentCount
+		if cfg.Tiers != nil && cfg.Shards > 1 {
+			counts, err := counter.ReadShards(tx)
+			if err != nil {
+				return err
+			}
+			dayCount = sum(counts)
+		}
+
+		if cfg.PerUserLimit > 0 && user.Count >= cfg.PerUserLimit {
+			rejection := Rejection(ctx, event, "Per-user daily discount limit reached")
+			rejection.QuotaLimit, rejection.QuotaRemaining = cfg.Limit, max(capacity-currentCount, 0)
+			decisionEvent = rejection
+			q.Logger.Info("Per-User Quota Exhausted", "trace_id", event.TraceID, "order_id", event.OrderID,
+				"user_id", event.UserID, "user_limit", cfg.PerUserLimit)
+		} else if cfg.ReleaseLimit > 0 && user.Releases >= cfg.ReleaseLimit {
+			rejection := Rejection(ctx, event, "Too many reservation attempts")
+			rejection.QuotaLimit, rejection.QuotaRemaining = cfg.Limit, max(capacity-currentCount, 0)
+			decisionEvent = rejection
+			q.Logger.Warn("Too Many Reservation Attempts", "trace_id", event.TraceID, "order_id", event.OrderID,
+				"user_id", event.UserID, "releases", user.Releases, "release_limit", cfg.ReleaseLimit)
+		} else if currentCount < capacity {
+			// Approve
+			newCount := currentCount + 1
+			if err := tx.Set(quotaPath, map[string]interface{}{"count": newCount}, firestore.MergeAll); err != nil {
+				return err
+			}
+			if err := counter.reserveUser(tx, event.UserID, user); err != nil {
+				return err
+			}
+			if err := tx.Set(ReservationPath(event.OrderID), newReservation(event, today, time.Now())); err != nil {
+				return err
+			}
+			percent, finalPrice, discountAmount := event.DiscountPercent, event.FinalPrice, event.DiscountAmount
+			if cfg.Tiers != nil {
+				finalPrice, discountAmount, percent = tierPrice(event, TierPercent(cfg.Tiers, dayCount))
+			} else if q.overRate(event) {
+				q.Logger.Warn("Order discount above the configured rate, repriced", "trace_id", event.TraceID,
+					"order_id", event.OrderID, "discount", event.DiscountPercent, "percent", cfg.Percent)
+				finalPrice, discountAmount, percent = tierPrice(event, cfg.Percent)
+			}
+
+			var deadline time.Time
+			if cfg.HoldTTL > 0 {
+				hold := q.newHold(event, today, time.Now())
+				if err := tx.Set(HoldPath(event.OrderID), hold); err != nil {
+					return err
+				}
+				deadline = hold.ExpiresAt
+			}
+
+			reserved := events.NewDiscountReserved(event.TraceID, common.ParentSpanID(ctx), event.OrderID)
+			reserved.IsTest = event.IsTest
+			reserved.LocationID = event.LocationID
+			reserved.ConfirmDeadline = deadline
+			reserved.QuotaLimit = cfg.Limit
+			reserved.QuotaRemaining = capacity - newCount // this shard only, so a lower bound when sharded
+			reserved.InstanceID = event.InstanceID
+			reserved.DiscountPercent = percent
+			reserved.FinalPrice = finalPrice
+			reserved.DiscountAmount = discountAmount
+			decisionEvent = reserved
+			// With sharding, used/remaining are the shard's share of the quota
+			q.Logger.Info("R2 Quota Reserved", "trace_id", event.TraceID, "order_id", event.OrderID, "location", event.LocationID,
+				"shard", shard, "quota_used", newCount, "quota_remaining", capacity-newCount, "discount", percent)
+		} else if last {
+			// Reject
+			rejection := Rejection(ctx, event, "Daily discount quota reached. Please try again tomorrow.")
+			rejection.QuotaLimit = cfg.Limit
+			decisionEvent = rejection
+			q.Logger.Info("R2 Quota Exhausted", "trace_id", event.TraceID, "order_id", event.OrderID, "location", event.LocationID,
+				"shard", shard, "quota_limit", cfg.Limit, "current_count", currentCount)
+		} else {
+			// Shard full, try the next one
+			return nil
+		}
+
+		decided = true
+		eventID, decisionEvent, err = q.writeDecision(tx, event.OrderID, decisionEvent)
+		return err
+	})
+	if err != nil || !decided {
+		return nil, err
+	}
+	q.Publisher.MirrorEvent(ctx, eventID, decisionEvent)
+	return decisionEvent, nil
+}
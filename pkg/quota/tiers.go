@@ -0,0 +1,60 @@
+package quota
+
+import (
+	"fmt"
+
+	"github.com/devdolphintest/discount-system/pkg/eligibility"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
+)
+
+// Tier grants Percent to the day's approvals up to UpTo (cumulative); the last tier has no bound
+// and covers the rest of the day
+type Tier struct {
+	UpTo    int64   `json:"up_to,omitempty"`
+	Percent float64 `json:"percent"`
+}
+
+// ValidateTiers checks a DISCOUNT_TIERS table, such as
+// [{"up_to":30,"percent":15},{"up_to":70,"percent":10},{"percent":5}]
+func ValidateTiers(tiers []Tier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("no tiers")
+	}
+	var prev int64
+	for i, t := range tiers {
+		if err := eligibility.ValidatePercent(t.Percent); err != nil {
+			return fmt.Errorf("tier %d: %w", i+1, err)
+		}
+		last := i == len(tiers)-1
+		switch {
+		case last && t.UpTo != 0:
+			return fmt.Errorf("the last tier must have no up_to, so it covers the rest of the day")
+		case !last && t.UpTo <= prev:
+			return fmt.Errorf("tier %d: up_to must exceed the previous tier's (%d), got %d", i+1, prev, t.UpTo)
+		}
+		prev = t.UpTo
+	}
+	return nil
+}
+
+// TierPercent is the rate for the approval that follows approved earlier approvals that day
+func TierPercent(tiers []Tier, approved int64) float64 {
+	for _, t := range tiers {
+		if t.UpTo == 0 || approved < t.UpTo {
+			return t.Percent
+		}
+	}
+	return tiers[len(tiers)-1].Percent
+}
+
+// tierPrice reprices an order at percent. Orders from order services that predate
+// DiscountableAmount can't be repriced and keep their own rate, as do orders with nothing
+// discountable.
+func tierPrice(event events.OrderCreated, percent float64) (finalPrice, discountAmount, applied float64) {
+	if event.DiscountableAmount <= 0 {
+		return event.FinalPrice, event.DiscountAmount, event.DiscountPercent
+	}
+	finalPrice, _, _ = pricing.FinalPrice(event.BasePrice, event.DiscountableAmount, percent, pricing.FloorClamp)
+	return finalPrice, pricing.DiscountAmount(event.BasePrice, finalPrice), percent
+}
@@ -0,0 +1,71 @@
+package quota
+
+import "testing"
+
+func TestTierPercentBoundaries(t *testing.T) {
+	tiers := []Tier{{UpTo: 30, Percent: 15}, {UpTo: 70, Percent: 10}, {Percent: 5}}
+	tests := []struct {
+		approved int64
+		want     float64
+	}{
+		{0, 15},
+		{29, 15}, // the 30th approval, the first tier's last
+		{30, 10}, // exactly the threshold starts the next tier
+		{31, 10},
+		{69, 10},
+		{70, 5},
+		{71, 5},
+		{10000, 5},
+	}
+	for _, tt := range tests {
+		if got := TierPercent(tiers, tt.approved); got != tt.want {
+			t.Errorf("TierPercent after %d approvals = %g, want %g", tt.approved, got, tt.want)
+		}
+	}
+
+	// A table whose last tier is bounded matches nothing past it and keeps the last rate
+	bounded := []Tier{{UpTo: 10, Percent: 15}, {UpTo: 20, Percent: 10}}
+	if got := TierPercent(bounded, 25); got != 10 {
+		t.Errorf("past every tier: %g, want the last tier's 10", got)
+	}
+}
+
+func TestValidateTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   []Tier
+		wantErr bool
+	}{
+		{"valid", []Tier{{UpTo: 30, Percent: 15}, {UpTo: 70, Percent: 10}, {Percent: 5}}, false},
+		{"single open tier", []Tier{{Percent: 12}}, false},
+		{"empty", nil, true},
+		{"bounded last tier", []Tier{{UpTo: 30, Percent: 15}, {UpTo: 70, Percent: 10}}, true},
+		{"equal bounds", []Tier{{UpTo: 30, Percent: 15}, {UpTo: 30, Percent: 10}, {Percent: 5}}, true},
+		{"decreasing bounds", []Tier{{UpTo: 30, Percent: 15}, {UpTo: 20, Percent: 10}, {Percent: 5}}, true},
+		{"zero percent", []Tier{{UpTo: 30, Percent: 0}, {Percent: 5}}, true},
+		{"percent over 100", []Tier{{Percent: 101}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTiers(tt.tiers); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTiers = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTierPrice(t *testing.T) {
+	order := testOrder("o1", "u1") // 1000, all discountable, 12%
+	final, amount, percent := tierPrice(order, 15)
+	if final != 850 || amount != 150 || percent != 15 {
+		t.Errorf("tierPrice at 15%% = %g, %g, %g; want 850, 150, 15", final, amount, percent)
+	}
+
+	// Orders without a discountable amount keep their own rate
+	legacy := order
+	legacy.DiscountableAmount = 0
+	final, amount, percent = tierPrice(legacy, 15)
+	if final != 880 || amount != 120 || percent != 12 {
+		t.Errorf("tierPrice without discountable_amount = %g, %g, %g; want the order's 880, 120, 12", final, amount, percent)
+	}
+}
@@ -0,0 +1,73 @@
+package quota
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// userQuota is a user's standing within the counter's day
+type userQuota struct {
+	Count    int64 // reservations held
+	Releases int64 // reservations returned, by a release or an expired hold
+}
+
+// userTracked reports whether the user's document is read and written: only for known users while
+// either cap is enabled
+func (c Counter) userTracked(userID string) bool {
+	return userID != "" && (c.q.Config.PerUserLimit > 0 || c.q.Config.ReleaseLimit > 0)
+}
+
+// userDoc is a user's count within the counter's day, e.g. daily_quotas/{date}/users/{user_id}
+func (c Counter) userDoc(userID string) string {
+	return c.path + "/users/" + userID
+}
+
+// userQuota reads a user's standing for the day inside tx. It is zero when the user isn't tracked,
+// so callers never read or write the user document then.
+func (c Counter) userQuota(tx common.DocTx, userID string) (userQuota, error) {
+	if !c.userTracked(userID) {
+		return userQuota{}, nil
+	}
+	docs, err := tx.GetAll([]string{c.userDoc(userID)})
+	if err != nil {
+		return userQuota{}, err
+	}
+	doc := docs[0]
+	if doc == nil {
+		return userQuota{}, nil
+	}
+	var q userQuota
+	if q.Count, err = countField(doc); err != nil {
+		return userQuota{}, err
+	}
+	data := doc.Data()
+	if _, present := data["releases"]; present {
+		var ok bool
+		if q.Releases, ok = common.GetInt64(data, "releases"); !ok {
+			return userQuota{}, fmt.Errorf("malformed releases %v (%T) in %s", data["releases"], data["releases"], doc.Path)
+		}
+	}
+	return q, nil
+}
+
+// reserveUser counts one more reservation for the user; q must come from userQuota in the same transaction
+func (c Counter) reserveUser(tx common.DocTx, userID string, q userQuota) error {
+	if !c.userTracked(userID) {
+		return nil
+	}
+	return tx.Set(c.userDoc(userID), map[string]interface{}{"count": q.Count + 1}, firestore.MergeAll)
+}
+
+// releaseUser returns one of the user's reservations and counts the release; q must come from
+// userQuota in the same transaction
+func (c Counter) releaseUser(tx common.DocTx, userID string, q userQuota) error {
+	if !c.userTracked(userID) {
+		return nil
+	}
+	return tx.Set(c.userDoc(userID), map[string]interface{}{
+		"count":    max(q.Count-1, 0),
+		"releases": q.Releases + 1,
+	}, firestore.MergeAll)
+}
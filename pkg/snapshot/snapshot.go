@@ -0,0 +1,221 @@
+// Package snapshot exports and restores the discount service's quota state (daily and monthly
+// counters with their shards and per-user counts, reservations and holds) as a portable JSON file.
+package snapshot
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"google.golang.org/api/iterator"
+)
+
+// Collections are the top-level collections holding quota state. Their subcollections are included.
+var Collections = []string{"daily_quotas", "test_quotas", "quota_monthly", "reservations", "holds", "order_decisions"}
+
+// File is a snapshot as written to disk
+type File struct {
+	TakenAt   time.Time  `json:"taken_at"`
+	Project   string     `json:"project"`
+	Database  string     `json:"database"`
+	Documents []Document `json:"documents"`
+}
+
+// Document is one Firestore document. Path is relative to the database root, e.g.
+// "daily_quotas/2024-01-01/shards/0". Fields are tagged with their Firestore type (see
+// EncodeValue) so integers and timestamps survive the JSON round trip.
+type Document struct {
+	Path   string                 `json:"path"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// Export reads every document under collections, depth first. Documents that exist only as parents
+// of subcollections (such as a location under daily_quotas) are walked but not exported.
+func Export(ctx context.Context, client *firestore.Client, collections []string) ([]Document, error) {
+	var docs []Document
+	for _, name := range collections {
+		if err := exportCollection(ctx, client.Collection(name), &docs); err != nil {
+			return nil, fmt.Errorf("export %s: %w", name, err)
+		}
+	}
+	return docs, nil
+}
+
+func exportCollection(ctx context.Context, coll *firestore.CollectionRef, docs *[]Document) error {
+	refs := coll.DocumentRefs(ctx)
+	for {
+		ref, err := refs.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snap, err := ref.Get(ctx)
+		if err != nil && !common.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			fields, err := EncodeFields(snap.Data())
+			if err != nil {
+				return fmt.Errorf("%s: %w", RelativePath(ref), err)
+			}
+			*docs = append(*docs, Document{Path: RelativePath(ref), Fields: fields})
+		}
+
+		subs := ref.Collections(ctx)
+		for {
+			sub, err := subs.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := exportCollection(ctx, sub, docs); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RelativePath strips the "projects/.../documents/" prefix from a document's full path
+func RelativePath(ref *firestore.DocumentRef) string {
+	_, path, _ := strings.Cut(ref.Path, "/documents/")
+	return path
+}
+
+// EncodeFields tags every field of a document with its type
+func EncodeFields(data map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		encoded, err := EncodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", k, err)
+		}
+		out[k] = encoded
+	}
+	return out, nil
+}
+
+// DecodeFields reverses EncodeFields on fields read back from JSON
+func DecodeFields(fields map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		decoded, err := DecodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", k, err)
+		}
+		out[k] = decoded
+	}
+	return out, nil
+}
+
+// EncodeValue wraps a Firestore value in a single-key object naming its type: {"int": "5"},
+// {"float": 1.5}, {"string": "x"}, {"bool": true}, {"time": "<RFC 3339>"}, {"bytes": "<base64>"},
+// {"null": true}, {"array": [...]} or {"map": {...}}. Integers are strings to keep 64-bit precision.
+func EncodeValue(v interface{}) (map[string]interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return map[string]interface{}{"null": true}, nil
+	case bool:
+		return map[string]interface{}{"bool": v}, nil
+	case int64:
+		return map[string]interface{}{"int": strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return map[string]interface{}{"float": v}, nil
+	case string:
+		return map[string]interface{}{"string": v}, nil
+	case []byte:
+		return map[string]interface{}{"bytes": base64.StdEncoding.EncodeToString(v)}, nil
+	case time.Time:
+		return map[string]interface{}{"time": v.UTC().Format(time.RFC3339Nano)}, nil
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			encoded, err := EncodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = encoded
+		}
+		return map[string]interface{}{"array": items}, nil
+	case map[string]interface{}:
+		fields, err := EncodeFields(v)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"map": fields}, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+// DecodeValue reverses EncodeValue
+func DecodeValue(v interface{}) (interface{}, error) {
+	tagged, ok := v.(map[string]interface{})
+	if !ok || len(tagged) != 1 {
+		return nil, fmt.Errorf("malformed value %v", v)
+	}
+	for tag, raw := range tagged {
+		switch tag {
+		case "null":
+			return nil, nil
+		case "bool":
+			if b, ok := raw.(bool); ok {
+				return b, nil
+			}
+		case "int":
+			if s, ok := raw.(string); ok {
+				return strconv.ParseInt(s, 10, 64)
+			}
+		case "float":
+			if f, ok := raw.(float64); ok {
+				return f, nil
+			}
+		case "string":
+			if s, ok := raw.(string); ok {
+				return s, nil
+			}
+		case "bytes":
+			if s, ok := raw.(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+		case "time":
+			if s, ok := raw.(string); ok {
+				return time.Parse(time.RFC3339Nano, s)
+			}
+		case "array":
+			if items, ok := raw.([]interface{}); ok {
+				out := make([]interface{}, len(items))
+				for i, item := range items {
+					decoded, err := DecodeValue(item)
+					if err != nil {
+						return nil, err
+					}
+					out[i] = decoded
+				}
+				return out, nil
+			}
+		case "map":
+			if fields, ok := raw.(map[string]interface{}); ok {
+				return DecodeFields(fields)
+			}
+		default:
+			return nil, fmt.Errorf("unknown value type %q", tag)
+		}
+		return nil, fmt.Errorf("malformed %s value %v", tag, raw)
+	}
+	return nil, nil
+}
+
+// SameFields reports whether a stored document already holds the snapshot's fields
+func SameFields(data map[string]interface{}, fields map[string]interface{}) bool {
+	encoded, err := EncodeFields(data)
+	return err == nil && reflect.DeepEqual(encoded, fields)
+}
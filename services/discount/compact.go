@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+	"google.golang.org/api/iterator"
+)
+
+// CollectionQuotaMonths holds monthly roll-ups of compacted daily counters:
+// quota_monthly/{location}/months/{YYYY-MM} with a days map of date to total
+const CollectionQuotaMonths = "quota_monthly"
+
+// maxHistoryDays bounds one /quota/history request
+const maxHistoryDays = 366
+
+// compactConfig rolls production daily counters older than Retention into monthly summaries
+type compactConfig struct {
+	Retention time.Duration // age after which dailies are rolled up; 0 keeps them forever
+	Interval  time.Duration
+}
+
+var compactCfg compactConfig
+
+func loadCompactConfig() (compactConfig, error) {
+	var cfg compactConfig
+	var err error
+	if cfg.Retention, err = common.EnvDuration("QUOTA_RETENTION", 90*24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.Interval, err = common.EnvDuration("QUOTA_COMPACT_INTERVAL", 24*time.Hour); err != nil {
+		return cfg, err
+	}
+	// Reconciliation and expiring holds still write to recent days; never compact those
+	if cfg.Retention != 0 && cfg.Retention < 7*24*time.Hour {
+		return cfg, fmt.Errorf("QUOTA_RETENTION must be 0 or at least 168h, got %s", cfg.Retention)
+	}
+	if cfg.Retention > 0 && cfg.Interval <= 0 {
+		return cfg, fmt.Errorf("QUOTA_COMPACT_INTERVAL must be positive, got %s", cfg.Interval)
+	}
+	return cfg, nil
+}
+
+// quotaMonth is one location's roll-up for a month
+type quotaMonth struct {
+	Location  string           `firestore:"location"`
+	Month     string           `firestore:"month"`
+	Days      map[string]int64 `firestore:"days"`
+	UpdatedAt time.Time        `firestore:"updated_at"`
+}
+
+func monthDoc(client *firestore.Client, location, month string) *firestore.DocumentRef {
+	return client.Collection(CollectionQuotaMonths).Doc(location).Collection("months").Doc(month)
+}
+
+func compactLoop(ctx context.Context, client *firestore.Client) {
+	if compactCfg.Retention <= 0 {
+		logger.Info("Quota compaction disabled")
+		return
+	}
+
+	ticker := time.NewTicker(compactCfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := quotaDateAt(time.Now().Add(-compactCfg.Retention))
+			for location := range quotaLocations {
+				if err := compactLocation(ctx, client, location, cutoff); err != nil {
+					logger.Error("Quota compaction failed", "location", location, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// compactLocation rolls up a location's daily counters dated before cutoff. Listing document refs
+// (rather than querying) also finds days whose counts live only in shard subdocuments.
+func compactLocation(ctx context.Context, client *firestore.Client, location, cutoff string) error {
+	refs := client.Collection(quota.DaysPath(false, location)).DocumentRefs(ctx)
+	compacted := 0
+	for {
+		day, err := refs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// The global collection also holds the per-location parent documents
+		if _, err := time.Parse(common.QuotaDateLayout, day.ID); err != nil || day.ID >= cutoff {
+			continue
+		}
+		if err := compactDay(ctx, client, location, day); err != nil {
+			return fmt.Errorf("compact %s: %w", day.ID, err)
+		}
+		compacted++
+	}
+	if compacted > 0 {
+		logger.Info("Compacted daily quotas", "location", location, "days", compacted, "before", cutoff)
+	}
+	return nil
+}
+
+// compactDay records a day's total in its month's summary, then deletes the day's documents.
+// Old days no longer change, so rerunning after a partial failure records the same total.
+func compactDay(ctx context.Context, client *firestore.Client, location string, day *firestore.DocumentRef) error {
+	// Sum the document and every shard, whatever QUOTA_SHARDS was when the day was written
+	shards, err := day.Collection("shards").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, ref := range append([]*firestore.DocumentRef{day}, shards...) {
+		paths = append(paths, common.DocPath(ref))
+	}
+	docs, err := quotas.Store.GetAll(ctx, paths)
+	if err != nil {
+		return err
+	}
+	counts, err := quota.ShardCounts(docs)
+	if err != nil {
+		return err
+	}
+	total := int64(0)
+	for _, count := range counts {
+		total += count
+	}
+
+	month := day.ID[:7]
+	_, err = monthDoc(client, location, month).Set(ctx, map[string]interface{}{
+		"location":   location,
+		"month":      month,
+		"days":       map[string]interface{}{day.ID: total},
+		"updated_at": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return err
+	}
+
+	users, err := day.Collection("users").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	bw := client.BulkWriter(ctx)
+	for _, doc := range slices.Concat(shards, users, []*firestore.DocumentRef{day}) {
+		if _, err := bw.Delete(doc); err != nil {
+			bw.End()
+			return err
+		}
+	}
+	bw.End()
+	return nil
+}
+
+type quotaDay struct {
+	Date string `json:"date"`
+	Used int64  `json:"used"`
+}
+
+type quotaHistory struct {
+	Location string     `json:"location"`
+	Days     []quotaDay `json:"days"`
+}
+
+// handleQuotaHistory reports a location's daily production usage over a date range
+// (?from=&to=, default the last 30 days), reading monthly roll-ups for compacted days
+func handleQuotaHistory(client *firestore.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		location, err := quotaLocations.Resolve(r.URL.Query().Get("location"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, end, err := historyRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		days, err := readHistory(r.Context(), client, location, start, end)
+		if err != nil {
+			logger.Error("Failed to read quota history", "location", location, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quotaHistory{Location: location, Days: days})
+	}
+}
+
+// historyRange parses an inclusive range of dates
+func historyRange(from, to string) (time.Time, time.Time, error) {
+	end := time.Now().In(quotaTimezone)
+	if to != "" {
+		t, err := time.ParseInLocation(common.QuotaDateLayout, to, quotaTimezone)
+		if err != nil {
+			return t, t, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -29)
+	if from != "" {
+		t, err := time.ParseInLocation(common.QuotaDateLayout, from, quotaTimezone)
+		if err != nil {
+			return t, t, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		start = t
+	}
+	if end.Before(start) || end.Sub(start) >= maxHistoryDays*24*time.Hour {
+		return start, end, fmt.Errorf("range must run forwards and span at most %d days", maxHistoryDays)
+	}
+	return start, end, nil
+}
+
+// readHistory merges monthly roll-ups with the daily counters still on disk. Each day is either
+// rolled up or still daily; if a partial compaction left both, they agree.
+func readHistory(ctx context.Context, client *firestore.Client, location string, start, end time.Time) ([]quotaDay, error) {
+	var dates []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, common.QuotaDate(d, quotaTimezone))
+	}
+
+	var monthRefs []*firestore.DocumentRef
+	seen := make(map[string]bool)
+	for _, date := range dates {
+		if month := date[:7]; !seen[month] {
+			seen[month] = true
+			monthRefs = append(monthRefs, monthDoc(client, location, month))
+		}
+	}
+	monthSnaps, err := client.GetAll(ctx, monthRefs)
+	if err != nil {
+		return nil, err
+	}
+	rolledUp := make(map[string]int64)
+	for _, snap := range monthSnaps {
+		if !snap.Exists() {
+			continue
+		}
+		var m quotaMonth
+		if err := snap.DataTo(&m); err != nil {
+			return nil, err
+		}
+		for date, used := range m.Days {
+			rolledUp[date] = used
+		}
+	}
+
+	var dayPaths []string
+	for _, date := range dates {
+		dayPaths = append(dayPaths, quotas.Counter(false, location, date).ShardPaths()...)
+	}
+	dayDocs, err := quotas.Store.GetAll(ctx, dayPaths)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := quota.ShardCounts(dayDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]quotaDay, len(dates))
+	for i, date := range dates {
+		used, ok := rolledUp[date]
+		if !ok {
+			for _, count := range counts[i*quotaCfg.Shards : (i+1)*quotaCfg.Shards] {
+				used += count
+			}
+		}
+		days[i] = quotaDay{Date: date, Used: used}
+	}
+	return days, nil
+}
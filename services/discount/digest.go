@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// digestLoop emits the previous day's DailyDigest shortly after each midnight in QUOTA_TIMEZONE.
+// The digest document ID is derived from the date, so replicas and restarts never emit it twice.
+func digestLoop(ctx context.Context, client *firestore.Client) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	lastEmitted := ""
+	for {
+		yesterday := time.Now().In(quotaTimezone).AddDate(0, 0, -1)
+		if date := common.QuotaDate(yesterday, quotaTimezone); date != lastEmitted {
+			if err := emitDailyDigest(ctx, client, yesterday); err != nil {
+				logger.Error("Failed to emit daily digest", "date", date, "error", err)
+			} else {
+				lastEmitted = date
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// emitDailyDigest aggregates one quota day's decision and release events into a DailyDigest
+func emitDailyDigest(ctx context.Context, client *firestore.Client, day time.Time) error {
+	date := common.QuotaDate(day, quotaTimezone)
+	digestRef := client.Collection(CollectionEvents).Doc("digest-" + date)
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	iter := client.Collection(CollectionEvents).
+		Where("type", "in", []events.EventType{events.EventTypeDiscountReserved, events.EventTypeDiscountRejected, events.EventTypeDiscountRelease}).
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		OrderBy("timestamp", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	digest := events.NewDailyDigest(date)
+	digest.Limit = quotaCfg.Limit
+	var used int64
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data := doc.Data()
+		if isTest, _ := data["is_test"].(bool); isTest {
+			continue
+		}
+		switch data["type"] {
+		case events.EventTypeDiscountReserved:
+			digest.Approvals++
+			used++
+			digest.PeakUsage = max(digest.PeakUsage, used)
+		case events.EventTypeDiscountRejected:
+			digest.Rejections++
+		case events.EventTypeDiscountRelease:
+			digest.Releases++
+			used--
+		}
+	}
+	digest.NetUsed = digest.Approvals - digest.Releases
+
+	stamped := publisher.Stamp(digest)
+	if _, err := digestRef.Create(ctx, stamped); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		return err
+	}
+	publisher.MirrorEvent(ctx, digestRef.ID, stamped)
+	logger.Info("Daily Digest Emitted", "date", date, "approvals", digest.Approvals, "rejections", digest.Rejections,
+		"releases", digest.Releases, "net_used", digest.NetUsed, "peak_usage", digest.PeakUsage)
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+)
+
+// resolveQuotaDate returns the quota day the order service stamped on the order. Older events
+// without one, and dates not matching the local quota day within the allowed skew, fall back to
+// the local quota day.
+func resolveQuotaDate(cfg quota.Drift, event events.OrderCreated, now time.Time) string {
+	today := quotaDateAt(now)
+	if event.QuotaDate == "" || event.QuotaDate == today {
+		return today
+	}
+	for _, t := range []time.Time{now.Add(-cfg.QuotaDateSkew), now.Add(cfg.QuotaDateSkew)} {
+		if event.QuotaDate == quotaDateAt(t) {
+			return event.QuotaDate
+		}
+	}
+	logger.Warn("Order quota date out of range, using local day", "order_id", event.OrderID, "trace_id", event.TraceID,
+		"quota_date", event.QuotaDate, "local_date", today)
+	return today
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// listenerHealthy reports whether the event snapshot listener last received successfully
+	listenerHealthy atomic.Bool
+	// lastEventAt is when the listener last delivered an event (unix nanoseconds)
+	lastEventAt atomic.Int64
+	// listenerStalled is set when orders are waiting but the listener has gone quiet
+	listenerStalled atomic.Bool
+	// backlogSince is when the oldest delivered but undecided OrderCreated was written (unix
+	// nanoseconds), zero when the listener has caught up
+	backlogSince atomic.Int64
+)
+
+// serveHTTP exposes the discount service's operational endpoints
+func serveHTTP(ctx context.Context, addr string, client *firestore.Client) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /lag", handleLag)
+	mux.HandleFunc("GET /quota", handleQuota(client))
+	mux.HandleFunc("GET /quota/history", handleQuotaHistory(client))
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Discount Service HTTP listening", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("HTTP server failed", "error", err)
+	}
+}
+
+type healthStatus struct {
+	ListenerConnected bool   `json:"listener_connected"`
+	ListenerStalled   bool   `json:"listener_stalled"`
+	LastEventAge      string `json:"last_event_age"`
+}
+
+func currentHealth() healthStatus {
+	return healthStatus{
+		ListenerConnected: listenerHealthy.Load(),
+		ListenerStalled:   listenerStalled.Load(),
+		LastEventAge:      time.Since(time.Unix(0, lastEventAt.Load())).Round(time.Second).String(),
+	}
+}
+
+func writeHealth(w http.ResponseWriter, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(currentHealth())
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, listenerHealthy.Load())
+}
+
+// handleReadyz additionally fails when the listener has silently stopped delivering
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, listenerHealthy.Load() && !listenerStalled.Load())
+}
+
+// processingLag is the age of the oldest OrderCreated the listener has delivered but not decided
+func processingLag() time.Duration {
+	since := backlogSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// handleLag reports the processing lag, which the order service polls to shed load
+func handleLag(w http.ResponseWriter, r *http.Request) {
+	lag := processingLag()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lag_seconds": lag.Seconds(),
+		"lag":         lag.Round(time.Millisecond).String(),
+	})
+}
+
+// markEventDelivered records listener progress
+func markEventDelivered() {
+	lastEventAt.Store(time.Now().UnixNano())
+}
+
+// stallWatchLoop flags the listener as stalled when nothing was delivered for longer than threshold
+// although OrderCreated events newer than the last delivery exist. Quiet periods without orders
+// are not stalls.
+func stallWatchLoop(ctx context.Context, client *firestore.Client) {
+	threshold, err := common.EnvDuration("LISTENER_STALL_THRESHOLD", 2*time.Minute)
+	if err != nil {
+		logger.Error("Invalid LISTENER_STALL_THRESHOLD, stall detection disabled", "error", err)
+		return
+	}
+	if threshold <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		last := time.Unix(0, lastEventAt.Load())
+		if time.Since(last) < threshold {
+			listenerStalled.Store(false)
+			continue
+		}
+
+		waiting, err := client.Collection(CollectionEvents).
+			Where("type", "==", events.EventTypeOrderCreated).
+			Where("timestamp", ">", last).
+			Limit(1).
+			Documents(ctx).GetAll()
+		if err != nil {
+			logger.Error("Stall check failed", "error", err)
+			continue
+		}
+
+		stalled := len(waiting) > 0
+		if stalled && !listenerStalled.Load() {
+			logger.Log(ctx, common.LevelCritical, "Discount Listener Stalled",
+				"last_event_at", last, "threshold", threshold.String())
+		}
+		listenerStalled.Store(stalled)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+	"google.golang.org/api/iterator"
+)
+
+// sweepInterval is how often the sweeper looks for holds past their deadline (RESERVATION_SWEEP_INTERVAL)
+var sweepInterval = 30 * time.Second
+
+// processConfirmEvent marks an order's hold as confirmed so the sweeper leaves it alone. It returns
+// an error when the hold could not be updated, so the event is retried.
+func processConfirmEvent(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) error {
+	if quotaCfg.HoldTTL <= 0 {
+		return nil
+	}
+
+	var event events.DiscountConfirm
+	if err := common.ParseEvent(common.SnapshotDoc(doc), &event); err != nil {
+		logger.Error("Failed to parse confirm event", "id", doc.Ref.ID, "error", err)
+		deadLetter(ctx, client, doc, err)
+		return nil
+	}
+	ctx, span := common.StartEventSpan(ctx, event.BaseEvent, "ProcessDiscountConfirm")
+	defer span.End()
+
+	confirmed, err := quotas.ConfirmHold(ctx, event.OrderID)
+	if err != nil {
+		logger.Error("Failed to confirm hold", "order_id", event.OrderID, "error", err)
+		return err
+	}
+	if confirmed {
+		logger.Info("Reservation Confirmed", "order_id", event.OrderID, "trace_id", event.TraceID)
+	}
+	return nil
+}
+
+// sweepLoop periodically expires holds that passed their deadline without a confirmation
+func sweepLoop(ctx context.Context, client *firestore.Client) {
+	if quotaCfg.HoldTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepExpiredHolds(ctx, client)
+		}
+	}
+}
+
+func sweepExpiredHolds(ctx context.Context, client *firestore.Client) {
+	iter := client.Collection(quota.CollectionHolds).
+		Where("state", "==", quota.HoldHeld).
+		Where("expires_at", "<=", time.Now()).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to query expired holds", "error", err)
+			return
+		}
+		if err := expireHold(ctx, doc.Ref.ID); err != nil {
+			logger.Error("Failed to expire hold", "id", doc.Ref.ID, "error", err)
+		}
+	}
+}
+
+// expireHold returns an unconfirmed hold's quota to its original day, recording a DiscountRelease
+// for the audit trail
+func expireHold(ctx context.Context, orderID string) error {
+	free, err := acquireReleaseSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer free()
+
+	expired, usedAfter, err := quotas.ExpireHold(ctx, orderID)
+	if err == nil && expired != nil {
+		quotas.RecordRelease(expired.IsTest, expired.QuotaDate, expired.LocationID, usedAfter)
+	}
+	return err
+}
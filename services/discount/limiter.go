@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// quotaSlots bounds concurrent quota transactions, which all contend on the same counter document
+var quotaSlots chan struct{}
+
+// loadQuotaConcurrency sizes the limiter from QUOTA_TX_CONCURRENCY (default 4)
+func loadQuotaConcurrency() error {
+	limit, err := common.EnvInt("QUOTA_TX_CONCURRENCY", 4)
+	if err != nil {
+		return err
+	}
+	if limit < 1 {
+		return fmt.Errorf("QUOTA_TX_CONCURRENCY must be at least 1, got %d", limit)
+	}
+	quotaSlots = make(chan struct{}, limit)
+	return nil
+}
+
+// releaseSlots bounds concurrent release transactions (DiscountRelease processing and hold expiry)
+// separately from reservations, so a compensation storm can't crowd out new orders or vice versa
+var releaseSlots chan struct{}
+
+// loadReleaseConcurrency sizes the release limiter from RELEASE_TX_CONCURRENCY (default 2)
+func loadReleaseConcurrency() error {
+	limit, err := common.EnvInt("RELEASE_TX_CONCURRENCY", 2)
+	if err != nil {
+		return err
+	}
+	if limit < 1 {
+		return fmt.Errorf("RELEASE_TX_CONCURRENCY must be at least 1, got %d", limit)
+	}
+	releaseSlots = make(chan struct{}, limit)
+	return nil
+}
+
+// acquireReleaseSlot waits for a free release transaction slot; call the returned func to free it
+func acquireReleaseSlot(ctx context.Context) (func(), error) {
+	compensationQueueDepth.Inc()
+	defer compensationQueueDepth.Dec()
+	select {
+	case releaseSlots <- struct{}{}:
+		return func() { <-releaseSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireQuotaSlot waits for a free quota transaction slot; call the returned func to free it
+func acquireQuotaSlot(ctx context.Context) (func(), error) {
+	start := time.Now()
+	select {
+	case quotaSlots <- struct{}{}:
+		quotaTransactionWait.Observe(time.Since(start).Seconds())
+		return func() { <-quotaSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
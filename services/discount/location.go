@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// quotaLocations is the allowlist of clinic locations, each with an independent daily quota
+var quotaLocations common.Locations
+
+func loadLocations() error {
+	var err error
+	quotaLocations, err = common.ParseLocations(common.EnvList("QUOTA_LOCATIONS", []string{events.DefaultLocation}))
+	return err
+}
+
+// rejectUnknownLocation rejects an order whose location is not on the allowlist, returning an error
+// when the rejection couldn't be written so the order is retried
+func rejectUnknownLocation(ctx context.Context, event events.OrderCreated) error {
+	if err := rejectOrder(ctx, event, "Discounts are not available at this location."); err != nil {
+		logger.Error("Failed to write location rejection", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
+	}
+	return nil
+}
+
+type quotaStatus struct {
+	Location  string `json:"location"`
+	Date      string `json:"date"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+}
+
+// handleQuota reports a location's production quota usage for a day (default: today's quota day)
+func handleQuota(client *firestore.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		location, err := quotaLocations.Resolve(r.URL.Query().Get("location"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		date := r.URL.Query().Get("date")
+		if date == "" {
+			date = quotaDateAt(time.Now())
+		} else if _, err := time.Parse(common.QuotaDateLayout, date); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+
+		used, err := quotas.Counter(false, location, date).Total(r.Context())
+		if err != nil {
+			logger.Error("Failed to read quota", "location", location, "date", date, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quotaStatus{
+			Location:  location,
+			Date:      date,
+			Limit:     quotaCfg.Limit,
+			Used:      used,
+			Remaining: max(quotaCfg.Limit-used, 0),
+		})
+	}
+}
@@ -2,32 +2,113 @@ package main
 
 import (
 	"context"
-	"log/slog"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/devdolphintest/discount-system/pkg/common"
 	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
 	"github.com/joho/godotenv"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 const (
 	ProjectID        = "devdolphins-93118"
-	CollectionEvents = "events"
-	CollectionQuotas = "daily_quotas"
-	QuotaLimit       = 100 // R1
-	ISTOffset        = 5*time.Hour + 30*time.Minute
+	CollectionEvents = quota.CollectionEvents
 )
 
-var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+var (
+	logger = common.NewLogger()
+
+	// quotaCfg is the quota's limit, shards, caps, tiers and holds; quotas decides orders against it
+	quotaCfg = quota.Config{Limit: quota.DefaultLimit, Shards: 1}
+	quotas   *quota.Quota
+
+	// quotaTimezone's midnight starts each quota day (QUOTA_TIMEZONE)
+	quotaTimezone *time.Location
+
+	// orderTimeout bounds how long a single order may hold the listener before it is abandoned
+	orderTimeout = 15 * time.Second
+
+	dailyDigest bool
+	publisher   *common.Publisher
+	reconnect   common.Reconnect
+)
 
 func main() {
 	_ = godotenv.Load()
-	ctx := context.Background()
+
+	reconcileCfg, err := loadReconcileConfig()
+	if err != nil {
+		logger.Error("Invalid reconciliation configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if dailyDigest, err = common.EnvBool("DAILY_DIGEST", true); err != nil {
+		logger.Error("Invalid DAILY_DIGEST", "error", err)
+		os.Exit(1)
+	}
+
+	if quotaCfg, err = quota.LoadConfig(logger); err != nil {
+		logger.Error("Invalid quota configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if quotaTimezone, err = common.LoadQuotaTimezone(); err != nil {
+		logger.Error("Invalid QUOTA_TIMEZONE", "error", err)
+		os.Exit(1)
+	}
+
+	if sweepInterval, err = common.EnvDuration("RESERVATION_SWEEP_INTERVAL", sweepInterval); err != nil {
+		logger.Error("Invalid RESERVATION_SWEEP_INTERVAL", "error", err)
+		os.Exit(1)
+	}
+
+	if compactCfg, err = loadCompactConfig(); err != nil {
+		logger.Error("Invalid quota compaction configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadLocations(); err != nil {
+		logger.Error("Invalid QUOTA_LOCATIONS", "error", err)
+		os.Exit(1)
+	}
+
+	if orderTimeout, err = common.EnvDuration("ORDER_PROCESSING_TIMEOUT", orderTimeout); err != nil || orderTimeout <= 0 {
+		logger.Error("Invalid order processing timeout", "error", err, "timeout", orderTimeout)
+		os.Exit(1)
+	}
+
+	if err := loadQuotaConcurrency(); err != nil {
+		logger.Error("Invalid quota transaction limit", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadReleaseConcurrency(); err != nil {
+		logger.Error("Invalid release transaction limit", "error", err)
+		os.Exit(1)
+	}
+
+	if reconnect, err = common.LoadReconnect(); err != nil {
+		logger.Error("Invalid listener reconnect configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// SIGINT/SIGTERM stops the listener and background loops; see the listener for in-flight events
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := common.InitTracing(ctx, "discount-service")
+	if err != nil {
+		logger.Error("Invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	client, err := common.NewFirestoreClient(ctx, ProjectID)
 	if err != nil {
 		logger.Error("Failed to create client", "error", err)
@@ -35,51 +116,136 @@ func main() {
 	}
 	defer client.Close()
 
-	logger.Info("Discount Service Started", "limit", QuotaLimit)
+	ackModes, err := common.ParseAckModes(common.EnvOrDefault("EVENT_ACK_MODES", ""))
+	if err != nil {
+		logger.Error("Invalid EVENT_ACK_MODES", "error", err)
+		os.Exit(1)
+	}
+	mirrorCfg, err := common.LoadMirrorConfig()
+	if err != nil {
+		logger.Error("Invalid event mirror configuration", "error", err)
+		os.Exit(1)
+	}
+	retention, err := common.LoadEventRetention()
+	if err != nil {
+		logger.Error("Invalid EVENT_RETENTION", "error", err)
+		os.Exit(1)
+	}
+	publisher = &common.Publisher{Collection: client.Collection(CollectionEvents), Modes: ackModes, Logger: logger,
+		Mirror: mirrorCfg.Mirror, Retention: retention}
+	quotas = &quota.Quota{Store: common.FirestoreStore{Client: client}, Config: quotaCfg, Publisher: publisher, Logger: logger,
+		Timezone: quotaTimezone}
 
-	// Listen for OrderCreated and DiscountRelease events
-	iter := client.Collection(CollectionEvents).
-		Where("type", "in", []string{events.EventTypeOrderCreated, events.EventTypeDiscountRelease}).
-		OrderBy("timestamp", firestore.Asc).
-		Snapshots(ctx)
-	defer iter.Stop()
+	checkpoint, err := common.CheckpointFromEnv(ctx, client, "discount")
+	if err != nil {
+		logger.Error("Failed to load listener checkpoint", "error", err)
+		os.Exit(1)
+	}
 
-	for {
-		snap, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			logger.Error("Error listening to events", "error", err)
-			time.Sleep(1 * time.Second)
-			continue
+	logger.Info("Discount Service Started", "limit", quotaCfg.Limit, "percent", quotaCfg.Percent, "order_timeout", orderTimeout.String())
+
+	go reconcileLoop(ctx, client, reconcileCfg)
+	go sweepLoop(ctx, client)
+	go compactLoop(ctx, client)
+	go common.VerifyMirrorLoop(ctx, client.Collection(CollectionEvents), mirrorCfg, logger)
+	if dailyDigest {
+		go digestLoop(ctx, client)
+	}
+	markEventDelivered()
+	go serveHTTP(ctx, common.EnvOrDefault("DISCOUNT_HTTP_ADDR", ":8082"), client)
+	go stallWatchLoop(ctx, client)
+
+	// Listen for OrderCreated, DiscountRelease, DiscountConfirm and PaymentCompleted events
+	q := client.Collection(CollectionEvents).
+		Where("type", "in", []events.EventType{events.EventTypeOrderCreated, events.EventTypeDiscountRelease, events.EventTypeDiscountConfirm,
+			events.EventTypePaymentCompleted}).
+		OrderBy("timestamp", firestore.Asc)
+	onError := func(err error) {
+		listenerHealthy.Store(false)
+		logger.Error("Error listening to events", "error", err)
+	}
+	reconnect.ListenFrom(ctx, q, checkpoint, logger, onError, func(snap *firestore.QuerySnapshot) error {
+		listenerHealthy.Store(true)
+		if len(snap.Changes) > 0 {
+			markEventDelivered()
 		}
 
+		// An event already being processed at shutdown runs to completion rather than aborting its
+		// transaction; the rest of the snapshot is redelivered on the next start
+		eventCtx := context.WithoutCancel(ctx)
+		var failed error
 		for _, change := range snap.Changes {
+			if ctx.Err() != nil {
+				break
+			}
 			if change.Kind == firestore.DocumentAdded {
-				eventType := change.Doc.Data()["type"].(string)
-				switch eventType {
+				eventType, ok := common.GetString(change.Doc.Data(), "type")
+				if !ok {
+					logger.Warn("Skipping malformed event", "id", change.Doc.Ref.ID, "type", change.Doc.Data()["type"])
+					continue
+				}
+				switch events.EventType(eventType) {
 				case events.EventTypeOrderCreated:
-					processOrderEvent(ctx, client, change.Doc)
+					// Events are delivered in order, so this is the oldest one not yet decided
+					backlogSince.Store(change.Doc.CreateTime.UnixNano())
+					quotas.AwaitFreeze(ctx)
+					orderCtx, cancel := context.WithTimeout(eventCtx, orderTimeout)
+					if err := processOrderEvent(orderCtx, client, change.Doc); err != nil && failed == nil {
+						// Later events still run; the checkpoint stays before this one so it is retried
+						failed = fmt.Errorf("order event %s: %w", change.Doc.Ref.ID, err)
+					}
+					cancel()
 				case events.EventTypeDiscountRelease:
-					processReleaseEvent(ctx, client, change.Doc)
+					if err := processReleaseEvent(eventCtx, client, change.Doc); err != nil && failed == nil {
+						failed = fmt.Errorf("release event %s: %w", change.Doc.Ref.ID, err)
+					}
+				case events.EventTypeDiscountConfirm:
+					if err := processConfirmEvent(eventCtx, client, change.Doc); err != nil && failed == nil {
+						failed = fmt.Errorf("confirm event %s: %w", change.Doc.Ref.ID, err)
+					}
+				case events.EventTypePaymentCompleted:
+					if err := processPaymentCompleted(eventCtx, client, change.Doc); err != nil && failed == nil {
+						failed = fmt.Errorf("payment event %s: %w", change.Doc.Ref.ID, err)
+					}
 				}
 			}
 		}
+		backlogSince.Store(0)
+		return failed
+	})
+	logger.Info("Discount Service stopped")
+}
+
+// quotaDateAt is the quota day t falls on, in quotaTimezone
+func quotaDateAt(t time.Time) string {
+	return common.QuotaDate(t, quotaTimezone)
+}
+
+// deadLetter keeps an event that failed to parse with parseErr in the dead_letter collection for
+// inspection and replay, logging if that fails too
+func deadLetter(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot, parseErr error) {
+	if err := common.DeadLetterEvent(ctx, common.FirestoreStore{Client: client}, "discount", common.SnapshotDoc(doc), parseErr); err != nil {
+		logger.Error("Failed to dead-letter event", "id", doc.Ref.ID, "error", err)
 	}
 }
 
-func processOrderEvent(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) {
+// processOrderEvent decides an OrderCreated. It returns an error when the order was left
+// undecided by a failure worth retrying; events that can never be decided are dead-lettered or
+// rejected instead.
+func processOrderEvent(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) error {
 	var event events.OrderCreated
-	if err := doc.DataTo(&event); err != nil {
+	if err := common.ParseEvent(common.SnapshotDoc(doc), &event); err != nil {
 		logger.Error("Failed to parse event", "id", doc.Ref.ID, "error", err)
-		return
+		deadLetter(ctx, client, doc, err)
+		return nil
 	}
+	ctx, span := common.StartEventSpan(ctx, event.BaseEvent, "ProcessOrderCreated")
+	defer span.End()
 
 	// Only process R1-eligible requests
 	if !event.IsR1Eligible {
 		logger.Info("Skipping Non-R1-Eligible Order", "order_id", event.OrderID, "trace_id", event.TraceID)
-		return
+		return nil
 	}
 
 	logger.Info("Processing R1-Eligible Order", "order_id", event.OrderID, "trace_id", event.TraceID,
@@ -89,16 +255,31 @@ func processOrderEvent(ctx context.Context, client *firestore.Client, doc *fires
 	exists, err := checkDecisionExists(ctx, client, event.OrderID)
 	if err != nil {
 		logger.Error("Failed to check existing decision", "trace_id", event.TraceID, "error", err)
-		return
+		return err
 	}
 	if exists {
 		logger.Info("Decision Already Exists", "order_id", event.OrderID, "trace_id", event.TraceID)
-		return
+		return nil
 	}
 
-	if err := runQuotaTransaction(ctx, client, event); err != nil {
+	location, err := quotaLocations.Resolve(event.LocationID)
+	if err != nil {
+		logger.Warn("Order for unknown location", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return rejectUnknownLocation(ctx, event)
+	}
+	event.LocationID = location
+
+	if err := runQuotaTransaction(ctx, event); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// No decision was written; the listener delivers the event again from its checkpoint
+			logger.Error("Order processing timed out, decision left pending", "order_id", event.OrderID,
+				"trace_id", event.TraceID, "timeout", orderTimeout.String(), "error", err)
+			return err
+		}
 		logger.Error("Transaction failed", "trace_id", event.TraceID, "error", err)
+		return err
 	}
+	return nil
 }
 
 func checkDecisionExists(ctx context.Context, client *firestore.Client, orderID string) (bool, error) {
@@ -106,7 +287,7 @@ func checkDecisionExists(ctx context.Context, client *firestore.Client, orderID
 	// Firestore supports 'In' operator now.
 	q := client.Collection(CollectionEvents).
 		Where("order_id", "==", orderID).
-		Where("type", "in", []string{events.EventTypeDiscountReserved, events.EventTypeDiscountRejected}).
+		Where("type", "in", []events.EventType{events.EventTypeDiscountReserved, events.EventTypeDiscountRejected}).
 		Limit(1)
 
 	snaps, err := q.Documents(ctx).GetAll()
@@ -116,132 +297,60 @@ func checkDecisionExists(ctx context.Context, client *firestore.Client, orderID
 	return len(snaps) > 0, nil
 }
 
-func runQuotaTransaction(ctx context.Context, client *firestore.Client, event events.OrderCreated) error {
-	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		// 1. Determine Date in IST
-		ist := time.FixedZone("IST", int(ISTOffset.Seconds()))
-		today := time.Now().In(ist).Format("2006-01-02")
-		quotaRef := client.Collection(CollectionQuotas).Doc(today)
-
-		// 2. Read current quota
-		// Note: Document might not exist yet.
-		doc, err := tx.Get(quotaRef)
-		currentCount := int64(0)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				// It's a new day, count is 0
-			} else {
-				return err
-			}
-		} else {
-			if v, ok := doc.Data()["count"].(int64); ok {
-				currentCount = v
-			}
-		}
-
-		// 3. Decision
-		var decisionEvent interface{}
-
-		if currentCount < QuotaLimit {
-			// Approve
-			newCount := currentCount + 1
-			if err := tx.Set(quotaRef, map[string]interface{}{"count": newCount}, firestore.MergeAll); err != nil {
-				return err
-			}
+func runQuotaTransaction(ctx context.Context, event events.OrderCreated) error {
+	release, err := acquireQuotaSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-			decisionEvent = events.DiscountReserved{
-				BaseEvent: events.BaseEvent{
-					TraceID:   event.TraceID,
-					Type:      events.EventTypeDiscountReserved,
-					Timestamp: time.Now(),
-				},
-				OrderID: event.OrderID,
-				Status:  "Approved",
-			}
-			logger.Info("R2 Quota Reserved", "trace_id", event.TraceID, "order_id", event.OrderID,
-				"quota_used", newCount, "quota_remaining", QuotaLimit-newCount)
-		} else {
-			// Reject
-			decisionEvent = events.DiscountRejected{
-				BaseEvent: events.BaseEvent{
-					TraceID:   event.TraceID,
-					Type:      events.EventTypeDiscountRejected,
-					Timestamp: time.Now(),
-				},
-				OrderID: event.OrderID,
-				Status:  "Rejected",
-				Reason:  "Daily discount quota reached. Please try again tomorrow.",
-			}
-			logger.Info("R2 Quota Exhausted", "trace_id", event.TraceID, "order_id", event.OrderID,
-				"quota_limit", QuotaLimit, "current_count", currentCount)
-		}
+	// The quota day, as decided when the order was created
+	today := resolveQuotaDate(quotaCfg.Drift, event, time.Now())
+	_, err = quotas.Decide(ctx, event, today)
+	if errors.Is(err, quota.ErrAlreadyDecided) {
+		logger.Info("Decision Already Exists", "order_id", event.OrderID, "trace_id", event.TraceID)
+		return nil
+	}
+	return err
+}
 
-		// 4. Publish Decision
-		// We use a new document for the event.
-		newEventRef := client.Collection(CollectionEvents).NewDoc()
-		return tx.Set(newEventRef, decisionEvent)
-	})
+// rejectOrder decides an order with a rejection that doesn't reach the quota, writing its decision
+// marker with it as runQuotaTransaction does. An order decided before is left as it is.
+func rejectOrder(ctx context.Context, event events.OrderCreated, reason string) error {
+	_, err := quotas.Reject(ctx, event, reason)
+	if errors.Is(err, quota.ErrAlreadyDecided) {
+		logger.Info("Decision Already Exists", "order_id", event.OrderID, "trace_id", event.TraceID)
+		return nil
+	}
+	return err
 }
 
-func processReleaseEvent(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) {
+// processReleaseEvent returns a compensated order's reservation to the quota. It returns an error
+// when the release failed, so the event is retried.
+func processReleaseEvent(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) error {
 	var event events.DiscountRelease
-	if err := doc.DataTo(&event); err != nil {
+	if err := common.ParseEvent(common.SnapshotDoc(doc), &event); err != nil {
 		logger.Error("Failed to parse release event", "id", doc.Ref.ID, "error", err)
-		return
-	}
-
-	// Check if we need to release for this OrderID
-	// Idempotency: Ideally track "Released" state.
-	// For now, transactionally decrement.
-
-	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		// Find if we approved this order.
-		// We can query events to see if we approved it.
-		// OR simply decrement today's quota.
-		// Using "Date from Timestamp" of the ORIGINAL order would be best, but we might just use today or event timestamp.
-		// Let's assume release happens same day or we accept slight skew for simplicity.
-		// Better: Store "Reserved" status in a separate collection 'reservations' to track Date.
-		// For this demo: use Today's quota.
-
-		ist := time.FixedZone("IST", int(ISTOffset.Seconds()))
-		today := time.Now().In(ist).Format("2006-01-02")
-		quotaRef := client.Collection(CollectionQuotas).Doc(today)
-
-		doc, err := tx.Get(quotaRef)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				// Quota document for today doesn't exist, meaning no quotas were used today.
-				// Nothing to decrement.
-				logger.Info("Quota document not found for today, nothing to release", "order_id", event.OrderID, "date", today)
-				return nil // Successfully did nothing
-			}
-			return err
-		}
-
-		count := doc.Data()["count"].(int64)
-		if count > 0 {
-			if err := tx.Set(quotaRef, map[string]interface{}{"count": count - 1}, firestore.MergeAll); err != nil {
-				return err
-			}
-			logger.Info("Quota Compensation Executed", "order_id", event.OrderID, "new_count", count-1)
-		} else {
-			logger.Info("Quota count is already zero, nothing to decrement", "order_id", event.OrderID, "date", today)
-		}
+		deadLetter(ctx, client, doc, err)
 		return nil
-	})
+	}
+	ctx, span := common.StartEventSpan(ctx, event.BaseEvent, "ProcessDiscountRelease")
+	defer span.End()
 
+	release, err := acquireReleaseSlot(ctx)
 	if err != nil {
-		logger.Error("Compensation failed", "order_id", event.OrderID, "error", err)
+		logger.Error("Failed to process release", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
 	}
-}
+	defer release()
 
-// statusCode extracts gRPC status code, simple helper needed because err isn't directly grpc error always
-// Simplified for this context: just assume standard error checks or use error strings if needed.
-// Actually firestore.NotFound is simpler.
-func statusCode(err error) int {
-	// This is a dummy implementation. In real code we'd use status.Code(err)
-	// But client.Get returns specific error for not found.
-	// Let's stick to checking string or use firestore wrapper.
-	// Rewriting usage above to just use Error check logic provided by client lib patterns.
-	return 0
+	released, usedAfter, err := quotas.Release(ctx, event)
+	if err != nil {
+		logger.Error("Compensation failed", "order_id", event.OrderID, "error", err)
+		return err
+	}
+	if released != nil {
+		quotas.RecordRelease(released.IsTest, released.QuotaDate, released.LocationID, usedAfter)
+	}
+	return nil
 }
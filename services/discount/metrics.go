@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The quota's own metrics (quota_transactions_total, quota_decisions_total, quota_used, ...) are
+// recorded by pkg/quota; these are the discount service's transaction limits
+var (
+	quotaTransactionWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "quota_transaction_wait_seconds",
+		Help:    "Time spent waiting for a quota transaction slot.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+
+	compensationQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "compensation_queue_depth",
+		Help: "Release transactions waiting for a free slot.",
+	})
+)
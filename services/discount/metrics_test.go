@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrape gathers the default registry into name -> "label=value,..." -> value, labels sorted by name
+func scrape(t *testing.T) map[string]map[string]float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraped := map[string]map[string]float64{}
+	for _, family := range families {
+		series := map[string]float64{}
+		for _, m := range family.GetMetric() {
+			labels := ""
+			for i, l := range m.GetLabel() {
+				if i > 0 {
+					labels += ","
+				}
+				labels += l.GetName() + "=" + l.GetValue()
+			}
+			switch {
+			case m.GetCounter() != nil:
+				series[labels] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				series[labels] = m.GetGauge().GetValue()
+			}
+		}
+		scraped[family.GetName()] = series
+	}
+	return scraped
+}
+
+func TestQuotaMetricsAfterDecisions(t *testing.T) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	quotaTimezone = time.UTC
+	quotaSlots = make(chan struct{}, 1)
+	quotaCfg = quota.Config{Limit: 2, Shards: 1}
+	quotas = &quota.Quota{Store: common.NewMemStore(), Config: quotaCfg, Publisher: &common.Publisher{Logger: logger}, Logger: logger}
+	ctx := context.Background()
+	date := quotaDateAt(time.Now())
+
+	order := func(id string, isTest bool) events.OrderCreated {
+		event := events.NewOrderCreated("trace-"+id, "", id)
+		event.UserID, event.Name, event.Gender, event.DOB = "u-"+id, "Test User", "F", "1990-01-01"
+		event.SelectedServices = []events.Service{{Name: "Consultation", Price: 1000}}
+		event.BasePrice, event.IsR1Eligible, event.DiscountPercent = 1000, true, 12
+		event.FinalPrice, event.DiscountAmount, event.DiscountableAmount = 880, 120, 1000
+		event.LocationID, event.QuotaDate, event.IsTest = events.DefaultLocation, date, isTest
+		return event
+	}
+	// Two approvals fill the quota, the third order is rejected and a duplicate is skipped
+	for _, event := range []events.OrderCreated{order("o1", false), order("o2", false), order("o3", false), order("o1", false)} {
+		if err := runQuotaTransaction(ctx, event); err != nil {
+			t.Fatalf("%s: %v", event.OrderID, err)
+		}
+	}
+	// Test traffic has its own quota and isn't reported
+	if err := runQuotaTransaction(ctx, order("t1", true)); err != nil {
+		t.Fatal(err)
+	}
+	// A release, as processReleaseEvent records it once committed
+	released, usedAfter, err := quotas.Release(ctx, events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed"))
+	if err != nil || released == nil {
+		t.Fatalf("Release = %+v, %v", released, err)
+	}
+	quotas.RecordRelease(false, released.QuotaDate, released.LocationID, usedAfter)
+
+	day := "date=" + date + ",location=" + events.DefaultLocation
+	want := map[string]map[string]float64{
+		"quota_decisions_total": {
+			"date=" + date + ",decision=approved,location=" + events.DefaultLocation: 2,
+			"date=" + date + ",decision=rejected,location=" + events.DefaultLocation: 1,
+		},
+		"quota_releases_total":     {day: 1},
+		"quota_used":               {day: 1},
+		"quota_remaining":          {day: 1},
+		"quota_transactions_total": {"result=committed": 4, "result=duplicate": 1},
+	}
+	got := scrape(t)
+	for name, series := range want {
+		if got[name] == nil {
+			t.Errorf("%s isn't registered", name)
+			continue
+		}
+		for labels, value := range series {
+			if v, ok := got[name][labels]; !ok || v != value {
+				t.Errorf("%s{%s} = %v (present %v), want %v; scraped %v", name, labels, v, ok, value, got[name])
+			}
+		}
+		if len(got[name]) != len(series) {
+			t.Errorf("%s has series %v, want only %v", name, got[name], series)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"google.golang.org/api/iterator"
+)
+
+// reconcileConfig controls the periodic quota drift check
+type reconcileConfig struct {
+	Interval  time.Duration // 0 disables the check
+	AutoFix   bool
+	Tolerance int64 // drift at or below this is corrected automatically when AutoFix is on
+}
+
+func loadReconcileConfig() (reconcileConfig, error) {
+	var cfg reconcileConfig
+	var err error
+	if cfg.Interval, err = common.EnvDuration("QUOTA_RECONCILE_INTERVAL", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.AutoFix, err = common.EnvBool("QUOTA_DRIFT_AUTOFIX", false); err != nil {
+		return cfg, err
+	}
+	tolerance, err := common.EnvInt("QUOTA_DRIFT_TOLERANCE", 5)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Tolerance = int64(tolerance)
+	return cfg, nil
+}
+
+// reconcileLoop periodically compares stored quota counts for today and yesterday against live reservations
+func reconcileLoop(ctx context.Context, client *firestore.Client, cfg reconcileConfig) {
+	if cfg.Interval <= 0 {
+		logger.Info("Quota reconciliation disabled")
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().In(quotaTimezone)
+			for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+				if err := reconcileDate(ctx, client, cfg, day); err != nil {
+					logger.Error("Quota reconciliation failed", "date", common.QuotaDate(day, quotaTimezone), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// reconcileDate recomputes the expected production count of every location for one quota day
+// and reports (or fixes) any drift
+func reconcileDate(ctx context.Context, client *firestore.Client, cfg reconcileConfig, day time.Time) error {
+	date := common.QuotaDate(day, quotaTimezone)
+	expected, err := countLiveReservations(ctx, client, day)
+	if err != nil {
+		return err
+	}
+
+	for location := range quotaLocations {
+		if err := reconcileLocation(ctx, client, cfg, location, date, expected[location]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reconcileLocation(ctx context.Context, client *firestore.Client, cfg reconcileConfig, location, date string, expected int64) error {
+	counter := quotas.Counter(false, location, date)
+	return quotas.Store.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		counts, err := counter.ReadShards(tx)
+		if err != nil {
+			return err
+		}
+		stored := int64(0)
+		for _, count := range counts {
+			stored += count
+		}
+
+		drift := stored - expected
+		if drift == 0 {
+			return nil
+		}
+
+		abs := drift
+		if abs < 0 {
+			abs = -abs
+		}
+		if !cfg.AutoFix || abs > cfg.Tolerance {
+			logger.Error("Quota Drift Detected", "location", location, "date", date, "stored_count", stored,
+				"expected_count", expected, "drift", drift, "auto_fixed", false)
+			return nil
+		}
+
+		logger.Warn("Quota Drift Corrected", "location", location, "date", date, "stored_count", stored,
+			"expected_count", expected, "drift", drift, "auto_fixed", true)
+		return counter.SetTotal(tx, expected)
+	})
+}
+
+// countLiveReservations counts, per location, orders reserved during the given quota day that have not been
+// released since. Reservations are derived from the event log: DiscountReserved events of that day minus any
+// DiscountRelease.
+func countLiveReservations(ctx context.Context, client *firestore.Client, day time.Time) (map[string]int64, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	reserved := make(map[string]string) // order_id -> location
+	iter := client.Collection(CollectionEvents).
+		Where("type", "==", events.EventTypeDiscountReserved).
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := doc.Data()
+		if isTest, _ := data["is_test"].(bool); isTest {
+			continue
+		}
+		if orderID, ok := data["order_id"].(string); ok {
+			location, _ := data["location_id"].(string)
+			if location == "" {
+				location = events.DefaultLocation
+			}
+			reserved[orderID] = location
+		}
+	}
+
+	releases := client.Collection(CollectionEvents).
+		Where("type", "==", events.EventTypeDiscountRelease).
+		Where("timestamp", ">=", start).
+		Documents(ctx)
+	defer releases.Stop()
+	for {
+		doc, err := releases.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if orderID, ok := doc.Data()["order_id"].(string); ok {
+			delete(reserved, orderID)
+		}
+	}
+
+	counts := make(map[string]int64)
+	for _, location := range reserved {
+		counts[location]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+)
+
+// rejectTestQuota points quotas at a fresh store with cfg and returns it
+func rejectTestQuota(cfg quota.Config) *common.MemStore {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	quotaTimezone = time.UTC
+	quotaSlots = make(chan struct{}, 1)
+	cfg.Limit, cfg.Shards = 2, 1
+	quotaCfg = cfg
+	store := common.NewMemStore()
+	quotas = &quota.Quota{Store: store, Config: cfg, Publisher: &common.Publisher{Logger: logger}, Logger: logger, Timezone: quotaTimezone}
+	return store
+}
+
+// rejectTestOrder is an R1 order at the default location
+func rejectTestOrder(id string) events.OrderCreated {
+	event := events.NewOrderCreated("trace-"+id, "", id)
+	event.UserID, event.Name, event.Gender, event.DOB = "u-"+id, "Test User", "F", "1990-01-01"
+	event.SelectedServices = []events.Service{{Name: "Consultation", Price: 1000}}
+	event.BasePrice, event.IsR1Eligible, event.DiscountPercent = 1000, true, 12
+	event.FinalPrice, event.DiscountAmount, event.DiscountableAmount = 880, 120, 1000
+	event.LocationID = events.DefaultLocation
+	return event
+}
+
+// assertRejected checks the order has exactly one decision, a rejection its marker points at
+func assertRejected(t *testing.T, store *common.MemStore, orderID string) {
+	t.Helper()
+	marker, err := store.Get(context.Background(), quota.DecisionPath(orderID))
+	if err != nil {
+		t.Fatalf("no decision marker for %s: %v", orderID, err)
+	}
+	var m quota.DecisionMarker
+	if err := marker.DataTo(&m); err != nil {
+		t.Fatal(err)
+	}
+	decisions := 0
+	for _, doc := range store.List(CollectionEvents) {
+		if doc.Data()["order_id"] != orderID {
+			continue
+		}
+		decisions++
+		if doc.ID != m.EventID || doc.Data()["type"] != string(events.EventTypeDiscountRejected) {
+			t.Errorf("event %s %v, marker %+v", doc.ID, doc.Data()["type"], m)
+		}
+	}
+	if decisions != 1 {
+		t.Errorf("%d decisions for %s, want 1", decisions, orderID)
+	}
+}
+
+func TestDriftRejectionWritesMarker(t *testing.T) {
+	store := rejectTestQuota(quota.Config{Drift: quota.Drift{MaxDrift: 5 * time.Minute, Action: quota.DriftReject}})
+	event := rejectTestOrder("o1")
+	event.Timestamp = time.Now().Add(-time.Hour)
+
+	// The rejection is written with the marker; a redelivery finds the order decided
+	for range 2 {
+		if err := runQuotaTransaction(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertRejected(t, store, "o1")
+
+	// A rejection that couldn't be written is returned, so the listener retries the order
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	event = rejectTestOrder("o2")
+	event.Timestamp = time.Now().Add(-time.Hour)
+	if err := runQuotaTransaction(ctx, event); err == nil {
+		t.Error("a rejection that couldn't be written returned no error")
+	}
+
+	// An event within the allowed drift is reserved
+	if err := runQuotaTransaction(context.Background(), rejectTestOrder("o3")); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := store.Get(context.Background(), quota.DecisionPath("o3")); err != nil || doc.Data()["type"] != string(events.EventTypeDiscountReserved) {
+		t.Errorf("o3's decision = %v, %v; want DiscountReserved", doc, err)
+	}
+}
+
+func TestLocationRejectionWritesMarker(t *testing.T) {
+	store := rejectTestQuota(quota.Config{})
+	event := rejectTestOrder("o1")
+	event.LocationID = "nowhere"
+
+	for range 2 {
+		if err := rejectUnknownLocation(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertRejected(t, store, "o1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rejectUnknownLocation(ctx, rejectTestOrder("o2")); err == nil {
+		t.Error("a rejection that couldn't be written returned no error")
+	}
+}
+
+func TestFreezeRejectionWritesMarker(t *testing.T) {
+	// A window wider than half a day covers every instant
+	store := rejectTestQuota(quota.Config{Freeze: quota.Freeze{Window: 13 * time.Hour, Action: quota.FreezeReject}})
+
+	for range 2 {
+		if err := runQuotaTransaction(context.Background(), rejectTestOrder("o1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertRejected(t, store, "o1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := runQuotaTransaction(ctx, rejectTestOrder("o2")); err == nil {
+		t.Error("a rejection that couldn't be written returned no error")
+	}
+}
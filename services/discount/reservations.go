@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// processPaymentCompleted commits a paid order's reservation. With holds enabled it also confirms
+// the hold, so a booking whose DiscountConfirm was lost is not expired by the sweeper. A failed
+// commit is returned so the event is retried.
+func processPaymentCompleted(ctx context.Context, client *firestore.Client, doc *firestore.DocumentSnapshot) error {
+	var event events.PaymentCompleted
+	if err := common.ParseEvent(common.SnapshotDoc(doc), &event); err != nil {
+		logger.Error("Failed to parse payment event", "id", doc.Ref.ID, "error", err)
+		deadLetter(ctx, client, doc, err)
+		return nil
+	}
+	ctx, span := common.StartEventSpan(ctx, event.BaseEvent, "ProcessPaymentCompleted")
+	defer span.End()
+
+	committed, err := quotas.Commit(ctx, event)
+	if err != nil {
+		logger.Error("Failed to commit reservation", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
+	}
+	if committed {
+		logger.Info("Reservation Committed", "order_id", event.OrderID, "trace_id", event.TraceID, "amount", event.Amount)
+	}
+	return nil
+}
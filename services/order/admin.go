@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requireAdmin rejects requests that don't carry "Authorization: Bearer <ADMIN_TOKEN>".
+// When ADMIN_TOKEN is unset the admin endpoints are disabled.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type pendingInfo struct {
+	OrderID   string    `json:"order_id"`
+	TraceID   string    `json:"trace_id"`
+	StartedAt time.Time `json:"started_at"`
+	Age       string    `json:"age"`
+}
+
+// handlePending lists the orders this instance is currently waiting on, oldest first
+func handlePending(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	mapMutex.RLock()
+	pending := make([]pendingInfo, 0, len(responseMap))
+	for orderID, p := range responseMap {
+		pending = append(pending, pendingInfo{
+			OrderID:   orderID,
+			TraceID:   p.traceID,
+			StartedAt: p.startedAt,
+			Age:       now.Sub(p.startedAt).Round(time.Millisecond).String(),
+		})
+	}
+	mapMutex.RUnlock()
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].StartedAt.Before(pending[j].StartedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
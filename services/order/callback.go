@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// CallbackConfig asks for the order's outcome to be POSTed to URL as well as returned in the
+// response, e.g. for clients whose connection may drop while the order waits on its decision
+type CallbackConfig struct {
+	URL string `json:"url"`
+	// Outcomes limits callbacks to these outcomes (see OutcomeConfirmed etc.); empty means all
+	Outcomes []string `json:"outcomes,omitempty"`
+}
+
+// callbackPayload is the body of a callback POST
+type callbackPayload struct {
+	Outcome string        `json:"outcome"`
+	Order   OrderResponse `json:"order"`
+}
+
+var (
+	// callbackHosts are the hosts callbacks may be sent to; empty disables callbacks
+	callbackHosts   []string
+	callbackTimeout = 5 * time.Second
+)
+
+// loadCallbacks reads ORDER_CALLBACK_HOSTS and ORDER_CALLBACK_TIMEOUT
+func loadCallbacks() error {
+	callbackHosts = common.EnvList("ORDER_CALLBACK_HOSTS", nil)
+	var err error
+	if callbackTimeout, err = common.EnvDuration("ORDER_CALLBACK_TIMEOUT", callbackTimeout); err != nil {
+		return err
+	}
+	if callbackTimeout <= 0 {
+		return fmt.Errorf("ORDER_CALLBACK_TIMEOUT must be positive, got %s", callbackTimeout)
+	}
+	return nil
+}
+
+// validateCallback checks the callback URL against the allowlist and the outcome filter against the
+// known outcomes
+func validateCallback(cb *CallbackConfig) error {
+	if len(callbackHosts) == 0 {
+		return fmt.Errorf("callbacks are not enabled")
+	}
+	u, err := url.Parse(cb.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback url %q", cb.URL)
+	}
+	if !slices.Contains(callbackHosts, u.Hostname()) {
+		return fmt.Errorf("callback host %q is not allowed", u.Hostname())
+	}
+	for _, outcome := range cb.Outcomes {
+		if _, known := outcomeStatus[outcome]; !known {
+			return fmt.Errorf("unknown callback outcome %q", outcome)
+		}
+	}
+	return nil
+}
+
+// notify posts the outcome in the background if the order asked for a callback and the filter
+// selects it
+func (w *orderWriter) notify(outcome string, resp OrderResponse) {
+	if w.cb == nil || (len(w.cb.Outcomes) > 0 && !slices.Contains(w.cb.Outcomes, outcome)) {
+		return
+	}
+	go postCallback(w.cb.URL, w.traceID, callbackPayload{Outcome: outcome, Order: resp})
+}
+
+// postCallback delivers one callback. It is attempted once; failures are logged.
+func postCallback(target, traceID string, payload callbackPayload) {
+	ctx, cancel := context.WithTimeout(serverCtx, callbackTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Callback failed", "order_id", payload.Order.OrderID, "trace_id", traceID, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Warn("Callback failed", "order_id", payload.Order.OrderID, "trace_id", traceID, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger.Warn("Callback rejected", "order_id", payload.Order.OrderID, "trace_id", traceID, "status", resp.StatusCode)
+	}
+}
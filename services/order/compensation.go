@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// compensationSlots bounds concurrent DiscountRelease publishes, so a burst of payment failures
+// doesn't become a burst of writes and, behind it, of release transactions on the quota counter
+var compensationSlots chan struct{}
+
+var compensationQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "compensation_queue_depth",
+	Help: "Compensation publishes waiting for a free slot.",
+})
+
+// loadCompensationConcurrency sizes the limiter from COMPENSATION_CONCURRENCY (default 8)
+func loadCompensationConcurrency() error {
+	limit, err := common.EnvInt("COMPENSATION_CONCURRENCY", 8)
+	if err != nil {
+		return err
+	}
+	if limit < 1 {
+		return fmt.Errorf("COMPENSATION_CONCURRENCY must be at least 1, got %d", limit)
+	}
+	compensationSlots = make(chan struct{}, limit)
+	return nil
+}
+
+// publishCompensation publishes a DiscountRelease with retries once a slot is free. Time spent
+// waiting for the slot counts against ctx.
+func publishCompensation(ctx context.Context, event events.DiscountRelease) error {
+	compensationQueueDepth.Inc()
+	select {
+	case compensationSlots <- struct{}{}:
+		compensationQueueDepth.Dec()
+	case <-ctx.Done():
+		compensationQueueDepth.Dec()
+		return ctx.Err()
+	}
+	defer func() { <-compensationSlots }()
+
+	return publisher.PublishWithRetry(ctx, event, compensationAttempts, 200*time.Millisecond)
+}
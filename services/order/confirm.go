@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// How a reserved discount becomes a confirmed booking
+const (
+	ConfirmSingle = "single" // confirm the reservation and answer CONFIRMED in one step (default)
+	ConfirmAwait  = "await"  // answer CONFIRMED only once the confirmation is written, RESERVED otherwise
+	ConfirmClient = "client" // answer RESERVED; the client confirms with POST /order/{id}/confirm
+)
+
+var confirmationMode = ConfirmSingle
+
+func loadConfirmationMode() error {
+	confirmationMode = common.EnvOrDefault("ORDER_CONFIRMATION_MODE", ConfirmSingle)
+	switch confirmationMode {
+	case ConfirmSingle, ConfirmAwait, ConfirmClient:
+		return nil
+	}
+	return fmt.Errorf("ORDER_CONFIRMATION_MODE: unknown mode %q", confirmationMode)
+}
+
+func newConfirmEvent(r *http.Request, orderID, traceID string) events.DiscountConfirm {
+	return events.NewDiscountConfirm(traceID, common.ParentSpanID(r.Context()), orderID)
+}
+
+// publishPaymentCompleted records a paid booking so the discount service commits its reservation.
+// A failure is logged; the confirmed hold already keeps the reservation.
+func publishPaymentCompleted(r *http.Request, orderID, traceID string, amount float64) {
+	err := publisher.Publish(r.Context(), events.NewPaymentCompleted(traceID, common.ParentSpanID(r.Context()), orderID, amount))
+	if err != nil {
+		logger.Error("Failed to publish payment completion", "order_id", orderID, "trace_id", traceID, "error", err)
+	}
+}
+
+// applyReservedPrice takes the rate and amounts the discount service granted the reservation at,
+// which differ from the order's own when a discount tier repriced it. Decisions that don't carry a
+// rate leave the order as priced.
+func applyReservedPrice(req *OrderRequest, reserved events.DiscountReserved, orderID string) {
+	if reserved.DiscountPercent == 0 || reserved.DiscountPercent == req.DiscountPercent {
+		return
+	}
+	logger.Info("Order repriced at discount tier", "order_id", orderID, "order_percent", req.DiscountPercent,
+		"tier_percent", reserved.DiscountPercent, "final_price", reserved.FinalPrice)
+	req.DiscountPercent = reserved.DiscountPercent
+	req.FinalPrice = reserved.FinalPrice
+	req.DiscountAmount = reserved.DiscountAmount
+}
+
+// respondReserved answers 202 RESERVED for a reservation that isn't confirmed yet
+func respondReserved(w http.ResponseWriter, req OrderRequest, orderID string, reserved events.DiscountReserved, message string) {
+	writeOutcome(w, OutcomeReserved, OrderResponse{
+		OrderID:         orderID,
+		Status:          "RESERVED",
+		Message:         message,
+		FinalPrice:      req.FinalPrice,
+		DiscountPercent: req.DiscountPercent,
+		DiscountAmount:  req.DiscountAmount,
+		ConfirmDeadline: reserved.ConfirmDeadline,
+		Reasons:         req.Reasons,
+	})
+}
+
+// CollectionConfirmations records how each client-confirmed order's reservation ended, keyed by
+// order_id: confirmed, with the response sent, or released by the saga's compensation. Confirming
+// writes it together with the confirmation's events, so a repeated or concurrent confirm replays the
+// first answer instead of settling twice.
+const CollectionConfirmations = "order_confirmations"
+
+// Confirmation states
+const (
+	ConfirmationConfirmed = "confirmed"
+	ConfirmationReleased  = "released"
+)
+
+// OrderConfirmation is an order's document in CollectionConfirmations
+type OrderConfirmation struct {
+	OrderID   string    `firestore:"order_id"`
+	State     string    `firestore:"state"`
+	Body      []byte    `firestore:"body,omitempty"` // the CONFIRMED response, when confirmed
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+func confirmationPath(orderID string) string {
+	return CollectionConfirmations + "/" + orderID
+}
+
+// recordRelease marks an order's reservation released before its compensation is published, so a
+// later confirm is refused rather than settling a booking whose discount was given back
+func recordRelease(ctx context.Context, orderID string) {
+	err := docStore.Set(ctx, confirmationPath(orderID), OrderConfirmation{
+		OrderID:   orderID,
+		State:     ConfirmationReleased,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		logger.Warn("Failed to record reservation release", "order_id", orderID, "error", err)
+	}
+}
+
+// recordConfirmation records an order the saga confirmed itself, so a later confirm replays its
+// answer instead of settling it again
+func recordConfirmation(ctx context.Context, resp OrderResponse) {
+	body, err := confirmationBody(resp)
+	if err == nil {
+		err = docStore.Create(ctx, confirmationPath(resp.OrderID), OrderConfirmation{
+			OrderID:   resp.OrderID,
+			State:     ConfirmationConfirmed,
+			Body:      body,
+			UpdatedAt: time.Now(),
+		})
+	}
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		logger.Warn("Failed to record confirmation", "order_id", resp.OrderID, "error", err)
+	}
+}
+
+// confirmationBody is resp as writeOutcome sends it, so a replay matches the first answer byte for byte
+func confirmationBody(resp OrderResponse) ([]byte, error) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(resp)
+	return body.Bytes(), err
+}
+
+// findReservation returns an order's DiscountReserved through its decision marker or, for orders
+// decided before markers were written, the event log. ok is false when the order wasn't reserved.
+func findReservation(ctx context.Context, orderID string) (reserved events.DiscountReserved, ok bool, err error) {
+	doc, err := docStore.Get(ctx, quota.DecisionPath(orderID))
+	if common.IsNotFound(err) {
+		decision, err := findDecision(ctx, orderID)
+		reserved, ok = decision.(events.DiscountReserved)
+		return reserved, ok, err
+	}
+	if err != nil {
+		return reserved, false, err
+	}
+	var marker quota.DecisionMarker
+	if err := doc.DataTo(&marker); err != nil {
+		return reserved, false, err
+	}
+	if marker.Type != events.EventTypeDiscountReserved {
+		return reserved, false, nil
+	}
+	if doc, err = docStore.Get(ctx, CollectionEvents+"/"+marker.EventID); err != nil {
+		return reserved, false, err
+	}
+	if err := common.ParseEvent(doc, &reserved); err != nil {
+		return reserved, false, fmt.Errorf("parse decision %s: %w", marker.EventID, err)
+	}
+	return reserved, true, nil
+}
+
+// reservationReleased reports, inside tx, whether the discount service has returned an order's
+// quota: a released reservation, or a hold that expired or was released
+func reservationReleased(tx common.DocTx, orderID string) (bool, error) {
+	doc, err := tx.Get(quota.ReservationPath(orderID))
+	if err != nil && !common.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		var res quota.Reservation
+		if err := doc.DataTo(&res); err != nil {
+			return false, err
+		}
+		if res.Released {
+			return true, nil
+		}
+	}
+	doc, err = tx.Get(quota.HoldPath(orderID))
+	if common.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	state, _ := common.GetString(doc.Data(), "state")
+	return state == quota.HoldExpired || state == quota.HoldReleased, nil
+}
+
+// handleConfirm confirms a reservation that was answered RESERVED. It finds the reservation through
+// the decision marker rather than the read-model, so it works straight after the reservation, and
+// writes DiscountConfirm, PaymentCompleted and OrderSettled in one transaction with the order's
+// confirmation record. Confirming again replays the first answer; a reservation whose quota was
+// released is refused.
+func handleConfirm(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+	ctx := r.Context()
+
+	reserved, ok, err := findReservation(ctx, orderID)
+	if err != nil {
+		logger.Error("Failed to look up decision", "order_id", orderID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Order has no discount reservation to confirm", http.StatusConflict)
+		return
+	}
+	traceID := reserved.TraceID
+
+	// Amounts come from the read-model, which has normally caught up by the time the client
+	// confirms, or else from the order's events. Without them nothing can be paid or settled.
+	var view projection.OrderView
+	found := false
+	if doc, err := docStore.Get(ctx, ordersCollection+"/"+orderID); err == nil && doc.DataTo(&view) == nil {
+		found = true
+	} else if found, err = orderFromEvents(ctx, orderID, &view); err != nil {
+		logger.Error("Failed to read order events", "order_id", orderID, "trace_id", traceID, "error", err)
+	}
+	if !found {
+		logger.Warn("Order amounts unknown, not confirmed", "order_id", orderID, "trace_id", traceID)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Order details are not available yet, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := OrderResponse{
+		OrderID:         orderID,
+		Status:          "CONFIRMED",
+		Message:         fmt.Sprintf("Booking confirmed! Final price: ₹%.2f (%g%% discount applied)", view.FinalPrice, view.DiscountPercent),
+		FinalPrice:      view.FinalPrice,
+		DiscountPercent: view.DiscountPercent,
+		DiscountAmount:  view.DiscountAmount,
+	}
+	body, err := confirmationBody(resp)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	confirm := events.Event(newConfirmEvent(r, orderID, traceID))
+	payment := events.Event(events.NewPaymentCompleted(traceID, common.ParentSpanID(ctx), orderID, view.FinalPrice))
+	var settled events.Event
+	if s, err := newOrderSettled(ctx, traceID, events.OrderSettled{
+		OrderID:        orderID,
+		UserID:         view.UserID,
+		BasePrice:      view.BasePrice,
+		DiscountAmount: view.DiscountAmount,
+		FinalPrice:     view.FinalPrice,
+		IsTest:         reserved.IsTest,
+	}); err != nil {
+		logger.Log(ctx, common.LevelCritical, "Inconsistent settlement amounts, not settled",
+			"order_id", orderID, "trace_id", traceID, "error", err)
+	} else {
+		settled = s
+	}
+
+	var existing OrderConfirmation
+	var written map[string]events.Event
+	released, expired := false, false
+	err = docStore.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		existing, written, released, expired = OrderConfirmation{}, nil, false, false
+		doc, err := tx.Get(confirmationPath(orderID))
+		if err != nil && !common.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			return doc.DataTo(&existing)
+		}
+		if released, err = reservationReleased(tx, orderID); err != nil || released {
+			return err
+		}
+		// Until the sweeper expires the hold, the deadline itself still applies
+		if expired = !reserved.ConfirmDeadline.IsZero() && time.Now().After(reserved.ConfirmDeadline); expired {
+			return nil
+		}
+
+		written = make(map[string]events.Event)
+		for _, event := range []events.Event{confirm, payment, settled} {
+			if event == nil {
+				continue
+			}
+			if err := events.Validate(event); err != nil {
+				return err
+			}
+			id := docStore.NewDocID(CollectionEvents)
+			written[id] = publisher.Stamp(event)
+			if err := tx.Set(CollectionEvents+"/"+id, written[id]); err != nil {
+				return err
+			}
+		}
+		return tx.Create(confirmationPath(orderID), OrderConfirmation{
+			OrderID:   orderID,
+			State:     ConfirmationConfirmed,
+			Body:      body,
+			UpdatedAt: time.Now(),
+		})
+	})
+	switch {
+	case err != nil:
+		logger.Error("Failed to record confirmation", "order_id", orderID, "trace_id", traceID, "error", err)
+		http.Error(w, "Confirmation could not be recorded, please retry", http.StatusServiceUnavailable)
+	case existing.State == ConfirmationConfirmed:
+		logger.Info("Replaying earlier confirmation", "order_id", orderID, "trace_id", traceID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(outcomeStatus[OutcomeConfirmed])
+		w.Write(existing.Body)
+	case existing.State == ConfirmationReleased || released:
+		logger.Warn("Confirm for released reservation", "order_id", orderID, "trace_id", traceID)
+		http.Error(w, "Reservation was released before confirmation", http.StatusGone)
+	case expired:
+		http.Error(w, "Reservation expired before confirmation", http.StatusGone)
+	default:
+		for id, event := range written {
+			publisher.MirrorEvent(ctx, id, event)
+		}
+		logger.Info("Reservation confirmed by client", "order_id", orderID, "trace_id", traceID)
+		writeOutcome(w, OutcomeConfirmed, resp)
+	}
+}
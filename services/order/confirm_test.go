@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+)
+
+// reserveForConfirm reserves order o1 on a fresh store, as the discount service would, and writes
+// its read-model view
+func reserveForConfirm(t *testing.T) (*quota.Quota, *common.MemStore) {
+	t.Helper()
+	store := common.NewMemStore()
+	docStore = store
+	publisher = &common.Publisher{Logger: logger}
+	ordersCollection = projection.DefaultCollection
+
+	order := events.NewOrderCreated("trace-o1", "", "o1")
+	order.UserID = "u1"
+	order.Name = "Test User"
+	order.Gender = "F"
+	order.DOB = "1990-01-01"
+	order.SelectedServices = []events.Service{{Name: "Consultation", Price: 1000}}
+	order.BasePrice = 1000
+	order.IsR1Eligible = true
+	order.DiscountPercent = 12
+	order.FinalPrice = 880
+	order.DiscountAmount = 120
+	order.DiscountableAmount = 1000
+	order.LocationID = events.DefaultLocation
+	order.QuotaDate = "2026-10-15"
+
+	q := &quota.Quota{Store: store, Config: quota.Config{Limit: 3, Shards: 1}, Publisher: publisher, Logger: logger}
+	if _, err := q.Reserve(context.Background(), order, order.QuotaDate); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Set(context.Background(), ordersCollection+"/o1", projection.OrderView{
+		OrderID: "o1", TraceID: "trace-o1", UserID: "u1", Status: projection.StatusReserved,
+		BasePrice: 1000, DiscountPercent: 12, FinalPrice: 880, DiscountAmount: 120, QuotaReserved: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, store
+}
+
+func postConfirm(orderID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/order/"+orderID+"/confirm", nil)
+	req.SetPathValue("id", orderID)
+	rec := httptest.NewRecorder()
+	handleConfirm(rec, req)
+	return rec
+}
+
+// countEvents counts the events of each type written to the store
+func countEvents(store *common.MemStore) map[events.EventType]int {
+	counts := make(map[events.EventType]int)
+	for _, doc := range store.List(CollectionEvents) {
+		eventType, _ := common.GetString(doc.Data(), "type")
+		counts[events.EventType(eventType)]++
+	}
+	return counts
+}
+
+func TestConfirmTwiceSettlesOnce(t *testing.T) {
+	_, store := reserveForConfirm(t)
+
+	first := postConfirm("o1")
+	second := postConfirm("o1")
+
+	if first.Code != http.StatusOK {
+		t.Fatalf("first confirm = %d %s", first.Code, first.Body)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("second confirm = %d %q, want the first answer %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	counts := countEvents(store)
+	for _, eventType := range []events.EventType{events.EventTypeDiscountConfirm, events.EventTypePaymentCompleted, events.EventTypeOrderSettled} {
+		if counts[eventType] != 1 {
+			t.Errorf("%d %s events, want 1", counts[eventType], eventType)
+		}
+	}
+}
+
+func TestConfirmRefusesReleasedReservation(t *testing.T) {
+	tests := []struct {
+		name    string
+		release func(t *testing.T, q *quota.Quota)
+	}{
+		{"released by the discount service", func(t *testing.T, q *quota.Quota) {
+			if _, _, err := q.Release(context.Background(), events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed")); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"compensated by the saga", func(t *testing.T, q *quota.Quota) {
+			recordRelease(context.Background(), "o1")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := reserveForConfirm(t)
+			tt.release(t, q)
+			before := countEvents(store)
+
+			rec := postConfirm("o1")
+			if rec.Code != http.StatusGone {
+				t.Errorf("status = %d, want 410", rec.Code)
+			}
+			after := countEvents(store)
+			if after[events.EventTypeDiscountConfirm] != before[events.EventTypeDiscountConfirm] ||
+				after[events.EventTypeOrderSettled] != before[events.EventTypeOrderSettled] {
+				t.Errorf("a refused confirm wrote events: %v", after)
+			}
+		})
+	}
+}
+
+func TestConfirmReplaysSagaConfirmation(t *testing.T) {
+	_, store := reserveForConfirm(t)
+	recordConfirmation(context.Background(), OrderResponse{OrderID: "o1", Status: "CONFIRMED", FinalPrice: 880})
+
+	rec := postConfirm("o1")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	if n := countEvents(store)[events.EventTypeOrderSettled]; n != 0 {
+		t.Errorf("confirming an order the saga confirmed wrote %d OrderSettled events", n)
+	}
+}
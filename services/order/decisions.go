@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/google/uuid"
+)
+
+// decisionDecoders maps each terminal event type the order handler can wait on to the decoder of the
+// value delivered on the order's channel. A new terminal type is registered here and given a branch
+// in handleOrder's decision switch.
+var decisionDecoders = map[events.EventType]func(*common.Doc) (interface{}, error){
+	events.EventTypeDiscountReserved: decodeDecision[events.DiscountReserved],
+	events.EventTypeDiscountRejected: decodeDecision[events.DiscountRejected],
+}
+
+// decisionTypes are the terminal types the decision listener subscribes to
+var decisionTypes []events.EventType
+
+// instanceID identifies this replica. It is stamped on OrderCreated, copied onto the decision, and
+// the decision listener only subscribes to decisions carrying it, so replicas never see each
+// other's orders.
+var instanceID string
+
+func decodeDecision[T any](doc *common.Doc) (interface{}, error) {
+	var e T
+	err := common.ParseEvent(doc, &e)
+	return e, err
+}
+
+// loadDecisionTypes reads ORDER_DECISION_TYPES, the comma-separated terminal types to wait on.
+// The default is every registered type.
+func loadDecisionTypes() error {
+	registered := make([]string, 0, len(decisionDecoders))
+	for eventType := range decisionDecoders {
+		registered = append(registered, string(eventType))
+	}
+	slices.Sort(registered)
+
+	decisionTypes = nil
+	for _, name := range common.EnvList("ORDER_DECISION_TYPES", registered) {
+		eventType := events.EventType(name)
+		if _, ok := decisionDecoders[eventType]; !ok {
+			return fmt.Errorf("ORDER_DECISION_TYPES: no decoder registered for %q", name)
+		}
+		decisionTypes = append(decisionTypes, eventType)
+	}
+	return nil
+}
+
+// loadInstanceID reads ORDER_INSTANCE_ID, defaulting to a random ID per process. A fixed ID must be
+// unique per running replica.
+func loadInstanceID() {
+	instanceID = common.EnvOrDefault("ORDER_INSTANCE_ID", uuid.New().String())
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+)
+
+func TestAddedTerminalTypeIsDelivered(t *testing.T) {
+	store := common.NewMemStore()
+	docStore = store
+	responseMap = make(map[string]*pendingOrder)
+	decisionBuffer = 1
+
+	decisionDecoders[events.EventTypeDiscountRelease] = decodeDecision[events.DiscountRelease]
+	defer delete(decisionDecoders, events.EventTypeDiscountRelease)
+	t.Setenv("ORDER_DECISION_TYPES", "DiscountReserved,DiscountRejected,DiscountRelease")
+	if err := loadDecisionTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if len(decisionTypes) != 3 || decisionTypes[2] != events.EventTypeDiscountRelease {
+		t.Fatalf("decisionTypes = %v, want the added type subscribed to", decisionTypes)
+	}
+
+	ch, err := publishAwaitingDecision("o1", "trace-o1", func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterPending("o1")
+	routeDecision(context.Background(), eventDoc(t, store, "e1", events.NewDiscountRelease("trace-o1", "", "o1", "Payment failed")))
+
+	select {
+	case got := <-ch:
+		if release, ok := got.(events.DiscountRelease); !ok || release.OrderID != "o1" {
+			t.Errorf("received %T %+v, want o1's DiscountRelease", got, got)
+		}
+	default:
+		t.Error("the added terminal type was ignored")
+	}
+}
+
+func TestDecisionTypesDefaultAndUnknown(t *testing.T) {
+	t.Setenv("ORDER_DECISION_TYPES", "")
+	if err := loadDecisionTypes(); err != nil {
+		t.Fatal(err)
+	}
+	want := []events.EventType{events.EventTypeDiscountRejected, events.EventTypeDiscountReserved}
+	if len(decisionTypes) != len(want) || decisionTypes[0] != want[0] || decisionTypes[1] != want[1] {
+		t.Errorf("default decisionTypes = %v, want %v", decisionTypes, want)
+	}
+
+	// A type the handler can't decode would be subscribed to and then dropped
+	t.Setenv("ORDER_DECISION_TYPES", "DiscountReserved,OrderSettled")
+	if err := loadDecisionTypes(); err == nil {
+		t.Error("accepted a decision type with no decoder")
+	}
+}
+
+// replica is one order-service process: its instance ID and its map of orders awaiting a decision
+type replica struct {
+	id      string
+	pending map[string]*pendingOrder
+}
+
+// as runs f with the package state of replica r
+func (r replica) as(f func()) {
+	instanceID, responseMap = r.id, r.pending
+	f()
+}
+
+func TestDecisionsRouteToPublishingReplica(t *testing.T) {
+	store := common.NewMemStore()
+	docStore = store
+	decisionBuffer = 1
+	quotaTimezone = time.UTC
+	q := &quota.Quota{Store: store, Config: quota.Config{Limit: 10, Shards: 1}, Publisher: &common.Publisher{Logger: logger}, Logger: logger}
+	defer func() { instanceID, responseMap = "", make(map[string]*pendingOrder) }()
+
+	a := replica{id: "replica-a", pending: make(map[string]*pendingOrder)}
+	b := replica{id: "replica-b", pending: make(map[string]*pendingOrder)}
+
+	// Both replicas await a decision for o1, so only the instance filter tells them apart
+	channels := map[string]chan interface{}{}
+	for _, r := range []replica{a, b} {
+		r.as(func() {
+			ch, err := publishAwaitingDecision("o1", "trace-o1", func() error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			channels[r.id] = ch
+		})
+	}
+
+	// Replica a published the order
+	var decision *common.Doc
+	a.as(func() {
+		req := OrderRequest{UserID: "u-o1", Name: "Test User", Gender: "Female", DOB: "1990-01-01",
+			SelectedServices: []Service{{"Mammography", 1500}}, BasePrice: 1500, IsR1Eligible: true,
+			DiscountPercent: 12, FinalPrice: 1320, DiscountAmount: 180, LocationID: events.DefaultLocation}
+		order := newOrderCreated(httptest.NewRequest(http.MethodPost, "/order", nil), req, "o1", "trace-o1", nil)
+		if order.InstanceID != a.id {
+			t.Errorf("OrderCreated carries instance %q, want %q", order.InstanceID, a.id)
+		}
+		reserved, err := q.Reserve(context.Background(), order, order.QuotaDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision = eventDoc(t, store, "decision-o1", reserved)
+	})
+
+	// Both replicas see the decision, as they would without the listener's instance filter
+	for _, r := range []replica{a, b} {
+		r.as(func() { routeDecision(context.Background(), decision) })
+	}
+
+	if n := len(channels[a.id]); n != 1 {
+		t.Fatalf("the publishing replica received %d decisions, want 1", n)
+	}
+	if got := (<-channels[a.id]).(events.DiscountReserved).OrderID; got != "o1" {
+		t.Errorf("the publishing replica received %s's decision", got)
+	}
+	if n := len(channels[b.id]); n != 0 {
+		t.Errorf("the other replica received %d decisions for an order it didn't publish", n)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dedupWindow is how long an identical submission from the same user joins the first one (0 disables)
+var dedupWindow = 2 * time.Second
+
+// inflightOrder is the shared outcome of the first of several identical submissions
+type inflightOrder struct {
+	done   chan struct{}
+	result *bufferedResponse
+}
+
+var (
+	inflight   = make(map[string]*inflightOrder)
+	inflightMu sync.Mutex
+)
+
+// bufferedResponse records a handler's response so it can be replayed to duplicate requests
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(code int)        { b.status = code }
+
+func (b *bufferedResponse) replay(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+// dedupOrders collapses near-simultaneous identical POSTs (same user_id and body) into one saga:
+// duplicates arriving within the window wait for and receive the first request's response.
+func dedupOrders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dedupWindow <= 0 || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid Body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var ident struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.Unmarshal(body, &ident); err != nil || ident.UserID == "" {
+			next(w, r)
+			return
+		}
+		sum := sha256.Sum256(body)
+		key := ident.UserID + ":" + hex.EncodeToString(sum[:])
+
+		inflightMu.Lock()
+		if existing, ok := inflight[key]; ok {
+			inflightMu.Unlock()
+			logger.Info("Duplicate order submission joined in-flight request", "user_id", ident.UserID)
+			select {
+			case <-existing.done:
+				existing.result.replay(w)
+			case <-r.Context().Done():
+			}
+			return
+		}
+		entry := &inflightOrder{done: make(chan struct{})}
+		inflight[key] = entry
+		inflightMu.Unlock()
+
+		started := time.Now()
+		entry.result = newBufferedResponse()
+		defer func() {
+			close(entry.done)
+			// Keep the result around for the rest of the window so late duplicates still join it
+			time.AfterFunc(max(dedupWindow-time.Since(started), 0), func() {
+				inflightMu.Lock()
+				delete(inflight, key)
+				inflightMu.Unlock()
+			})
+		}()
+
+		next(entry.result, r)
+		entry.result.replay(w)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// maxEmbeddedServices caps the services embedded in OrderCreated; 0 always embeds
+var maxEmbeddedServices = 20
+
+// externalizeServices moves a large service list out of the event into an order_details document
+// referenced by DetailsID, keeping the hot event small. Small lists stay embedded.
+func externalizeServices(ctx context.Context, event *events.OrderCreated) error {
+	event.ServiceCount = len(event.SelectedServices)
+	if maxEmbeddedServices <= 0 || event.ServiceCount <= maxEmbeddedServices {
+		return nil
+	}
+
+	details := events.OrderDetails{
+		OrderID:          event.OrderID,
+		SelectedServices: event.SelectedServices,
+		CreatedAt:        time.Now(),
+	}
+	if _, err := client.Collection(events.CollectionOrderDetails).Doc(event.OrderID).Set(ctx, details); err != nil {
+		return err
+	}
+	event.DetailsID = event.OrderID
+	event.SelectedServices = nil
+	return nil
+}
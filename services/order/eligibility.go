@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/eligibility"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+)
+
+var (
+	eligibilityEngine *eligibility.Engine
+	clinicLocation    *time.Location
+	historyNeeded     bool // a rule depends on the user's booking history
+	excludedServices  = map[string]bool{}
+	priceFloor        = pricing.FloorClamp
+	remoteRules       *eligibility.Remote // nil unless RULES_SERVICE_URL is set
+)
+
+// loadEligibility builds the server-side rule engine from the environment:
+//   - CLINIC_TIMEZONE: IANA zone used for "today" and time-of-day rules (default Asia/Kolkata)
+//   - DISCOUNT_PERCENT: the rate every rule grants, in (0, 100] (default 12)
+//   - DISCOUNT_STACKING: how matched rule percentages combine, "max" or "sum" (default max)
+//   - DISCOUNT_OFFPEAK_WINDOW: e.g. "14:00-17:00"; when set, discounts only apply inside it (default disabled)
+//   - DISCOUNT_FIRST_BOOKING: grant the discount to users without a prior confirmed booking (default false)
+//   - DISCOUNT_LOYALTY_BOOKINGS: grant the discount to users with at least this many prior confirmed
+//     bookings (default 0, disabled)
+//   - DISCOUNT_EXCLUDED_SERVICES: comma-separated service names the discount never applies to
+//   - DISCOUNT_PRICE_FLOOR: "clamp" a negative final price to zero or "reject" the order (default clamp)
+//   - RULES_SERVICE_URL: external rules service that decides instead of the local rules, which remain
+//     the fallback; RULES_SERVICE_TIMEOUT (default 500ms) and RULES_CACHE_TTL (default 30s) tune it
+//
+// The history rules count bookings in the read-model, so they are refused unless ORDER_RECORD_ALL,
+// read before this, records the bookings that get no discount there too.
+func loadEligibility() error {
+	loc, err := time.LoadLocation(common.EnvOrDefault("CLINIC_TIMEZONE", "Asia/Kolkata"))
+	if err != nil {
+		return fmt.Errorf("CLINIC_TIMEZONE: %w", err)
+	}
+
+	percent, err := common.EnvFloat("DISCOUNT_PERCENT", eligibility.DefaultPercent)
+	if err != nil {
+		return err
+	}
+	if err := eligibility.ValidatePercent(percent); err != nil {
+		return fmt.Errorf("DISCOUNT_PERCENT: %w", err)
+	}
+
+	engine := eligibility.DefaultAt(percent)
+	engine.Stacking = common.EnvOrDefault("DISCOUNT_STACKING", eligibility.StackingMax)
+	if err := eligibility.ValidateStacking(engine.Stacking); err != nil {
+		return fmt.Errorf("DISCOUNT_STACKING: %w", err)
+	}
+
+	if window := common.EnvOrDefault("DISCOUNT_OFFPEAK_WINDOW", ""); window != "" {
+		gate, err := eligibility.ParseTimeOfDay(window)
+		if err != nil {
+			return fmt.Errorf("DISCOUNT_OFFPEAK_WINDOW: %w", err)
+		}
+		engine.Gates = append(engine.Gates, gate)
+	}
+
+	firstBooking, err := common.EnvBool("DISCOUNT_FIRST_BOOKING", false)
+	if err != nil {
+		return err
+	}
+	if firstBooking {
+		engine.Rules = append(engine.Rules, eligibility.FirstBooking{Percent: percent})
+		historyNeeded = true
+	}
+
+	loyaltyBookings, err := common.EnvInt("DISCOUNT_LOYALTY_BOOKINGS", 0)
+	if err != nil {
+		return err
+	}
+	if loyaltyBookings < 0 {
+		return fmt.Errorf("DISCOUNT_LOYALTY_BOOKINGS must not be negative, got %d", loyaltyBookings)
+	}
+	if loyaltyBookings > 0 {
+		engine.Rules = append(engine.Rules, eligibility.Loyalty{MinBookings: loyaltyBookings, Percent: percent})
+		historyNeeded = true
+	}
+
+	if historyNeeded && !recordAllOrders {
+		return fmt.Errorf("DISCOUNT_FIRST_BOOKING and DISCOUNT_LOYALTY_BOOKINGS need ORDER_RECORD_ALL=true: " +
+			"without it the read-model only holds discounted orders, so bookings without a discount aren't counted")
+	}
+
+	for _, name := range strings.Split(common.EnvOrDefault("DISCOUNT_EXCLUDED_SERVICES", ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			excludedServices[name] = true
+		}
+	}
+
+	priceFloor = common.EnvOrDefault("DISCOUNT_PRICE_FLOOR", pricing.FloorClamp)
+	if err := pricing.ValidateFloorPolicy(priceFloor); err != nil {
+		return fmt.Errorf("DISCOUNT_PRICE_FLOOR: %w", err)
+	}
+
+	if url := common.EnvOrDefault("RULES_SERVICE_URL", ""); url != "" {
+		remote := &eligibility.Remote{URL: url}
+		if remote.Timeout, err = common.EnvDuration("RULES_SERVICE_TIMEOUT", 500*time.Millisecond); err != nil {
+			return err
+		}
+		if remote.CacheTTL, err = common.EnvDuration("RULES_CACHE_TTL", 30*time.Second); err != nil {
+			return err
+		}
+		remoteRules = remote
+	}
+
+	eligibilityEngine = engine
+	clinicLocation = loc
+	return nil
+}
+
+// applyEligibility replaces the client's eligibility claim with the server's own evaluation.
+// It fails with pricing.ErrNegativePrice when the discount exceeds the total under the reject floor policy.
+func applyEligibility(ctx context.Context, req *OrderRequest, orderID string) (eligibility.Decision, error) {
+	order := eligibility.Order{
+		UserID:    req.UserID,
+		Gender:    req.Gender,
+		DOB:       req.DOB,
+		BasePrice: req.BasePrice,
+		Now:       time.Now().In(clinicLocation),
+	}
+	if historyNeeded {
+		prior, err := countPriorBookings(ctx, req.UserID)
+		if err != nil {
+			// History-based rules don't match on unknown history
+			logger.Error("Failed to look up booking history", "order_id", orderID, "user_id", req.UserID, "error", err)
+		} else {
+			order.HistoryKnown = true
+			order.PriorBookings = prior
+		}
+	}
+
+	decision := evaluateRules(ctx, order, req.SelectedServices, orderID)
+
+	if decision.Eligible != req.IsR1Eligible {
+		logger.Warn("Client eligibility overridden", "order_id", orderID,
+			"client_eligible", req.IsR1Eligible, "server_eligible", decision.Eligible, "reasons", decision.Reasons)
+	}
+
+	if decision.Eligible {
+		recordEligibility(decision.Reasons)
+	}
+
+	req.IsR1Eligible = decision.Eligible
+	req.DiscountPercent = decision.Percent
+	finalPrice, clamped, err := pricing.FinalPrice(req.BasePrice, discountableAmount(req.SelectedServices), decision.Percent, priceFloor)
+	if err != nil {
+		logger.Warn("Negative final price rejected", "order_id", orderID, "base_price", req.BasePrice,
+			"discount", decision.Percent, "final_price", finalPrice)
+		return decision, err
+	}
+	if clamped {
+		logger.Warn("Negative final price clamped to zero", "order_id", orderID, "base_price", req.BasePrice,
+			"discount", decision.Percent)
+	}
+	req.FinalPrice = finalPrice
+	req.DiscountAmount = pricing.DiscountAmount(req.BasePrice, finalPrice)
+	return decision, nil
+}
+
+// evaluateRules asks the external rules service when one is configured and falls back to the
+// built-in rules when it fails or times out
+func evaluateRules(ctx context.Context, order eligibility.Order, services []Service, orderID string) eligibility.Decision {
+	if remoteRules == nil {
+		return eligibilityEngine.Evaluate(order)
+	}
+	names := make([]string, len(services))
+	for i, s := range services {
+		names[i] = s.Name
+	}
+	decision, err := remoteRules.Evaluate(ctx, order, names)
+	if err != nil {
+		logger.Warn("Rules service unavailable, using built-in rules", "order_id", orderID, "error", err)
+		return eligibilityEngine.Evaluate(order)
+	}
+	return decision
+}
+
+// discountableAmount sums the prices of selected services that aren't excluded from discounts
+func discountableAmount(services []Service) float64 {
+	total := 0.0
+	for _, s := range services {
+		if !excludedServices[s.Name] {
+			total += s.Price
+		}
+	}
+	return total
+}
+
+// handleExclusions publishes the discount-ineligible services so clients can preview prices accurately
+func handleExclusions(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(excludedServices))
+	for name := range excludedServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"excluded_services": names})
+}
+
+// countPriorBookings counts the user's confirmed orders in the read-model, which holds every booking
+// since loadEligibility requires ORDER_RECORD_ALL for the rules that ask
+func countPriorBookings(ctx context.Context, userID string) (int, error) {
+	q := client.Collection(ordersCollection).
+		Where("user_id", "==", userID).
+		Where("status", "==", projection.StatusConfirmed)
+	result, err := q.NewAggregationQuery().
+		WithCount("confirmed").
+		Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := result["confirmed"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", result["confirmed"])
+	}
+	return int(v.GetIntegerValue()), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHistoryRulesNeedRecordAll(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		recordAll bool
+		wantErr   bool
+	}{
+		{"first booking without ORDER_RECORD_ALL", map[string]string{"DISCOUNT_FIRST_BOOKING": "true"}, false, true},
+		{"loyalty without ORDER_RECORD_ALL", map[string]string{"DISCOUNT_LOYALTY_BOOKINGS": "3"}, false, true},
+		{"first booking with ORDER_RECORD_ALL", map[string]string{"DISCOUNT_FIRST_BOOKING": "true"}, true, false},
+		{"loyalty with ORDER_RECORD_ALL", map[string]string{"DISCOUNT_LOYALTY_BOOKINGS": "3"}, true, false},
+		{"no history rules", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			recordAllOrders, historyNeeded = tt.recordAll, false
+			defer func() { recordAllOrders, historyNeeded = false, false }()
+
+			if err := loadEligibility(); (err != nil) != tt.wantErr {
+				t.Errorf("loadEligibility() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscountPercentConfigured(t *testing.T) {
+	tests := []struct {
+		percent string
+		wantErr bool
+	}{
+		{"20", false},
+		{"100", false},
+		{"0", true},
+		{"-5", true},
+		{"100.5", true},
+		{"twelve", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.percent, func(t *testing.T) {
+			t.Setenv("DISCOUNT_PERCENT", tt.percent)
+			if err := loadEligibility(); (err != nil) != tt.wantErr {
+				t.Errorf("loadEligibility() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A high-value order is priced at the configured rate
+	t.Setenv("DISCOUNT_PERCENT", "20")
+	if err := loadEligibility(); err != nil {
+		t.Fatal(err)
+	}
+	req := OrderRequest{UserID: "u1", Gender: "Male", DOB: "1990-01-01", BasePrice: 2000,
+		SelectedServices: []Service{{Name: "Consultation", Price: 2000}}}
+	if _, err := applyEligibility(context.Background(), &req, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if req.DiscountPercent != 20 || req.FinalPrice != 1600 || req.DiscountAmount != 400 {
+		t.Errorf("priced at %g%%: final %.2f, discount %.2f; want 20%%: 1600.00, 400.00",
+			req.DiscountPercent, req.FinalPrice, req.DiscountAmount)
+	}
+}
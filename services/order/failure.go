@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Failure injection points for chaos testing
+const (
+	FailureNone                = "none"
+	FailurePreReservation      = "pre_reservation"      // fail before any quota is reserved
+	FailurePostReservation     = "post_reservation"     // fail after reservation and compensate
+	FailureCompensationFailure = "compensation_failure" // fail after reservation and the compensation publish fails too
+)
+
+var errSimulatedCompensationFailure = errors.New("simulated compensation publish failure")
+
+// resolveFailureMode validates failure_mode, mapping the legacy simulate_failure flag when it is absent:
+// eligible orders fail after reservation, non-eligible orders (which reserve nothing) fail up front.
+func resolveFailureMode(req OrderRequest) (string, error) {
+	switch req.FailureMode {
+	case "":
+		if !req.SimulateFailure {
+			return FailureNone, nil
+		}
+		if req.IsR1Eligible {
+			return FailurePostReservation, nil
+		}
+		return FailurePreReservation, nil
+	case FailureNone, FailurePreReservation, FailurePostReservation, FailureCompensationFailure:
+		return req.FailureMode, nil
+	}
+	return "", fmt.Errorf("unknown failure_mode %q", req.FailureMode)
+}
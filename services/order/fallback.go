@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// Policies for R1 orders while the discount service is down
+const (
+	FallbackWait       = "wait"        // publish and wait for the decision timeout (default)
+	FallbackFailClosed = "fail_closed" // reject immediately: discounts temporarily unavailable
+	FallbackFailOpen   = "fail_open"   // confirm at full price without a discount
+)
+
+var (
+	fallbackPolicy  = FallbackWait
+	discountHealthy atomic.Bool
+)
+
+// loadFallback reads DISCOUNT_FALLBACK and, for any policy other than wait, starts polling
+// DISCOUNT_HEALTH_URL every DISCOUNT_HEALTH_INTERVAL.
+func loadFallback(ctx context.Context) error {
+	fallbackPolicy = common.EnvOrDefault("DISCOUNT_FALLBACK", FallbackWait)
+	switch fallbackPolicy {
+	case FallbackWait:
+		return nil
+	case FallbackFailClosed, FallbackFailOpen:
+	default:
+		return fmt.Errorf("DISCOUNT_FALLBACK: unknown policy %q", fallbackPolicy)
+	}
+
+	interval, err := common.EnvDuration("DISCOUNT_HEALTH_INTERVAL", 5*time.Second)
+	if err != nil {
+		return err
+	}
+	url := common.EnvOrDefault("DISCOUNT_HEALTH_URL", "http://localhost:8082/healthz")
+
+	// Assume healthy until the first poll says otherwise
+	discountHealthy.Store(true)
+	go pollDiscountHealth(ctx, url, interval)
+	return nil
+}
+
+func pollDiscountHealth(ctx context.Context, url string, interval time.Duration) {
+	httpClient := &http.Client{Timeout: interval}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		healthy := false
+		resp, err := httpClient.Get(url)
+		if err == nil {
+			healthy = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+		if discountHealthy.Swap(healthy) != healthy {
+			logger.Warn("Discount service health changed", "healthy", healthy, "url", url, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// discountUnavailable reports whether R1 orders should take the fallback path instead of waiting
+func discountUnavailable() bool {
+	return fallbackPolicy != FallbackWait && !discountHealthy.Load()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+const (
+	// healthPingTimeout bounds the Firestore read behind /healthz
+	healthPingTimeout = 2 * time.Second
+
+	// healthPingPath is the document /healthz reads; it never exists, and not found is an answer
+	healthPingPath = CollectionEvents + "/healthz"
+)
+
+// listenerConnected reports whether the decision listener last received successfully
+var listenerConnected atomic.Bool
+
+type healthStatus struct {
+	FirestoreOK       bool   `json:"firestore_ok"`
+	ListenerConnected bool   `json:"listener_connected"`
+	Error             string `json:"error,omitempty"`
+}
+
+// handleHealthz answers 200 when Firestore answers a one-document read and the decision listener
+// is connected, 503 otherwise
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := healthStatus{ListenerConnected: listenerConnected.Load()}
+	if docStore == nil {
+		health.Error = "firestore client not initialized"
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), healthPingTimeout)
+		_, err := docStore.Get(ctx, healthPingPath)
+		cancel()
+		if err != nil && !common.IsNotFound(err) {
+			health.Error = err.Error()
+		} else {
+			health.FirestoreOK = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.FirestoreOK || !health.ListenerConnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+func TestHealthz(t *testing.T) {
+	defer listenerConnected.Store(false)
+	tests := []struct {
+		name      string
+		store     common.DocStore
+		connected bool
+		code      int
+		want      healthStatus
+	}{
+		{"healthy", common.NewMemStore(), true, http.StatusOK, healthStatus{FirestoreOK: true, ListenerConnected: true}},
+		{"listener disconnected", common.NewMemStore(), false, http.StatusServiceUnavailable, healthStatus{FirestoreOK: true}},
+		{"no firestore", nil, true, http.StatusServiceUnavailable,
+			healthStatus{ListenerConnected: true, Error: "firestore client not initialized"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docStore = tt.store
+			listenerConnected.Store(tt.connected)
+
+			rec := httptest.NewRecorder()
+			handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if rec.Code != tt.code {
+				t.Errorf("status = %d, want %d", rec.Code, tt.code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var got healthStatus
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("body = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
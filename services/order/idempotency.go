@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// CollectionIdempotencyKeys maps each Idempotency-Key to the order it started and the response sent
+// for it, keyed by the key's hash. expires_at is the field for the collection's TTL policy.
+const CollectionIdempotencyKeys = "idempotency_keys"
+
+// Idempotency key states
+const (
+	IdempotencyPending   = "pending"   // the first request is still running
+	IdempotencyCompleted = "completed" // Status, ContentType and Body hold its answer
+)
+
+// maxIdempotencyKeyLength bounds the header, which is client-supplied
+const maxIdempotencyKeyLength = 255
+
+// idempotencyTTL is how long a key keeps its response; 0 ignores the Idempotency-Key header
+var idempotencyTTL = 24 * time.Hour
+
+// idempotencyStale is when a pending key is presumed abandoned by a crashed replica and taken over;
+// well past the longest a saga's handler can run
+var idempotencyStale = 5 * time.Minute
+
+// loadIdempotency reads ORDER_IDEMPOTENCY_TTL (default 24h, 0 disables)
+func loadIdempotency() error {
+	ttl, err := common.EnvDuration("ORDER_IDEMPOTENCY_TTL", idempotencyTTL)
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return fmt.Errorf("ORDER_IDEMPOTENCY_TTL must not be negative, got %s", ttl)
+	}
+	idempotencyTTL = ttl
+	return nil
+}
+
+// IdempotencyRecord is a key's document
+type IdempotencyRecord struct {
+	RequestHash string    `firestore:"request_hash"` // SHA-256 of the body, so a reused key with a different order is refused
+	State       string    `firestore:"state"`
+	OrderID     string    `firestore:"order_id,omitempty"`
+	Status      int       `firestore:"status,omitempty"`
+	ContentType string    `firestore:"content_type,omitempty"`
+	Body        []byte    `firestore:"body,omitempty"`
+	CreatedAt   time.Time `firestore:"created_at"`
+	ExpiresAt   time.Time `firestore:"expires_at"`
+}
+
+// idempotentOrders answers a POST carrying an Idempotency-Key header it has seen before with the
+// first request's response instead of starting another saga. The key is bound once the handler
+// answers with an order; answers without one (invalid requests, failures before the order existed)
+// or asking the client to retry (Retry-After) release it, so the retry runs afresh.
+func idempotentOrders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if idempotencyTTL <= 0 || r.Method != http.MethodPost || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid Body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		bodySum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(bodySum[:])
+
+		keySum := sha256.Sum256([]byte(key))
+		path := CollectionIdempotencyKeys + "/" + hex.EncodeToString(keySum[:])
+		existing, claimed, err := claimIdempotencyKey(r.Context(), path, requestHash)
+		if err != nil {
+			logger.Error("Failed to claim idempotency key", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			switch {
+			case existing.RequestHash != requestHash:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case existing.State == IdempotencyPending:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+			default:
+				logger.Info("Replaying response for idempotency key", "order_id", existing.OrderID)
+				w.Header().Set("Content-Type", existing.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		result := newBufferedResponse()
+		next(result, r)
+		result.replay(w)
+
+		// Not tied to the request, which the client may already have abandoned
+		ctx := context.WithoutCancel(r.Context())
+		var resp OrderResponse
+		_ = json.Unmarshal(result.body.Bytes(), &resp)
+		if resp.OrderID == "" || result.header.Get("Retry-After") != "" {
+			if err := docStore.Delete(ctx, path); err != nil {
+				logger.Warn("Failed to release idempotency key", "error", err)
+			}
+			return
+		}
+		err = docStore.Update(ctx, path, []firestore.Update{
+			{Path: "state", Value: IdempotencyCompleted},
+			{Path: "order_id", Value: resp.OrderID},
+			{Path: "status", Value: result.status},
+			{Path: "content_type", Value: result.header.Get("Content-Type")},
+			{Path: "body", Value: result.body.Bytes()},
+		})
+		if err != nil {
+			logger.Error("Failed to record idempotent response", "order_id", resp.OrderID, "error", err)
+		}
+	}
+}
+
+// claimIdempotencyKey marks the key pending for this request. When another request holds it, it
+// returns that record instead, unless the record is a pending one old enough to be abandoned.
+func claimIdempotencyKey(ctx context.Context, path, requestHash string) (IdempotencyRecord, bool, error) {
+	var existing IdempotencyRecord
+	claimed := false
+	err := docStore.RunTransaction(ctx, func(ctx context.Context, tx common.DocTx) error {
+		existing, claimed = IdempotencyRecord{}, false
+		doc, err := tx.Get(path)
+		if err != nil && !common.IsNotFound(err) {
+			return err
+		}
+		now := time.Now()
+		if err == nil {
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			abandoned := existing.State == IdempotencyPending && now.Sub(existing.CreatedAt) > idempotencyStale
+			if now.Before(existing.ExpiresAt) && !abandoned {
+				return nil
+			}
+		}
+		claimed = true
+		return tx.Set(path, IdempotencyRecord{
+			RequestHash: requestHash,
+			State:       IdempotencyPending,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL),
+		})
+	})
+	return existing, claimed, err
+}
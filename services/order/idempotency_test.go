@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// countingOrders answers every order with a new order ID, counting the sagas it starts
+func countingOrders(calls *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(OrderResponse{OrderID: fmt.Sprintf("order-%d", *calls), Status: "CONFIRMED"})
+	}
+}
+
+func postWithKey(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestIdempotencyKeyReplaysFirstResponse(t *testing.T) {
+	docStore = common.NewMemStore()
+	calls := 0
+	handler := idempotentOrders(countingOrders(&calls))
+	body := `{"user_id":"u1","name":"Asha"}`
+
+	first := postWithKey(handler, "key-1", body)
+	second := postWithKey(handler, "key-1", body)
+
+	if calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if ct := second.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("replayed Content-Type = %q", ct)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("only the replay should carry Idempotent-Replayed")
+	}
+
+	// Another key is another order
+	if third := postWithKey(handler, "key-2", body); third.Body.String() == first.Body.String() || calls != 2 {
+		t.Errorf("a new key was answered from the old one's record (calls = %d)", calls)
+	}
+}
+
+func TestIdempotencyKeyRefusesDifferentBody(t *testing.T) {
+	docStore = common.NewMemStore()
+	calls := 0
+	handler := idempotentOrders(countingOrders(&calls))
+
+	postWithKey(handler, "key-1", `{"user_id":"u1","base_price":1000}`)
+	rec := postWithKey(handler, "key-1", `{"user_id":"u1","base_price":2000}`)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", rec.Code)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyKeyReleasedWithoutOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(w http.ResponseWriter)
+	}{
+		{"invalid request", func(w http.ResponseWriter) {
+			http.Error(w, "Invalid Body", http.StatusBadRequest)
+		}},
+		{"retry later", func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(OrderResponse{OrderID: "order-1", Status: "REJECTED"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docStore = common.NewMemStore()
+			calls := 0
+			handler := idempotentOrders(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				tt.answer(w)
+			})
+			postWithKey(handler, "key-1", `{}`)
+			postWithKey(handler, "key-1", `{}`)
+			if calls != 2 {
+				t.Errorf("the handler ran %d times, want 2: the retry must run afresh", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+)
+
+// inlineQuota decides R1 orders within the request when DISCOUNT_INLINE is on. nil keeps the
+// event-driven flow, where the discount service decides and the handler waits for its answer.
+var inlineQuota *quota.Quota
+
+// loadInlineQuota reads DISCOUNT_INLINE (default false) and, when it is on, the quota
+// configuration, including the drift and freeze settings, which must match the discount service's
+func loadInlineQuota(store common.DocStore) error {
+	inline, err := common.EnvBool("DISCOUNT_INLINE", false)
+	if err != nil || !inline {
+		return err
+	}
+	cfg, err := quota.LoadConfig(logger)
+	if err != nil {
+		return err
+	}
+	inlineQuota = &quota.Quota{Store: store, Config: cfg, Publisher: publisher, Logger: logger, Timezone: quotaTimezone}
+	return nil
+}
+
+// decideInline reserves an R1 order's discount within the request and answers it as the
+// event-driven flow would. The decision event is written by the quota transaction; OrderCreated
+// follows in the background for the audit trail and the read-model, and the discount service
+// skips it as already decided.
+func decideInline(w http.ResponseWriter, r *http.Request, req OrderRequest, orderID, traceID, failureMode string, reasons []string) {
+	event := newOrderCreated(r, req, orderID, traceID, reasons)
+	if err := checkOrderCreated(event); err != nil {
+		var invalid *events.ValidationError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	decision, err := decideOrder(r.Context(), event)
+	if err != nil {
+		logger.Error("Inline discount decision failed", "order_id", orderID, "trace_id", traceID, "error", err)
+		http.Error(w, "Discount decision failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	logger.Info("Discount Decided Inline", "order_id", orderID, "trace_id", traceID, "type", decision.EventType())
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), decisionTimeout)
+		defer cancel()
+		// publishOrderCreated logs its own failures; the decision stands either way
+		_ = publishOrderCreated(ctx, event)
+	}()
+
+	respondToDecision(w, r, req, orderID, traceID, failureMode, decision)
+}
+
+// decideOrder decides an R1 order as the discount service would: it waits out the midnight freeze
+// window when the defer action is configured, then runs the drift, freeze and quota checks, counting
+// the decision in the same metrics. The wait counts against decisionTimeout.
+func decideOrder(ctx context.Context, event events.OrderCreated) (events.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, decisionTimeout)
+	defer cancel()
+	inlineQuota.AwaitFreeze(ctx)
+	return inlineQuota.Decide(ctx, event, event.QuotaDate)
+}
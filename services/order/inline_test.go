@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/quota"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// inlineOrder is an R1 OrderCreated as handleOrder builds it for decideInline
+func inlineOrder(orderID string) events.OrderCreated {
+	req := OrderRequest{UserID: "u-" + orderID, Name: "Test User", Gender: "Female", DOB: "1990-01-01",
+		SelectedServices: []Service{{"Mammography", 1500}}, BasePrice: 1500, IsR1Eligible: true,
+		DiscountPercent: 12, FinalPrice: 1320, DiscountAmount: 180, LocationID: events.DefaultLocation}
+	return newOrderCreated(httptest.NewRequest(http.MethodPost, "/order", nil), req, orderID, "trace-"+orderID, nil)
+}
+
+// committedTransactions reads quota_transactions_total{result="committed"}
+func committedTransactions(t *testing.T) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "quota_transactions_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" && l.GetValue() == "committed" {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestInlineDecisionsApplyFreezeAndMetrics(t *testing.T) {
+	defer func(q *quota.Quota, timeout time.Duration) { inlineQuota, decisionTimeout = q, timeout }(inlineQuota, decisionTimeout)
+	quotaTimezone = time.UTC
+	inline := func(freeze quota.Freeze) *common.MemStore {
+		store := common.NewMemStore()
+		inlineQuota = &quota.Quota{Store: store, Config: quota.Config{Limit: 10, Shards: 1, Freeze: freeze},
+			Publisher: &common.Publisher{Logger: logger}, Logger: logger, Timezone: quotaTimezone}
+		return store
+	}
+	ctx := context.Background()
+
+	// Outside a freeze the order is reserved and counted as the discount service counts it
+	inline(quota.Freeze{})
+	before := committedTransactions(t)
+	decision, err := decideOrder(ctx, inlineOrder("o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decision.(events.DiscountReserved); !ok {
+		t.Errorf("decision = %T, want DiscountReserved", decision)
+	}
+	if got := committedTransactions(t); got != before+1 {
+		t.Errorf("quota_transactions_total{result=committed} = %v, want %v", got, before+1)
+	}
+
+	// A window wider than half a day covers every instant: the reject action rejects, with a marker
+	store := inline(quota.Freeze{Window: 13 * time.Hour, Action: quota.FreezeReject})
+	decision, err = decideOrder(ctx, inlineOrder("o2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decision.(events.DiscountRejected); !ok {
+		t.Errorf("decision during a freeze = %T, want DiscountRejected", decision)
+	}
+	if _, err := store.Get(ctx, quota.DecisionPath("o2")); err != nil {
+		t.Errorf("the freeze rejection has no decision marker: %v", err)
+	}
+
+	// The defer action waits, here past the decision timeout, and decides nothing
+	store = inline(quota.Freeze{Window: 13 * time.Hour, Action: quota.FreezeDefer})
+	decisionTimeout = 20 * time.Millisecond
+	if decision, err := decideOrder(ctx, inlineOrder("o3")); err == nil {
+		t.Errorf("decideOrder inside a deferred freeze = %T, want a timeout", decision)
+	}
+	if _, err := store.Get(ctx, quota.DecisionPath("o3")); !common.IsNotFound(err) {
+		t.Errorf("an order decided during a deferred freeze: %v", err)
+	}
+}
@@ -3,77 +3,345 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/devdolphintest/discount-system/pkg/common"
 	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/pii"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
+	"github.com/devdolphintest/discount-system/pkg/projection"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	"google.golang.org/api/iterator"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	ProjectID        = "devdolphins-93118"
 	CollectionEvents = "events"
+
+	// compensationAttempts bounds the retries of a DiscountRelease publish before alerting
+	compensationAttempts = 5
 )
 
 var (
-	logger      = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger      = common.NewLogger()
 	client      *firestore.Client
-	responseMap = make(map[string]chan interface{})
+	docStore    common.DocStore // client's documents, for the code that runs transactions
+	responseMap = make(map[string]*pendingOrder)
 	mapMutex    sync.RWMutex
+
+	ordersCollection string
+	adminToken       string
+	piiCipher        *pii.Cipher // nil when PII encryption is disabled
+	testMode         bool        // route simulate-failure orders to the test quota
+	publisher        *common.Publisher
+	quotaLocations   common.Locations // clinic locations accepted on orders
+	quotaTimezone    *time.Location   // its midnight starts each quota day (QUOTA_TIMEZONE)
+	reconnect        common.Reconnect // decision listener resubscription policy
+
+	// serverCtx is cancelled when the service shuts down, after in-flight requests have drained;
+	// background work derives from it
+	serverCtx context.Context
+	// shutdownSignal is cancelled on SIGINT/SIGTERM, when draining starts
+	shutdownSignal      context.Context
+	shutdownTimeout     = 15 * time.Second
+	compensationTimeout = 10 * time.Second
+	// decisionTimeout is how long an R1 order waits for its discount decision before answering 504
+	decisionTimeout = 10 * time.Second
+
+	// decisionBuffer is the capacity of each order's decision channel; extras beyond it are dropped
+	decisionBuffer = 1
 )
 
+// pendingOrder is an order waiting on its discount decision
+type pendingOrder struct {
+	ch        chan interface{}
+	traceID   string
+	startedAt time.Time
+}
+
 type Service struct {
 	Name  string  `json:"name"`
 	Price float64 `json:"price"`
 }
 
 type OrderRequest struct {
-	UserID           string    `json:"user_id"`
-	Name             string    `json:"name"`
-	Gender           string    `json:"gender"`
-	DOB              string    `json:"dob"`
-	SelectedServices []Service `json:"selected_services"`
-	BasePrice        float64   `json:"base_price"`
-	IsR1Eligible     bool      `json:"is_r1_eligible"`
-	DiscountPercent  float64   `json:"discount_percent"`
-	FinalPrice       float64   `json:"final_price"`
-	SimulateFailure  bool      `json:"simulate_failure"`
+	UserID           string          `json:"user_id"`
+	Name             string          `json:"name"`
+	Gender           string          `json:"gender"`
+	DOB              string          `json:"dob"`
+	SelectedServices []Service       `json:"selected_services"`
+	BasePrice        float64         `json:"base_price"`
+	IsR1Eligible     bool            `json:"is_r1_eligible"`
+	DiscountPercent  float64         `json:"discount_percent"`
+	FinalPrice       float64         `json:"final_price"`
+	DiscountAmount   float64         `json:"discount_amount"`  // computed by the server; any client value is overwritten
+	SimulateFailure  bool            `json:"simulate_failure"` // legacy; superseded by FailureMode
+	FailureMode      string          `json:"failure_mode"`
+	IsTest           bool            `json:"is_test"`
+	LocationID       string          `json:"location_id"` // clinic location; empty means the global quota
+	Callback         *CallbackConfig `json:"callback,omitempty"`
+	// Reasons are the matched eligibility rules, echoed in the response for ?explain=true
+	Reasons []string `json:"-"`
 }
 
 type OrderResponse struct {
 	OrderID string `json:"order_id"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+	// Authoritative amounts for CONFIRMED orders; clients display these rather than their own estimate
+	FinalPrice      float64 `json:"final_price"`
+	DiscountPercent float64 `json:"discount_percent"`
+	DiscountAmount  float64 `json:"discount_amount"`
+	// ConfirmDeadline is when a RESERVED order's discount is released unless confirmed
+	ConfirmDeadline time.Time `json:"confirm_deadline,omitzero"`
+	// Reasons explain the discount on CONFIRMED and RESERVED orders; only sent for ?explain=true
+	Reasons []string `json:"reasons,omitempty"`
 }
 
 func main() {
 	_ = godotenv.Load()
 
-	ctx := context.Background()
+	ordersCollection = common.EnvOrDefault("ORDERS_COLLECTION", projection.DefaultCollection)
+	adminToken = common.EnvOrDefault("ADMIN_TOKEN", "")
+
 	var err error
+	if keys := common.EnvOrDefault("PII_KEYS", ""); keys != "" {
+		if piiCipher, err = pii.NewCipher(keys); err != nil {
+			logger.Error("Invalid PII_KEYS", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if testMode, err = common.EnvBool("TEST_MODE", false); err != nil {
+		logger.Error("Invalid TEST_MODE", "error", err)
+		os.Exit(1)
+	}
+
+	if trustedProxies, err = common.ParseTrustedProxies(common.EnvOrDefault("TRUSTED_PROXIES", "")); err != nil {
+		logger.Error("Invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
+
+	if quotaLocations, err = common.ParseLocations(common.EnvList("QUOTA_LOCATIONS", []string{events.DefaultLocation})); err != nil {
+		logger.Error("Invalid QUOTA_LOCATIONS", "error", err)
+		os.Exit(1)
+	}
+
+	if quotaTimezone, err = common.LoadQuotaTimezone(); err != nil {
+		logger.Error("Invalid QUOTA_TIMEZONE", "error", err)
+		os.Exit(1)
+	}
+
+	if dedupWindow, err = common.EnvDuration("ORDER_DEDUP_WINDOW", dedupWindow); err != nil {
+		logger.Error("Invalid ORDER_DEDUP_WINDOW", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadIdempotency(); err != nil {
+		logger.Error("Invalid ORDER_IDEMPOTENCY_TTL", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadPagination(); err != nil {
+		logger.Error("Invalid pagination configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadValidation(); err != nil {
+		logger.Error("Invalid validation configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if recordAllOrders, err = common.EnvBool("ORDER_RECORD_ALL", false); err != nil {
+		logger.Error("Invalid ORDER_RECORD_ALL", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadEligibility(); err != nil {
+		logger.Error("Invalid eligibility configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if compensationTimeout, err = common.EnvDuration("COMPENSATION_TIMEOUT", compensationTimeout); err != nil || compensationTimeout <= 0 {
+		logger.Error("Invalid COMPENSATION_TIMEOUT", "error", err, "timeout", compensationTimeout)
+		os.Exit(1)
+	}
+
+	if err := loadCallbacks(); err != nil {
+		logger.Error("Invalid callback configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadCompensationConcurrency(); err != nil {
+		logger.Error("Invalid compensation limit", "error", err)
+		os.Exit(1)
+	}
+
+	if maxEmbeddedServices, err = common.EnvInt("ORDER_MAX_EMBEDDED_SERVICES", maxEmbeddedServices); err != nil {
+		logger.Error("Invalid ORDER_MAX_EMBEDDED_SERVICES", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadDecisionTypes(); err != nil {
+		logger.Error("Invalid decision types", "error", err)
+		os.Exit(1)
+	}
+	loadInstanceID()
+
+	if decisionLookupTimeout, err = common.EnvDuration("ORDER_DECISION_LOOKUP_TIMEOUT", decisionLookupTimeout); err != nil {
+		logger.Error("Invalid ORDER_DECISION_LOOKUP_TIMEOUT", "error", err)
+		os.Exit(1)
+	}
+
+	if reconnect, err = common.LoadReconnect(); err != nil {
+		logger.Error("Invalid listener reconnect configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if quotaHeaders, err = common.EnvBool("ORDER_QUOTA_HEADERS", false); err != nil {
+		logger.Error("Invalid ORDER_QUOTA_HEADERS", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadStatusCodes(); err != nil {
+		logger.Error("Invalid status code mapping", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadConfirmationMode(); err != nil {
+		logger.Error("Invalid confirmation mode", "error", err)
+		os.Exit(1)
+	}
+
+	if pendingMax, err = common.EnvInt("ORDER_PENDING_MAX", pendingMax); err != nil || pendingMax < 1 {
+		logger.Error("Invalid ORDER_PENDING_MAX", "error", err, "max", pendingMax)
+		os.Exit(1)
+	}
+
+	if decisionTimeout, err = common.EnvDuration("ORDER_DECISION_TIMEOUT", decisionTimeout); err != nil || decisionTimeout <= 0 {
+		logger.Error("Invalid ORDER_DECISION_TIMEOUT", "error", err, "timeout", decisionTimeout)
+		os.Exit(1)
+	}
+
+	if pendingTTL, err = common.EnvDuration("ORDER_PENDING_TTL", pendingTTL); err != nil || pendingTTL <= decisionTimeout {
+		logger.Error("ORDER_PENDING_TTL must exceed the decision timeout", "error", err, "ttl", pendingTTL,
+			"decision_timeout", decisionTimeout)
+		os.Exit(1)
+	}
+
+	if decisionBuffer, err = common.EnvInt("ORDER_DECISION_BUFFER", decisionBuffer); err != nil || decisionBuffer < 1 {
+		logger.Error("Invalid ORDER_DECISION_BUFFER", "error", err, "buffer", decisionBuffer)
+		os.Exit(1)
+	}
+
+	if shutdownTimeout, err = common.EnvDuration("ORDER_SHUTDOWN_TIMEOUT", shutdownTimeout); err != nil || shutdownTimeout <= 0 {
+		logger.Error("Invalid ORDER_SHUTDOWN_TIMEOUT", "error", err, "timeout", shutdownTimeout)
+		os.Exit(1)
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownSignal = signalCtx
+
+	// Background work, the decision listener included, keeps running while requests drain
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverCtx = ctx
+
+	shutdownTracing, err := common.InitTracing(ctx, "order-service")
+	if err != nil {
+		logger.Error("Invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	client, err = common.NewFirestoreClient(ctx, ProjectID)
 	if err != nil {
 		logger.Error("Failed to create client", "error", err)
 		os.Exit(1)
 	}
 	defer client.Close()
+	docStore = common.FirestoreStore{Client: client}
+
+	ackModes, err := common.ParseAckModes(common.EnvOrDefault("EVENT_ACK_MODES", ""))
+	if err != nil {
+		logger.Error("Invalid EVENT_ACK_MODES", "error", err)
+		os.Exit(1)
+	}
+	mirrorCfg, err := common.LoadMirrorConfig()
+	if err != nil {
+		logger.Error("Invalid event mirror configuration", "error", err)
+		os.Exit(1)
+	}
+	retention, err := common.LoadEventRetention()
+	if err != nil {
+		logger.Error("Invalid EVENT_RETENTION", "error", err)
+		os.Exit(1)
+	}
+	publisher = &common.Publisher{Collection: client.Collection(CollectionEvents), Modes: ackModes, Logger: logger,
+		Mirror: mirrorCfg.Mirror, Retention: retention}
+
+	if err := loadInlineQuota(docStore); err != nil {
+		logger.Error("Invalid inline discount configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadFallback(ctx); err != nil {
+		logger.Error("Invalid fallback configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if shedCfg, err = loadShedConfig(); err != nil {
+		logger.Error("Invalid load shedding configuration", "error", err)
+		os.Exit(1)
+	}
+	go pollDiscountLag(ctx, shedCfg)
 
 	// Start Background Listener
 	go listenForDecisions(ctx)
+	go sweepPendingLoop(ctx)
+
+	http.HandleFunc("/order", idempotentOrders(dedupOrders(handleOrder)))
+	http.HandleFunc("GET /order/{id}", handleOrderStatus)
+	http.HandleFunc("POST /order/{id}/confirm", handleConfirm)
+	http.HandleFunc("GET /orders", handleUserOrders)
+	http.HandleFunc("GET /admin/pending", requireAdmin(handlePending))
+	http.HandleFunc("GET /events/stream", handleEventStream)
+	http.HandleFunc("GET /discount/exclusions", handleExclusions)
+	http.HandleFunc("GET /status", handleStatus)
+	http.HandleFunc("GET /healthz", handleHealthz)
+	http.Handle("GET /metrics", promhttp.Handler())
+	server := &http.Server{Addr: ":8081", Handler: accessLog(recoverPanics(http.DefaultServeMux))}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+	logger.Info("Order Service listening on :8081", "instance_id", instanceID)
 
-	http.HandleFunc("/order", handleOrder)
-	logger.Info("Order Service listening on :8081")
-	if err := http.ListenAndServe(":8081", nil); err != nil {
+	select {
+	case err := <-serveErr:
 		logger.Error("Server failed", "error", err)
+		return
+	case <-signalCtx.Done():
+	}
+
+	logger.Info("Shutting down, draining in-flight requests", "timeout", shutdownTimeout.String())
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		logger.Error("Requests still in flight at shutdown", "error", err)
 	}
+	cancel()
+	logger.Info("Order Service stopped")
 }
 
 func handleOrder(w http.ResponseWriter, r *http.Request) {
@@ -82,135 +350,351 @@ func handleOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
+	ow := &orderWriter{ResponseWriter: w}
+	w = ow
+	defer func() { orderDuration.WithLabelValues(ow.durationLabel()).Observe(time.Since(start).Seconds()) }()
+
 	var req OrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
 
+	if err := validateDOB(req.DOB, time.Now().In(clinicLocation)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	location, err := quotaLocations.Resolve(req.LocationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.LocationID = location
+
+	if req.Callback != nil {
+		if err := validateCallback(req.Callback); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	orderID := uuid.New().String()
 	traceID := uuid.New().String()
+	noteTrace(r, orderID, traceID)
+	ow.cb, ow.traceID = req.Callback, traceID
 
-	logger.Info("Order Received", "order_id", orderID, "trace_id", traceID, "user", req.Name,
-		"base_price", req.BasePrice, "r1_eligible", req.IsR1Eligible, "final_price", req.FinalPrice)
+	// The saga's root span; events published below carry it as their ParentSpanID
+	ctx, span := common.StartTraceSpan(r.Context(), traceID, "HandleOrder")
+	defer span.End()
+	span.SetAttributes(attribute.String("order_id", orderID))
+	r = r.WithContext(ctx)
 
-	// If R1 not eligible, complete order immediately without quota check
-	if !req.IsR1Eligible {
-		if req.SimulateFailure {
-			logger.Warn("Simulating Payment Failure (Non-Discount Order)", "order_id", orderID, "trace_id", traceID)
-			w.WriteHeader(http.StatusInternalServerError)
+	if err := priceFromCatalog(&req, orderID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := applyEligibility(r.Context(), &req, orderID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if explain, _ := strconv.ParseBool(r.URL.Query().Get("explain")); explain {
+		req.Reasons = decision.Reasons
+	}
+
+	failureMode, err := resolveFailureMode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if testMode && failureMode != FailureNone {
+		req.IsTest = true
+	}
+	// A decision replaces these with the quota it read
+	setCachedQuotaHeaders(w, req)
+
+	logger.Info("Order Received", "order_id", orderID, "trace_id", traceID, "user", req.Name, "is_test", req.IsTest,
+		"base_price", req.BasePrice, "r1_eligible", req.IsR1Eligible, "reasons", decision.Reasons,
+		"final_price", req.FinalPrice, "failure_mode", failureMode)
+
+	// Inline decisions don't depend on the discount service, so neither its health nor its lag applies
+	if req.IsR1Eligible && inlineQuota == nil && discountUnavailable() {
+		if fallbackPolicy == FallbackFailClosed {
+			logger.Warn("Discount Service Unavailable - Rejecting R1 Order", "order_id", orderID, "trace_id", traceID)
+			w.WriteHeader(http.StatusServiceUnavailable)
 			json.NewEncoder(w).Encode(OrderResponse{
 				OrderID: orderID,
-				Status:  "FAILED",
-				Message: "Payment processing failed (simulated).",
+				Status:  "REJECTED",
+				Message: "Discounts are temporarily unavailable. Please try again shortly.",
 			})
 			return
 		}
 
-		logger.Info("Order Completed Without Discount", "order_id", orderID, "trace_id", traceID)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(OrderResponse{
+		// Fail open: book at full price through the non-discount path
+		logger.Warn("Discount Service Unavailable - Confirming Without Discount", "order_id", orderID, "trace_id", traceID)
+		req.IsR1Eligible = false
+		req.DiscountPercent = 0
+		req.FinalPrice = req.BasePrice
+		req.DiscountAmount = 0
+		req.Reasons = nil
+	}
+
+	// Shed R1 orders the discount service is too far behind to decide in time
+	if req.IsR1Eligible && inlineQuota == nil && shedding.Load() {
+		logger.Warn("Discount Service Lagging - Shedding R1 Order", "order_id", orderID, "trace_id", traceID,
+			"lag", time.Duration(discountLag.Load()).String())
+		writeBusy(w, orderID)
+		return
+	}
+
+	// Orders that reserve nothing can only fail up front
+	if failureMode == FailurePreReservation || (!req.IsR1Eligible && failureMode != FailureNone) {
+		logger.Warn("Simulating Payment Failure Before Reservation", "order_id", orderID, "trace_id", traceID)
+		writeOutcome(w, OutcomeFailed, OrderResponse{
 			OrderID: orderID,
-			Status:  "CONFIRMED",
-			Message: fmt.Sprintf("Booking confirmed! Total: ₹%.2f (No discount applied)", req.FinalPrice),
+			Status:  "FAILED",
+			Message: "Payment processing failed (simulated).",
 		})
 		return
 	}
 
-	// Setup Response Channel for R1-eligible requests
-	respChan := make(chan interface{}, 1)
-	mapMutex.Lock()
-	responseMap[orderID] = respChan
-	mapMutex.Unlock()
-
-	defer func() {
-		mapMutex.Lock()
-		delete(responseMap, orderID)
-		mapMutex.Unlock()
-	}()
-
-	// Publish OrderCreated event for discount quota check
-	event := events.OrderCreated{
-		BaseEvent: events.BaseEvent{
-			TraceID:   traceID,
-			Type:      events.EventTypeOrderCreated,
-			Timestamp: time.Now(),
-		},
-		OrderID:          orderID,
-		UserID:           req.UserID,
-		Name:             req.Name,
-		Gender:           req.Gender,
-		DOB:              req.DOB,
-		SelectedServices: convertToEventServices(req.SelectedServices),
-		BasePrice:        req.BasePrice,
-		IsR1Eligible:     req.IsR1Eligible,
-		DiscountPercent:  req.DiscountPercent,
-		FinalPrice:       req.FinalPrice,
-	}
-
-	_, _, err := client.Collection(CollectionEvents).Add(r.Context(), event)
+	// If R1 not eligible, complete order immediately without quota check
+	if !req.IsR1Eligible {
+		if recordAllOrders {
+			recordOrderWithoutDiscount(r, req, orderID, traceID, decision.Reasons)
+		}
+		logger.Info("Order Completed Without Discount", "order_id", orderID, "trace_id", traceID)
+		publishOrderSettled(r, traceID, settlementFor(req, orderID))
+		writeOutcome(w, OutcomeConfirmed, OrderResponse{
+			OrderID:    orderID,
+			Status:     "CONFIRMED",
+			Message:    fmt.Sprintf("Booking confirmed! Total: ₹%.2f (No discount applied)", req.FinalPrice),
+			FinalPrice: req.FinalPrice,
+		})
+		return
+	}
+
+	if inlineQuota != nil {
+		decideInline(w, r, req, orderID, traceID, failureMode, decision.Reasons)
+		return
+	}
+
+	// Setup Response Channel for R1-eligible requests, then publish OrderCreated for the quota check
+	respChan, err := publishAwaitingDecision(orderID, traceID, func() error {
+		return publishOrderCreated(r.Context(), newOrderCreated(r, req, orderID, traceID, decision.Reasons))
+	})
+	defer unregisterPending(orderID)
 	if err != nil {
-		logger.Error("Failed to publish event", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		var invalid *events.ValidationError
+		switch {
+		case errors.Is(err, errTooManyPending):
+			logger.Error("Too many orders awaiting a decision", "order_id", orderID, "trace_id", traceID, "limit", pendingMax)
+			http.Error(w, "Too many orders in progress, please retry", http.StatusServiceUnavailable)
+		case errors.As(err, &invalid):
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
 		return
 	}
 
 	logger.Info("Order Event Published - Checking R2 Quota", "order_id", orderID, "trace_id", traceID)
 
 	// Wait for response
+	decisionRaw, ok := awaitDecision(w, r, respChan, orderID, traceID)
+	if !ok {
+		return
+	}
+
+	// Process Decision
+	respondToDecision(w, r, req, orderID, traceID, failureMode, decisionRaw)
+}
+
+// awaitDecision waits up to decisionTimeout for an order's decision on respChan. When none arrives
+// it answers the order as timed out and reports false.
+func awaitDecision(w http.ResponseWriter, r *http.Request, respChan chan interface{}, orderID, traceID string) (interface{}, bool) {
+	waitStart := time.Now()
 	select {
 	case decisionRaw := <-respChan:
-		// Process Decision
-		switch d := decisionRaw.(type) {
-		case events.DiscountReserved:
-			logger.Info("Discount Reserved", "order_id", orderID, "trace_id", traceID)
-
-			if req.SimulateFailure {
-				// Chaos Test: Simulate post-reservation failure
-				logger.Warn("Simulating Failure after Reservation", "order_id", orderID, "trace_id", traceID)
-
-				// Publish Compensation
-				compEvent := events.DiscountRelease{
-					BaseEvent: events.BaseEvent{
-						TraceID:   traceID,
-						Type:      events.EventTypeDiscountRelease,
-						Timestamp: time.Now(),
-					},
-					OrderID: orderID,
-					Reason:  "Payment Processing Failed (Simulated Failure)",
-				}
-				client.Collection(CollectionEvents).Add(context.Background(), compEvent)
+		return decisionRaw, true
+	case <-time.After(decisionTimeout):
+	}
+
+	// The listener may have missed the decision during a reconnect; look for it once before giving up
+	decisionRaw := lookupDecision(r.Context(), orderID)
+	if decisionRaw == nil {
+		logger.Error("Timeout waiting for discount decision", "order_id", orderID, "trace_id", traceID,
+			"waited", time.Since(waitStart).String(), "timeout", decisionTimeout.String())
+		writeOutcome(w, OutcomeTimeout, OrderResponse{
+			OrderID: orderID,
+			Status:  "PENDING",
+			Message: "Timeout waiting for discount service. The booking may still complete; check GET /order/" + orderID,
+		})
+		return nil, false
+	}
+	logger.Warn("Decision found by lookup after timeout", "order_id", orderID, "trace_id", traceID)
+	return decisionRaw, true
+}
+
+// respondToDecision completes an R1 order once its discount decision is known
+func respondToDecision(w http.ResponseWriter, r *http.Request, req OrderRequest, orderID, traceID, failureMode string, decisionRaw interface{}) {
+	setDecisionQuotaHeaders(w, req, decisionRaw)
+
+	switch d := decisionRaw.(type) {
+	case events.DiscountReserved:
+		logger.Info("Discount Reserved", "order_id", orderID, "trace_id", traceID)
+		applyReservedPrice(&req, d, orderID)
+
+		if failureMode == FailurePostReservation || failureMode == FailureCompensationFailure {
+			// Chaos Test: Simulate post-reservation failure
+			logger.Warn("Simulating Failure after Reservation", "order_id", orderID, "trace_id", traceID)
 
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(OrderResponse{
+			// Record the failed payment, then publish compensation
+			failEvent := events.NewPaymentFailed(traceID, common.ParentSpanID(r.Context()), orderID, req.FinalPrice, "Simulated Failure")
+			if err := publisher.Publish(r.Context(), failEvent); err != nil {
+				logger.Error("Failed to publish payment failure", "order_id", orderID, "trace_id", traceID, "error", err)
+			}
+
+			compEvent := events.NewDiscountRelease(traceID, common.ParentSpanID(r.Context()), orderID, "Payment Processing Failed (Simulated Failure)")
+			compEvent.UserID = req.UserID
+			compEvent.IsTest = req.IsTest
+			compEvent.LocationID = req.LocationID
+			// Not tied to the request, which the client may abandon, but bounded and cancelled on shutdown
+			compCtx, cancel := context.WithTimeout(serverCtx, compensationTimeout)
+			err := errSimulatedCompensationFailure
+			if failureMode != FailureCompensationFailure {
+				recordRelease(compCtx, orderID)
+				err = publishCompensation(compCtx, compEvent)
+			}
+			cancel()
+			if err != nil {
+				// The slot stays reserved until an operator or the hold sweeper returns it
+				logger.Log(r.Context(), common.LevelCritical, "Compensation Publish Failed - Quota Leaked",
+					"order_id", orderID, "trace_id", traceID, "attempts", compensationAttempts, "error", err)
+				writeOutcome(w, OutcomeFailed, OrderResponse{
 					OrderID: orderID,
-					Status:  "FAILED",
-					Message: "Payment processing failed. Discount quota has been released.",
+					Status:  "FAILED_UNCOMPENSATED",
+					Message: "Payment processing failed. The discount quota could not be released yet and will be reconciled.",
 				})
 				return
 			}
 
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(OrderResponse{
+			writeOutcome(w, OutcomeFailed, OrderResponse{
 				OrderID: orderID,
-				Status:  "CONFIRMED",
-				Message: fmt.Sprintf("Booking confirmed! Final price: ₹%.2f (12%% discount applied)", req.FinalPrice),
+				Status:  "FAILED",
+				Message: "Payment processing failed. Discount quota has been released.",
 			})
+			return
+		}
 
-		case events.DiscountRejected:
-			logger.Info("Discount Rejected", "order_id", orderID, "trace_id", traceID, "reason", d.Reason)
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(OrderResponse{
-				OrderID: orderID,
-				Status:  "REJECTED",
-				Message: d.Reason,
-			})
+		switch confirmationMode {
+		case ConfirmClient:
+			logger.Info("Reservation awaiting client confirmation", "order_id", orderID, "trace_id", traceID, "deadline", d.ConfirmDeadline)
+			respondReserved(w, req, orderID, d, fmt.Sprintf("Discount reserved. Confirm with POST /order/%s/confirm to complete the booking.", orderID))
+			return
+		case ConfirmAwait:
+			// Only report the booking once the discount service will see the confirmation
+			err := publisher.PublishWithRetry(r.Context(), newConfirmEvent(r, orderID, traceID), compensationAttempts, 200*time.Millisecond)
+			if err != nil {
+				logger.Error("Failed to publish discount confirmation", "order_id", orderID, "trace_id", traceID, "error", err)
+				respondReserved(w, req, orderID, d, fmt.Sprintf("Discount reserved but not yet confirmed. Retry with POST /order/%s/confirm.", orderID))
+				return
+			}
+		default:
+			// Commit the reservation so the discount service doesn't expire its hold
+			if err := publisher.Publish(r.Context(), newConfirmEvent(r, orderID, traceID)); err != nil {
+				logger.Error("Failed to publish discount confirmation", "order_id", orderID, "trace_id", traceID, "error", err)
+			}
 		}
 
-	case <-time.After(10 * time.Second):
-		logger.Error("Timeout waiting for discount decision", "order_id", orderID, "trace_id", traceID)
-		http.Error(w, "Timeout waiting for discount service", http.StatusGatewayTimeout)
+		publishPaymentCompleted(r, orderID, traceID, req.FinalPrice)
+		publishOrderSettled(r, traceID, settlementFor(req, orderID))
+		resp := OrderResponse{
+			OrderID:         orderID,
+			Status:          "CONFIRMED",
+			Message:         fmt.Sprintf("Booking confirmed! Final price: ₹%.2f (%g%% discount applied)", req.FinalPrice, req.DiscountPercent),
+			FinalPrice:      req.FinalPrice,
+			DiscountPercent: req.DiscountPercent,
+			DiscountAmount:  req.DiscountAmount,
+			Reasons:         req.Reasons,
+		}
+		recordConfirmation(r.Context(), resp)
+		writeOutcome(w, OutcomeConfirmed, resp)
+
+	case events.DiscountRejected:
+		logger.Info("Discount Rejected", "order_id", orderID, "trace_id", traceID, "reason", d.Reason)
+		writeOutcome(w, OutcomeRejected, OrderResponse{
+			OrderID: orderID,
+			Status:  "REJECTED",
+			Message: d.Reason,
+		})
+
+	default:
+		logger.Error("Unhandled decision type", "order_id", orderID, "trace_id", traceID, "decision", fmt.Sprintf("%T", d))
+		http.Error(w, "Unexpected discount decision", http.StatusBadGateway)
+	}
+}
+
+func newOrderCreated(r *http.Request, req OrderRequest, orderID, traceID string, reasons []string) events.OrderCreated {
+	event := events.NewOrderCreated(traceID, common.ParentSpanID(r.Context()), orderID)
+	event.UserID = req.UserID
+	event.Name = req.Name
+	event.Gender = req.Gender
+	event.DOB = req.DOB
+	event.SelectedServices = convertToEventServices(req.SelectedServices)
+	event.BasePrice = req.BasePrice
+	event.IsR1Eligible = req.IsR1Eligible
+	event.Reasons = reasons
+	event.DiscountPercent = req.DiscountPercent
+	event.FinalPrice = req.FinalPrice
+	event.DiscountAmount = req.DiscountAmount
+	event.IsTest = req.IsTest
+	event.DiscountableAmount = discountableAmount(req.SelectedServices)
+	event.LocationID = req.LocationID
+	event.QuotaDate = common.QuotaDate(time.Now(), quotaTimezone)
+	event.InstanceID = instanceID
+	return event
+}
+
+// checkOrderCreated validates an OrderCreated and its amounts, logging any failure
+func checkOrderCreated(event events.OrderCreated) error {
+	if err := event.Validate(); err != nil {
+		logger.Warn("Invalid order event", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
+	}
+	if err := pricing.CheckDiscountAmount(event.BasePrice, event.FinalPrice, event.DiscountAmount); err != nil {
+		logger.Error("Inconsistent order amounts", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
 	}
+	return nil
+}
+
+// publishOrderCreated checks, externalizes, encrypts and publishes an OrderCreated, logging any failure
+func publishOrderCreated(ctx context.Context, event events.OrderCreated) error {
+	if err := checkOrderCreated(event); err != nil {
+		return err
+	}
+
+	if err := externalizeServices(ctx, &event); err != nil {
+		logger.Error("Failed to store order details", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+		return err
+	}
+
+	if piiCipher != nil {
+		if err := piiCipher.EncryptOrder(&event); err != nil {
+			logger.Error("Failed to encrypt event PII", "order_id", event.OrderID, "trace_id", event.TraceID, "error", err)
+			return err
+		}
+	}
+
+	if err := publisher.Publish(ctx, event); err != nil {
+		logger.Error("Failed to publish event", "error", err)
+		return err
+	}
+	return nil
 }
 
 func convertToEventServices(services []Service) []events.Service {
@@ -221,47 +705,66 @@ func convertToEventServices(services []Service) []events.Service {
 	return result
 }
 
-func listenForDecisions(ctx context.Context) {
-	iter := client.Collection(CollectionEvents).
-		Where("type", "in", []string{events.EventTypeDiscountReserved, events.EventTypeDiscountRejected}).
-		OrderBy("timestamp", firestore.Asc).
-		Snapshots(ctx)
-	defer iter.Stop()
-
-	for {
-		snap, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
+// deliverDecision hands a decision to the waiting handler without ever blocking the listener.
+// Duplicate decisions beyond the channel's buffer, or ones for a handler that stopped reading, are dropped.
+func deliverDecision(pending *pendingOrder, orderID, eventType string, decision interface{}) {
+	select {
+	case pending.ch <- decision:
+	default:
+		logger.Warn("Dropped extra decision for order", "order_id", orderID, "trace_id", pending.traceID, "type", eventType)
+	}
+}
+
+// deadLetter keeps an event that failed to parse with parseErr in the dead_letter collection for
+// inspection and replay, logging if that fails too
+func deadLetter(ctx context.Context, doc *common.Doc, parseErr error) {
+	if err := common.DeadLetterEvent(ctx, docStore, "order", doc, parseErr); err != nil {
+		logger.Error("Failed to dead-letter event", "id", doc.ID, "error", err)
+	}
+}
+
+// routeDecision hands a decision event to the handler waiting on its order, if this replica has
+// one. It never blocks, so one stuck order can't hold up the others' decisions.
+func routeDecision(ctx context.Context, doc *common.Doc) {
+	data := doc.Data()
+	eventType, typeOK := common.GetString(data, "type")
+	orderID, idOK := common.GetString(data, "order_id")
+	if !typeOK || !idOK {
+		logger.Warn("Skipping malformed decision", "id", doc.ID)
+		return
+	}
+	// The listener only subscribes to this replica's decisions; anything else isn't ours to route
+	if owner, _ := common.GetString(data, "instance_id"); owner != instanceID {
+		return
+	}
+
+	if pending, exists := lookupPending(orderID); exists {
+		// Route to handler
+		decision, err := decisionDecoders[events.EventType(eventType)](doc)
 		if err != nil {
-			logger.Error("Listener error", "error", err)
-			time.Sleep(1 * time.Second)
-			continue
+			logger.Error("Failed to parse decision", "id", doc.ID, "type", eventType, "error", err)
+			deadLetter(ctx, doc, err)
+			return
 		}
+		deliverDecision(pending, orderID, eventType, decision)
+	}
+}
 
+func listenForDecisions(ctx context.Context) {
+	q := client.Collection(CollectionEvents).
+		Where("instance_id", "==", instanceID).
+		Where("type", "in", decisionTypes).
+		OrderBy("timestamp", firestore.Asc)
+	onError := func(err error) {
+		listenerConnected.Store(false)
+		logger.Error("Listener error", "error", err)
+	}
+	reconnect.Listen(ctx, q, logger, onError, func(snap *firestore.QuerySnapshot) {
+		listenerConnected.Store(true)
 		for _, change := range snap.Changes {
 			if change.Kind == firestore.DocumentAdded {
-				data := change.Doc.Data()
-				eventType := data["type"].(string)
-				orderID := data["order_id"].(string)
-
-				mapMutex.RLock()
-				ch, exists := responseMap[orderID]
-				mapMutex.RUnlock()
-
-				if exists {
-					// Route to handler
-					if eventType == events.EventTypeDiscountReserved {
-						var e events.DiscountReserved
-						change.Doc.DataTo(&e)
-						ch <- e
-					} else {
-						var e events.DiscountRejected
-						change.Doc.DataTo(&e)
-						ch <- e
-					}
-				}
+				routeDecision(ctx, common.SnapshotDoc(change.Doc))
 			}
 		}
-	}
+	})
 }
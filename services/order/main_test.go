@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// eventDoc stores event as the events document id and reads it back, as the listener would see it
+func eventDoc(t *testing.T, store *common.MemStore, id string, event events.Event) *common.Doc {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.Set(ctx, CollectionEvents+"/"+id, event); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := store.Get(ctx, CollectionEvents+"/"+id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDuplicateDecisionDoesNotBlockListener(t *testing.T) {
+	store := common.NewMemStore()
+	docStore = store
+	responseMap = make(map[string]*pendingOrder)
+	decisionBuffer = 1
+
+	var channels []chan interface{}
+	for _, id := range []string{"o1", "o2"} {
+		ch, err := publishAwaitingDecision(id, "trace-"+id, func() error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unregisterPending(id)
+		channels = append(channels, ch)
+	}
+
+	// o1 gets its decision twice and nobody reads o1's channel; o2's decision follows
+	docs := []*common.Doc{
+		eventDoc(t, store, "e1", events.NewDiscountReserved("trace-o1", "", "o1")),
+		eventDoc(t, store, "e2", events.NewDiscountReserved("trace-o1", "", "o1")),
+		eventDoc(t, store, "e3", events.NewDiscountRejected("trace-o2", "", "o2", "Daily discount quota reached")),
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, doc := range docs {
+			routeDecision(context.Background(), doc)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the listener blocked on the duplicate decision")
+	}
+
+	if got := (<-channels[0]).(events.DiscountReserved); got.OrderID != "o1" {
+		t.Errorf("o1 received %+v", got)
+	}
+	if len(channels[0]) != 0 {
+		t.Error("the duplicate decision was queued rather than dropped")
+	}
+	select {
+	case got := <-channels[1]:
+		if got.(events.DiscountRejected).OrderID != "o2" {
+			t.Errorf("o2 received %+v", got)
+		}
+	default:
+		t.Error("o2's decision wasn't delivered after o1's duplicate")
+	}
+}
+
+func TestDecisionTimeoutAnswers504(t *testing.T) {
+	defer func(timeout, lookup time.Duration) { decisionTimeout, decisionLookupTimeout = timeout, lookup }(decisionTimeout, decisionLookupTimeout)
+	t.Setenv("ORDER_DECISION_TIMEOUT", "20ms")
+	timeout, err := common.EnvDuration("ORDER_DECISION_TIMEOUT", decisionTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisionTimeout, decisionLookupTimeout = timeout, 0
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	decision, ok := awaitDecision(rec, httptest.NewRequest(http.MethodPost, "/order", nil), make(chan interface{}), "o1", "trace-o1")
+	if ok || decision != nil {
+		t.Fatalf("awaitDecision = %v, %v with no decision", decision, ok)
+	}
+	if waited := time.Since(start); waited < timeout || waited > time.Second {
+		t.Errorf("waited %s for a %s timeout", waited, timeout)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", rec.Code)
+	}
+	var resp OrderResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Status != "PENDING" || resp.OrderID != "o1" {
+		t.Errorf("response = %+v, %v", resp, err)
+	}
+
+	// A decision in time is handed back without answering
+	ch := make(chan interface{}, 1)
+	ch <- events.NewDiscountReserved("trace-o2", "", "o2")
+	rec = httptest.NewRecorder()
+	if _, ok := awaitDecision(rec, httptest.NewRequest(http.MethodPost, "/order", nil), ch, "o2", "trace-o2"); !ok || rec.Body.Len() != 0 {
+		t.Errorf("awaitDecision = %v, answered %q", ok, rec.Body)
+	}
+}
+
+func TestConfirmationUsesReservedPercent(t *testing.T) {
+	docStore = common.NewMemStore()
+	publisher = &common.Publisher{Logger: logger}
+
+	// The order was priced at 12%; the discount service reserved it at 20%
+	req := OrderRequest{UserID: "u1", BasePrice: 1000, IsR1Eligible: true, DiscountPercent: 12, FinalPrice: 880, DiscountAmount: 120}
+	reserved := events.NewDiscountReserved("trace-o1", "", "o1")
+	reserved.DiscountPercent, reserved.FinalPrice, reserved.DiscountAmount = 20, 800, 200
+
+	rec := httptest.NewRecorder()
+	respondToDecision(rec, httptest.NewRequest(http.MethodPost, "/order", nil), req, "o1", "trace-o1", "", reserved)
+
+	var resp OrderResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "CONFIRMED" || resp.DiscountPercent != 20 || resp.FinalPrice != 800 {
+		t.Errorf("response = %+v, want CONFIRMED at 20%%", resp)
+	}
+	if !strings.Contains(resp.Message, "20% discount applied") || !strings.Contains(resp.Message, "₹800.00") {
+		t.Errorf("message = %q, want the reserved 20%% and ₹800.00", resp.Message)
+	}
+}
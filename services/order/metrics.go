@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// eligibleOrders counts server-evaluated eligible orders by matched rule. An order matching several
+// rules increments each of them, so the per-reason counts can sum to more than the eligible orders.
+var eligibleOrders = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "discount_eligible_orders_total",
+	Help: "Orders evaluated eligible for a discount, by matched eligibility reason.",
+}, []string{"reason"})
+
+// orderDuration times POST /order from request to response, by outcome, so the wait for the
+// discount decision shows up per outcome (timeouts sit at ORDER_DECISION_TIMEOUT)
+var orderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "order_request_duration_seconds",
+	Help:    "Time to answer POST /order, by outcome (confirmed, reserved, rejected, failed, timeout, shed, invalid or error).",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 15, 30},
+}, []string{"outcome"})
+
+// recordEligibility counts the reasons behind an eligible decision
+func recordEligibility(reasons []string) {
+	for _, reason := range reasons {
+		eligibleOrders.WithLabelValues(reason).Inc()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+var trustedProxies common.TrustedProxies
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written bool // headers have been sent
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.written = true
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.written = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush event streams)
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog logs every request with the real client IP resolved through trusted proxies
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		logger.Info("HTTP Request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(), "client_ip", trustedProxies.ClientIP(r))
+	})
+}
+
+// requestTrace holds the IDs a handler assigned to its request, so recoverPanics can log them
+type requestTrace struct {
+	orderID string
+	traceID string
+}
+
+type requestTraceKey struct{}
+
+// noteTrace records the order and trace IDs of the request being served
+func noteTrace(r *http.Request, orderID, traceID string) {
+	if t, ok := r.Context().Value(requestTraceKey{}).(*requestTrace); ok {
+		t.orderID, t.traceID = orderID, traceID
+	}
+}
+
+// recoverPanics turns a panic in a handler into a logged error and a 500 OrderResponse, keeping the
+// process up. Only panics on the request's own goroutine can be recovered; goroutines a handler
+// starts must not panic.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := &requestTrace{}
+		r = r.WithContext(context.WithValue(r.Context(), requestTraceKey{}, trace))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate abort of the response; let net/http handle it
+				panic(p)
+			}
+			logger.Log(r.Context(), common.LevelCritical, "Handler panicked", "method", r.Method, "path", r.URL.Path,
+				"order_id", trace.orderID, "trace_id", trace.traceID, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+			if rec.written {
+				return
+			}
+			rec.Header().Set("Content-Type", "application/json")
+			rec.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rec).Encode(OrderResponse{
+				OrderID: trace.orderID,
+				Status:  "ERROR",
+				Message: "Internal Server Error",
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanicsAnswers500(t *testing.T) {
+	defer func(l *slog.Logger) { logger = l }(logger)
+	var logs bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteTrace(r, "o1", "trace-o1")
+		var m map[string]int
+		m["boom"]++ // nil map write
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/order", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	var resp OrderResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("the 500 isn't an OrderResponse: %v", err)
+	}
+	if resp.OrderID != "o1" || resp.Status != "ERROR" {
+		t.Errorf("response = %+v", resp)
+	}
+	if !strings.Contains(logs.String(), "trace_id=trace-o1") || !strings.Contains(logs.String(), "Handler panicked") {
+		t.Errorf("the panic wasn't logged with its trace ID:\n%s", logs.String())
+	}
+}
+
+func TestRecoverPanicsAfterResponseStarted(t *testing.T) {
+	defer func(l *slog.Logger) { logger = l }(logger)
+	logger = slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"CONFIRMED"}`))
+		panic("after the answer")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/order", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"CONFIRMED"}` {
+		t.Errorf("a started response was rewritten: %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestRecoverPanicsLetsAbortThrough(t *testing.T) {
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler re-panicked", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// Order outcomes whose HTTP status codes operators can remap for their gateway
+const (
+	OutcomeConfirmed = "confirmed"
+	OutcomeReserved  = "reserved"
+	OutcomeRejected  = "rejected"
+	OutcomeFailed    = "failed"
+	OutcomeTimeout   = "timeout"
+)
+
+// outcomeStatus maps each outcome to its response status code
+var outcomeStatus = map[string]int{
+	OutcomeConfirmed: http.StatusOK,
+	OutcomeReserved:  http.StatusAccepted,
+	OutcomeRejected:  http.StatusTooManyRequests,
+	OutcomeFailed:    http.StatusInternalServerError,
+	OutcomeTimeout:   http.StatusGatewayTimeout,
+}
+
+// loadStatusCodes applies ORDER_STATUS_CODES overrides such as "rejected=200,timeout=202"
+func loadStatusCodes() error {
+	for _, entry := range strings.Split(common.EnvOrDefault("ORDER_STATUS_CODES", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		outcome, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("ORDER_STATUS_CODES: invalid entry %q: want <outcome>=<code>", entry)
+		}
+		if _, known := outcomeStatus[outcome]; !known {
+			return fmt.Errorf("ORDER_STATUS_CODES: unknown outcome %q", outcome)
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 200 || code > 599 {
+			return fmt.Errorf("ORDER_STATUS_CODES: invalid status code %q for %s", value, outcome)
+		}
+		outcomeStatus[outcome] = code
+	}
+	return nil
+}
+
+// orderWriter is handed to the order handler in place of its ResponseWriter. It remembers the
+// outcome for the duration histogram and posts it to the order's callback, if it asked for one.
+type orderWriter struct {
+	http.ResponseWriter
+	status  int
+	outcome string
+	cb      *CallbackConfig // nil without a callback
+	traceID string
+}
+
+func (w *orderWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// noteOutcome records an outcome answered without writeOutcome, such as load shedding
+func noteOutcome(w http.ResponseWriter, outcome string) {
+	if ow, ok := w.(*orderWriter); ok {
+		ow.outcome = outcome
+	}
+}
+
+// durationLabel is the outcome, or for requests answered without one "invalid" (a client error) or
+// "error" (a server error or a panic)
+func (w *orderWriter) durationLabel() string {
+	switch {
+	case w.outcome != "":
+		return w.outcome
+	case w.status != 0 && w.status < 500:
+		return "invalid"
+	default:
+		return "error"
+	}
+}
+
+// writeOutcome sends resp with the outcome's configured status code, and to the order's callback
+// if it asked for one
+func writeOutcome(w http.ResponseWriter, outcome string, resp OrderResponse) {
+	if ow, ok := w.(*orderWriter); ok {
+		ow.outcome = outcome
+		ow.notify(outcome, resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(outcomeStatus[outcome])
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadStatusCodes(t *testing.T) {
+	defaults := maps.Clone(outcomeStatus)
+	tests := []struct {
+		env     string
+		want    map[string]int // overrides of the defaults
+		wantErr bool
+	}{
+		{env: "", want: nil},
+		{env: "rejected=200, timeout=202", want: map[string]int{OutcomeRejected: 200, OutcomeTimeout: 202}},
+		{env: "failed=503,", want: map[string]int{OutcomeFailed: 503}},
+		{env: "rejected", wantErr: true},
+		{env: "cancelled=200", wantErr: true},
+		{env: "rejected=ok", wantErr: true},
+		{env: "rejected=199", wantErr: true},
+		{env: "rejected=600", wantErr: true},
+	}
+	for _, tt := range tests {
+		outcomeStatus = maps.Clone(defaults)
+		t.Setenv("ORDER_STATUS_CODES", tt.env)
+		err := loadStatusCodes()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ORDER_STATUS_CODES=%q: err = %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		want := maps.Clone(defaults)
+		maps.Copy(want, tt.want)
+		if !maps.Equal(outcomeStatus, want) {
+			t.Errorf("ORDER_STATUS_CODES=%q: codes = %v, want %v", tt.env, outcomeStatus, want)
+		}
+	}
+	outcomeStatus = defaults
+}
+
+func TestWriteOutcomeUsesMappedCode(t *testing.T) {
+	defer func(codes map[string]int) { outcomeStatus = codes }(maps.Clone(outcomeStatus))
+	outcomeStatus[OutcomeRejected] = http.StatusOK
+
+	rec := httptest.NewRecorder()
+	w := &orderWriter{ResponseWriter: rec}
+	writeOutcome(w, OutcomeRejected, OrderResponse{OrderID: "o1", Status: "REJECTED"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the mapped 200", rec.Code)
+	}
+	if w.durationLabel() != OutcomeRejected {
+		t.Errorf("duration label = %q, want %q whatever the code", w.durationLabel(), OutcomeRejected)
+	}
+}
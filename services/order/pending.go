@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// pendingMax bounds responseMap; R1 orders beyond it are turned away instead of growing the map
+	pendingMax = 10000
+	// pendingTTL is how long an entry may stay in responseMap. Handlers remove their own entry well
+	// before this; anything older is a leak.
+	pendingTTL = 30 * time.Second
+)
+
+// errTooManyPending turns an R1 order away when responseMap already holds pendingMax orders
+var errTooManyPending = errors.New("too many orders awaiting a decision")
+
+// registerPending adds an order to responseMap, reporting false when the map is full
+func registerPending(orderID string, p *pendingOrder) bool {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	if len(responseMap) >= pendingMax {
+		return false
+	}
+	responseMap[orderID] = p
+	return true
+}
+
+// unregisterPending removes an order's entry once its handler stops waiting
+func unregisterPending(orderID string) {
+	mapMutex.Lock()
+	delete(responseMap, orderID)
+	mapMutex.Unlock()
+}
+
+// lookupPending finds the handler waiting on an order's decision
+func lookupPending(orderID string) (*pendingOrder, bool) {
+	mapMutex.RLock()
+	defer mapMutex.RUnlock()
+	p, ok := responseMap[orderID]
+	return p, ok
+}
+
+// publishAwaitingDecision registers the order's decision channel and only then publishes its
+// OrderCreated with publish. The discount service writes the decision only after reading that
+// event, so the decision can never reach the listener before its channel exists. Keep this order.
+// The caller unregisters the order once it stops waiting, whatever the outcome.
+func publishAwaitingDecision(orderID, traceID string, publish func() error) (chan interface{}, error) {
+	ch := make(chan interface{}, decisionBuffer)
+	if !registerPending(orderID, &pendingOrder{ch: ch, traceID: traceID, startedAt: time.Now()}) {
+		return nil, errTooManyPending
+	}
+	if err := publish(); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// sweepPendingLoop periodically evicts responseMap entries older than pendingTTL
+func sweepPendingLoop(ctx context.Context) {
+	ticker := time.NewTicker(pendingTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepPending(time.Now())
+		}
+	}
+}
+
+func sweepPending(now time.Time) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	for orderID, p := range responseMap {
+		if age := now.Sub(p.startedAt); age > pendingTTL {
+			delete(responseMap, orderID)
+			logger.Error("Evicted leaked pending order", "order_id", orderID, "trace_id", p.traceID, "age", age.String())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+func TestDecisionChannelRegisteredBeforePublish(t *testing.T) {
+	responseMap = make(map[string]*pendingOrder)
+
+	decision := events.NewDiscountReserved("trace-o1", "", "o1")
+	published := false
+	ch, err := publishAwaitingDecision("o1", "trace-o1", func() error {
+		published = true
+		// The fastest possible decision: the listener routes it while OrderCreated is being written
+		pending, ok := lookupPending("o1")
+		if !ok {
+			t.Fatal("OrderCreated was published before the order's decision channel was registered")
+		}
+		deliverDecision(pending, "o1", string(decision.Type), decision)
+		return nil
+	})
+	defer unregisterPending("o1")
+	if err != nil || !published {
+		t.Fatalf("publishAwaitingDecision = %v, published = %v", err, published)
+	}
+
+	select {
+	case got := <-ch:
+		if got.(events.DiscountReserved).OrderID != "o1" {
+			t.Errorf("received %+v", got)
+		}
+	default:
+		t.Error("a decision delivered during the publish was lost")
+	}
+}
+
+func TestPublishAwaitingDecisionWhenFull(t *testing.T) {
+	responseMap = make(map[string]*pendingOrder)
+	defer func(max int) { pendingMax = max }(pendingMax)
+	pendingMax = 1
+
+	if _, err := publishAwaitingDecision("o1", "t1", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterPending("o1")
+
+	_, err := publishAwaitingDecision("o2", "t2", func() error {
+		t.Error("published an order the map had no room for")
+		return nil
+	})
+	if !errors.Is(err, errTooManyPending) {
+		t.Errorf("err = %v, want errTooManyPending", err)
+	}
+}
+
+func TestSweepPendingEvictsLeakedEntries(t *testing.T) {
+	responseMap = make(map[string]*pendingOrder)
+	now := time.Now()
+	// o1's handler panicked before its deferred cleanup; o2 is still waiting on its decision
+	responseMap["o1"] = &pendingOrder{ch: make(chan interface{}, 1), traceID: "t1", startedAt: now.Add(-pendingTTL - time.Second)}
+	responseMap["o2"] = &pendingOrder{ch: make(chan interface{}, 1), traceID: "t2", startedAt: now.Add(-pendingTTL / 2)}
+
+	sweepPending(now)
+
+	if _, ok := lookupPending("o1"); ok {
+		t.Error("the leaked entry wasn't swept")
+	}
+	if _, ok := lookupPending("o2"); !ok {
+		t.Error("an order still awaiting its decision was swept")
+	}
+
+	// The swept slot is free again for a new order
+	defer func(max int) { pendingMax = max }(pendingMax)
+	pendingMax = 2
+	if !registerPending("o3", &pendingOrder{startedAt: now}) {
+		t.Error("the map stayed full after the sweep")
+	}
+}
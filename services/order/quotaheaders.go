@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// quotaHeaders enables X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset on order responses
+var quotaHeaders bool
+
+// quotaState is the quota as last reported by a decision for one location's counter
+type quotaState struct {
+	Date      string
+	Limit     int64
+	Remaining int64
+}
+
+// lastQuota caches the latest decision per counter, so orders that never reach the discount
+// service can still report the quota without reading Firestore
+var (
+	lastQuota   = make(map[string]quotaState)
+	lastQuotaMu sync.Mutex
+)
+
+func quotaKey(isTest bool, location string) string {
+	if isTest {
+		return "test/" + location
+	}
+	return location
+}
+
+// decisionQuota extracts the quota a decision reports; ok is false when it reports none
+func decisionQuota(decision interface{}) (limit, remaining int64, ok bool) {
+	switch d := decision.(type) {
+	case events.DiscountReserved:
+		limit, remaining = d.QuotaLimit, d.QuotaRemaining
+	case events.DiscountRejected:
+		limit, remaining = d.QuotaLimit, d.QuotaRemaining
+	}
+	return limit, remaining, limit > 0
+}
+
+// setDecisionQuotaHeaders reports the quota carried by an R1 order's decision and caches it
+func setDecisionQuotaHeaders(w http.ResponseWriter, req OrderRequest, decision interface{}) {
+	if !quotaHeaders {
+		return
+	}
+	limit, remaining, ok := decisionQuota(decision)
+	if !ok {
+		return
+	}
+	now := time.Now().In(quotaTimezone)
+	state := quotaState{Date: now.Format(common.QuotaDateLayout), Limit: limit, Remaining: remaining}
+	lastQuotaMu.Lock()
+	lastQuota[quotaKey(req.IsTest, req.LocationID)] = state
+	lastQuotaMu.Unlock()
+	writeQuotaHeaders(w, state, now)
+}
+
+// setCachedQuotaHeaders reports the last decision's quota for orders that reserve nothing.
+// Nothing is reported until this replica has seen a decision for the counter.
+func setCachedQuotaHeaders(w http.ResponseWriter, req OrderRequest) {
+	if !quotaHeaders {
+		return
+	}
+	lastQuotaMu.Lock()
+	state, ok := lastQuota[quotaKey(req.IsTest, req.LocationID)]
+	lastQuotaMu.Unlock()
+	if !ok {
+		return
+	}
+	now := time.Now().In(quotaTimezone)
+	if today := now.Format(common.QuotaDateLayout); state.Date != today {
+		// The counter has reset since
+		state = quotaState{Date: today, Limit: state.Limit, Remaining: state.Limit}
+	}
+	writeQuotaHeaders(w, state, now)
+}
+
+// writeQuotaHeaders sets the headers; X-Quota-Reset is the Unix time the next quota day starts
+func writeQuotaHeaders(w http.ResponseWriter, state quotaState, now time.Time) {
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(state.Limit, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(state.Remaining, 10))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+func TestDecisionQuota(t *testing.T) {
+	reserved := events.NewDiscountReserved("t1", "", "o1")
+	reserved.QuotaLimit, reserved.QuotaRemaining = 100, 42
+	rejected := events.NewDiscountRejected("t2", "", "o2", "Daily discount quota reached")
+	rejected.QuotaLimit = 100
+	tests := []struct {
+		name                  string
+		decision              interface{}
+		wantLimit, wantRemain int64
+		wantOK                bool
+	}{
+		{"reserved", reserved, 100, 42, true},
+		{"quota exhausted", rejected, 100, 0, true},
+		{"rejection without quota", events.NewDiscountRejected("t3", "", "o3", "Invalid order"), 0, 0, false},
+		{"not a decision", events.NewOrderSettled("t4", "", "o4"), 0, 0, false},
+	}
+	for _, tt := range tests {
+		limit, remaining, ok := decisionQuota(tt.decision)
+		if limit != tt.wantLimit || remaining != tt.wantRemain || ok != tt.wantOK {
+			t.Errorf("%s: decisionQuota = %d, %d, %v; want %d, %d, %v",
+				tt.name, limit, remaining, ok, tt.wantLimit, tt.wantRemain, tt.wantOK)
+		}
+	}
+}
+
+func TestWriteQuotaHeaders(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+	rec := httptest.NewRecorder()
+	now := time.Date(2026, 10, 15, 23, 30, 0, 0, kolkata)
+	writeQuotaHeaders(rec, quotaState{Date: "2026-10-15", Limit: 100, Remaining: 7}, now)
+
+	want := map[string]string{
+		"X-Quota-Limit":     "100",
+		"X-Quota-Remaining": "7",
+		// Midnight in the quota zone, 18:30 UTC
+		"X-Quota-Reset": strconv.FormatInt(time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC).Unix(), 10),
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+}
+
+func TestCachedQuotaHeaders(t *testing.T) {
+	defer func(enabled bool) { quotaHeaders = enabled }(quotaHeaders)
+	quotaTimezone = time.UTC
+	lastQuota = make(map[string]quotaState)
+	req := OrderRequest{LocationID: "loc-1"}
+
+	// Disabled, or before any decision, nothing is reported
+	for _, enabled := range []bool{false, true} {
+		quotaHeaders = enabled
+		rec := httptest.NewRecorder()
+		setCachedQuotaHeaders(rec, req)
+		if got := rec.Header().Get("X-Quota-Limit"); got != "" {
+			t.Errorf("enabled=%v: X-Quota-Limit = %q before any decision", enabled, got)
+		}
+	}
+
+	reserved := events.NewDiscountReserved("t1", "", "o1")
+	reserved.QuotaLimit, reserved.QuotaRemaining = 10, 4
+	setDecisionQuotaHeaders(httptest.NewRecorder(), req, reserved)
+
+	rec := httptest.NewRecorder()
+	setCachedQuotaHeaders(rec, req)
+	if rec.Header().Get("X-Quota-Limit") != "10" || rec.Header().Get("X-Quota-Remaining") != "4" {
+		t.Errorf("cached headers = %v, want the last decision's quota", rec.Header())
+	}
+	// Test traffic has its own counter
+	rec = httptest.NewRecorder()
+	setCachedQuotaHeaders(rec, OrderRequest{LocationID: "loc-1", IsTest: true})
+	if got := rec.Header().Get("X-Quota-Limit"); got != "" {
+		t.Errorf("test traffic reported the production quota: X-Quota-Limit = %q", got)
+	}
+
+	// A decision from a previous quota day reports the full quota
+	yesterday := time.Now().In(quotaTimezone).AddDate(0, 0, -1).Format(common.QuotaDateLayout)
+	lastQuota[quotaKey(false, "loc-1")] = quotaState{Date: yesterday, Limit: 10, Remaining: 0}
+	rec = httptest.NewRecorder()
+	setCachedQuotaHeaders(rec, req)
+	if got := rec.Header().Get("X-Quota-Remaining"); got != "10" {
+		t.Errorf("X-Quota-Remaining = %q after the day reset, want 10", got)
+	}
+}
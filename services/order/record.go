@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// recordAllOrders also publishes orders that get no discount, so the event log holds every booking
+var recordAllOrders bool
+
+// recordOrderWithoutDiscount publishes OrderCreated and OrderConfirmed for a booking that skips the
+// quota. The discount service ignores non-eligible OrderCreated events, so the quota is untouched.
+// Failures are logged but don't fail the booking, which needs no other service.
+func recordOrderWithoutDiscount(r *http.Request, req OrderRequest, orderID, traceID string, reasons []string) {
+	if err := publishOrderCreated(r.Context(), newOrderCreated(r, req, orderID, traceID, reasons)); err != nil {
+		return
+	}
+	confirmed := events.NewOrderConfirmed(traceID, common.ParentSpanID(r.Context()), orderID, req.FinalPrice)
+	confirmed.IsTest = req.IsTest
+	if err := publisher.Publish(r.Context(), confirmed); err != nil {
+		logger.Error("Failed to publish order confirmation", "order_id", orderID, "trace_id", traceID, "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
+)
+
+// publishOrderSettled records a confirmed booking for billing. The caller fills in the order and
+// its amounts. Amounts that don't add up are logged and nothing is published, so billing never
+// receives an inconsistent charge; neither that nor a failed publish fails the booking.
+func publishOrderSettled(r *http.Request, traceID string, settled events.OrderSettled) {
+	settled, err := newOrderSettled(r.Context(), traceID, settled)
+	if err != nil {
+		logger.Log(r.Context(), common.LevelCritical, "Inconsistent settlement amounts, not settled",
+			"order_id", settled.OrderID, "trace_id", traceID, "error", err)
+		return
+	}
+	if err := publisher.PublishWithRetry(r.Context(), settled, compensationAttempts, 200*time.Millisecond); err != nil {
+		logger.Log(r.Context(), common.LevelCritical, "Settlement Publish Failed",
+			"order_id", settled.OrderID, "trace_id", traceID, "error", err)
+	}
+}
+
+// newOrderSettled completes a settlement's event fields, refusing amounts that don't add up
+func newOrderSettled(ctx context.Context, traceID string, settled events.OrderSettled) (events.OrderSettled, error) {
+	settled.BaseEvent = events.NewBaseEvent(events.EventTypeOrderSettled, traceID, common.ParentSpanID(ctx))
+	settled.Currency = pricing.Currency
+	settled.DiscountApplied = settled.DiscountAmount > 0
+	return settled, pricing.CheckSettlement(settled.BasePrice, settled.FinalPrice, settled.DiscountAmount)
+}
+
+// settlementFor is the settlement of an order confirmed within its own request
+func settlementFor(req OrderRequest, orderID string) events.OrderSettled {
+	return events.OrderSettled{
+		OrderID:        orderID,
+		UserID:         req.UserID,
+		BasePrice:      req.BasePrice,
+		DiscountAmount: req.DiscountAmount,
+		FinalPrice:     req.FinalPrice,
+		IsTest:         req.IsTest,
+	}
+}
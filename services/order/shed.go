@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+)
+
+// shedConfig rejects R1 orders up front while the discount service is behind, instead of
+// accepting them only to time out waiting for a decision
+type shedConfig struct {
+	MaxLag   time.Duration // processing lag above which R1 orders are shed; 0 disables shedding
+	URL      string
+	Interval time.Duration
+}
+
+var (
+	shedCfg shedConfig
+	// shedding is set while the last lag reading exceeded MaxLag
+	shedding atomic.Bool
+	// discountLag is the last lag reading (nanoseconds) and lagCheckedAt when it was taken (unix nanoseconds)
+	discountLag  atomic.Int64
+	lagCheckedAt atomic.Int64
+)
+
+// loadShedConfig reads ORDER_MAX_LAG, DISCOUNT_LAG_URL and DISCOUNT_LAG_INTERVAL
+func loadShedConfig() (shedConfig, error) {
+	cfg := shedConfig{URL: common.EnvOrDefault("DISCOUNT_LAG_URL", "http://localhost:8082/lag")}
+	var err error
+	if cfg.MaxLag, err = common.EnvDuration("ORDER_MAX_LAG", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.Interval, err = common.EnvDuration("DISCOUNT_LAG_INTERVAL", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxLag > 0 && cfg.Interval <= 0 {
+		return cfg, fmt.Errorf("DISCOUNT_LAG_INTERVAL must be positive, got %s", cfg.Interval)
+	}
+	return cfg, nil
+}
+
+// pollDiscountLag reads the discount service's processing lag every interval. A failed read keeps
+// the previous state; an unreachable service is the fallback policy's concern.
+func pollDiscountLag(ctx context.Context, cfg shedConfig) {
+	if cfg.MaxLag <= 0 {
+		return
+	}
+	httpClient := &http.Client{Timeout: cfg.Interval}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		lag, err := fetchLag(ctx, httpClient, cfg.URL)
+		if err != nil {
+			logger.Warn("Failed to read discount processing lag", "url", cfg.URL, "error", err)
+		} else {
+			discountLag.Store(int64(lag))
+			lagCheckedAt.Store(time.Now().UnixNano())
+			shed := lag > cfg.MaxLag
+			if shedding.Swap(shed) != shed {
+				logger.Warn("Load shedding changed", "shedding", shed, "lag", lag.String(), "max_lag", cfg.MaxLag.String())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetchLag(ctx context.Context, httpClient *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var body struct {
+		LagSeconds float64 `json:"lag_seconds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return time.Duration(body.LagSeconds * float64(time.Second)), nil
+}
+
+// OutcomeShed labels shed orders in the duration histogram. It isn't a remappable outcome: shed
+// orders are always answered 503 and never reach a callback.
+const OutcomeShed = "shed"
+
+// writeBusy sheds an R1 order with 503 and a Retry-After of one polling interval
+func writeBusy(w http.ResponseWriter, orderID string) {
+	noteOutcome(w, OutcomeShed)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(shedCfg.Interval.Seconds()))))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(OrderResponse{
+		OrderID: orderID,
+		Status:  "REJECTED",
+		Message: "System busy, please try again shortly.",
+	})
+}
+
+type statusReport struct {
+	Shedding     bool      `json:"shedding"`
+	MaxLag       string    `json:"max_lag"`
+	DiscountLag  string    `json:"discount_lag,omitempty"`
+	LagCheckedAt time.Time `json:"lag_checked_at,omitzero"`
+}
+
+// handleStatus reports the order service's load shedding state
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	report := statusReport{Shedding: shedding.Load(), MaxLag: shedCfg.MaxLag.String()}
+	if checked := lagCheckedAt.Load(); checked != 0 {
+		report.DiscountLag = time.Duration(discountLag.Load()).Round(time.Millisecond).String()
+		report.LagCheckedAt = time.Unix(0, checked)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// lagServer answers the discount service's lag endpoint with status and body
+func lagServer(t *testing.T, status int, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchLag(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"caught up", http.StatusOK, `{"lag_seconds": 0}`, 0, false},
+		{"behind", http.StatusOK, `{"lag_seconds": 12.5}`, 12500 * time.Millisecond, false},
+		{"unavailable", http.StatusServiceUnavailable, `{"lag_seconds": 1}`, 0, true},
+		{"malformed", http.StatusOK, `lag: 12`, 0, true},
+	}
+	for _, tt := range tests {
+		srv := lagServer(t, tt.status, tt.body)
+		lag, err := fetchLag(context.Background(), srv.Client(), srv.URL)
+		if (err != nil) != tt.wantErr || lag != tt.want {
+			t.Errorf("%s: fetchLag = %v, %v; want %v, error %v", tt.name, lag, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPollDiscountLagSheds(t *testing.T) {
+	defer shedding.Store(false)
+	defer lagCheckedAt.Store(0)
+	srv := lagServer(t, http.StatusOK, `{"lag_seconds": 12}`)
+	shedCfg = shedConfig{MaxLag: 10 * time.Second, URL: srv.URL, Interval: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pollDiscountLag(ctx, shedCfg)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for lagCheckedAt.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if !shedding.Load() {
+		t.Fatal("a 12s lag over a 10s maximum isn't shedding")
+	}
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var report statusReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Shedding || report.DiscountLag != "12s" || report.MaxLag != "10s" {
+		t.Errorf("/status = %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	writeBusy(rec, "o1")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("shed order answered %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
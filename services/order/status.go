@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+)
+
+// decisionLookupTimeout bounds the direct decision query made when waiting times out; 0 disables it
+var decisionLookupTimeout = 2 * time.Second
+
+// lookupDecision queries the event log for an order's decision, for when the listener missed it.
+// It returns nil when there is none yet, the lookup is disabled, or the query fails.
+func lookupDecision(ctx context.Context, orderID string) interface{} {
+	if decisionLookupTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, decisionLookupTimeout)
+	defer cancel()
+
+	decision, err := findDecision(ctx, orderID)
+	if err != nil {
+		logger.Error("Decision lookup failed", "order_id", orderID, "error", err)
+		return nil
+	}
+	return decision
+}
+
+// findDecision returns an order's decision event from the event log, or nil if there is none yet
+func findDecision(ctx context.Context, orderID string) (interface{}, error) {
+	snaps, err := client.Collection(CollectionEvents).
+		Where("order_id", "==", orderID).
+		Where("type", "in", decisionTypes).
+		Limit(1).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+	eventType, _ := snaps[0].Data()["type"].(string)
+	decision, err := decisionDecoders[events.EventType(eventType)](common.SnapshotDoc(snaps[0]))
+	if err != nil {
+		return nil, fmt.Errorf("parse decision %s: %w", snaps[0].Ref.ID, err)
+	}
+	return decision, nil
+}
+
+// Page sizes for list endpoints
+var (
+	ordersPageSize    = 20
+	ordersMaxPageSize = 100
+)
+
+func loadPagination() error {
+	var err error
+	if ordersPageSize, err = common.EnvInt("ORDERS_PAGE_SIZE", ordersPageSize); err != nil {
+		return err
+	}
+	if ordersMaxPageSize, err = common.EnvInt("ORDERS_MAX_PAGE_SIZE", ordersMaxPageSize); err != nil {
+		return err
+	}
+	if ordersPageSize < 1 || ordersMaxPageSize < ordersPageSize {
+		return fmt.Errorf("invalid page sizes: default %d, max %d", ordersPageSize, ordersMaxPageSize)
+	}
+	return nil
+}
+
+// handleOrderStatus returns the current state of a single order from the read-model. Orders the
+// projection hasn't caught up with yet, or never records, are folded from the event log instead.
+func handleOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+
+	var view projection.OrderView
+	snap, err := client.Collection(ordersCollection).Doc(orderID).Get(r.Context())
+	switch {
+	case common.IsNotFound(err):
+		found, err := orderFromEvents(r.Context(), orderID, &view)
+		if err != nil {
+			logger.Error("Failed to read order events", "order_id", orderID, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+	case err != nil:
+		logger.Error("Failed to read order view", "order_id", orderID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	default:
+		if err := snap.DataTo(&view); err != nil {
+			logger.Error("Failed to parse order view", "order_id", orderID, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// orderFromEvents folds an order's events into view the way the projection does. It reports
+// false when the log has no OrderCreated for the order.
+func orderFromEvents(ctx context.Context, orderID string, view *projection.OrderView) (bool, error) {
+	docs, err := client.Collection(CollectionEvents).
+		Where("order_id", "==", orderID).
+		Where("type", "in", projection.EventTypes).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	wrapped := make([]*common.Doc, len(docs))
+	for i, doc := range docs {
+		wrapped[i] = common.SnapshotDoc(doc)
+	}
+	return foldOrderEvents(view, wrapped)
+}
+
+// foldOrderEvents applies one order's events to view in timestamp order, reporting whether they
+// include its OrderCreated
+func foldOrderEvents(view *projection.OrderView, docs []*common.Doc) (bool, error) {
+	slices.SortFunc(docs, func(a, b *common.Doc) int {
+		ta, _ := a.Data()["timestamp"].(time.Time)
+		tb, _ := b.Data()["timestamp"].(time.Time)
+		return ta.Compare(tb)
+	})
+
+	created := false
+	for _, doc := range docs {
+		if eventType, _ := common.GetString(doc.Data(), "type"); events.EventType(eventType) == events.EventTypeOrderCreated {
+			created = true
+		}
+		if err := projection.Apply(view, doc); err != nil {
+			return false, fmt.Errorf("apply event %s: %w", doc.ID, err)
+		}
+	}
+	return created, nil
+}
+
+// userOrdersPage is one page of a user's orders; Next is empty on the last page
+type userOrdersPage struct {
+	Orders []projection.OrderView `json:"orders"`
+	Next   string                 `json:"next,omitempty"`
+}
+
+// handleUserOrders lists a user's orders from the read-model, newest first, one page at a time.
+// Query params: user_id (required), limit (capped at the configured maximum), cursor (from a previous page).
+func handleUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := ordersPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, ordersMaxPageSize)
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+	if cursor != "" {
+		if _, _, err := common.DecodeCursor(cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	q := client.Collection(ordersCollection).Where("user_id", "==", userID)
+	docs, next, err := common.Paginate(r.Context(), q, "created_at", firestore.Desc, limit, cursor)
+	if err != nil {
+		logger.Error("Failed to list user orders", "user_id", userID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	page := userOrdersPage{Orders: []projection.OrderView{}, Next: next}
+	for _, doc := range docs {
+		var view projection.OrderView
+		if err := doc.DataTo(&view); err != nil {
+			logger.Warn("Skipping unparseable order view", "id", doc.Ref.ID, "error", err)
+			continue
+		}
+		page.Orders = append(page.Orders, view)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/events"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+)
+
+func TestFoldOrderEvents(t *testing.T) {
+	store := common.NewMemStore()
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	at := func(e events.BaseEvent, i int) events.BaseEvent {
+		e.Timestamp = start.Add(time.Duration(i) * time.Second)
+		return e
+	}
+
+	created := events.NewOrderCreated("trace-o1", "", "o1")
+	created.UserID, created.BasePrice, created.FinalPrice = "u1", 1500, 1320
+	created.BaseEvent = at(created.BaseEvent, 0)
+	reserved := events.NewDiscountReserved("trace-o1", "", "o1")
+	reserved.BaseEvent = at(reserved.BaseEvent, 1)
+	paid := events.NewPaymentCompleted("trace-o1", "", "o1", 1320)
+	paid.BaseEvent = at(paid.BaseEvent, 2)
+	orphan := events.NewDiscountRejected("trace-o2", "", "o2", "Daily discount quota reached")
+
+	tests := []struct {
+		name       string
+		docs       []*common.Doc
+		wantFound  bool
+		wantStatus string
+		wantUserID string
+	}{
+		{
+			name: "known order, delivered out of order",
+			docs: []*common.Doc{
+				eventDoc(t, store, "e3", paid), eventDoc(t, store, "e1", created), eventDoc(t, store, "e2", reserved),
+			},
+			wantFound: true, wantStatus: projection.StatusConfirmed, wantUserID: "u1",
+		},
+		{
+			name:      "reserved, awaiting payment",
+			docs:      []*common.Doc{eventDoc(t, store, "e1", created), eventDoc(t, store, "e2", reserved)},
+			wantFound: true, wantStatus: projection.StatusReserved, wantUserID: "u1",
+		},
+		{
+			name:      "decision without OrderCreated",
+			docs:      []*common.Doc{eventDoc(t, store, "e4", orphan)},
+			wantFound: false,
+		},
+		{name: "unknown order", docs: nil, wantFound: false},
+	}
+	for _, tt := range tests {
+		var view projection.OrderView
+		found, err := foldOrderEvents(&view, tt.docs)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if found != tt.wantFound {
+			t.Errorf("%s: found = %v, want %v", tt.name, found, tt.wantFound)
+		}
+		if tt.wantFound && (view.Status != tt.wantStatus || view.UserID != tt.wantUserID || view.OrderID != "o1") {
+			t.Errorf("%s: view = %+v, want status %s for %s", tt.name, view, tt.wantStatus, tt.wantUserID)
+		}
+	}
+}
+
+func TestUserOrdersRejectsBadPaging(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"user_id=u1&limit=0",
+		"user_id=u1&limit=ten",
+		"user_id=u1&cursor=not-a-cursor",
+	} {
+		rec := httptest.NewRecorder()
+		handleUserOrders(rec, httptest.NewRequest(http.MethodGet, "/orders?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
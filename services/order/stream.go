@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/events"
+)
+
+// handleEventStream streams events added after the client connects as Server-Sent Events.
+// An optional ?type=OrderCreated,DiscountRejected restricts the stream to those event types.
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	q := client.Collection(CollectionEvents).Where("timestamp", ">", time.Now())
+	if types := r.URL.Query().Get("type"); types != "" {
+		var filter []events.EventType
+		for _, name := range strings.Split(types, ",") {
+			eventType := events.EventType(name)
+			if !eventType.IsValid() {
+				http.Error(w, fmt.Sprintf("unknown event type %q", name), http.StatusBadRequest)
+				return
+			}
+			filter = append(filter, eventType)
+		}
+		q = q.Where("type", "in", filter)
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The iterator is tied to the request context, so a client disconnect stops the snapshot listener.
+	// Shutdown ends streams too, since they never go idle for the server to drain.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(shutdownSignal, cancel)()
+	iter := q.Snapshots(ctx)
+	defer iter.Stop()
+
+	logger.Info("Event stream opened", "client_ip", trustedProxies.ClientIP(r), "types", r.URL.Query().Get("type"))
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			if r.Context().Err() != nil {
+				logger.Info("Event stream closed by client", "client_ip", trustedProxies.ClientIP(r))
+			} else if ctx.Err() != nil {
+				logger.Info("Event stream closed for shutdown", "client_ip", trustedProxies.ClientIP(r))
+			} else {
+				logger.Error("Event stream failed", "error", err)
+			}
+			return
+		}
+
+		for _, change := range snap.Changes {
+			if change.Kind != firestore.DocumentAdded {
+				continue
+			}
+			data := change.Doc.Data()
+			payload, err := json.Marshal(data)
+			if err != nil {
+				logger.Error("Failed to encode streamed event", "id", change.Doc.Ref.ID, "error", err)
+				continue
+			}
+			eventType, _ := data["type"].(string)
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", change.Doc.Ref.ID, eventType, payload)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/pricing"
+)
+
+// Plausible age range for a date of birth, in years
+var dobMinAge, dobMaxAge = 0, 120
+
+// How orders for services not offered to the customer's gender are handled
+const (
+	GenderCheckWarn   = "warn"   // log the mismatch and price the order anyway (default)
+	GenderCheckStrict = "strict" // reject the order with 400
+)
+
+var genderCheck = GenderCheckWarn
+
+func loadValidation() error {
+	var err error
+	if dobMinAge, err = common.EnvInt("DOB_MIN_AGE", dobMinAge); err != nil {
+		return err
+	}
+	if dobMaxAge, err = common.EnvInt("DOB_MAX_AGE", dobMaxAge); err != nil {
+		return err
+	}
+	if dobMinAge < 0 || dobMaxAge < dobMinAge {
+		return fmt.Errorf("invalid DOB age range %d-%d", dobMinAge, dobMaxAge)
+	}
+	switch genderCheck = common.EnvOrDefault("GENDER_SERVICE_CHECK", GenderCheckWarn); genderCheck {
+	case GenderCheckWarn, GenderCheckStrict:
+	default:
+		return fmt.Errorf("unknown GENDER_SERVICE_CHECK %q", genderCheck)
+	}
+	return nil
+}
+
+// validateDOB checks the date format, that it isn't in the future and that the age is plausible
+func validateDOB(dob string, now time.Time) error {
+	date, err := time.ParseInLocation("2006-01-02", dob, now.Location())
+	if err != nil {
+		return fmt.Errorf("invalid date of birth %q: use YYYY-MM-DD", dob)
+	}
+	if date.After(now) {
+		return fmt.Errorf("date of birth cannot be in the future")
+	}
+
+	age := now.Year() - date.Year()
+	if now.Month() < date.Month() || (now.Month() == date.Month() && now.Day() < date.Day()) {
+		age--
+	}
+	if age < dobMinAge || age > dobMaxAge {
+		return fmt.Errorf("date of birth gives an age of %d, outside %d-%d", age, dobMinAge, dobMaxAge)
+	}
+	return nil
+}
+
+// priceFromCatalog replaces the client's service and base prices with the catalog's list prices.
+// It fails when no service is selected or one isn't in the catalog, and in strict mode when one
+// isn't offered to the customer's gender.
+func priceFromCatalog(req *OrderRequest, orderID string) error {
+	if len(req.SelectedServices) == 0 {
+		return fmt.Errorf("select at least one service")
+	}
+	names := make([]string, len(req.SelectedServices))
+	for i, s := range req.SelectedServices {
+		names[i] = s.Name
+	}
+	priced, base, err := pricing.ListPrices(names)
+	if err != nil {
+		return err
+	}
+	if mismatched := pricing.NotOffered(req.Gender, names); len(mismatched) > 0 {
+		logger.Warn("Service not offered for gender", "order_id", orderID, "gender", req.Gender,
+			"services", mismatched, "mode", genderCheck)
+		if genderCheck == GenderCheckStrict {
+			return fmt.Errorf("service %q is not offered for gender %q", mismatched[0], req.Gender)
+		}
+	}
+	if math.Abs(base-req.BasePrice) > pricing.Tolerance {
+		logger.Warn("Client base price overridden", "order_id", orderID, "client_base_price", req.BasePrice, "base_price", base)
+	}
+	for i, s := range priced {
+		req.SelectedServices[i].Price = s.Price
+	}
+	req.BasePrice = base
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// notBirthday is a date of birth thirty-odd years ago that isn't today, so the birthday rule stays out
+func notBirthday() string {
+	now := time.Now().In(clinicLocation)
+	return time.Date(now.Year()-30, now.Month()%12+1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
+
+func TestTamperedRequestsRepriced(t *testing.T) {
+	if err := loadEligibility(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		req          OrderRequest
+		wantBase     float64
+		wantEligible bool
+		wantFinal    float64
+	}{
+		{
+			name: "inflated prices claiming the discount",
+			req: OrderRequest{Gender: "Male", SelectedServices: []Service{{"ECG", 2000}}, BasePrice: 2000,
+				IsR1Eligible: true, DiscountPercent: 50, FinalPrice: 1000, DiscountAmount: 1000},
+			wantBase: 400, wantEligible: false, wantFinal: 400,
+		},
+		{
+			name: "discount claimed on a small order",
+			req: OrderRequest{Gender: "Female", SelectedServices: []Service{{"General Consultation", 500}}, BasePrice: 500,
+				IsR1Eligible: true, DiscountPercent: 12, FinalPrice: 440},
+			wantBase: 500, wantEligible: false, wantFinal: 500,
+		},
+		{
+			name: "understated prices",
+			req: OrderRequest{Gender: "Female", SelectedServices: []Service{{"Mammography", 1}, {"Ultrasound", 1}}, BasePrice: 2,
+				FinalPrice: 2},
+			wantBase: 2700, wantEligible: true, wantFinal: 2376,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.DOB = notBirthday()
+			if err := priceFromCatalog(&req, "o1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := applyEligibility(context.Background(), &req, "o1"); err != nil {
+				t.Fatal(err)
+			}
+			if req.BasePrice != tt.wantBase || req.IsR1Eligible != tt.wantEligible || req.FinalPrice != tt.wantFinal {
+				t.Errorf("repriced to base %v, eligible %v, final %v; want %v, %v, %v",
+					req.BasePrice, req.IsR1Eligible, req.FinalPrice, tt.wantBase, tt.wantEligible, tt.wantFinal)
+			}
+			if req.DiscountAmount != req.BasePrice-req.FinalPrice {
+				t.Errorf("discount amount %v kept from the client", req.DiscountAmount)
+			}
+			for _, s := range req.SelectedServices {
+				if s.Price == 1 || s.Price == 2000 {
+					t.Errorf("service %s kept the client's price %v", s.Name, s.Price)
+				}
+			}
+		})
+	}
+}
+
+func TestPriceFromCatalogRefuses(t *testing.T) {
+	defer func(mode string) { genderCheck = mode }(genderCheck)
+	tests := []struct {
+		name    string
+		mode    string
+		req     OrderRequest
+		wantErr bool
+	}{
+		{"no services", GenderCheckWarn, OrderRequest{Gender: "Female"}, true},
+		{"unknown service", GenderCheckWarn, OrderRequest{Gender: "Female", SelectedServices: []Service{{"Free Checkup", 0}}}, true},
+		{"other gender's service, warn", GenderCheckWarn, OrderRequest{Gender: "Male", SelectedServices: []Service{{"Mammography", 1500}}}, false},
+		{"other gender's service, strict", GenderCheckStrict, OrderRequest{Gender: "Male", SelectedServices: []Service{{"Mammography", 1500}}}, true},
+	}
+	for _, tt := range tests {
+		genderCheck = tt.mode
+		req := tt.req
+		if err := priceFromCatalog(&req, "o1"); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"github.com/devdolphintest/discount-system/pkg/common"
+	"github.com/devdolphintest/discount-system/pkg/projection"
+	"github.com/joho/godotenv"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	ProjectID        = "devdolphins-93118"
+	CollectionEvents = "events"
+)
+
+var logger = common.NewLogger()
+
+func main() {
+	rebuild := flag.Bool("rebuild", false, "Rebuild the orders read-model from the event log and exit")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	ctx := context.Background()
+	client, err := common.NewFirestoreClient(ctx, ProjectID)
+	if err != nil {
+		logger.Error("Failed to create client", "error", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	collection := common.EnvOrDefault("ORDERS_COLLECTION", projection.DefaultCollection)
+
+	if *rebuild {
+		if err := rebuildProjection(ctx, client, collection); err != nil {
+			logger.Error("Rebuild failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	reconnect, err := common.LoadReconnect()
+	if err != nil {
+		logger.Error("Invalid listener reconnect configuration", "error", err)
+		os.Exit(1)
+	}
+
+	checkpoint, err := common.CheckpointFromEnv(ctx, client, "projection")
+	if err != nil {
+		logger.Error("Failed to load listener checkpoint", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Projection Service Started", "collection", collection)
+
+	q := client.Collection(CollectionEvents).
+		Where("type", "in", projection.EventTypes).
+		OrderBy("timestamp", firestore.Asc)
+	onError := func(err error) { logger.Error("Error listening to events", "error", err) }
+	reconnect.ListenFrom(ctx, q, checkpoint, logger, onError, func(snap *firestore.QuerySnapshot) error {
+		var failed error
+		for _, change := range snap.Changes {
+			if change.Kind == firestore.DocumentAdded {
+				if err := applyEvent(ctx, client, collection, change.Doc); err != nil {
+					logger.Error("Failed to project event", "id", change.Doc.Ref.ID, "error", err)
+					if failed == nil {
+						failed = fmt.Errorf("project event %s: %w", change.Doc.Ref.ID, err)
+					}
+				}
+			}
+		}
+		return failed
+	})
+}
+
+// applyEvent transactionally folds one event into its order's read-model document. An error means
+// the event wasn't applied and should be retried.
+func applyEvent(ctx context.Context, client *firestore.Client, collection string, doc *firestore.DocumentSnapshot) error {
+	orderID, _ := doc.Data()["order_id"].(string)
+	if orderID == "" {
+		return nil
+	}
+	viewRef := client.Collection(collection).Doc(orderID)
+
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var view projection.OrderView
+		snap, err := tx.Get(viewRef)
+		if err != nil {
+			if !common.IsNotFound(err) {
+				return err
+			}
+		} else if err := snap.DataTo(&view); err != nil {
+			return err
+		}
+
+		if err := projection.Apply(&view, common.SnapshotDoc(doc)); err != nil {
+			// Retrying can't fix the event, so it is skipped, as the rebuild skips it
+			logger.Warn("Skipping unparseable event", "id", doc.Ref.ID, "error", err)
+			return nil
+		}
+		return tx.Set(viewRef, view)
+	})
+}
+
+// rebuildProjection replays the whole event log in timestamp order and overwrites every order document
+func rebuildProjection(ctx context.Context, client *firestore.Client, collection string) error {
+	logger.Info("Rebuilding projection from events", "collection", collection)
+
+	iter := client.Collection(CollectionEvents).
+		Where("type", "in", projection.EventTypes).
+		OrderBy("timestamp", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	views := make(map[string]*projection.OrderView)
+	processed := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		orderID, _ := doc.Data()["order_id"].(string)
+		if orderID == "" {
+			continue
+		}
+		view, ok := views[orderID]
+		if !ok {
+			view = &projection.OrderView{}
+			views[orderID] = view
+		}
+		if err := projection.Apply(view, common.SnapshotDoc(doc)); err != nil {
+			logger.Warn("Skipping unparseable event", "id", doc.Ref.ID, "error", err)
+			continue
+		}
+		processed++
+	}
+
+	for orderID, view := range views {
+		if _, err := client.Collection(collection).Doc(orderID).Set(ctx, view); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Projection rebuilt", "events", processed, "orders", len(views))
+	return nil
+}